@@ -29,11 +29,15 @@ var (
 	rdb *redis.Client
 	acm *grpc.AuthClientManager
 	tcm *grpc.TodoClientManager
+	ncm *grpc.NotificationClientManager
 	err error
 )
 
 func init() {
 	e.Load()
+	if err := e.Require("NOTIFICATION_GRPC_DOMAIN", "NOTIFICATION_GRPC_PORT"); err != nil {
+		logger.Errorf(err)
+	}
 	db = database.Init(e)
 	rdb = rdbc.Init(e)
 	isProd := e.Environ == string(enums.Prd)
@@ -54,6 +58,11 @@ func init() {
 		DialTimeout: 5 * time.Second,
 		UseTLS:      isProd,
 	}
+	notificationCfg := grpc.ClientConfig{
+		Address:     fmt.Sprintf("%s:%s", e.NotificationGRPCDomain, e.NotificationGRPCPort),
+		DialTimeout: 5 * time.Second,
+		UseTLS:      isProd,
+	}
 
 	acm, err = grpc.NewAuthClientManager(authCfg)
 	if err != nil {
@@ -63,6 +72,10 @@ func init() {
 	if err != nil {
 		logger.Errorf(fmt.Errorf("failed to create todo client manager: %w", err))
 	}
+	ncm, err = grpc.NewNotificationClientManager(notificationCfg)
+	if err != nil {
+		logger.Errorf(fmt.Errorf("failed to create notification client manager: %w", err))
+	}
 }
 
 func main() {
@@ -70,7 +83,7 @@ func main() {
 		acm.Close()
 	}()
 
-	r := router.Init(acm, tcm, e, db, rdb)
+	r := router.Init(acm, tcm, ncm, e, db, rdb)
 
 	server := &http.Server{
 		Addr:    ":" + e.APIGatewayPort,
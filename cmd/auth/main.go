@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
 
 	"github.com/VinukaThejana/go-utils/logger"
 	"github.com/VinukaThejana/todoapp/internal/auth"
+	"github.com/VinukaThejana/todoapp/internal/auth/tokens"
 	env "github.com/VinukaThejana/todoapp/internal/config"
 	"github.com/VinukaThejana/todoapp/internal/database"
 	"github.com/VinukaThejana/todoapp/internal/enums"
@@ -37,13 +39,28 @@ func init() {
 }
 
 func main() {
+	// a misconfigured key would otherwise only show up when the first token is signed
+	if _, err := tokens.AccessKeyset(e); err != nil {
+		logger.Errorf(fmt.Errorf("failed to load the access token keys: %v", err))
+	}
+	if _, err := tokens.RefreshKeyset(e); err != nil {
+		logger.Errorf(fmt.Errorf("failed to load the refresh token keys: %v", err))
+	}
+
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", e.AuthgRPCPort))
 	if err != nil {
 		logger.Errorf(fmt.Errorf("failed to listen: %v", err))
 	}
 
 	s := grpc.NewServer()
-	pb.RegisterAuthServiceServer(s, &auth.Server{E: e, DB: db, R: rdb})
+	server := auth.NewServer(e, db, rdb)
+	pb.RegisterAuthServiceServer(s, server)
+	pb.RegisterAdminServiceServer(s, auth.NewAdminServer(server))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go server.Run(ctx)
 
 	go func() {
 		log.Info().Msg(fmt.Sprintf("starting the auth gRPC server on port %s", e.AuthgRPCPort))
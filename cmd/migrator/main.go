@@ -36,7 +36,8 @@ func main() {
 			db.AutoMigrate(table.Schema)
 			info(fmt.Sprintf("creating the %s table", table.Name))
 		} else {
-			info(fmt.Sprintf("%s table already exists, skipping ... ", table.Name))
+			db.AutoMigrate(table.Schema)
+			info(fmt.Sprintf("%s table already exists, migrating new columns ... ", table.Name))
 		}
 	}
 }
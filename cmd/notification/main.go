@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/VinukaThejana/go-utils/logger"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/internal/lib"
+	"github.com/VinukaThejana/todoapp/internal/notification"
+	rdbc "github.com/VinukaThejana/todoapp/internal/redis"
+	pb "github.com/VinukaThejana/todoapp/pkg/notification"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"gorm.io/gorm"
+)
+
+var e = &env.Env{}
+var db *gorm.DB
+var rdb *redis.Client
+
+func init() {
+	e.Load()
+	if err := e.Require("NOTIFICATION_GRPC_PORT"); err != nil {
+		logger.Errorf(err)
+	}
+	db = database.Init(e)
+	rdb = rdbc.Init(e)
+
+	if e.Environ == string(enums.Dev) {
+		log.Logger = log.Output(zerolog.ConsoleWriter{
+			Out: os.Stderr,
+		})
+	}
+}
+
+func main() {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", e.NotificationGRPCPort))
+	if err != nil {
+		logger.Errorf(fmt.Errorf("failed to listen: %v", err))
+	}
+
+	server := notification.New(e, db, rdb)
+
+	s := grpc.NewServer()
+	pb.RegisterNotificationServiceServer(s, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go server.Run(ctx)
+
+	go func() {
+		log.Info().Msg(fmt.Sprintf("starting the notification gRPC server on port %s", e.NotificationGRPCPort))
+		if err := s.Serve(lis); err != nil {
+			log.Error().Msg(fmt.Sprintf("failed to serve: %v", err))
+		}
+	}()
+
+	lib.GracefulShutdowngRPC(s)
+}
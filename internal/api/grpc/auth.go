@@ -10,9 +10,14 @@ import (
 	"google.golang.org/grpc/credentials"
 )
 
+// maxAuthMessageSize is the largest response that the auth service can send, the archives of the data exports
+// are kept well below it
+const maxAuthMessageSize = 64 << 20
+
 // AuthClientManager is a struct that manages the gRPC client connection to the auth service.
 type AuthClientManager struct {
 	client auth.AuthServiceClient
+	admin  auth.AdminServiceClient
 	conn   *grpc.ClientConn
 	mu     sync.Mutex
 }
@@ -32,6 +37,8 @@ func NewAuthClientManager(cfg ClientConfig) (*AuthClientManager, error) {
 
 		opts := []grpc.DialOption{
 			grpc.WithBlock(),
+			// the archives of the data exports are sent in a single message
+			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxAuthMessageSize)),
 		}
 
 		if cfg.UseTLS {
@@ -50,6 +57,7 @@ func NewAuthClientManager(cfg ClientConfig) (*AuthClientManager, error) {
 
 		authClientManager = &AuthClientManager{
 			client: auth.NewAuthServiceClient(conn),
+			admin:  auth.NewAdminServiceClient(conn),
 			conn:   conn,
 		}
 	})
@@ -67,6 +75,13 @@ func (m *AuthClientManager) Client() auth.AuthServiceClient {
 	return m.client
 }
 
+// Admin returns the gRPC client of the admin service, it shares the connection to the auth service.
+func (m *AuthClientManager) Admin() auth.AdminServiceClient {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.admin
+}
+
 // Close closes the gRPC client connection to the auth service.
 func (m *AuthClientManager) Close() error {
 	m.mu.Lock()
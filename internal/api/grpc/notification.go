@@ -0,0 +1,79 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+
+	"github.com/VinukaThejana/todoapp/pkg/notification"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// NotificationClientManager is a struct that manages the gRPC client connection to the notification service.
+type NotificationClientManager struct {
+	client notification.NotificationServiceClient
+	conn   *grpc.ClientConn
+	mu     sync.Mutex
+}
+
+var (
+	notificationClientManager *NotificationClientManager
+	notificationClientOnce    sync.Once
+)
+
+// NewNotificationClientManager creates a new NotificationClientManager.
+func NewNotificationClientManager(cfg ClientConfig) (*NotificationClientManager, error) {
+	var err error
+	notificationClientOnce.Do(func() {
+		var conn *grpc.ClientConn
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.DialTimeout)
+		defer cancel()
+
+		opts := []grpc.DialOption{
+			grpc.WithBlock(),
+		}
+
+		if cfg.UseTLS {
+			opts = append(
+				opts,
+				grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})),
+			)
+		} else {
+			opts = append(opts, grpc.WithInsecure())
+		}
+
+		conn, err = grpc.DialContext(ctx, cfg.Address, opts...)
+		if err != nil {
+			return
+		}
+
+		notificationClientManager = &NotificationClientManager{
+			client: notification.NewNotificationServiceClient(conn),
+			conn:   conn,
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return notificationClientManager, nil
+}
+
+// Client returns the gRPC client connection to the notification service.
+func (m *NotificationClientManager) Client() notification.NotificationServiceClient {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.client
+}
+
+// Close closes the gRPC client connection to the notification service.
+func (m *NotificationClientManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn != nil {
+		return m.conn.Close()
+	}
+
+	return nil
+}
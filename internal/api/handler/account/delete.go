@@ -0,0 +1,120 @@
+package account
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/bytedance/sonic"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// Delete : This function is for deleting the account of the user after they confirm it with their password, the
+// user is logged out and the account is purged after the grace period unless they log in again. Users who sign in
+// with a provider may leave the password out when they logged in within the last few minutes
+func Delete(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 9
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		Password string `json:"password" validate:"omitempty,max=100"`
+	}
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+
+		validationErrs := err.(validator.ValidationErrors)
+		handler.JSONr(w, http.StatusBadRequest, fmt.Sprintf("Please provide a valid %s", strings.ToLower(validationErrs[0].Field())))
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+	sessionID, _ := r.Context().Value(middleware.SessionID).(string)
+
+	res, err := acm.Client().DeleteAccount(r.Context(), &auth.DeleteAccountRequest{
+		UserId:    userID,
+		Password:  reqBody.Password,
+		SessionId: sessionID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete the account")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, "Invalid password")
+			return
+		case codes.PermissionDenied:
+			handler.JSONr(w, http.StatusForbidden, "Log in again to confirm the deletion of your account")
+			return
+		case codes.FailedPrecondition:
+			handler.JSONr(w, http.StatusConflict, "Remove the other members of your workspaces before deleting your account")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "todoapp_refresh_token",
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Expires:  time.Now().UTC().Add(-24 * time.Hour),
+		Secure:   e.Environ == string(enums.Prd),
+		Domain:   e.Domain,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "todoapp_session_token",
+		Value:    "",
+		Path:     "/",
+		HttpOnly: false,
+		Expires:  time.Now().UTC().Add(-24 * time.Hour),
+		Secure:   e.Environ == string(enums.Prd),
+		Domain:   e.Domain,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]string{
+		"message":   "Account deleted, log in before it is purged to restore it",
+		"delete_at": res.DeleteAt,
+	})
+}
@@ -0,0 +1,117 @@
+package account
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/bytedance/sonic"
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// RequestExport : This function is for exporting all the data of the user, the archive can be downloaded from the
+// returned link until it expires
+func RequestExport(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := acm.Client().RequestDataExport(r.Context(), &auth.RequestDataExportRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to export the data")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.ResourceExhausted:
+			handler.JSONr(w, http.StatusTooManyRequests, "A data export was made recently, please try again later")
+			return
+		case codes.FailedPrecondition:
+			handler.JSONr(w, http.StatusUnprocessableEntity, "Your data is too large to be exported, contact support")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]string{
+		"url":        res.Url,
+		"expires_at": res.ExpiresAt,
+	})
+}
+
+// DownloadExport : This function is for downloading the archive of a data export with the signed link from
+// RequestExport, the link works without being logged in
+func DownloadExport(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	query := r.URL.Query()
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		handler.JSONr(w, http.StatusNotFound, "Invalid or expired link")
+		return
+	}
+
+	res, err := acm.Client().DownloadDataExport(r.Context(), &auth.DownloadDataExportRequest{
+		Id:        chi.URLParam(r, "export"),
+		Expires:   expires,
+		Signature: query.Get("signature"),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the data export")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, "Invalid or expired link")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set(
+		"Content-Disposition",
+		fmt.Sprintf(`attachment; filename="todoapp-export-%s.zip"`, time.Now().UTC().Format("20060102")),
+	)
+	w.Header().Set("Content-Length", strconv.Itoa(len(res.Archive)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res.Archive)
+}
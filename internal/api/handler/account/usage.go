@@ -0,0 +1,55 @@
+// Package account : This package is for getting the plan of a user and the usage of their quotas, deleting the
+// account and exporting its data
+package account
+
+import (
+	"net/http"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Usage : This function is for getting the plan of the user along with the limit and the usage of each quota,
+// a limit of 0 means that there is no limit
+func Usage(
+	w http.ResponseWriter,
+	r *http.Request,
+	tcm *grpc.TodoClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := tcm.Client().GetUsage(r.Context(), &todo.GetUsageRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the usage")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	quotas := map[string]any{}
+	for _, q := range res.Quotas {
+		quotas[q.Subject] = map[string]int64{
+			"limit": q.Limit,
+			"used":  q.Used,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]any{
+		"plan":      res.Plan,
+		"quotas":    quotas,
+		"resets_at": res.ResetsAt,
+	})
+}
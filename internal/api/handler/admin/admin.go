@@ -0,0 +1,312 @@
+// Package admin : This package is for the user management of the admins and the support staff
+package admin
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/bytedance/sonic"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// fail sends the response for an error of the admin service
+func fail(w http.ResponseWriter, err error) {
+	st, ok := status.FromError(err)
+	if !ok {
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	switch st.Code() {
+	case codes.PermissionDenied:
+		handler.JSONr(w, http.StatusForbidden, "You are not allowed to do this")
+	case codes.NotFound:
+		handler.JSONr(w, http.StatusNotFound, "User not found")
+	case codes.InvalidArgument:
+		handler.JSONr(w, http.StatusBadRequest, st.Message())
+	case codes.FailedPrecondition:
+		handler.JSONr(w, http.StatusConflict, st.Message())
+	default:
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+// SearchUsers : This function is for finding users by their name, username or email, the q, role, page_size and
+// page_token query parameters narrow down and page through the users
+func SearchUsers(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	query := r.URL.Query()
+
+	pageSize, err := strconv.Atoi(query.Get("page_size"))
+	if err != nil {
+		pageSize = 0
+	}
+
+	res, err := acm.Admin().SearchUsers(r.Context(), &auth.SearchUsersRequest{
+		Query:     query.Get("q"),
+		Role:      query.Get("role"),
+		PageSize:  int32(pageSize),
+		PageToken: query.Get("page_token"),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to search the users")
+		fail(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]interface{}{
+		"users":           res.Users,
+		"next_page_token": res.NextPageToken,
+	})
+}
+
+// ListUserSessions : This function is for listing the sessions that a user is logged in with
+func ListUserSessions(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	res, err := acm.Admin().ListUserSessions(r.Context(), &auth.ListUserSessionsRequest{
+		UserId: chi.URLParam(r, "user"),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the sessions of the user")
+		fail(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(res.Sessions)
+}
+
+// DisableUser : This function is for disabling the account of a user, the user is logged out everywhere
+func DisableUser(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	_, err := acm.Admin().DisableUser(r.Context(), &auth.DisableUserRequest{
+		UserId: chi.URLParam(r, "user"),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to disable the user")
+		fail(w, err)
+		return
+	}
+
+	handler.JSONr(w, http.StatusOK, "User disabled successfully")
+}
+
+// EnableUser : This function is for enabling the account of a user that was disabled
+func EnableUser(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	_, err := acm.Admin().EnableUser(r.Context(), &auth.EnableUserRequest{
+		UserId: chi.URLParam(r, "user"),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to enable the user")
+		fail(w, err)
+		return
+	}
+
+	handler.JSONr(w, http.StatusOK, "User enabled successfully")
+}
+
+// ForcePasswordReset : This function is for making a user choose a new password, the user is logged out
+// everywhere and sent a password reset link
+func ForcePasswordReset(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	_, err := acm.Admin().ForcePasswordReset(r.Context(), &auth.ForcePasswordResetRequest{
+		UserId: chi.URLParam(r, "user"),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to force the password reset")
+		fail(w, err)
+		return
+	}
+
+	handler.JSONr(w, http.StatusOK, "Password reset required, the user was sent a reset link")
+}
+
+// DeleteUser : This function is for deleting the account of a user
+func DeleteUser(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	_, err := acm.Admin().DeleteUser(r.Context(), &auth.DeleteUserRequest{
+		UserId: chi.URLParam(r, "user"),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete the user")
+		fail(w, err)
+		return
+	}
+
+	handler.JSONr(w, http.StatusOK, "User deleted successfully")
+}
+
+// UnlockAccount : This function is for lifting the lockout of a user after too many failed logins, the lockout
+// of the IP address in the optional request body is lifted as well
+func UnlockAccount(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 10
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		IP string `json:"ip" validate:"omitempty,ip"`
+	}
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+		handler.JSONr(w, http.StatusBadRequest, "Please provide a valid ip")
+		return
+	}
+
+	_, err = acm.Admin().UnlockAccount(r.Context(), &auth.UnlockAccountRequest{
+		UserId: chi.URLParam(r, "user"),
+		Ip:     reqBody.IP,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to unlock the account")
+		fail(w, err)
+		return
+	}
+
+	handler.JSONr(w, http.StatusOK, "Account unlocked successfully")
+}
+
+// SetRole : This function is for changing the role of a user to user, support or admin
+func SetRole(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 10
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		Role string `json:"role" validate:"required,oneof=user support admin"`
+	}
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+		handler.JSONr(w, http.StatusBadRequest, "Please provide a valid role")
+		return
+	}
+
+	_, err = acm.Admin().SetRole(r.Context(), &auth.SetRoleRequest{
+		UserId: chi.URLParam(r, "user"),
+		Role:   reqBody.Role,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to change the role")
+		fail(w, err)
+		return
+	}
+
+	handler.JSONr(w, http.StatusOK, "Role changed successfully")
+}
+
+// LoginMetrics : This function is for getting the number of failed logins and lockouts
+func LoginMetrics(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	res, err := acm.Admin().LoginMetrics(r.Context(), &auth.LoginMetricsRequest{})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the login metrics")
+		fail(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]interface{}{
+		"failed_logins":    res.FailedLogins,
+		"blocked_logins":   res.BlockedLogins,
+		"account_lockouts": res.AccountLockouts,
+		"ip_lockouts":      res.IpLockouts,
+	})
+}
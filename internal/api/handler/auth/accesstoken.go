@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/bytedance/sonic"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// CreatePersonalAccessToken creates a personal access token for the user, the token is only shown in this
+// response.
+func CreatePersonalAccessToken(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 10
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		Name      string   `json:"name" validate:"required,min=1,max=50"`
+		Scopes    []string `json:"scopes" validate:"required,min=1,max=10"`
+		ExpiresAt string   `json:"expires_at" validate:"max=50"`
+	}
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+
+		validationErrs := err.(validator.ValidationErrors)
+		handler.JSONr(w, http.StatusBadRequest, fmt.Sprintf("Please provide a valid %s", strings.ToLower(validationErrs[0].Field())))
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := acm.Client().CreatePersonalAccessToken(r.Context(), &auth.CreatePersonalAccessTokenRequest{
+		UserId:    userID,
+		Name:      reqBody.Name,
+		Scopes:    reqBody.Scopes,
+		ExpiresAt: reqBody.ExpiresAt,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the personal access token")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, st.Message())
+			return
+		case codes.ResourceExhausted:
+			handler.JSONr(w, http.StatusConflict, st.Message())
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]interface{}{
+		"personal_access_token": res.PersonalAccessToken,
+		"token":                 res.Token,
+	})
+}
+
+// ListPersonalAccessTokens lists the personal access tokens of the user.
+func ListPersonalAccessTokens(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := acm.Client().ListPersonalAccessTokens(r.Context(), &auth.ListPersonalAccessTokensRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the personal access tokens")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(res.PersonalAccessTokens)
+}
+
+// RevokePersonalAccessToken revokes a personal access token of the user.
+func RevokePersonalAccessToken(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 10
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+		handler.JSONr(w, http.StatusBadRequest, "Please provide a valid id")
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	_, err = acm.Client().RevokePersonalAccessToken(r.Context(), &auth.RevokePersonalAccessTokenRequest{
+		Id:     fmt.Sprint(reqBody.ID),
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to revoke the personal access token")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, "Personal access token not found")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	handler.JSONr(w, http.StatusOK, "Personal access token revoked successfully")
+}
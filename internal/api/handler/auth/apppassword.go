@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/bytedance/sonic"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// CreateAppPassword creates an app password for the user, the password is only shown in this response.
+func CreateAppPassword(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 10
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		Name string `json:"name" validate:"required,min=1,max=50"`
+	}
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+
+		validationErrs := err.(validator.ValidationErrors)
+		handler.JSONr(w, http.StatusBadRequest, fmt.Sprintf("Please provide a valid %s", strings.ToLower(validationErrs[0].Field())))
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := acm.Client().CreateAppPassword(r.Context(), &auth.CreateAppPasswordRequest{
+		UserId: userID,
+		Name:   reqBody.Name,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the app password")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, st.Message())
+			return
+		case codes.ResourceExhausted:
+			handler.JSONr(w, http.StatusConflict, st.Message())
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]interface{}{
+		"app_password": res.AppPassword,
+		"password":     res.Password,
+	})
+}
+
+// ListAppPasswords lists the app passwords of the user.
+func ListAppPasswords(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := acm.Client().ListAppPasswords(r.Context(), &auth.ListAppPasswordsRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the app passwords")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(res.AppPasswords)
+}
+
+// RevokeAppPassword revokes an app password of the user.
+func RevokeAppPassword(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 10
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+		handler.JSONr(w, http.StatusBadRequest, "Please provide a valid id")
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	_, err = acm.Client().RevokeAppPassword(r.Context(), &auth.RevokeAppPasswordRequest{
+		Id:     fmt.Sprint(reqBody.ID),
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to revoke the app password")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, "App password not found")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	handler.JSONr(w, http.StatusOK, "App password revoked successfully")
+}
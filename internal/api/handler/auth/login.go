@@ -96,11 +96,18 @@ func Login(
 			log.Error().Err(err)
 			handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
 			return
-		case codes.NotFound:
-			handler.JSONr(w, http.StatusNotFound, "User not found")
-			return
 		case codes.Unauthenticated:
-			handler.JSONr(w, http.StatusUnauthorized, "Invalid password")
+			handler.JSONr(w, http.StatusUnauthorized, "Invalid username or password")
+			return
+		case codes.ResourceExhausted:
+			handler.RetryAfter(w, st)
+			handler.JSONr(w, http.StatusTooManyRequests, "Too many failed logins, please try again later")
+			return
+		case codes.FailedPrecondition:
+			handler.JSONr(w, http.StatusForbidden, "Please verify your email address before logging in")
+			return
+		case codes.PermissionDenied:
+			handler.JSONr(w, http.StatusForbidden, st.Message())
 			return
 		default:
 			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
@@ -108,27 +115,54 @@ func Login(
 		}
 	}
 
-	w.Header().Add("X-New-Access-Token", resp.TokenSet.AccessToken)
+	if resp.SecondFactorRequired {
+		writeSecondFactor(w, resp)
+		return
+	}
+
+	setSession(w, e, resp.TokenSet)
+
+	handler.JSONr(w, http.StatusOK, "Login successful")
+}
+
+// setSession sets the new access token header and the cookies of the session that was created at login.
+func setSession(w http.ResponseWriter, e *env.Env, tokenSet *auth.TokenSet) {
+	w.Header().Add("X-New-Access-Token", tokenSet.AccessToken)
+	setRefreshToken(w, e, tokenSet.RefreshToken)
 	http.SetCookie(w, &http.Cookie{
-		Name:     "todoapp_refresh_token",
-		Value:    resp.TokenSet.RefreshToken,
+		Name:     "todoapp_session_token",
+		Value:    tokenSet.SessionToken,
 		Path:     "/",
-		HttpOnly: true,
+		HttpOnly: false,
 		MaxAge:   int(e.RefreshTokenExpiresIn.Seconds()),
 		Expires:  time.Now().UTC().Add(e.RefreshTokenExpiresIn),
 		Secure:   e.Environ == string(enums.Prd),
 		Domain:   e.Domain,
 	})
+}
+
+// setRefreshToken sets the refresh token cookie, it is replaced on every refresh since refresh tokens are rotated.
+func setRefreshToken(w http.ResponseWriter, e *env.Env, refreshToken string) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     "todoapp_session_token",
-		Value:    resp.TokenSet.SessionToken,
+		Name:     "todoapp_refresh_token",
+		Value:    refreshToken,
 		Path:     "/",
-		HttpOnly: false,
+		HttpOnly: true,
 		MaxAge:   int(e.RefreshTokenExpiresIn.Seconds()),
 		Expires:  time.Now().UTC().Add(e.RefreshTokenExpiresIn),
 		Secure:   e.Environ == string(enums.Prd),
 		Domain:   e.Domain,
 	})
+}
 
-	handler.JSONr(w, http.StatusOK, "Login successful")
+// writeSecondFactor tells the client to complete the login with the second factor, no session is set yet.
+func writeSecondFactor(w http.ResponseWriter, resp *auth.LoginResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]interface{}{
+		"message":                resp.Message,
+		"second_factor_required": true,
+		"challenge_token":        resp.ChallengeToken,
+		"second_factors":         resp.SecondFactors,
+	})
 }
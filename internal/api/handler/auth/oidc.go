@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// oidcStateCookie binds the login to the browser that started it so that a link with someone else's code can not
+// log the browser in to their account
+const oidcStateCookie = "todoapp_oidc_state"
+
+// StartOIDC redirects the user to the OpenID Connect provider to sign in.
+func StartOIDC(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	res, err := acm.Client().StartOIDCLogin(r.Context(), &auth.StartOIDCLoginRequest{
+		Provider: chi.URLParam(r, "provider"),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to start the OIDC login")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, "Provider not found")
+			return
+		case codes.Unavailable:
+			handler.JSONr(w, http.StatusBadGateway, "The provider is not available")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    res.State,
+		Path:     "/auth/oidc",
+		HttpOnly: true,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		Secure:   e.Environ == string(enums.Prd),
+		SameSite: http.SameSiteLaxMode,
+		Domain:   e.Domain,
+	})
+	http.Redirect(w, r, res.Url, http.StatusFound)
+}
+
+// OIDCCallback completes the login when the OpenID Connect provider redirects back, the session is set like at
+// login.
+func OIDCCallback(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		log.Error().Str("error", query.Get("error")).Str("description", query.Get("error_description")).Msg("the provider returned an error")
+		handler.JSONr(w, http.StatusUnauthorized, "Sign in with the provider failed")
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		handler.JSONr(w, http.StatusBadRequest, "Invalid state")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     "/auth/oidc",
+		HttpOnly: true,
+		MaxAge:   -1,
+		Secure:   e.Environ == string(enums.Prd),
+		SameSite: http.SameSiteLaxMode,
+		Domain:   e.Domain,
+	})
+
+	resp, err := acm.Client().FinishOIDCLogin(r.Context(), &auth.FinishOIDCLoginRequest{
+		Provider: chi.URLParam(r, "provider"),
+		Code:     query.Get("code"),
+		State:    state,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to finish the OIDC login")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, "Provider not found")
+			return
+		case codes.Unauthenticated:
+			handler.JSONr(w, http.StatusUnauthorized, st.Message())
+			return
+		case codes.PermissionDenied, codes.FailedPrecondition:
+			handler.JSONr(w, http.StatusForbidden, st.Message())
+			return
+		case codes.Unavailable:
+			handler.JSONr(w, http.StatusBadGateway, "The provider is not available")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	if resp.SecondFactorRequired {
+		writeSecondFactor(w, resp)
+		return
+	}
+
+	setSession(w, e, resp.TokenSet)
+
+	handler.JSONr(w, http.StatusOK, "Login successful")
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/bytedance/sonic"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// maxCredentialSize is the largest credential that the browser can send back, attestation certificates make
+// registrations the largest
+const maxCredentialSize = 1 << 16
+
+// readCredential reads the PublicKeyCredential that the browser returned, it is passed on as is.
+func readCredential(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxCredentialSize)
+	defer r.Body.Close()
+
+	credential, err := io.ReadAll(r.Body)
+	if err != nil || len(credential) == 0 {
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+
+	return credential, true
+}
+
+// writeOptions writes the options for the WebAuthn browser API.
+func writeOptions(w http.ResponseWriter, options []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(options)
+}
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create.
+func BeginPasskeyRegistration(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 8
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		Name string `json:"name" validate:"required,min=1,max=50"`
+	}
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+
+		validationErrs := err.(validator.ValidationErrors)
+		handler.JSONr(w, http.StatusBadRequest, fmt.Sprintf("Please provide a valid %s", strings.ToLower(validationErrs[0].Field())))
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := acm.Client().BeginPasskeyRegistration(r.Context(), &auth.BeginPasskeyRegistrationRequest{
+		UserId: userID,
+		Name:   reqBody.Name,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to start the passkey registration")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, "User not found")
+			return
+		case codes.ResourceExhausted:
+			handler.JSONr(w, http.StatusConflict, st.Message())
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	writeOptions(w, res.Options)
+}
+
+// FinishPasskeyRegistration stores the passkey from the PublicKeyCredential that navigator.credentials.create
+// returned.
+func FinishPasskeyRegistration(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	credential, ok := readCredential(w, r)
+	if !ok {
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := acm.Client().FinishPasskeyRegistration(r.Context(), &auth.FinishPasskeyRegistrationRequest{
+		UserId:     userID,
+		Credential: credential,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to finish the passkey registration")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, "Invalid credential")
+			return
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, "Invalid or expired registration")
+			return
+		case codes.AlreadyExists:
+			handler.JSONr(w, http.StatusConflict, "Passkey is already registered")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	sonic.ConfigDefault.NewEncoder(w).Encode(res.Passkey)
+}
+
+// ListPasskeys lists the passkeys of the user.
+func ListPasskeys(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := acm.Client().ListPasskeys(r.Context(), &auth.ListPasskeysRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the passkeys")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(res.Passkeys)
+}
+
+// DeletePasskey deletes a passkey of the user.
+func DeletePasskey(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 10
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+		handler.JSONr(w, http.StatusBadRequest, "Please provide a valid id")
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	_, err = acm.Client().DeletePasskey(r.Context(), &auth.DeletePasskeyRequest{
+		Id:     fmt.Sprint(reqBody.ID),
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete the passkey")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, "Passkey not found")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	handler.JSONr(w, http.StatusOK, "Passkey deleted successfully")
+}
+
+// BeginPasskeyLogin returns the options for navigator.credentials.get, the challenge token from the login
+// response is sent when the passkey is used as the second factor.
+func BeginPasskeyLogin(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 8
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		ChallengeToken string `json:"challenge_token" validate:"max=100"`
+	}
+
+	// the body is optional for a login without a password
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+		handler.JSONr(w, http.StatusBadRequest, "Please provide a valid challenge_token")
+		return
+	}
+
+	res, err := acm.Client().BeginPasskeyLogin(r.Context(), &auth.BeginPasskeyLoginRequest{
+		ChallengeToken: reqBody.ChallengeToken,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to start the passkey login")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.Unauthenticated:
+			handler.JSONr(w, http.StatusUnauthorized, st.Message())
+			return
+		case codes.FailedPrecondition:
+			handler.JSONr(w, http.StatusBadRequest, "No passkeys registered")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	writeOptions(w, res.Options)
+}
+
+// FinishPasskeyLogin logs in the user with the PublicKeyCredential that navigator.credentials.get returned, the
+// session is set like at login.
+func FinishPasskeyLogin(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	credential, ok := readCredential(w, r)
+	if !ok {
+		return
+	}
+
+	resp, err := acm.Client().FinishPasskeyLogin(r.Context(), &auth.FinishPasskeyLoginRequest{
+		Credential: credential,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to finish the passkey login")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, "Invalid credential")
+			return
+		case codes.Unauthenticated:
+			handler.JSONr(w, http.StatusUnauthorized, st.Message())
+			return
+		case codes.FailedPrecondition:
+			handler.JSONr(w, http.StatusForbidden, "Please verify your email address before logging in")
+			return
+		case codes.PermissionDenied:
+			handler.JSONr(w, http.StatusForbidden, st.Message())
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	setSession(w, e, resp.TokenSet)
+
+	handler.JSONr(w, http.StatusOK, "Login successful")
+}
@@ -47,6 +47,7 @@ func Refresh(
 	}
 
 	w.Header().Add("X-New-Access-Token", resp.AccessToken)
+	setRefreshToken(w, e, resp.RefreshToken)
 	handler.JSONr(w, http.StatusOK, "Refreshed")
 	return
 }
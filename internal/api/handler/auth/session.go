@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/bytedance/sonic"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// ListSessions lists the sessions that the user is logged in with.
+func ListSessions(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+	sessionID, _ := r.Context().Value(middleware.SessionID).(string)
+
+	res, err := acm.Client().ListSessions(r.Context(), &auth.ListSessionsRequest{
+		UserId:    userID,
+		SessionId: sessionID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the sessions")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(res.Sessions)
+}
+
+// RevokeSession logs out a session of the user.
+func RevokeSession(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 10
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		ID string `json:"id" validate:"required,max=50"`
+	}
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+		handler.JSONr(w, http.StatusBadRequest, "Please provide a valid id")
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	_, err = acm.Client().RevokeSession(r.Context(), &auth.RevokeSessionRequest{
+		Id:     reqBody.ID,
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to revoke the session")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, "Session not found")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	handler.JSONr(w, http.StatusOK, "Session revoked successfully")
+}
+
+// RevokeOtherSessions logs out every session of the user except the current one.
+func RevokeOtherSessions(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+	sessionID, _ := r.Context().Value(middleware.SessionID).(string)
+
+	res, err := acm.Client().RevokeAllOtherSessions(r.Context(), &auth.RevokeAllOtherSessionsRequest{
+		UserId:    userID,
+		SessionId: sessionID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to revoke the other sessions")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, "Please refresh the access token and try again")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Logged out of every other session",
+		"revoked": res.Revoked,
+	})
+}
@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/bytedance/sonic"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// decodeCode decodes a request body with a code from an authenticator app or a recovery code.
+func decodeCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	const (
+		maxRequestBodySize = 1 << 8
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		Code string `json:"code" validate:"required,min=6,max=20"`
+	}
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return "", false
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+
+		validationErrs := err.(validator.ValidationErrors)
+		handler.JSONr(w, http.StatusBadRequest, fmt.Sprintf("Please provide a valid %s", strings.ToLower(validationErrs[0].Field())))
+		return "", false
+	}
+
+	return reqBody.Code, true
+}
+
+// EnrollTOTP starts the enrolment of an authenticator app, the URI is meant to be shown as a QR code.
+func EnrollTOTP(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := acm.Client().EnrollTOTP(r.Context(), &auth.EnrollTOTPRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to enroll the authenticator app")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, "User not found")
+			return
+		case codes.FailedPrecondition:
+			handler.JSONr(w, http.StatusConflict, "Two-factor authentication is already enabled")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]interface{}{
+		"message": res.Message,
+		"secret":  res.Secret,
+		"uri":     res.Uri,
+	})
+}
+
+// ConfirmTOTP enables two-factor authentication with a code from the enrolled app, the recovery codes are only
+// shown in this response.
+func ConfirmTOTP(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	code, ok := decodeCode(w, r)
+	if !ok {
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := acm.Client().ConfirmTOTP(r.Context(), &auth.ConfirmTOTPRequest{
+		UserId: userID,
+		Code:   code,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to confirm the authenticator app")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, "No enrolment to confirm")
+			return
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, "Invalid code")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        res.Message,
+		"recovery_codes": res.RecoveryCodes,
+	})
+}
+
+// DisableTOTP turns off two-factor authentication after checking a code from the app or a recovery code.
+func DisableTOTP(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	code, ok := decodeCode(w, r)
+	if !ok {
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	_, err := acm.Client().DisableTOTP(r.Context(), &auth.DisableTOTPRequest{
+		UserId: userID,
+		Code:   code,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to disable two-factor authentication")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, "Invalid code")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	handler.JSONr(w, http.StatusOK, "Two-factor authentication disabled")
+}
+
+// VerifySecondFactor completes a login that needs a second factor, the session is set like at login.
+func VerifySecondFactor(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 9
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		ChallengeToken string `json:"challenge_token" validate:"required,max=100"`
+		Code           string `json:"code" validate:"required,min=6,max=20"`
+	}
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+
+		validationErrs := err.(validator.ValidationErrors)
+		handler.JSONr(w, http.StatusBadRequest, fmt.Sprintf("Please provide a valid %s", strings.ToLower(validationErrs[0].Field())))
+		return
+	}
+
+	resp, err := acm.Client().VerifySecondFactor(r.Context(), &auth.VerifySecondFactorRequest{
+		ChallengeToken: reqBody.ChallengeToken,
+		Code:           reqBody.Code,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to verify the second factor")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.Unauthenticated:
+			handler.JSONr(w, http.StatusUnauthorized, st.Message())
+			return
+		case codes.PermissionDenied:
+			handler.JSONr(w, http.StatusForbidden, st.Message())
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	setSession(w, e, resp.TokenSet)
+
+	handler.JSONr(w, http.StatusOK, "Login successful")
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/bytedance/sonic"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// VerifyEmail verifies the email address of the user with the token from the verification email, the token is
+// taken from the query for GET requests and from the body otherwise.
+func VerifyEmail(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 8
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		Token string `json:"token" validate:"required,max=100"`
+	}
+
+	// the link in the verification email opens this endpoint with the token in the query
+	if r.Method == http.MethodGet {
+		reqBody.Token = r.URL.Query().Get("token")
+	} else {
+		err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+		if err != nil {
+			log.Error().Err(err)
+			handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	validate := validator.New()
+	err := validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+
+		validationErrs := err.(validator.ValidationErrors)
+		handler.JSONr(w, http.StatusBadRequest, fmt.Sprintf("Please provide a valid %s", strings.ToLower(validationErrs[0].Field())))
+		return
+	}
+
+	_, err = acm.Client().VerifyEmail(r.Context(), &auth.VerifyEmailRequest{
+		Token: reqBody.Token,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to verify the email")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusBadRequest, "The link is invalid or has expired")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	handler.JSONr(w, http.StatusOK, "Email verified")
+}
+
+// ResendVerification sends a new verification email, the response does not tell whether the email belongs to an account.
+func ResendVerification(
+	w http.ResponseWriter,
+	r *http.Request,
+	acm *grpc.AuthClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 8
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+
+		validationErrs := err.(validator.ValidationErrors)
+		handler.JSONr(w, http.StatusBadRequest, fmt.Sprintf("Please provide a valid %s", strings.ToLower(validationErrs[0].Field())))
+		return
+	}
+
+	res, err := acm.Client().ResendVerification(r.Context(), &auth.ResendVerificationRequest{
+		Email: reqBody.Email,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to resend the verification email")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	handler.JSONr(w, http.StatusAccepted, res.Message)
+}
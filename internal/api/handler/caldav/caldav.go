@@ -0,0 +1,399 @@
+// Package caldav : This package is for serving the todos of a user as CalDAV (RFC 4791) calendar collections
+// so that native reminder apps can read and edit them. Todos without a project live in the todos collection
+// and every project gets its own collection, all changes are mapped onto the TodoService RPCs.
+//
+//	/caldav/                          the principal and the calendar home of the user
+//	/caldav/todos/                    the todos without a project
+//	/caldav/project-{project}/        the todos of a project
+//	/caldav/{collection}/{name}.ics   a single todo
+package caldav
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	// Prefix is the path that the CalDAV endpoint is mounted on
+	Prefix = "/caldav"
+
+	defaultCollection = "todos"
+	projectPrefix     = "project-"
+	maxObjectSize     = 256 << 10
+
+	davNS            = "DAV:"
+	caldavNS         = "urn:ietf:params:xml:ns:caldav"
+	calendarServerNS = "http://calendarserver.org/ns/"
+
+	contentType = "text/calendar; charset=utf-8; component=VTODO"
+)
+
+// collection is a calendar collection of the user
+type collection struct {
+	name    string
+	project string
+}
+
+func (c *collection) href() string {
+	return Prefix + "/" + url.PathEscape(c.name) + "/"
+}
+
+func (c *collection) displayName() string {
+	if c.project == "" {
+		return "Todos"
+	}
+
+	return c.project
+}
+
+// collectionFor returns the collection of the given path segment
+func collectionFor(name string) (*collection, bool) {
+	if name == defaultCollection {
+		return &collection{name: name}, true
+	}
+	if project, ok := strings.CutPrefix(name, projectPrefix); ok && project != "" {
+		return &collection{name: name, project: project}, true
+	}
+
+	return nil, false
+}
+
+// objectName returns the resource name of the todo inside its collection
+func objectName(t *todo.Todo) string {
+	if t.CalendarName != "" {
+		return t.CalendarName
+	}
+
+	return t.Id + ".ics"
+}
+
+func objectHref(c *collection, t *todo.Todo) string {
+	return c.href() + url.PathEscape(objectName(t))
+}
+
+// etag returns the strong entity tag of the todo, it changes whenever the todo is saved
+func etag(t *todo.Todo) string {
+	sum := sha1.Sum([]byte(t.Id + "|" + t.UpdatedAt))
+	return `"` + hex.EncodeToString(sum[:10]) + `"`
+}
+
+// ctag returns the tag of the collection, it changes whenever a todo in it is added, changed or removed
+func ctag(todos []*todo.Todo) string {
+	tags := []string{}
+	for _, t := range todos {
+		tags = append(tags, objectName(t)+etag(t))
+	}
+	sort.Strings(tags)
+
+	sum := sha1.Sum([]byte(strings.Join(tags, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// quote quotes the value as a string of the query language of the List RPC
+func quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// listTodos returns the todos of the collection
+func listTodos(ctx context.Context, tcm *grpc.TodoClientManager, userID string, c *collection) ([]*todo.Todo, error) {
+	res, err := tcm.Client().List(ctx, &todo.ListRequest{
+		UserId: userID,
+		Query:  "project = " + quote(c.project),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Todos, nil
+}
+
+// listCollections returns the collections of the user, the todos collection always exists
+func listCollections(ctx context.Context, tcm *grpc.TodoClientManager, userID string) ([]*collection, error) {
+	res, err := tcm.Client().List(ctx, &todo.ListRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	projects := map[string]bool{}
+	for _, t := range res.Todos {
+		if t.Project != "" {
+			projects[t.Project] = true
+		}
+	}
+
+	collections := []*collection{{name: defaultCollection}}
+	names := []string{}
+	for project := range projects {
+		names = append(names, project)
+	}
+	sort.Strings(names)
+	for _, project := range names {
+		collections = append(collections, &collection{name: projectPrefix + project, project: project})
+	}
+
+	return collections, nil
+}
+
+// findObject returns the todo with the given resource name from the todos of a collection
+func findObject(todos []*todo.Todo, name string) (*todo.Todo, bool) {
+	for _, t := range todos {
+		if objectName(t) == name {
+			return t, true
+		}
+	}
+
+	return nil, false
+}
+
+// splitPath splits the escaped request path into the collection and the object name, both are empty for
+// the root. The segments are unescaped after splitting as project names may contain slashes.
+func splitPath(path string) (string, string, bool) {
+	path = strings.TrimPrefix(path, Prefix)
+
+	segments := []string{}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			continue
+		}
+		segment, err := url.PathUnescape(segment)
+		if err != nil {
+			return "", "", false
+		}
+		segments = append(segments, segment)
+	}
+
+	switch len(segments) {
+	case 0:
+		return "", "", true
+	case 1:
+		return segments[0], "", true
+	case 2:
+		return segments[0], segments[1], true
+	}
+
+	return "", "", false
+}
+
+func lastModified(t *todo.Todo) string {
+	updated, err := time.Parse(time.RFC3339Nano, t.UpdatedAt)
+	if err != nil {
+		return ""
+	}
+
+	return updated.UTC().Format(http.TimeFormat)
+}
+
+// Serve : This function is for handling every CalDAV request, the method decides what is done with the target
+func Serve(
+	w http.ResponseWriter,
+	r *http.Request,
+	tcm *grpc.TodoClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("DAV", "1, 3, calendar-access")
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		w.WriteHeader(http.StatusOK)
+	case "PROPFIND":
+		propfind(w, r, tcm, userID)
+	case "REPORT":
+		report(w, r, tcm, userID)
+	case http.MethodGet, http.MethodHead:
+		get(w, r, tcm, userID)
+	case http.MethodPut:
+		put(w, r, tcm, userID)
+	case http.MethodDelete:
+		remove(w, r, tcm, userID)
+	default:
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// prefixes are the namespace prefixes that are declared on the multistatus element
+var prefixes = map[string]string{
+	davNS:            "d",
+	caldavNS:         "c",
+	calendarServerNS: "cs",
+}
+
+// element renders an element with the given inner XML, unknown namespaces are declared on the element itself
+func element(name xml.Name, inner string) string {
+	tag := name.Local
+	attrs := ""
+	if prefix, ok := prefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+	} else if name.Space != "" {
+		tag = "x:" + name.Local
+		attrs = fmt.Sprintf(` xmlns:x="%s"`, escape(name.Space))
+	}
+
+	if inner == "" {
+		return fmt.Sprintf("<%s%s/>", tag, attrs)
+	}
+	return fmt.Sprintf("<%s%s>%s</%s>", tag, attrs, inner, tag)
+}
+
+// escape escapes the text for XML
+func escape(text string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
+
+func href(value string) string {
+	return "<d:href>" + escape(value) + "</d:href>"
+}
+
+// response is a response element of a multistatus, props holds the inner XML of every property the resource has
+type response struct {
+	href   string
+	status int
+	props  map[xml.Name]string
+}
+
+// writeMultistatus writes the responses, the requested properties that a resource does not have are reported
+// with 404. When no properties are requested every property except the calendar data is returned.
+func writeMultistatus(w http.ResponseWriter, responses []*response, requested []xml.Name) {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/">`)
+
+	for _, res := range responses {
+		b.WriteString("<d:response>")
+		b.WriteString(href(res.href))
+
+		if res.status != 0 {
+			fmt.Fprintf(&b, "<d:status>HTTP/1.1 %d %s</d:status>", res.status, http.StatusText(res.status))
+			b.WriteString("</d:response>")
+			continue
+		}
+
+		names := requested
+		if len(names) == 0 {
+			for name := range res.props {
+				if name.Local != "calendar-data" {
+					names = append(names, name)
+				}
+			}
+			sort.Slice(names, func(i, j int) bool {
+				return names[i].Space+names[i].Local < names[j].Space+names[j].Local
+			})
+		}
+
+		var found, missing strings.Builder
+		for _, name := range names {
+			if inner, ok := res.props[name]; ok {
+				found.WriteString(element(name, inner))
+			} else {
+				missing.WriteString(element(name, ""))
+			}
+		}
+		if found.Len() > 0 {
+			b.WriteString("<d:propstat><d:prop>" + found.String() + "</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>")
+		}
+		if missing.Len() > 0 {
+			b.WriteString("<d:propstat><d:prop>" + missing.String() + "</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>")
+		}
+
+		b.WriteString("</d:response>")
+	}
+
+	b.WriteString("</d:multistatus>")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(b.String()))
+}
+
+// writeError writes a WebDAV error body with the given precondition element
+func writeError(w http.ResponseWriter, status int, condition xml.Name) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(
+		w,
+		`<?xml version="1.0" encoding="utf-8"?><d:error xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">%s</d:error>`,
+		element(condition, ""),
+	)
+}
+
+func davName(local string) xml.Name {
+	return xml.Name{Space: davNS, Local: local}
+}
+
+func caldavName(local string) xml.Name {
+	return xml.Name{Space: caldavNS, Local: local}
+}
+
+// rootProps returns the properties of the principal and calendar home
+func rootProps() map[xml.Name]string {
+	home := href(Prefix + "/")
+
+	return map[xml.Name]string{
+		davName("resourcetype"):           "<d:collection/><d:principal/>",
+		davName("displayname"):            "todoapp",
+		davName("current-user-principal"): home,
+		davName("principal-URL"):          home,
+		davName("owner"):                  home,
+		caldavName("calendar-home-set"):   home,
+	}
+}
+
+// collectionProps returns the properties of a calendar collection
+func collectionProps(c *collection, todos []*todo.Todo) map[xml.Name]string {
+	privileges := ""
+	for _, privilege := range []string{"read", "write", "write-content", "write-properties", "bind", "unbind", "read-current-user-privilege-set"} {
+		privileges += "<d:privilege><d:" + privilege + "/></d:privilege>"
+	}
+	reports := ""
+	for _, report := range []string{"calendar-query", "calendar-multiget"} {
+		reports += "<d:supported-report><d:report><c:" + report + "/></d:report></d:supported-report>"
+	}
+
+	return map[xml.Name]string{
+		davName("resourcetype"):                        "<d:collection/><c:calendar/>",
+		davName("displayname"):                         escape(c.displayName()),
+		davName("current-user-principal"):              href(Prefix + "/"),
+		davName("owner"):                               href(Prefix + "/"),
+		davName("current-user-privilege-set"):          privileges,
+		davName("supported-report-set"):                reports,
+		caldavName("supported-calendar-component-set"): `<c:comp name="VTODO"/>`,
+		{Space: calendarServerNS, Local: "getctag"}:    escape(ctag(todos)),
+	}
+}
+
+// objectProps returns the properties of a todo, the calendar data is only returned when it is requested
+func objectProps(t *todo.Todo) map[xml.Name]string {
+	data := encode(t)
+
+	return map[xml.Name]string{
+		davName("resourcetype"):     "",
+		davName("getetag"):          escape(etag(t)),
+		davName("getcontenttype"):   escape(contentType),
+		davName("getcontentlength"): fmt.Sprint(len(data)),
+		davName("getlastmodified"):  escape(lastModified(t)),
+		caldavName("calendar-data"): escape(data),
+	}
+}
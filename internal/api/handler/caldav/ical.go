@@ -0,0 +1,372 @@
+package caldav
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/pkg/todo"
+)
+
+const (
+	icalDateTime    = "20060102T150405"
+	icalDateTimeUTC = "20060102T150405Z"
+	icalDate        = "20060102"
+	maxLineOctets   = 75
+)
+
+var (
+	errNoTodo          = errors.New("the calendar does not contain a VTODO")
+	errUnsupportedComp = errors.New("only VTODO components are supported")
+	errMalformed       = errors.New("malformed iCalendar data")
+)
+
+// vtodo holds the properties of a VTODO component that are mapped onto a todo
+type vtodo struct {
+	UID         string
+	Summary     string
+	Description string
+	Completed   bool
+	Due         string
+	Priority    string
+	Categories  []string
+	RRule       string
+}
+
+// uid returns the iCalendar UID of the todo
+func uid(t *todo.Todo) string {
+	if t.CalendarUid != "" {
+		return t.CalendarUid
+	}
+
+	return fmt.Sprintf("todoapp-%s", t.Id)
+}
+
+// escapeText escapes a TEXT value
+func escapeText(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(value)
+}
+
+// unescapeText reverses escapeText
+func unescapeText(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+
+		i++
+		switch value[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+
+	return b.String()
+}
+
+// splitList splits a list value on the commas that are not escaped
+func splitList(value string) []string {
+	items := []string{}
+	start := 0
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' {
+			i++
+			continue
+		}
+		if value[i] == ',' {
+			items = append(items, value[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(items, value[start:])
+}
+
+// fold writes the content line folded at 75 octets without breaking UTF-8 sequences
+func fold(b *strings.Builder, line string) {
+	first := true
+	for len(line) > 0 {
+		limit := maxLineOctets
+		if !first {
+			// the leading space of a continuation line counts towards the limit
+			limit--
+		}
+
+		n := len(line)
+		if n > limit {
+			n = limit
+			for n > 0 && !utf8.RuneStart(line[n]) {
+				n--
+			}
+		}
+
+		if !first {
+			b.WriteString(" ")
+		}
+		b.WriteString(line[:n])
+		b.WriteString("\r\n")
+
+		line = line[n:]
+		first = false
+	}
+}
+
+// toICalPriority maps the priority of a todo to the 1 (highest) to 9 (lowest) scale of iCalendar
+func toICalPriority(priority string) int {
+	switch enums.Priority(priority) {
+	case enums.Urgent:
+		return 1
+	case enums.High:
+		return 3
+	case enums.Medium:
+		return 5
+	case enums.Low:
+		return 9
+	}
+
+	return 0
+}
+
+// fromICalPriority maps an iCalendar priority to the priority of a todo
+func fromICalPriority(priority int) string {
+	switch {
+	case priority == 1:
+		return string(enums.Urgent)
+	case priority >= 2 && priority <= 4:
+		return string(enums.High)
+	case priority == 5:
+		return string(enums.Medium)
+	case priority >= 6 && priority <= 9:
+		return string(enums.Low)
+	}
+
+	return ""
+}
+
+// encode renders the todo as an iCalendar object with a single VTODO
+func encode(t *todo.Todo) string {
+	var b strings.Builder
+	line := func(name string, value string) {
+		fold(&b, name+":"+value)
+	}
+	utc := func(value string) string {
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return ""
+		}
+		return parsed.UTC().Format(icalDateTimeUTC)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//todoapp//CalDAV//EN")
+	line("BEGIN", "VTODO")
+	line("UID", escapeText(uid(t)))
+	if stamp := utc(t.UpdatedAt); stamp != "" {
+		line("DTSTAMP", stamp)
+		line("LAST-MODIFIED", stamp)
+	}
+	if created := utc(t.CreatedAt); created != "" {
+		line("CREATED", created)
+	}
+	line("SUMMARY", escapeText(t.Title))
+	if t.Description != "" {
+		line("DESCRIPTION", escapeText(t.Description))
+	}
+	if t.Completed {
+		line("STATUS", "COMPLETED")
+		line("PERCENT-COMPLETE", "100")
+		if completed := utc(t.CompletedAt); completed != "" {
+			line("COMPLETED", completed)
+		}
+	} else {
+		line("STATUS", "NEEDS-ACTION")
+	}
+	if due := utc(t.DueAt); due != "" {
+		line("DUE", due)
+	}
+	if priority := toICalPriority(t.Priority); priority != 0 {
+		line("PRIORITY", strconv.Itoa(priority))
+	}
+	if len(t.Tags) > 0 {
+		categories := []string{}
+		for _, tag := range t.Tags {
+			categories = append(categories, escapeText(tag))
+		}
+		line("CATEGORIES", strings.Join(categories, ","))
+	}
+	if t.Recurrence != "" {
+		line("RRULE", t.Recurrence)
+	}
+	line("END", "VTODO")
+	line("END", "VCALENDAR")
+
+	return b.String()
+}
+
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// parseProperty parses an unfolded content line
+func parseProperty(line string) (*property, bool) {
+	// the value starts at the first colon that is not inside a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon <= 0 {
+		return nil, false
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	p := &property{
+		name:   strings.ToUpper(parts[0]),
+		params: map[string]string{},
+		value:  line[colon+1:],
+	}
+	for _, param := range parts[1:] {
+		key, value, ok := strings.Cut(param, "=")
+		if ok {
+			p.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+		}
+	}
+
+	return p, true
+}
+
+// parseTime parses a DATE or a DATE-TIME value, floating times and dates are taken as UTC
+func parseTime(p *property) (time.Time, error) {
+	if p.params["VALUE"] == "DATE" || len(p.value) == len(icalDate) {
+		return time.Parse(icalDate, p.value)
+	}
+	if strings.HasSuffix(p.value, "Z") {
+		return time.Parse(icalDateTimeUTC, p.value)
+	}
+
+	loc := time.UTC
+	if tzid := p.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+
+	return time.ParseInLocation(icalDateTime, p.value, loc)
+}
+
+// decode parses an iCalendar object and returns its VTODO, nested components like alarms are ignored
+func decode(data string) (*vtodo, error) {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+
+	lines := []string{}
+	for _, line := range strings.Split(data, "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	var todo *vtodo
+	collecting := false
+	stack := []string{}
+	for _, line := range lines {
+		p, ok := parseProperty(line)
+		if !ok {
+			return nil, errMalformed
+		}
+
+		switch p.name {
+		case "BEGIN":
+			comp := strings.ToUpper(p.value)
+			if comp == "VEVENT" || comp == "VJOURNAL" {
+				return nil, errUnsupportedComp
+			}
+			if comp == "VTODO" && todo == nil {
+				todo = &vtodo{}
+				collecting = true
+			}
+			stack = append(stack, comp)
+			continue
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1] != strings.ToUpper(p.value) {
+				return nil, errMalformed
+			}
+			if stack[len(stack)-1] == "VTODO" {
+				collecting = false
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		// only the properties of the first VTODO itself are used, overridden instances of recurring
+		// todos and alarms are ignored
+		if !collecting || len(stack) != 2 {
+			continue
+		}
+
+		switch p.name {
+		case "UID":
+			todo.UID = p.value
+		case "SUMMARY":
+			todo.Summary = unescapeText(p.value)
+		case "DESCRIPTION":
+			todo.Description = unescapeText(p.value)
+		case "STATUS":
+			todo.Completed = todo.Completed || strings.ToUpper(p.value) == "COMPLETED"
+		case "COMPLETED":
+			todo.Completed = true
+		case "DUE":
+			due, err := parseTime(p)
+			if err != nil {
+				return nil, errMalformed
+			}
+			todo.Due = due.Format(time.RFC3339)
+		case "PRIORITY":
+			priority, err := strconv.Atoi(p.value)
+			if err != nil {
+				return nil, errMalformed
+			}
+			todo.Priority = fromICalPriority(priority)
+		case "CATEGORIES":
+			for _, category := range splitList(p.value) {
+				if category = strings.TrimSpace(unescapeText(category)); category != "" {
+					todo.Categories = append(todo.Categories, category)
+				}
+			}
+		case "RRULE":
+			todo.RRule = p.value
+		}
+	}
+
+	if len(stack) != 0 {
+		return nil, errMalformed
+	}
+	if todo == nil {
+		return nil, errNoTodo
+	}
+	if todo.UID == "" {
+		return nil, errMalformed
+	}
+
+	return todo, nil
+}
@@ -0,0 +1,267 @@
+package caldav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// target resolves the object that the request is for, ok is false when a response was already written
+func target(w http.ResponseWriter, r *http.Request, tcm *grpc.TodoClientManager, userID string) (*collection, string, *todo.Todo, bool) {
+	collectionName, object, ok := splitPath(r.URL.EscapedPath())
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, "", nil, false
+	}
+	c, isCollection := collectionFor(collectionName)
+	if !isCollection {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, "", nil, false
+	}
+	if object == "" {
+		// collections are created from the projects of the todos and can not be changed directly, clients
+		// do not download them either as they use the reports instead
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil, "", nil, false
+	}
+
+	todos, err := listTodos(r.Context(), tcm, userID, c)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list the todos of the collection")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, "", nil, false
+	}
+
+	t, _ := findObject(todos, object)
+	return c, object, t, true
+}
+
+// matches checks the If-Match and If-None-Match preconditions of the request against the current todo
+func matches(r *http.Request, t *todo.Todo) bool {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if t == nil {
+			return false
+		}
+		if ifMatch != "*" && !containsTag(ifMatch, etag(t)) {
+			return false
+		}
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && t != nil {
+		if ifNoneMatch == "*" || containsTag(ifNoneMatch, etag(t)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsTag(header string, tag string) bool {
+	for _, value := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(value), "W/") == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// get returns the todo as an iCalendar object
+func get(w http.ResponseWriter, r *http.Request, tcm *grpc.TodoClientManager, userID string) {
+	_, _, t, ok := target(w, r, tcm, userID)
+	if !ok {
+		return
+	}
+	if t == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	data := encode(t)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", etag(t))
+	if modified := lastModified(t); modified != "" {
+		w.Header().Set("Last-Modified", modified)
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write([]byte(data))
+	}
+}
+
+// put creates or replaces a todo from an iCalendar object
+func put(w http.ResponseWriter, r *http.Request, tcm *grpc.TodoClientManager, userID string) {
+	c, name, t, ok := target(w, r, tcm, userID)
+	if !ok {
+		return
+	}
+	if !matches(r, t) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxObjectSize))
+	if err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	v, err := decode(string(body))
+	if err != nil {
+		if errors.Is(err, errNoTodo) || errors.Is(err, errUnsupportedComp) {
+			writeError(w, http.StatusForbidden, caldavName("supported-calendar-component"))
+			return
+		}
+		writeError(w, http.StatusBadRequest, caldavName("valid-calendar-data"))
+		return
+	}
+
+	created := t == nil
+	if created {
+		t, err = create(r.Context(), tcm, userID, c, name, v)
+	} else {
+		t, err = replace(r.Context(), tcm, userID, t, v)
+	}
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			writeError(w, http.StatusForbidden, caldavName("valid-calendar-object-resource"))
+		case codes.AlreadyExists:
+			writeError(w, http.StatusConflict, caldavName("no-uid-conflict"))
+		case codes.FailedPrecondition:
+			w.WriteHeader(http.StatusConflict)
+		case codes.ResourceExhausted:
+			writeError(w, http.StatusInsufficientStorage, davName("quota-not-exceeded"))
+		default:
+			log.Error().Err(err).Msg("failed to save the calendar object")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", etag(t))
+	if created {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// create creates a todo in the collection, the UID must not be used by another todo of the user
+func create(ctx context.Context, tcm *grpc.TodoClientManager, userID string, c *collection, name string, v *vtodo) (*todo.Todo, error) {
+	all, err := tcm.Client().List(ctx, &todo.ListRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range all.Todos {
+		if uid(t) == v.UID {
+			return nil, status.Error(codes.AlreadyExists, "the uid is used by another todo")
+		}
+	}
+
+	title := v.Summary
+	if title == "" {
+		title = "Untitled"
+	}
+
+	res, err := tcm.Client().Create(ctx, &todo.CreateRequest{
+		Title:        title,
+		Description:  v.Description,
+		UserId:       userID,
+		Tags:         v.Categories,
+		Priority:     v.Priority,
+		Project:      c.project,
+		DueAt:        v.Due,
+		Recurrence:   v.RRule,
+		CalendarUid:  v.UID,
+		CalendarName: name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !v.Completed {
+		return res.Todo, nil
+	}
+
+	completed := true
+	updated, err := tcm.Client().Update(ctx, &todo.UpdateRequest{
+		Id:        res.Todo.Id,
+		UserId:    userID,
+		Completed: &completed,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated.Todo, nil
+}
+
+// replace overwrites the todo with the properties of the calendar object, the project is kept as is
+func replace(ctx context.Context, tcm *grpc.TodoClientManager, userID string, t *todo.Todo, v *vtodo) (*todo.Todo, error) {
+	priority := v.Priority
+	if priority == "" {
+		priority = string(enums.Medium)
+	}
+
+	res, err := tcm.Client().Update(ctx, &todo.UpdateRequest{
+		Id:          t.Id,
+		UserId:      userID,
+		Title:       v.Summary,
+		Description: v.Description,
+		Completed:   &v.Completed,
+		Priority:    &priority,
+		DueAt:       &v.Due,
+		Recurrence:  &v.RRule,
+		Tags:        v.Categories,
+		SetTags:     true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Todo, nil
+}
+
+// remove deletes the todo
+func remove(w http.ResponseWriter, r *http.Request, tcm *grpc.TodoClientManager, userID string) {
+	_, _, t, ok := target(w, r, tcm, userID)
+	if !ok {
+		return
+	}
+	if t == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !matches(r, t) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	_, err := tcm.Client().Delete(r.Context(), &todo.DeleteRequest{
+		Id:     t.Id,
+		UserId: userID,
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		log.Error().Err(err).Msg("failed to delete the todo")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,133 @@
+package caldav
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/rs/zerolog/log"
+)
+
+// anyName captures the name of an element that is not known in advance
+type anyName struct {
+	XMLName xml.Name
+}
+
+// propList holds the names of the properties inside a prop element
+type propList struct {
+	Names []anyName `xml:",any"`
+}
+
+func (p *propList) names() []xml.Name {
+	if p == nil {
+		return nil
+	}
+
+	names := []xml.Name{}
+	for _, name := range p.Names {
+		names = append(names, name.XMLName)
+	}
+	return names
+}
+
+type propfindBody struct {
+	XMLName xml.Name  `xml:"DAV: propfind"`
+	AllProp *struct{} `xml:"DAV: allprop"`
+	Prop    *propList `xml:"DAV: prop"`
+}
+
+// readBody reads the XML body of the request into v, an empty body leaves v untouched
+func readBody(w http.ResponseWriter, r *http.Request, v any) (bool, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		return false, err
+	}
+	if len(body) == 0 {
+		return false, nil
+	}
+
+	return true, xml.Unmarshal(body, v)
+}
+
+// propfind returns the properties of the target and with a depth of 1 the properties of its members
+func propfind(w http.ResponseWriter, r *http.Request, tcm *grpc.TodoClientManager, userID string) {
+	body := propfindBody{}
+	_, err := readBody(w, r, &body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	requested := []xml.Name{}
+	if body.AllProp == nil {
+		requested = body.Prop.names()
+	}
+	depth := r.Header.Get("Depth") != "0"
+
+	collectionName, object, ok := splitPath(r.URL.EscapedPath())
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	responses := []*response{}
+
+	if collectionName == "" {
+		responses = append(responses, &response{href: Prefix + "/", props: rootProps()})
+
+		if depth {
+			collections, err := listCollections(r.Context(), tcm, userID)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to list the collections")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			for _, c := range collections {
+				todos, err := listTodos(r.Context(), tcm, userID, c)
+				if err != nil {
+					log.Error().Err(err).Msg("failed to list the todos of the collection")
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				responses = append(responses, &response{href: c.href(), props: collectionProps(c, todos)})
+			}
+		}
+
+		writeMultistatus(w, responses, requested)
+		return
+	}
+
+	c, ok := collectionFor(collectionName)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	todos, err := listTodos(r.Context(), tcm, userID, c)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list the todos of the collection")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if object != "" {
+		t, ok := findObject(todos, object)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		writeMultistatus(w, []*response{{href: objectHref(c, t), props: objectProps(t)}}, requested)
+		return
+	}
+
+	responses = append(responses, &response{href: c.href(), props: collectionProps(c, todos)})
+	if depth {
+		for _, t := range todos {
+			responses = append(responses, &response{href: objectHref(c, t), props: objectProps(t)})
+		}
+	}
+
+	writeMultistatus(w, responses, requested)
+}
@@ -0,0 +1,92 @@
+package caldav
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/rs/zerolog/log"
+)
+
+type reportBody struct {
+	XMLName xml.Name
+	Prop    *propList `xml:"DAV: prop"`
+	Hrefs   []string  `xml:"DAV: href"`
+}
+
+// report handles the calendar-query and calendar-multiget reports, the filters of a calendar-query are not
+// evaluated and every todo of the collection is returned as clients filter the results themselves
+func report(w http.ResponseWriter, r *http.Request, tcm *grpc.TodoClientManager, userID string) {
+	body := reportBody{}
+	ok, err := readBody(w, r, &body)
+	if err != nil || !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	requested := body.Prop.names()
+
+	switch body.XMLName {
+	case caldavName("calendar-query"):
+		collectionName, object, ok := splitPath(r.URL.EscapedPath())
+		c, isCollection := collectionFor(collectionName)
+		if !ok || !isCollection || object != "" {
+			writeError(w, http.StatusForbidden, davName("supported-report"))
+			return
+		}
+
+		todos, err := listTodos(r.Context(), tcm, userID, c)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to list the todos of the collection")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		responses := []*response{}
+		for _, t := range todos {
+			responses = append(responses, &response{href: objectHref(c, t), props: objectProps(t)})
+		}
+		writeMultistatus(w, responses, requested)
+	case caldavName("calendar-multiget"):
+		// the todos of every collection are only listed once even when many of its objects are requested
+		listed := map[string][]*todo.Todo{}
+
+		responses := []*response{}
+		for _, h := range body.Hrefs {
+			missing := &response{href: h, status: http.StatusNotFound}
+
+			path := h
+			if u, err := url.Parse(h); err == nil {
+				path = u.EscapedPath()
+			}
+			collectionName, object, ok := splitPath(path)
+			c, isCollection := collectionFor(collectionName)
+			if !ok || !isCollection || object == "" {
+				responses = append(responses, missing)
+				continue
+			}
+
+			todos, ok := listed[c.name]
+			if !ok {
+				todos, err = listTodos(r.Context(), tcm, userID, c)
+				if err != nil {
+					log.Error().Err(err).Msg("failed to list the todos of the collection")
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				listed[c.name] = todos
+			}
+
+			t, ok := findObject(todos, object)
+			if !ok {
+				responses = append(responses, missing)
+				continue
+			}
+			responses = append(responses, &response{href: objectHref(c, t), props: objectProps(t)})
+		}
+		writeMultistatus(w, responses, requested)
+	default:
+		writeError(w, http.StatusForbidden, davName("supported-report"))
+	}
+}
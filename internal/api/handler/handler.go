@@ -2,9 +2,13 @@
 package handler
 
 import (
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/bytedance/sonic"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
 )
 
 // JSONr is a helper function to send a JSON response
@@ -15,3 +19,41 @@ func JSONr(w http.ResponseWriter, status int, msg string) {
 		"message": msg,
 	})
 }
+
+// Quota is a helper function to send the quotas that a ResourceExhausted status reports as violated
+func Quota(w http.ResponseWriter, statusCode int, st *status.Status) {
+	violations := []map[string]string{}
+	for _, detail := range st.Details() {
+		failure, ok := detail.(*errdetails.QuotaFailure)
+		if !ok {
+			continue
+		}
+		for _, v := range failure.Violations {
+			violations = append(violations, map[string]string{
+				"subject":     v.Subject,
+				"description": v.Description,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]any{
+		"message":    st.Message(),
+		"violations": violations,
+	})
+}
+
+// RetryAfter sets the Retry-After header from the RetryInfo in the details of the status, if there is one
+func RetryAfter(w http.ResponseWriter, st *status.Status) {
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.RetryInfo)
+		if !ok {
+			continue
+		}
+
+		seconds := math.Ceil(info.RetryDelay.AsDuration().Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(int(seconds)))
+		return
+	}
+}
@@ -0,0 +1,49 @@
+// Package notification : This package is for getting the delivery history of the notifications of a user
+package notification
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/notification"
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// ListDeliveries : This function is for getting the latest deliveries of the notifications of the user,
+// the number of deliveries can be limited with the limit query parameter
+func ListDeliveries(
+	w http.ResponseWriter,
+	r *http.Request,
+	ncm *grpc.NotificationClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		limit = 0
+	}
+
+	res, err := ncm.Client().ListDeliveries(r.Context(), &notification.ListDeliveriesRequest{
+		UserId: userID,
+		Limit:  int32(limit),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the deliveries")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(res.Deliveries)
+}
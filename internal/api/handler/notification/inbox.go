@@ -0,0 +1,237 @@
+// Package notification : This package is for reading and organising the in-app inbox of a user
+package notification
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/notification"
+	"github.com/bytedance/sonic"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+type idsBody struct {
+	IDs []uint `json:"ids" validate:"required,min=1,max=100,dive,required"`
+}
+
+// decodeIDs decodes and validates the ids in the request body
+func decodeIDs(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	const (
+		maxRequestBodySize = 4 << 10
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody idsBody
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+
+		validationErrs := err.(validator.ValidationErrors)
+		handler.JSONr(w, http.StatusBadRequest, fmt.Sprintf("Please provide a valid %s", strings.ToLower(validationErrs[0].Field())))
+		return nil, false
+	}
+
+	ids := make([]string, 0, len(reqBody.IDs))
+	for _, id := range reqBody.IDs {
+		ids = append(ids, fmt.Sprint(id))
+	}
+
+	return ids, true
+}
+
+// ListInbox : This function is for getting a page of the inbox of the user, the page is selected with the
+// page_size and page_token query parameters and unread=true or archived=true narrow down the notifications
+func ListInbox(
+	w http.ResponseWriter,
+	r *http.Request,
+	ncm *grpc.NotificationClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+	query := r.URL.Query()
+
+	pageSize, err := strconv.Atoi(query.Get("page_size"))
+	if err != nil {
+		pageSize = 0
+	}
+
+	res, err := ncm.Client().ListInbox(r.Context(), &notification.ListInboxRequest{
+		UserId:     userID,
+		PageSize:   int32(pageSize),
+		PageToken:  query.Get("page_token"),
+		UnreadOnly: query.Get("unread") == "true",
+		Archived:   query.Get("archived") == "true",
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the inbox")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, "Invalid page token")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]interface{}{
+		"notifications":   res.Notifications,
+		"next_page_token": res.NextPageToken,
+		"unread_count":    res.UnreadCount,
+	})
+}
+
+// UnreadCount : This function is for getting the number of unread notifications of the user
+func UnreadCount(
+	w http.ResponseWriter,
+	r *http.Request,
+	ncm *grpc.NotificationClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := ncm.Client().UnreadCount(r.Context(), &notification.UnreadCountRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the unread notifications")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]int64{
+		"unread_count": res.UnreadCount,
+	})
+}
+
+// MarkRead : This function is for marking notifications of the user as read
+func MarkRead(
+	w http.ResponseWriter,
+	r *http.Request,
+	ncm *grpc.NotificationClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	ids, ok := decodeIDs(w, r)
+	if !ok {
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := ncm.Client().MarkRead(r.Context(), &notification.MarkReadRequest{
+		UserId: userID,
+		Ids:    ids,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to mark the notifications as read")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]int64{
+		"updated":      res.Updated,
+		"unread_count": res.UnreadCount,
+	})
+}
+
+// MarkAllRead : This function is for marking every notification of the user as read
+func MarkAllRead(
+	w http.ResponseWriter,
+	r *http.Request,
+	ncm *grpc.NotificationClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := ncm.Client().MarkAllRead(r.Context(), &notification.MarkAllReadRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to mark the notifications as read")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]int64{
+		"updated":      res.Updated,
+		"unread_count": res.UnreadCount,
+	})
+}
+
+// Archive : This function is for moving notifications of the user out of the inbox
+func Archive(
+	w http.ResponseWriter,
+	r *http.Request,
+	ncm *grpc.NotificationClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	ids, ok := decodeIDs(w, r)
+	if !ok {
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := ncm.Client().Archive(r.Context(), &notification.ArchiveRequest{
+		UserId: userID,
+		Ids:    ids,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to archive the notifications")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]int64{
+		"updated":      res.Updated,
+		"unread_count": res.UnreadCount,
+	})
+}
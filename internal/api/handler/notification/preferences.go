@@ -0,0 +1,143 @@
+// Package notification : This package is for managing the notification preferences of a user
+package notification
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/notification"
+	"github.com/bytedance/sonic"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+type preferences struct {
+	Email         bool   `json:"email"`
+	Webhook       bool   `json:"webhook"`
+	Inbox         bool   `json:"inbox"`
+	WebhookURL    string `json:"webhook_url" validate:"omitempty,url,max=255"`
+	WebhookSecret string `json:"webhook_secret,omitempty" validate:"-"`
+	QuietStart    string `json:"quiet_start" validate:"omitempty,len=5"`
+	QuietEnd      string `json:"quiet_end" validate:"omitempty,len=5"`
+	TimeZone      string `json:"time_zone" validate:"omitempty,max=64"`
+}
+
+func fromPB(p *notification.Preferences) *preferences {
+	return &preferences{
+		Email:         p.Email,
+		Webhook:       p.Webhook,
+		Inbox:         p.Inbox,
+		WebhookURL:    p.WebhookUrl,
+		WebhookSecret: p.WebhookSecret,
+		QuietStart:    p.QuietStart,
+		QuietEnd:      p.QuietEnd,
+		TimeZone:      p.TimeZone,
+	}
+}
+
+// GetPreferences : This function is for getting the notification preferences of the user
+func GetPreferences(
+	w http.ResponseWriter,
+	r *http.Request,
+	ncm *grpc.NotificationClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := ncm.Client().GetPreferences(r.Context(), &notification.GetPreferencesRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the preferences")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(fromPB(res.Preferences))
+}
+
+// SetPreferences : This function is for setting the notification channels and the quiet hours of the user,
+// the webhook secret is generated when a webhook url is set for the first time
+func SetPreferences(
+	w http.ResponseWriter,
+	r *http.Request,
+	ncm *grpc.NotificationClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 10
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody preferences
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+
+		validationErrs := err.(validator.ValidationErrors)
+		handler.JSONr(w, http.StatusBadRequest, fmt.Sprintf("Please provide a valid %s", strings.ToLower(validationErrs[0].Field())))
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := ncm.Client().SetPreferences(r.Context(), &notification.SetPreferencesRequest{
+		UserId: userID,
+		Preferences: &notification.Preferences{
+			Email:      reqBody.Email,
+			Webhook:    reqBody.Webhook,
+			Inbox:      reqBody.Inbox,
+			WebhookUrl: reqBody.WebhookURL,
+			QuietStart: reqBody.QuietStart,
+			QuietEnd:   reqBody.QuietEnd,
+			TimeZone:   reqBody.TimeZone,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to set the preferences")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, st.Message())
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(fromPB(res.Preferences))
+}
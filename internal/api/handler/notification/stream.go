@@ -0,0 +1,96 @@
+// Package notification : This package is for pushing new notifications to connected clients
+package notification
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	rdbs "github.com/VinukaThejana/todoapp/internal/redis"
+	"github.com/VinukaThejana/todoapp/pkg/notification"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// heartbeat keeps idle connections from being closed by proxies
+const heartbeat = 25 * time.Second
+
+// Stream : This function is for streaming the new notifications of the user as server-sent events, the
+// client first gets an unread event with the unread count and then a notification event for each new
+// notification as it arrives
+func Stream(
+	w http.ResponseWriter,
+	r *http.Request,
+	ncm *grpc.NotificationClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		handler.JSONr(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	userIDStr := r.Context().Value(middleware.UserID).(string)
+	userID, err := strconv.ParseUint(userIDStr, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	// subscribe before reading the unread count so that nothing that arrives in between is missed
+	sub := rdb.Subscribe(r.Context(), rdbs.NotificationChannel(uint(userID)))
+	defer sub.Close()
+
+	_, err = sub.Receive(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("failed to subscribe to the notifications")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	res, err := ncm.Client().UnreadCount(r.Context(), &notification.UnreadCountRequest{
+		UserId: userIDStr,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the unread notifications")
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "event: unread\ndata: {\"unread_count\":%d}\n\n", res.UnreadCount)
+	flusher.Flush()
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "event: notification\ndata: %s\n\n", msg.Payload)
+			flusher.Flush()
+		}
+	}
+}
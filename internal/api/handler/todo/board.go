@@ -0,0 +1,47 @@
+// Package todo : This package is for getting the todos of a user grouped by their state
+package todo
+
+import (
+	"net/http"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// Board : This function is for getting the todos of the user grouped by their workflow state
+func Board(
+	w http.ResponseWriter,
+	r *http.Request,
+	tcm *grpc.TodoClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := tcm.Client().Board(r.Context(), &todo.BoardRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the board")
+		if status.Code(err) == codes.NotFound {
+			handler.JSONr(w, http.StatusNotFound, "Workspace not found")
+			return
+		}
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(res.Columns)
+}
@@ -15,6 +15,8 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
 )
 
@@ -32,9 +34,15 @@ func Create(
 	)
 
 	type body struct {
-		Title       string `json:"title" validate:"required,min=4,max=30"`
-		Description string `json:"description" validate:"required,min=4,max=200"`
-		Content     string `json:"content" validate:"required,min=4,max=1000"`
+		Title       string   `json:"title" validate:"required,min=4,max=30"`
+		Description string   `json:"description" validate:"required,min=4,max=200"`
+		Content     string   `json:"content" validate:"required,min=4,max=1000"`
+		State       string   `json:"state" validate:"omitempty,max=50"`
+		Tags        []string `json:"tags" validate:"omitempty,max=20,dive,min=1,max=50"`
+		Priority    string   `json:"priority" validate:"omitempty,oneof=low medium high urgent"`
+		Project     string   `json:"project" validate:"omitempty,max=50"`
+		DueAt       string   `json:"due_at" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+		Recurrence  string   `json:"recurrence" validate:"omitempty,max=100"`
 	}
 
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
@@ -66,11 +74,38 @@ func Create(
 		Description: reqBody.Description,
 		Content:     reqBody.Content,
 		UserId:      userID,
+		State:       reqBody.State,
+		Tags:        reqBody.Tags,
+		Priority:    reqBody.Priority,
+		Project:     reqBody.Project,
+		DueAt:       reqBody.DueAt,
+		Recurrence:  reqBody.Recurrence,
 	})
 	if err != nil {
-		log.Error().Err(err)
-		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
-		return
+		log.Error().Err(err).Msg("failed to create the todo")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, st.Message())
+			return
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, st.Message())
+			return
+		case codes.PermissionDenied:
+			handler.JSONr(w, http.StatusForbidden, st.Message())
+			return
+		case codes.ResourceExhausted:
+			handler.Quota(w, http.StatusForbidden, st)
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
 	}
 
 	handler.JSONr(w, http.StatusCreated, "Todo created")
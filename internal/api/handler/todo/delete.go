@@ -80,6 +80,9 @@ func Delete(
 		case codes.NotFound:
 			handler.JSONr(w, http.StatusNotFound, "Todo not found")
 			return
+		case codes.PermissionDenied:
+			handler.JSONr(w, http.StatusForbidden, st.Message())
+			return
 		default:
 			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
 			return
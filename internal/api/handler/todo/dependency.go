@@ -0,0 +1,156 @@
+// Package todo : This package is for adding and removing dependencies between todos
+package todo
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/bytedance/sonic"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+type dependencyBody struct {
+	ID          uint `json:"id" validate:"required"`
+	BlockedByID uint `json:"blocked_by_id" validate:"required"`
+}
+
+// decodeDependency decodes and validates the request body of the dependency endpoints
+func decodeDependency(w http.ResponseWriter, r *http.Request) (*dependencyBody, bool) {
+	const (
+		maxRequestBodySize = 1 << 10
+	)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody dependencyBody
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+
+		validationErrs := err.(validator.ValidationErrors)
+		handler.JSONr(w, http.StatusBadRequest, fmt.Sprintf("Please provide a valid %s", strings.ToLower(validationErrs[0].Field())))
+		return nil, false
+	}
+
+	return &reqBody, true
+}
+
+// AddDependency : This function is for marking a todo as blocked by another todo
+func AddDependency(
+	w http.ResponseWriter,
+	r *http.Request,
+	tcm *grpc.TodoClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	reqBody, ok := decodeDependency(w, r)
+	if !ok {
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	_, err := tcm.Client().AddDependency(r.Context(), &todo.AddDependencyRequest{
+		Id:          fmt.Sprint(reqBody.ID),
+		BlockedById: fmt.Sprint(reqBody.BlockedByID),
+		UserId:      userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to add the dependency")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, "A todo cannot block itself")
+			return
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, "Todo not found")
+			return
+		case codes.AlreadyExists:
+			handler.JSONr(w, http.StatusConflict, "Dependency already exists")
+			return
+		case codes.FailedPrecondition:
+			handler.JSONr(w, http.StatusConflict, "The dependency would create a cycle")
+			return
+		case codes.PermissionDenied:
+			handler.JSONr(w, http.StatusForbidden, st.Message())
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	handler.JSONr(w, http.StatusCreated, "Dependency added successfully")
+}
+
+// RemoveDependency : This function is for removing a dependency between two todos
+func RemoveDependency(
+	w http.ResponseWriter,
+	r *http.Request,
+	tcm *grpc.TodoClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	reqBody, ok := decodeDependency(w, r)
+	if !ok {
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	_, err := tcm.Client().RemoveDependency(r.Context(), &todo.RemoveDependencyRequest{
+		Id:          fmt.Sprint(reqBody.ID),
+		BlockedById: fmt.Sprint(reqBody.BlockedByID),
+		UserId:      userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to remove the dependency")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, "Dependency not found")
+			return
+		case codes.PermissionDenied:
+			handler.JSONr(w, http.StatusForbidden, st.Message())
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	handler.JSONr(w, http.StatusOK, "Dependency removed successfully")
+}
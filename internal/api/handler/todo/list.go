@@ -5,16 +5,20 @@ import (
 	"net/http"
 
 	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
 	"github.com/VinukaThejana/todoapp/internal/api/middleware"
 	env "github.com/VinukaThejana/todoapp/internal/config"
 	"github.com/VinukaThejana/todoapp/pkg/todo"
 	"github.com/bytedance/sonic"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
 )
 
-// List: This function is for getting all the todos under a given user
+// List: This function is for getting all the todos under a given user, the todos can be narrowed down
+// with the query parameter or with the id of a saved filter in the filter parameter
 func List(
 	w http.ResponseWriter,
 	r *http.Request,
@@ -24,16 +28,33 @@ func List(
 	rdb *redis.Client,
 ) {
 	userID := r.Context().Value(middleware.UserID).(string)
+	query := r.URL.Query()
 
 	res, err := tcm.Client().List(r.Context(), &todo.ListRequest{
-		UserId: userID,
+		UserId:   userID,
+		Query:    query.Get("query"),
+		FilterId: query.Get("filter"),
+		TimeZone: query.Get("time_zone"),
 	})
 	if err != nil {
-		log.Error().Err(err).Msg("failed to update the todo")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		sonic.ConfigDefault.NewEncoder(w).Encode(res.Todos)
-		return
+		log.Error().Err(err).Msg("failed to get the todos")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, st.Message())
+			return
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, "Filter not found")
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
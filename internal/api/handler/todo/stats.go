@@ -0,0 +1,64 @@
+// Package todo : This package is for getting the productivity statistics of a user
+package todo
+
+import (
+	"net/http"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// Stats : This function is for getting the productivity statistics of the user for a date range,
+// the range is given with the from and to query parameters in the YYYY-MM-DD format
+func Stats(
+	w http.ResponseWriter,
+	r *http.Request,
+	tcm *grpc.TodoClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+	query := r.URL.Query()
+
+	res, err := tcm.Client().Stats(r.Context(), &todo.StatsRequest{
+		UserId:      userID,
+		From:        query.Get("from"),
+		To:          query.Get("to"),
+		Granularity: query.Get("granularity"),
+		TimeZone:    query.Get("time_zone"),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the statistics")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, st.Message())
+			return
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, st.Message())
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(res)
+}
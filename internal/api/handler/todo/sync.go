@@ -0,0 +1,175 @@
+// Package todo : This package is for syncing the todos of offline clients
+package todo
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/bytedance/sonic"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// Sync : This function is for applying the changes that a client made while it was offline and for
+// returning the todos that were changed or deleted since the given sync token
+func Sync(
+	w http.ResponseWriter,
+	r *http.Request,
+	tcm *grpc.TodoClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 22
+	)
+
+	type change struct {
+		ID          string   `json:"id" validate:"omitempty,numeric"`
+		ClientID    string   `json:"client_id" validate:"max=100"`
+		Deleted     bool     `json:"deleted"`
+		Title       string   `json:"title" validate:"max=30"`
+		Description string   `json:"description" validate:"max=200"`
+		Content     string   `json:"content" validate:"max=1000"`
+		Completed   bool     `json:"completed"`
+		State       string   `json:"state" validate:"omitempty,max=50"`
+		Tags        []string `json:"tags" validate:"omitempty,max=20,dive,min=1,max=50"`
+		Priority    string   `json:"priority" validate:"omitempty,oneof=low medium high urgent"`
+		Project     string   `json:"project" validate:"omitempty,max=50"`
+		DueAt       string   `json:"due_at" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+		Recurrence  string   `json:"recurrence" validate:"omitempty,max=100"`
+	}
+	type body struct {
+		SyncToken string   `json:"sync_token" validate:"max=100"`
+		Changes   []change `json:"changes" validate:"max=500,dive"`
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody body
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+
+		validationErrs := err.(validator.ValidationErrors)
+		handler.JSONr(w, http.StatusBadRequest, fmt.Sprintf("Please provide a valid %s", strings.ToLower(validationErrs[0].Field())))
+		return
+	}
+
+	// the route only needs todo:read so that clients can pull, pushing changes needs todo:write
+	if len(reqBody.Changes) > 0 && !middleware.HasScope(r, enums.TodoWrite) {
+		handler.JSONr(w, http.StatusForbidden, fmt.Sprintf("The personal access token needs the %s scope", enums.TodoWrite))
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	changes := []*todo.TodoChange{}
+	for _, c := range reqBody.Changes {
+		changes = append(changes, &todo.TodoChange{
+			Id:          c.ID,
+			ClientId:    c.ClientID,
+			Deleted:     c.Deleted,
+			Title:       c.Title,
+			Description: c.Description,
+			Content:     c.Content,
+			Completed:   c.Completed,
+			State:       c.State,
+			Priority:    c.Priority,
+			Project:     c.Project,
+			DueAt:       c.DueAt,
+			Recurrence:  c.Recurrence,
+			Tags:        c.Tags,
+		})
+	}
+
+	res, err := tcm.Client().Sync(r.Context(), &todo.SyncRequest{
+		UserId:    userID,
+		SyncToken: reqBody.SyncToken,
+		Changes:   changes,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to sync the todos")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, st.Message())
+			return
+		case codes.FailedPrecondition:
+			handler.JSONr(w, http.StatusGone, "The sync token has expired, sync again without a token")
+			return
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, st.Message())
+			return
+		case codes.PermissionDenied:
+			handler.JSONr(w, http.StatusForbidden, st.Message())
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	type conflict struct {
+		ID       string     `json:"id,omitempty"`
+		ClientID string     `json:"client_id,omitempty"`
+		Reason   string     `json:"reason"`
+		Message  string     `json:"message"`
+		Todo     *todo.Todo `json:"todo,omitempty"`
+	}
+	conflicts := []conflict{}
+	for _, c := range res.Conflicts {
+		conflicts = append(conflicts, conflict{
+			ID:       c.Id,
+			ClientID: c.ClientId,
+			Reason:   c.Reason,
+			Message:  c.Message,
+			Todo:     c.Todo,
+		})
+	}
+
+	created := map[string]string{}
+	for _, c := range res.Created {
+		if c.ClientId != "" {
+			created[c.ClientId] = c.Id
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(map[string]any{
+		"sync_token": res.SyncToken,
+		"full":       res.Full,
+		"todos":      res.Todos,
+		"deleted":    res.Deleted,
+		"created":    created,
+		"conflicts":  conflicts,
+	})
+	return
+}
@@ -38,7 +38,8 @@ func Update(
 		Title       string `json:"title" validate:"omitempty,min=4,max=30"`
 		Description string `json:"description" validate:"omitempty,min=4,max=200"`
 		Content     string `json:"content" validate:"omitempty,min=4,max=1000"`
-		IsCompleted bool   `json:"is_completed" validate:"omitempty,boolean"`
+		IsCompleted *bool  `json:"is_completed" validate:"omitempty,boolean"`
+		State       string `json:"state" validate:"omitempty,max=50"`
 	}
 
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
@@ -78,11 +79,14 @@ func Update(
 	if reqBody.Content != "" {
 		todo.Content = reqBody.Content
 	}
-	if reqBody.IsCompleted {
-		todo.Completed = true
+	if reqBody.IsCompleted != nil {
+		todo.Completed = reqBody.IsCompleted
+	}
+	if reqBody.State != "" {
+		todo.State = reqBody.State
 	}
 
-	_, err = tcm.Client().Update(r.Context(), &todo)
+	res, err := tcm.Client().Update(r.Context(), &todo)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to update the todo")
 		st, ok := status.FromError(err)
@@ -95,11 +99,28 @@ func Update(
 		case codes.NotFound:
 			handler.JSONr(w, http.StatusNotFound, "Todo not found")
 			return
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, "Please provide a valid state")
+			return
+		case codes.FailedPrecondition:
+			handler.JSONr(w, http.StatusConflict, st.Message())
+			return
+		case codes.PermissionDenied:
+			handler.JSONr(w, http.StatusForbidden, st.Message())
+			return
+		case codes.ResourceExhausted:
+			handler.Quota(w, http.StatusForbidden, st)
+			return
 		default:
 			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
 			return
 		}
 	}
 
+	if res.Message != "" {
+		handler.JSONr(w, http.StatusCreated, res.Message)
+		return
+	}
+
 	handler.JSONr(w, http.StatusCreated, "Todo updated successfully")
 }
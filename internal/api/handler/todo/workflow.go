@@ -0,0 +1,152 @@
+// Package todo : This package is for getting and replacing the workflow of a user
+package todo
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	"github.com/VinukaThejana/todoapp/internal/api/middleware"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/bytedance/sonic"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// GetWorkflow : This function is for getting the workflow of the user
+func GetWorkflow(
+	w http.ResponseWriter,
+	r *http.Request,
+	tcm *grpc.TodoClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	res, err := tcm.Client().GetWorkflow(r.Context(), &todo.GetWorkflowRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the workflow")
+		if status.Code(err) == codes.NotFound {
+			handler.JSONr(w, http.StatusNotFound, "Workspace not found")
+			return
+		}
+		handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sonic.ConfigDefault.NewEncoder(w).Encode(res.Workflow)
+}
+
+// SetWorkflow : This function is for replacing the workflow of the user
+func SetWorkflow(
+	w http.ResponseWriter,
+	r *http.Request,
+	tcm *grpc.TodoClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	const (
+		maxRequestBodySize = 1 << 14
+	)
+
+	type state struct {
+		Name     string `json:"name" validate:"required,min=1,max=50"`
+		Terminal bool   `json:"terminal"`
+	}
+	type transition struct {
+		From string `json:"from" validate:"required,min=1,max=50"`
+		To   string `json:"to" validate:"required,min=1,max=50"`
+	}
+	type body struct {
+		Name        string       `json:"name" validate:"required,min=1,max=50"`
+		States      []state      `json:"states" validate:"required,min=1,max=20,dive"`
+		Transitions []transition `json:"transitions" validate:"max=400,dive"`
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	var reqBody body
+
+	err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		log.Error().Err(err)
+		handler.JSONr(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	validate := validator.New()
+	err = validate.Struct(reqBody)
+	if err != nil {
+		log.Error().Err(err).Msg("validation failed")
+
+		validationErrs := err.(validator.ValidationErrors)
+		handler.JSONr(w, http.StatusBadRequest, fmt.Sprintf("Please provide a valid %s", strings.ToLower(validationErrs[0].Field())))
+		return
+	}
+
+	workflow := &todo.Workflow{
+		Name: reqBody.Name,
+	}
+	for i, state := range reqBody.States {
+		workflow.States = append(workflow.States, &todo.WorkflowState{
+			Name:     state.Name,
+			Position: int32(i),
+			Terminal: state.Terminal,
+		})
+	}
+	for _, transition := range reqBody.Transitions {
+		workflow.Transitions = append(workflow.Transitions, &todo.WorkflowTransition{
+			From: transition.From,
+			To:   transition.To,
+		})
+	}
+
+	userID := r.Context().Value(middleware.UserID).(string)
+
+	_, err = tcm.Client().SetWorkflow(r.Context(), &todo.SetWorkflowRequest{
+		UserId:   userID,
+		Workflow: workflow,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to update the workflow")
+		st, ok := status.FromError(err)
+		if !ok {
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch st.Code() {
+		case codes.InvalidArgument:
+			handler.JSONr(w, http.StatusBadRequest, st.Message())
+			return
+		case codes.FailedPrecondition:
+			handler.JSONr(w, http.StatusConflict, "Move the todos out of the removed states first")
+			return
+		case codes.NotFound:
+			handler.JSONr(w, http.StatusNotFound, st.Message())
+			return
+		case codes.PermissionDenied:
+			handler.JSONr(w, http.StatusForbidden, st.Message())
+			return
+		default:
+			handler.JSONr(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	handler.JSONr(w, http.StatusOK, "Workflow updated successfully")
+}
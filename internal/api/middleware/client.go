@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/internal/lib"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/metadata"
+	"gorm.io/gorm"
+)
+
+// ClientIP is the context key of the IP address of the client
+const ClientIP = "client_ip"
+
+// maxUserAgentSize keeps clients from filling the sessions with huge user agents
+const maxUserAgentSize = 512
+
+// trustedProxies parses the comma separated list of IP addresses and CIDR ranges of the proxies that are
+// allowed to set the X-Forwarded-For header
+func trustedProxies(e *env.Env) []*net.IPNet {
+	proxies := []*net.IPNet{}
+	for _, proxy := range strings.Split(e.TrustedProxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			log.Error().Err(err).Str("proxy", proxy).Msg("invalid trusted proxy")
+			continue
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies
+}
+
+func isTrusted(ip net.IP, proxies []*net.IPNet) bool {
+	for _, proxy := range proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIP returns the IP address of the client, the X-Forwarded-For header is only followed while the hops
+// are trusted proxies since anyone else can put whatever they want in it
+func clientIP(r *http.Request, proxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if !isTrusted(ip, proxies) {
+		return ip.String()
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+
+		ip = hop
+		if !isTrusted(hop, proxies) {
+			break
+		}
+	}
+
+	return ip.String()
+}
+
+// ClientInfo is a middleware that passes the IP address and the user agent of the client to the services in
+// the gRPC metadata, the IP address is also assigned to the context.
+func ClientInfo(next http.Handler, e *env.Env, db *gorm.DB, rdb *redis.Client) http.Handler {
+	proxies := trustedProxies(e)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r, proxies)
+
+		// gRPC only allows printable ASCII in the metadata
+		userAgent := strings.Map(func(c rune) rune {
+			if c < ' ' || c > '~' {
+				return -1
+			}
+			return c
+		}, r.UserAgent())
+		if len(userAgent) > maxUserAgentSize {
+			userAgent = userAgent[:maxUserAgentSize]
+		}
+
+		ctx := context.WithValue(r.Context(), ClientIP, ip)
+		ctx = metadata.AppendToOutgoingContext(
+			ctx,
+			lib.ClientIPMetadataKey, ip,
+			lib.UserAgentMetadataKey, userAgent,
+		)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -3,16 +3,30 @@ package middleware
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
 	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/internal/lib"
+	"github.com/VinukaThejana/todoapp/internal/quota"
+	"github.com/VinukaThejana/todoapp/internal/ratelimit"
 	"github.com/VinukaThejana/todoapp/pkg/auth"
 	"github.com/bytedance/sonic"
+	"github.com/go-chi/chi/v5"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
 )
@@ -20,6 +34,16 @@ import (
 const (
 	RefreshToken = "todoapp_refresh_token"
 	UserID       = "user_id"
+	SessionID    = "session_id"
+	Role         = "role"
+
+	// scopedRoutes is the context key that marks the routes which personal access tokens can use
+	scopedRoutes = "scoped_routes"
+	// tokenScopes is the context key of the scopes of the personal access token of the request
+	tokenScopes = "token_scopes"
+
+	// WorkspaceHeader is the header that clients use to choose the active workspace
+	WorkspaceHeader = "X-Workspace-ID"
 )
 
 // ContentJSON is a middleware that checks if the content type is application/json
@@ -45,6 +69,69 @@ func ContentJSON(next http.Handler) http.Handler {
 	})
 }
 
+// Workspace is a middleware that passes the active workspace to the todo service in the gRPC metadata, the
+// workspace is taken from the path of the request or else from the X-Workspace-ID header. Requests without
+// a workspace work on the personal todos of the user.
+func Workspace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		workspaceID := chi.URLParam(r, "workspace")
+		if workspaceID == "" {
+			workspaceID = r.Header.Get(WorkspaceHeader)
+		}
+		if workspaceID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		for _, c := range workspaceID {
+			if c < '0' || c > '9' {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				sonic.ConfigDefault.NewEncoder(w).Encode(
+					map[string]interface{}{
+						"message": "invalid workspace id",
+					})
+				return
+			}
+		}
+
+		ctx := metadata.AppendToOutgoingContext(r.Context(), lib.WorkspaceMetadataKey, workspaceID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// TodoScopes is a middleware that lets personal access tokens use the todo routes, each route then requires the
+// scope that it needs with Scope. It has to run before Auth, which turns personal access tokens away from the
+// routes that do not take them.
+func TodoScopes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), scopedRoutes, true)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Scope is a middleware that only lets personal access tokens that were created with the scope use the route, the
+// other tokens are let through. It has to run after Auth.
+func Scope(scope enums.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasScope(r, scope) {
+				handler.JSONr(w, http.StatusForbidden, fmt.Sprintf("The personal access token needs the %s scope", scope))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HasScope checks if the request can do what the scope allows, only personal access tokens are limited to the
+// scopes that they were created with. It is used by the routes that need a scope depending on the request.
+func HasScope(r *http.Request, scope enums.Scope) bool {
+	scopes, ok := r.Context().Value(tokenScopes).([]string)
+	return !ok || slices.Contains(scopes, string(scope))
+}
+
 // RefreshTokenPresent is a middleware that checks if the refresh token is present in the request
 func RefreshTokenPresent(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -60,8 +147,9 @@ func RefreshTokenPresent(next http.Handler) http.Handler {
 	})
 }
 
-// Auth is a middleware that validates the access token and assigns the user id of the requesting
-// user to the context if the access token is valid.
+// Auth is a middleware that validates the access token or the personal access token and assigns the user id, the
+// session id and the role of the requesting user to the context if the token is valid. Personal access tokens
+// are only accepted on the routes that take them, where Scope checks the scopes that they were created with.
 func Auth(next http.Handler, acm *grpc.AuthClientManager, e *env.Env, db *gorm.DB, rdb *redis.Client) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("Authorization")
@@ -71,9 +159,102 @@ func Auth(next http.Handler, acm *grpc.AuthClientManager, e *env.Env, db *gorm.D
 			return
 		}
 		authToken := strings.TrimPrefix(header, "Bearer ")
+		isPersonalAccessToken := strings.HasPrefix(authToken, lib.PersonalAccessTokenPrefix)
+
+		var res *auth.ValidateResponse
+		var err error
+		if isPersonalAccessToken {
+			res, err = acm.Client().ValidatePersonalAccessToken(r.Context(), &auth.ValidatePersonalAccessTokenRequest{
+				Token: authToken,
+			})
+		} else {
+			res, err = acm.Client().Validate(r.Context(), &auth.ValidateRequest{
+				AccessToken: authToken,
+			})
+		}
+		if err != nil || !res.IsValid || !res.Success {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Error().Err(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			switch st.Code() {
+			case codes.Unauthenticated:
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		ctx := r.Context()
+		if isPersonalAccessToken {
+			if scoped, _ := ctx.Value(scopedRoutes).(bool); !scoped {
+				handler.JSONr(w, http.StatusForbidden, "Personal access tokens can not be used here")
+				return
+			}
+			ctx = context.WithValue(ctx, tokenScopes, res.Scopes)
+		}
 
-		res, err := acm.Client().Validate(r.Context(), &auth.ValidateRequest{
-			AccessToken: authToken,
+		ctx = context.WithValue(ctx, UserID, res.UserId)
+		ctx = context.WithValue(ctx, SessionID, res.SessionId)
+		ctx = context.WithValue(ctx, Role, res.Role)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Roles is a middleware that only lets users with one of the roles through, the user is passed to the admin
+// service in the gRPC metadata so that it can check the role again. It has to run after Auth.
+func Roles(roles ...enums.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, _ := r.Context().Value(Role).(string)
+			if !slices.Contains(roles, enums.Role(role)) {
+				handler.JSONr(w, http.StatusForbidden, "You are not allowed to do this")
+				return
+			}
+
+			userID := r.Context().Value(UserID).(string)
+			ctx := metadata.AppendToOutgoingContext(r.Context(), lib.ActorMetadataKey, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// AppPasswordAuth is a middleware that validates the username and the app password that are sent with
+// basic authentication and assigns the user id of the requesting user to the context if they are valid.
+// It is used by clients like CalDAV apps that can not go through the login flow. The failed attempts are
+// limited by the IP address of the client and by the account, so it has to run after ClientInfo.
+func AppPasswordAuth(next http.Handler, acm *grpc.AuthClientManager, e *env.Env, db *gorm.DB, rdb *redis.Client) http.Handler {
+	failures := ratelimit.New(rdb, "app_password", ratelimit.AppPasswordRule(e))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="todoapp", charset="UTF-8"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		ip, _ := r.Context().Value(ClientIP).(string)
+		account := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(username))))
+		keys := []string{"ip:" + ip, "account:" + hex.EncodeToString(account[:])}
+		if !failures.Disabled() {
+			for _, key := range keys {
+				if limited := failures.Check(r.Context(), key); !limited.Allowed {
+					w.Header().Set("Retry-After", seconds(max(limited.RetryAfter, time.Second)))
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+			}
+		}
+
+		res, err := acm.Client().ValidateAppPassword(r.Context(), &auth.ValidateAppPasswordRequest{
+			Username: username,
+			Password: password,
 		})
 		if err != nil || !res.IsValid || !res.Success {
 			st, ok := status.FromError(err)
@@ -85,6 +266,12 @@ func Auth(next http.Handler, acm *grpc.AuthClientManager, e *env.Env, db *gorm.D
 
 			switch st.Code() {
 			case codes.Unauthenticated:
+				if !failures.Disabled() {
+					for _, key := range keys {
+						failures.Allow(r.Context(), key)
+					}
+				}
+				w.Header().Set("WWW-Authenticate", `Basic realm="todoapp", charset="UTF-8"`)
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			default:
@@ -97,3 +284,41 @@ func Auth(next http.Handler, acm *grpc.AuthClientManager, e *env.Env, db *gorm.D
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// Quota is a middleware that counts the API requests of the user and rejects the requests that go over the
+// daily limit of their plan. It has to run after Auth. Requests are let through when the count can not be
+// checked so that an outage of Redis does not take the API down with it.
+func Quota(next http.Handler, e *env.Env, db *gorm.DB, rdb *redis.Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.ParseUint(r.Context().Value(UserID).(string), 10, 64)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to parse user id")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		requests, err := quota.CountRequest(r.Context(), rdb, uint(userID))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to count the request")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		_, limits, err := quota.Of(db, uint(userID))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get the quotas")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var exceeded *quota.Exceeded
+		if err := quota.CheckRequest(limits, requests); errors.As(err, &exceeded) {
+			now := time.Now()
+			w.Header().Set("Retry-After", strconv.Itoa(int(quota.ResetAt(now).Sub(now).Seconds())+1))
+			handler.Quota(w, http.StatusTooManyRequests, exceeded.Status())
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
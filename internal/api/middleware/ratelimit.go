@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/api/handler"
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/internal/ratelimit"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// seconds rounds the duration up to whole seconds for the headers
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// rateLimit rejects the requests of a key that go over the limit of the limiter with 429, the state of the limit
+// is sent in the RateLimit-* headers
+func rateLimit(next http.Handler, limiter *ratelimit.Limiter, key func(r *http.Request) string) http.Handler {
+	if limiter.Disabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := limiter.Allow(r.Context(), key(r))
+
+		w.Header().Set("RateLimit-Limit", strconv.FormatInt(res.Limit, 10))
+		w.Header().Set("RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+		w.Header().Set("RateLimit-Reset", seconds(res.Reset))
+		if !res.Allowed {
+			w.Header().Set("Retry-After", seconds(max(res.RetryAfter, time.Second)))
+			handler.JSONr(w, http.StatusTooManyRequests, "Too many requests, please try again later")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimitAuth is a middleware that rate limits the requests by the IP address of the client. It has to run after
+// ClientInfo.
+func RateLimitAuth(next http.Handler, e *env.Env, db *gorm.DB, rdb *redis.Client) http.Handler {
+	return rateLimit(next, ratelimit.New(rdb, "auth", ratelimit.AuthRule(e)), func(r *http.Request) string {
+		ip, _ := r.Context().Value(ClientIP).(string)
+		return ip
+	})
+}
+
+// RateLimitTodo is a middleware that rate limits the requests by the user. It has to run after Auth.
+func RateLimitTodo(next http.Handler, e *env.Env, db *gorm.DB, rdb *redis.Client) http.Handler {
+	return rateLimit(next, ratelimit.New(rdb, "todo", ratelimit.TodoRule(e)), func(r *http.Request) string {
+		userID, _ := r.Context().Value(UserID).(string)
+		return userID
+	})
+}
+
+// RateLimitCalDAV is a middleware that rate limits the CalDAV requests by the user. It has to run after
+// AppPasswordAuth.
+func RateLimitCalDAV(next http.Handler, e *env.Env, db *gorm.DB, rdb *redis.Client) http.Handler {
+	return rateLimit(next, ratelimit.New(rdb, "caldav", ratelimit.CalDAVRule(e)), func(r *http.Request) string {
+		userID, _ := r.Context().Value(UserID).(string)
+		return userID
+	})
+}
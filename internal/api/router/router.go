@@ -2,11 +2,18 @@
 package router
 
 import (
+	"net/http"
+
 	"github.com/VinukaThejana/todoapp/internal/api/grpc"
+	"github.com/VinukaThejana/todoapp/internal/api/handler/account"
+	"github.com/VinukaThejana/todoapp/internal/api/handler/admin"
 	"github.com/VinukaThejana/todoapp/internal/api/handler/auth"
+	"github.com/VinukaThejana/todoapp/internal/api/handler/caldav"
+	"github.com/VinukaThejana/todoapp/internal/api/handler/notification"
 	"github.com/VinukaThejana/todoapp/internal/api/handler/todo"
 	m "github.com/VinukaThejana/todoapp/internal/api/middleware"
 	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/internal/enums"
 	"github.com/VinukaThejana/todoapp/internal/lib"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -17,16 +24,30 @@ import (
 func Init(
 	acm *grpc.AuthClientManager,
 	tcm *grpc.TodoClientManager,
+	ncm *grpc.NotificationClientManager,
 	e *env.Env,
 	db *gorm.DB,
 	rdb *redis.Client,
 ) *chi.Mux {
+	// the WebDAV methods that CalDAV clients use have to be known before the routes are registered
+	chi.RegisterMethod("PROPFIND")
+	chi.RegisterMethod("REPORT")
+
 	r := chi.NewRouter()
 
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
+	r.Use(lib.WrapMiddleware(
+		m.ClientInfo,
+		e, db, rdb,
+	))
 
 	r.Route("/auth", func(r chi.Router) {
+		r.Use(lib.WrapMiddleware(
+			m.RateLimitAuth,
+			e, db, rdb,
+		))
+
 		r.Group(func(r chi.Router) {
 			r.Use(m.ContentJSON)
 			r.Post("/register", lib.WrapHandlerWAuthClient(
@@ -37,8 +58,49 @@ func Init(
 				auth.Login,
 				acm, e, db, rdb,
 			))
+			r.Post("/verify-email", lib.WrapHandlerWAuthClient(
+				auth.VerifyEmail,
+				acm, e, db, rdb,
+			))
+			r.Post("/verify-email/resend", lib.WrapHandlerWAuthClient(
+				auth.ResendVerification,
+				acm, e, db, rdb,
+			))
+			r.Post("/password/forgot", lib.WrapHandlerWAuthClient(
+				auth.ForgotPassword,
+				acm, e, db, rdb,
+			))
+			r.Post("/password/reset", lib.WrapHandlerWAuthClient(
+				auth.ResetPassword,
+				acm, e, db, rdb,
+			))
+			r.Post("/2fa/verify", lib.WrapHandlerWAuthClient(
+				auth.VerifySecondFactor,
+				acm, e, db, rdb,
+			))
+			r.Post("/passkey/login/begin", lib.WrapHandlerWAuthClient(
+				auth.BeginPasskeyLogin,
+				acm, e, db, rdb,
+			))
+			r.Post("/passkey/login/finish", lib.WrapHandlerWAuthClient(
+				auth.FinishPasskeyLogin,
+				acm, e, db, rdb,
+			))
 		})
 
+		r.Get("/verify-email", lib.WrapHandlerWAuthClient(
+			auth.VerifyEmail,
+			acm, e, db, rdb,
+		))
+		r.Get("/oidc/{provider}/start", lib.WrapHandlerWAuthClient(
+			auth.StartOIDC,
+			acm, e, db, rdb,
+		))
+		r.Get("/oidc/{provider}/callback", lib.WrapHandlerWAuthClient(
+			auth.OIDCCallback,
+			acm, e, db, rdb,
+		))
+
 		r.Group(func(r chi.Router) {
 			r.Use(m.RefreshTokenPresent)
 			r.Patch("/refresh", lib.WrapHandlerWAuthClient(
@@ -50,35 +112,419 @@ func Init(
 				acm, e, db, rdb,
 			))
 		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(lib.WrapMiddlewareWAuth(
+				m.Auth,
+				acm, e, db, rdb,
+			))
+			r.Get("/app-passwords", lib.WrapHandlerWAuthClient(
+				auth.ListAppPasswords,
+				acm, e, db, rdb,
+			))
+			r.Post("/app-passwords", lib.WrapHandlerWAuthClient(
+				auth.CreateAppPassword,
+				acm, e, db, rdb,
+			))
+			r.Delete("/app-passwords", lib.WrapHandlerWAuthClient(
+				auth.RevokeAppPassword,
+				acm, e, db, rdb,
+			))
+			r.Get("/personal-access-tokens", lib.WrapHandlerWAuthClient(
+				auth.ListPersonalAccessTokens,
+				acm, e, db, rdb,
+			))
+			r.Post("/personal-access-tokens", lib.WrapHandlerWAuthClient(
+				auth.CreatePersonalAccessToken,
+				acm, e, db, rdb,
+			))
+			r.Delete("/personal-access-tokens", lib.WrapHandlerWAuthClient(
+				auth.RevokePersonalAccessToken,
+				acm, e, db, rdb,
+			))
+			r.Post("/2fa/totp/enroll", lib.WrapHandlerWAuthClient(
+				auth.EnrollTOTP,
+				acm, e, db, rdb,
+			))
+			r.Post("/2fa/totp/confirm", lib.WrapHandlerWAuthClient(
+				auth.ConfirmTOTP,
+				acm, e, db, rdb,
+			))
+			r.Delete("/2fa/totp", lib.WrapHandlerWAuthClient(
+				auth.DisableTOTP,
+				acm, e, db, rdb,
+			))
+			r.Get("/passkeys", lib.WrapHandlerWAuthClient(
+				auth.ListPasskeys,
+				acm, e, db, rdb,
+			))
+			r.Post("/passkeys/register/begin", lib.WrapHandlerWAuthClient(
+				auth.BeginPasskeyRegistration,
+				acm, e, db, rdb,
+			))
+			r.Post("/passkeys/register/finish", lib.WrapHandlerWAuthClient(
+				auth.FinishPasskeyRegistration,
+				acm, e, db, rdb,
+			))
+			r.Delete("/passkeys", lib.WrapHandlerWAuthClient(
+				auth.DeletePasskey,
+				acm, e, db, rdb,
+			))
+			r.Get("/sessions", lib.WrapHandlerWAuthClient(
+				auth.ListSessions,
+				acm, e, db, rdb,
+			))
+			r.Delete("/sessions", lib.WrapHandlerWAuthClient(
+				auth.RevokeSession,
+				acm, e, db, rdb,
+			))
+			r.Delete("/sessions/others", lib.WrapHandlerWAuthClient(
+				auth.RevokeOtherSessions,
+				acm, e, db, rdb,
+			))
+		})
 	})
 
 	r.Route("/todo", func(r chi.Router) {
+		r.Use(m.TodoScopes)
 		r.Use(lib.WrapMiddlewareWAuth(
 			m.Auth,
 			acm, e, db, rdb,
 		))
+		r.Use(lib.WrapMiddleware(
+			m.RateLimitTodo,
+			e, db, rdb,
+		))
+		r.Use(lib.WrapMiddleware(
+			m.Quota,
+			e, db, rdb,
+		))
+		r.Use(m.Workspace)
 
-		r.Get("/{id}", lib.WrapHandlerWTodoClient(
-			todo.Get,
-			tcm, e, db, rdb,
+		todoRoutes(r, tcm, e, db, rdb)
+	})
+
+	r.Route("/workspace", func(r chi.Router) {
+		r.Group(func(r chi.Router) {
+			r.Use(lib.WrapMiddlewareWAuth(
+				m.Auth,
+				acm, e, db, rdb,
+			))
+			r.Use(lib.WrapMiddleware(
+				m.RateLimitTodo,
+				e, db, rdb,
+			))
+			r.Use(lib.WrapMiddleware(
+				m.Quota,
+				e, db, rdb,
+			))
+
+			r.Get("/", lib.WrapHandlerWTodoClient(
+				todo.ListWorkspaces,
+				tcm, e, db, rdb,
+			))
+			r.Post("/", lib.WrapHandlerWTodoClient(
+				todo.CreateWorkspace,
+				tcm, e, db, rdb,
+			))
+			r.Post("/join", lib.WrapHandlerWTodoClient(
+				todo.JoinWorkspace,
+				tcm, e, db, rdb,
+			))
+
+			r.Route("/{workspace:[0-9]+}", func(r chi.Router) {
+				r.Get("/members", lib.WrapHandlerWTodoClient(
+					todo.ListMembers,
+					tcm, e, db, rdb,
+				))
+				r.Post("/invite", lib.WrapHandlerWTodoClient(
+					todo.InviteMember,
+					tcm, e, db, rdb,
+				))
+				r.Post("/leave", lib.WrapHandlerWTodoClient(
+					todo.LeaveWorkspace,
+					tcm, e, db, rdb,
+				))
+				r.Delete("/member", lib.WrapHandlerWTodoClient(
+					todo.RemoveMember,
+					tcm, e, db, rdb,
+				))
+			})
+		})
+
+		// the todos of the workspace share the routes of the personal todos, they are kept out of the group
+		// above since the scopes of personal access tokens have to be known before Auth runs
+		r.Route("/{workspace:[0-9]+}/todo", func(r chi.Router) {
+			r.Use(m.TodoScopes)
+			r.Use(lib.WrapMiddlewareWAuth(
+				m.Auth,
+				acm, e, db, rdb,
+			))
+			r.Use(lib.WrapMiddleware(
+				m.RateLimitTodo,
+				e, db, rdb,
+			))
+			r.Use(lib.WrapMiddleware(
+				m.Quota,
+				e, db, rdb,
+			))
+			r.Use(m.Workspace)
+
+			todoRoutes(r, tcm, e, db, rdb)
+		})
+	})
+
+	r.Route("/account", func(r chi.Router) {
+		r.Group(func(r chi.Router) {
+			r.Use(lib.WrapMiddlewareWAuth(
+				m.Auth,
+				acm, e, db, rdb,
+			))
+
+			r.Get("/usage", lib.WrapHandlerWTodoClient(
+				account.Usage,
+				tcm, e, db, rdb,
+			))
+			r.Delete("/", lib.WrapHandlerWAuthClient(
+				account.Delete,
+				acm, e, db, rdb,
+			))
+			r.Post("/export", lib.WrapHandlerWAuthClient(
+				account.RequestExport,
+				acm, e, db, rdb,
+			))
+		})
+
+		// the download link is signed so that it can be opened without being logged in
+		r.Group(func(r chi.Router) {
+			r.Use(lib.WrapMiddleware(
+				m.RateLimitAuth,
+				e, db, rdb,
+			))
+
+			r.Get("/export/{export}", lib.WrapHandlerWAuthClient(
+				account.DownloadExport,
+				acm, e, db, rdb,
+			))
+		})
+	})
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(lib.WrapMiddlewareWAuth(
+			m.Auth,
+			acm, e, db, rdb,
 		))
-		r.Get("/list", lib.WrapHandlerWTodoClient(
-			todo.List,
-			tcm, e, db, rdb,
+		// the admin service decides which of the roles can do what
+		r.Use(m.Roles(enums.AdminRole, enums.SupportRole))
+
+		r.Get("/users", lib.WrapHandlerWAuthClient(
+			admin.SearchUsers,
+			acm, e, db, rdb,
 		))
-		r.Post("/create", lib.WrapHandlerWTodoClient(
-			todo.Create,
-			tcm, e, db, rdb,
+		r.Get("/login-metrics", lib.WrapHandlerWAuthClient(
+			admin.LoginMetrics,
+			acm, e, db, rdb,
 		))
-		r.Post("/update", lib.WrapHandlerWTodoClient(
-			todo.Update,
+
+		r.Route("/users/{user:[0-9]+}", func(r chi.Router) {
+			r.Delete("/", lib.WrapHandlerWAuthClient(
+				admin.DeleteUser,
+				acm, e, db, rdb,
+			))
+			r.Get("/sessions", lib.WrapHandlerWAuthClient(
+				admin.ListUserSessions,
+				acm, e, db, rdb,
+			))
+			r.Post("/disable", lib.WrapHandlerWAuthClient(
+				admin.DisableUser,
+				acm, e, db, rdb,
+			))
+			r.Post("/enable", lib.WrapHandlerWAuthClient(
+				admin.EnableUser,
+				acm, e, db, rdb,
+			))
+			r.Post("/password-reset", lib.WrapHandlerWAuthClient(
+				admin.ForcePasswordReset,
+				acm, e, db, rdb,
+			))
+			r.Post("/unlock", lib.WrapHandlerWAuthClient(
+				admin.UnlockAccount,
+				acm, e, db, rdb,
+			))
+
+			r.Group(func(r chi.Router) {
+				r.Use(m.ContentJSON)
+				r.Put("/role", lib.WrapHandlerWAuthClient(
+					admin.SetRole,
+					acm, e, db, rdb,
+				))
+			})
+		})
+	})
+
+	r.Route("/notification", func(r chi.Router) {
+		r.Use(lib.WrapMiddlewareWAuth(
+			m.Auth,
+			acm, e, db, rdb,
+		))
+		r.Use(lib.WrapMiddleware(
+			m.Quota,
+			e, db, rdb,
+		))
+
+		r.Get("/preferences", lib.WrapHandlerWNotificationClient(
+			notification.GetPreferences,
+			ncm, e, db, rdb,
+		))
+		r.Put("/preferences", lib.WrapHandlerWNotificationClient(
+			notification.SetPreferences,
+			ncm, e, db, rdb,
+		))
+		r.Get("/deliveries", lib.WrapHandlerWNotificationClient(
+			notification.ListDeliveries,
+			ncm, e, db, rdb,
+		))
+		r.Get("/inbox", lib.WrapHandlerWNotificationClient(
+			notification.ListInbox,
+			ncm, e, db, rdb,
+		))
+		r.Get("/inbox/unread", lib.WrapHandlerWNotificationClient(
+			notification.UnreadCount,
+			ncm, e, db, rdb,
+		))
+		r.Get("/inbox/stream", lib.WrapHandlerWNotificationClient(
+			notification.Stream,
+			ncm, e, db, rdb,
+		))
+		r.Post("/inbox/read", lib.WrapHandlerWNotificationClient(
+			notification.MarkRead,
+			ncm, e, db, rdb,
+		))
+		r.Post("/inbox/read-all", lib.WrapHandlerWNotificationClient(
+			notification.MarkAllRead,
+			ncm, e, db, rdb,
+		))
+		r.Post("/inbox/archive", lib.WrapHandlerWNotificationClient(
+			notification.Archive,
+			ncm, e, db, rdb,
+		))
+	})
+
+	r.Get("/.well-known/jwks.json", lib.WrapHandlerWAuthClient(
+		auth.JWKS,
+		acm, e, db, rdb,
+	))
+
+	// clients discover the CalDAV endpoint from the server name alone (RFC 6764)
+	r.Handle("/.well-known/caldav", http.RedirectHandler(caldav.Prefix+"/", http.StatusMovedPermanently))
+
+	r.Route(caldav.Prefix, func(r chi.Router) {
+		// the failed app password attempts are limited by AppPasswordAuth and the syncs by the user
+		r.Use(lib.WrapMiddlewareWAuth(
+			m.AppPasswordAuth,
+			acm, e, db, rdb,
+		))
+		r.Use(lib.WrapMiddleware(
+			m.RateLimitCalDAV,
+			e, db, rdb,
+		))
+		r.Use(lib.WrapMiddleware(
+			m.Quota,
+			e, db, rdb,
+		))
+
+		r.Handle("/", lib.WrapHandlerWTodoClient(
+			caldav.Serve,
 			tcm, e, db, rdb,
 		))
-		r.Delete("/delete", lib.WrapHandlerWTodoClient(
-			todo.Delete,
+		r.Handle("/*", lib.WrapHandlerWTodoClient(
+			caldav.Serve,
 			tcm, e, db, rdb,
 		))
 	})
 
 	return r
 }
+
+// todoRoutes registers the routes of the todo service, they are mounted for the personal todos and for the
+// todos of a workspace. Every route is registered with the scope that personal access tokens need for it, saved
+// filters only search the todos so they need todo:read, and so does a sync that only pulls changes.
+func todoRoutes(
+	r chi.Router,
+	tcm *grpc.TodoClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) {
+	read := r.With(m.Scope(enums.TodoRead))
+	write := r.With(m.Scope(enums.TodoWrite))
+
+	read.Get("/{id}", lib.WrapHandlerWTodoClient(
+		todo.Get,
+		tcm, e, db, rdb,
+	))
+	read.Get("/list", lib.WrapHandlerWTodoClient(
+		todo.List,
+		tcm, e, db, rdb,
+	))
+	write.Post("/create", lib.WrapHandlerWTodoClient(
+		todo.Create,
+		tcm, e, db, rdb,
+	))
+	write.Post("/quick", lib.WrapHandlerWTodoClient(
+		todo.QuickAdd,
+		tcm, e, db, rdb,
+	))
+	write.Post("/update", lib.WrapHandlerWTodoClient(
+		todo.Update,
+		tcm, e, db, rdb,
+	))
+	write.Delete("/delete", lib.WrapHandlerWTodoClient(
+		todo.Delete,
+		tcm, e, db, rdb,
+	))
+	read.Get("/stats", lib.WrapHandlerWTodoClient(
+		todo.Stats,
+		tcm, e, db, rdb,
+	))
+	read.Get("/board", lib.WrapHandlerWTodoClient(
+		todo.Board,
+		tcm, e, db, rdb,
+	))
+	read.Get("/workflow", lib.WrapHandlerWTodoClient(
+		todo.GetWorkflow,
+		tcm, e, db, rdb,
+	))
+	write.Put("/workflow", lib.WrapHandlerWTodoClient(
+		todo.SetWorkflow,
+		tcm, e, db, rdb,
+	))
+	read.Get("/graph", lib.WrapHandlerWTodoClient(
+		todo.Graph,
+		tcm, e, db, rdb,
+	))
+	write.Post("/dependency", lib.WrapHandlerWTodoClient(
+		todo.AddDependency,
+		tcm, e, db, rdb,
+	))
+	write.Delete("/dependency", lib.WrapHandlerWTodoClient(
+		todo.RemoveDependency,
+		tcm, e, db, rdb,
+	))
+	read.Get("/filters", lib.WrapHandlerWTodoClient(
+		todo.ListFilters,
+		tcm, e, db, rdb,
+	))
+	read.Post("/filter", lib.WrapHandlerWTodoClient(
+		todo.CreateFilter,
+		tcm, e, db, rdb,
+	))
+	read.Delete("/filter", lib.WrapHandlerWTodoClient(
+		todo.DeleteFilter,
+		tcm, e, db, rdb,
+	))
+	read.Post("/sync", lib.WrapHandlerWTodoClient(
+		todo.Sync,
+		tcm, e, db, rdb,
+	))
+}
@@ -0,0 +1,306 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/internal/lib"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const maxPersonalAccessTokens = 10
+
+// scopes are the scopes that a personal access token can be created with
+var scopes = []enums.Scope{
+	enums.TodoRead,
+	enums.TodoWrite,
+}
+
+// hashPersonalAccessToken hashes the personal access token, the tokens are random so a fast hash is enough
+func hashPersonalAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newPersonalAccessToken generates a new personal access token
+func newPersonalAccessToken() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return lib.PersonalAccessTokenPrefix + strings.ToLower(base32.StdEncoding.EncodeToString(b)), nil
+}
+
+// parseScopes checks the scopes that a personal access token is created with and returns them sorted without
+// the duplicates
+func parseScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+
+	res := []string{}
+	for _, scope := range requested {
+		if !slices.Contains(scopes, enums.Scope(scope)) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !slices.Contains(res, scope) {
+			res = append(res, scope)
+		}
+	}
+	slices.Sort(res)
+
+	return res, nil
+}
+
+func personalAccessTokenToPB(t *database.PersonalAccessToken) *pb.PersonalAccessToken {
+	res := &pb.PersonalAccessToken{
+		Id:        fmt.Sprint(t.ID),
+		Name:      t.Name,
+		Scopes:    strings.Split(t.Scopes, ","),
+		CreatedAt: t.CreatedAt.Format(time.RFC3339),
+	}
+	if t.ExpiresAt != nil {
+		res.ExpiresAt = t.ExpiresAt.Format(time.RFC3339)
+	}
+	if t.LastUsedAt != nil {
+		res.LastUsedAt = t.LastUsedAt.Format(time.RFC3339)
+	}
+
+	return res
+}
+
+// CreatePersonalAccessToken is a gRPC endpoint to create a personal access token, the token is only returned once
+// returns Internal, InvalidArgument, ResourceExhausted, nil
+func (s *Server) CreatePersonalAccessToken(
+	ctx context.Context,
+	req *pb.CreatePersonalAccessTokenRequest,
+) (*pb.CreatePersonalAccessTokenResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.CreatePersonalAccessTokenResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 50 {
+		return &pb.CreatePersonalAccessTokenResponse{
+			Success: false,
+			Message: "Invalid name",
+		}, status.Error(codes.InvalidArgument, "the name must be between 1 and 50 characters")
+	}
+
+	tokenScopes, err := parseScopes(req.Scopes)
+	if err != nil {
+		return &pb.CreatePersonalAccessTokenResponse{
+			Success: false,
+			Message: "Invalid scopes",
+		}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil || !t.After(time.Now()) {
+			return &pb.CreatePersonalAccessTokenResponse{
+				Success: false,
+				Message: "Invalid expiry",
+			}, status.Error(codes.InvalidArgument, "the expiry must be a time in the future")
+		}
+		expiresAt = &t
+	}
+
+	var count int64
+	err = s.DB.Model(&database.PersonalAccessToken{}).Where("user_id = ?", userID).Count(&count).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the personal access tokens")
+		return &pb.CreatePersonalAccessTokenResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to count the personal access tokens")
+	}
+	if count >= maxPersonalAccessTokens {
+		return &pb.CreatePersonalAccessTokenResponse{
+			Success: false,
+			Message: "Too many personal access tokens",
+		}, status.Error(codes.ResourceExhausted, fmt.Sprintf("a user can not have more than %d personal access tokens", maxPersonalAccessTokens))
+	}
+
+	token, err := newPersonalAccessToken()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to generate the personal access token")
+		return &pb.CreatePersonalAccessTokenResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to generate the personal access token")
+	}
+
+	personalAccessToken := &database.PersonalAccessToken{
+		UserID:    uint(userID),
+		Name:      name,
+		Hash:      hashPersonalAccessToken(token),
+		Scopes:    strings.Join(tokenScopes, ","),
+		ExpiresAt: expiresAt,
+	}
+
+	err = s.DB.Create(personalAccessToken).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the personal access token")
+		return &pb.CreatePersonalAccessTokenResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to create the personal access token")
+	}
+
+	return &pb.CreatePersonalAccessTokenResponse{
+		Success:             true,
+		Message:             "Personal access token created successfully",
+		PersonalAccessToken: personalAccessTokenToPB(personalAccessToken),
+		Token:               token,
+	}, nil
+}
+
+// ListPersonalAccessTokens is a gRPC endpoint to list the personal access tokens of the user
+// returns Internal, nil
+func (s *Server) ListPersonalAccessTokens(
+	ctx context.Context,
+	req *pb.ListPersonalAccessTokensRequest,
+) (*pb.ListPersonalAccessTokensResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.ListPersonalAccessTokensResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	personalAccessTokens := []*database.PersonalAccessToken{}
+
+	err = s.DB.Where("user_id = ?", userID).Order("id").Find(&personalAccessTokens).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the personal access tokens")
+		return &pb.ListPersonalAccessTokensResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the personal access tokens")
+	}
+
+	res := &pb.ListPersonalAccessTokensResponse{
+		Success:              true,
+		PersonalAccessTokens: []*pb.PersonalAccessToken{},
+	}
+	for _, personalAccessToken := range personalAccessTokens {
+		res.PersonalAccessTokens = append(res.PersonalAccessTokens, personalAccessTokenToPB(personalAccessToken))
+	}
+
+	return res, nil
+}
+
+// RevokePersonalAccessToken is a gRPC endpoint to revoke a personal access token of the user
+// returns Internal, NotFound, nil
+func (s *Server) RevokePersonalAccessToken(
+	ctx context.Context,
+	req *pb.RevokePersonalAccessTokenRequest,
+) (*pb.RevokePersonalAccessTokenResponse, error) {
+	id, err := strconv.ParseUint(req.Id, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse personal access token id")
+		return &pb.RevokePersonalAccessTokenResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse personal access token id")
+	}
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.RevokePersonalAccessTokenResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	result := s.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&database.PersonalAccessToken{})
+	if result.Error != nil {
+		log.Error().Err(result.Error).Msg("failed to revoke the personal access token")
+		return &pb.RevokePersonalAccessTokenResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to revoke the personal access token")
+	}
+	if result.RowsAffected == 0 {
+		return &pb.RevokePersonalAccessTokenResponse{
+			Success: false,
+			Message: "Personal access token not found",
+		}, status.Error(codes.NotFound, "personal access token not found")
+	}
+
+	return &pb.RevokePersonalAccessTokenResponse{
+		Success: true,
+		Message: "Personal access token revoked successfully",
+	}, nil
+}
+
+// ValidatePersonalAccessToken is a gRPC endpoint to validate a personal access token, the scopes of the token
+// are returned so that the gateway can check them against the route
+// returns Internal, Unauthenticated, nil
+func (s *Server) ValidatePersonalAccessToken(
+	ctx context.Context,
+	req *pb.ValidatePersonalAccessTokenRequest,
+) (*pb.ValidateResponse, error) {
+	unauthenticated := func() (*pb.ValidateResponse, error) {
+		return &pb.ValidateResponse{
+			Success: false,
+			IsValid: false,
+		}, status.Error(codes.Unauthenticated, "invalid personal access token")
+	}
+
+	if !strings.HasPrefix(req.Token, lib.PersonalAccessTokenPrefix) {
+		return unauthenticated()
+	}
+
+	personalAccessToken := &database.PersonalAccessToken{}
+
+	err := s.DB.
+		Joins("JOIN users ON users.id = personal_access_tokens.user_id AND users.deleted_at IS NULL AND users.disabled_at IS NULL AND users.deletion_scheduled_at IS NULL").
+		Where("personal_access_tokens.hash = ?", hashPersonalAccessToken(req.Token)).
+		First(personalAccessToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return unauthenticated()
+		}
+
+		log.Error().Err(err).Msg("failed to get the personal access token")
+		return &pb.ValidateResponse{
+			Success: false,
+			IsValid: false,
+		}, status.Error(codes.Internal, "failed to get the personal access token")
+	}
+
+	now := time.Now()
+	if personalAccessToken.ExpiresAt != nil && !now.Before(*personalAccessToken.ExpiresAt) {
+		return unauthenticated()
+	}
+	if personalAccessToken.LastUsedAt == nil || now.Sub(*personalAccessToken.LastUsedAt) > lastUsedInterval {
+		err = s.DB.Model(personalAccessToken).UpdateColumn("last_used_at", now).Error
+		if err != nil {
+			log.Error().Err(err).Msg("failed to update the last use of the personal access token")
+		}
+	}
+
+	return &pb.ValidateResponse{
+		Success: true,
+		IsValid: true,
+		UserId:  fmt.Sprint(personalAccessToken.UserID),
+		Scopes:  strings.Split(personalAccessToken.Scopes, ","),
+	}, nil
+}
@@ -0,0 +1,499 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/auth/tokens"
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/internal/lib"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultUserPageSize = 20
+	maxUserPageSize     = 100
+)
+
+// roleRanks orders the roles of the users, a caller can not act on a user with a higher role
+var roleRanks = map[enums.Role]int{
+	enums.UserRole:    0,
+	enums.SupportRole: 1,
+	enums.AdminRole:   2,
+}
+
+var (
+	// errDisabled is returned when a user whose account was disabled by an admin tries to log in
+	errDisabled = status.Error(codes.PermissionDenied, "the account is disabled")
+	// errPasswordResetRequired is returned when a user has to reset the password before logging in with it
+	errPasswordResetRequired = status.Error(
+		codes.PermissionDenied,
+		"a password reset is required, check your email for the link",
+	)
+)
+
+// AdminServer is used to implement auth.AdminServiceServer, it shares the dependencies and the helpers of the
+// auth server
+type AdminServer struct {
+	pb.UnimplementedAdminServiceServer
+	*Server
+}
+
+// NewAdminServer creates a new admin server
+func NewAdminServer(s *Server) *AdminServer {
+	return &AdminServer{
+		Server: s,
+	}
+}
+
+// authorize checks that the user who is calling, which the gateway passes in the metadata, has one of the roles.
+// The role is read from the database so that taking it away works before the access token of the caller expires.
+func (s *AdminServer) authorize(ctx context.Context, roles ...enums.Role) (*database.User, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(lib.ActorMetadataKey)
+	if len(values) == 0 {
+		return nil, status.Error(codes.PermissionDenied, "the caller is not known")
+	}
+	actorID, err := strconv.ParseUint(values[0], 10, 64)
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied, "the caller is not known")
+	}
+
+	actor := &database.User{}
+	err = s.DB.Select("id", "role", "disabled_at").First(actor, actorID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.PermissionDenied, "the caller is not known")
+		}
+
+		log.Error().Err(err).Msg("failed to get the caller")
+		return nil, status.Error(codes.Internal, "failed to get the caller")
+	}
+	if actor.DisabledAt != nil || !slices.Contains(roles, enums.Role(actor.Role)) {
+		return nil, status.Error(codes.PermissionDenied, "the caller is not allowed to do this")
+	}
+
+	return actor, nil
+}
+
+// outranks checks that the caller does not have a lower role than the user they act on, so that support can not
+// act on the accounts of the admins
+func outranks(actor, user *database.User) error {
+	if roleRanks[enums.Role(user.Role)] > roleRanks[enums.Role(actor.Role)] {
+		return status.Error(codes.PermissionDenied, "the caller can not act on a user with a higher role")
+	}
+
+	return nil
+}
+
+// targetUser returns the user that an admin endpoint works on, the error is a gRPC status
+func (s *AdminServer) targetUser(userID string) (*database.User, error) {
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return nil, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	user := &database.User{}
+	err = s.DB.First(user, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		log.Error().Err(err).Msg("failed to get the user")
+		return nil, status.Error(codes.Internal, "failed to get the user")
+	}
+
+	return user, nil
+}
+
+func userToPB(user *database.User) *pb.User {
+	res := &pb.User{
+		Id:                    fmt.Sprint(user.ID),
+		Name:                  user.Name,
+		Username:              user.Username,
+		Email:                 user.Email,
+		Role:                  user.Role,
+		Plan:                  user.Plan,
+		EmailVerified:         user.EmailVerified,
+		Disabled:              user.DisabledAt != nil,
+		PasswordResetRequired: user.PasswordResetRequired,
+		CreatedAt:             user.CreatedAt.Format(time.RFC3339),
+	}
+	if user.DeletionScheduledAt != nil {
+		res.DeletionScheduledAt = user.DeletionScheduledAt.Format(time.RFC3339)
+	}
+
+	return res
+}
+
+// SearchUsers is a gRPC endpoint to find users by their name, username or email, ordered by when they signed up.
+// The page token is the id of the last user of the previous page.
+// returns Internal, PermissionDenied, InvalidArgument, nil
+func (s *AdminServer) SearchUsers(ctx context.Context, req *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	_, err := s.authorize(ctx, enums.AdminRole, enums.SupportRole)
+	if err != nil {
+		return &pb.SearchUsersResponse{
+			Success: false,
+		}, err
+	}
+
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultUserPageSize
+	}
+	if pageSize > maxUserPageSize {
+		pageSize = maxUserPageSize
+	}
+
+	tx := s.DB.Model(&database.User{})
+	if query := strings.ToLower(strings.TrimSpace(req.Query)); query != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+		tx = tx.Where(
+			`LOWER(username) LIKE ? ESCAPE '\' OR LOWER(email) LIKE ? ESCAPE '\' OR LOWER(name) LIKE ? ESCAPE '\'`,
+			pattern, pattern, pattern,
+		)
+	}
+	if req.Role != "" {
+		if !slices.Contains([]enums.Role{enums.UserRole, enums.SupportRole, enums.AdminRole}, enums.Role(req.Role)) {
+			return &pb.SearchUsersResponse{
+				Success: false,
+				Message: "Invalid role",
+			}, status.Error(codes.InvalidArgument, "invalid role")
+		}
+		tx = tx.Where("role = ?", req.Role)
+	}
+	if req.PageToken != "" {
+		after, err := strconv.ParseUint(req.PageToken, 10, 64)
+		if err != nil {
+			return &pb.SearchUsersResponse{
+				Success: false,
+				Message: "Invalid page token",
+			}, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		tx = tx.Where("id > ?", after)
+	}
+
+	users := []*database.User{}
+
+	// one more than the page size is loaded to know if there is a next page
+	err = tx.Order("id").Limit(pageSize + 1).Find(&users).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to search the users")
+		return &pb.SearchUsersResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to search the users")
+	}
+
+	res := &pb.SearchUsersResponse{
+		Success: true,
+		Users:   []*pb.User{},
+	}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		res.NextPageToken = fmt.Sprint(users[pageSize-1].ID)
+	}
+	for _, user := range users {
+		res.Users = append(res.Users, userToPB(user))
+	}
+
+	return res, nil
+}
+
+// ListUserSessions is a gRPC endpoint to list the sessions that a user is logged in with
+// returns Internal, PermissionDenied, NotFound, nil
+func (s *AdminServer) ListUserSessions(ctx context.Context, req *pb.ListUserSessionsRequest) (*pb.ListSessionsResponse, error) {
+	_, err := s.authorize(ctx, enums.AdminRole, enums.SupportRole)
+	if err != nil {
+		return &pb.ListSessionsResponse{
+			Success: false,
+		}, err
+	}
+
+	user, err := s.targetUser(req.UserId)
+	if err != nil {
+		return &pb.ListSessionsResponse{
+			Success: false,
+		}, err
+	}
+
+	sessions, err := s.activeSessions(user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the sessions")
+		return &pb.ListSessionsResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the sessions")
+	}
+
+	res := &pb.ListSessionsResponse{
+		Success:  true,
+		Sessions: []*pb.Session{},
+	}
+	for _, session := range sessions {
+		res.Sessions = append(res.Sessions, sessionToPB(&session, ""))
+	}
+
+	return res, nil
+}
+
+// DisableUser is a gRPC endpoint to disable the account of a user, every session of the user is logged out and
+// the user can not log in until the account is enabled again
+// returns Internal, PermissionDenied, NotFound, FailedPrecondition, nil
+func (s *AdminServer) DisableUser(ctx context.Context, req *pb.DisableUserRequest) (*pb.DisableUserResponse, error) {
+	actor, err := s.authorize(ctx, enums.AdminRole)
+	if err != nil {
+		return &pb.DisableUserResponse{
+			Success: false,
+		}, err
+	}
+
+	user, err := s.targetUser(req.UserId)
+	if err != nil {
+		return &pb.DisableUserResponse{
+			Success: false,
+		}, err
+	}
+	if user.ID == actor.ID {
+		return &pb.DisableUserResponse{
+			Success: false,
+			Message: "You can not disable your own account",
+		}, status.Error(codes.FailedPrecondition, "an admin can not disable their own account")
+	}
+
+	if user.DisabledAt == nil {
+		err = s.DB.Model(user).Update("disabled_at", time.Now()).Error
+		if err != nil {
+			log.Error().Err(err).Msg("failed to disable the user")
+			return &pb.DisableUserResponse{
+				Success: false,
+			}, status.Error(codes.Internal, "failed to disable the user")
+		}
+	}
+
+	err = tokens.NewRefreshToken(s.E, s.DB, s.R).RevokeAll(ctx, user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to revoke the sessions")
+		return &pb.DisableUserResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to revoke the sessions")
+	}
+
+	log.Info().Str("event", "admin_disable_user").Uint("actor", actor.ID).Uint("user", user.ID).Msg("the user was disabled")
+	return &pb.DisableUserResponse{
+		Success: true,
+		Message: "User disabled successfully",
+	}, nil
+}
+
+// EnableUser is a gRPC endpoint to enable the account of a user that was disabled
+// returns Internal, PermissionDenied, NotFound, nil
+func (s *AdminServer) EnableUser(ctx context.Context, req *pb.EnableUserRequest) (*pb.EnableUserResponse, error) {
+	actor, err := s.authorize(ctx, enums.AdminRole)
+	if err != nil {
+		return &pb.EnableUserResponse{
+			Success: false,
+		}, err
+	}
+
+	user, err := s.targetUser(req.UserId)
+	if err != nil {
+		return &pb.EnableUserResponse{
+			Success: false,
+		}, err
+	}
+
+	err = s.DB.Model(user).Update("disabled_at", nil).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to enable the user")
+		return &pb.EnableUserResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to enable the user")
+	}
+
+	log.Info().Str("event", "admin_enable_user").Uint("actor", actor.ID).Uint("user", user.ID).Msg("the user was enabled")
+	return &pb.EnableUserResponse{
+		Success: true,
+		Message: "User enabled successfully",
+	}, nil
+}
+
+// ForcePasswordReset is a gRPC endpoint to make a user choose a new password, every session of the user is logged
+// out, the old password stops working and a password reset link is sent to the user
+// returns Internal, PermissionDenied, NotFound, nil
+func (s *AdminServer) ForcePasswordReset(
+	ctx context.Context,
+	req *pb.ForcePasswordResetRequest,
+) (*pb.ForcePasswordResetResponse, error) {
+	actor, err := s.authorize(ctx, enums.AdminRole, enums.SupportRole)
+	if err != nil {
+		return &pb.ForcePasswordResetResponse{
+			Success: false,
+		}, err
+	}
+
+	user, err := s.targetUser(req.UserId)
+	if err != nil {
+		return &pb.ForcePasswordResetResponse{
+			Success: false,
+		}, err
+	}
+	if err := outranks(actor, user); err != nil {
+		return &pb.ForcePasswordResetResponse{
+			Success: false,
+		}, err
+	}
+
+	err = s.DB.Model(user).Update("password_reset_required", true).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to require the password reset")
+		return &pb.ForcePasswordResetResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to require the password reset")
+	}
+
+	err = tokens.NewRefreshToken(s.E, s.DB, s.R).RevokeAll(ctx, user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to revoke the sessions")
+		return &pb.ForcePasswordResetResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to revoke the sessions")
+	}
+
+	err = s.sendPasswordReset(ctx, user, true)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the password reset token")
+		return &pb.ForcePasswordResetResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to send the password reset email")
+	}
+
+	log.Info().Str("event", "admin_force_password_reset").Uint("actor", actor.ID).Uint("user", user.ID).Msg("a password reset is required")
+	return &pb.ForcePasswordResetResponse{
+		Success: true,
+		Message: "Password reset required, the user was sent a reset link",
+	}, nil
+}
+
+// DeleteUser is a gRPC endpoint to delete the account of a user right away, the user is purged with all of their
+// data like after the grace period of a deletion by the user
+// returns Internal, PermissionDenied, NotFound, FailedPrecondition, nil
+func (s *AdminServer) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	actor, err := s.authorize(ctx, enums.AdminRole)
+	if err != nil {
+		return &pb.DeleteUserResponse{
+			Success: false,
+		}, err
+	}
+
+	user, err := s.targetUser(req.UserId)
+	if err != nil {
+		return &pb.DeleteUserResponse{
+			Success: false,
+		}, err
+	}
+	if user.ID == actor.ID {
+		return &pb.DeleteUserResponse{
+			Success: false,
+			Message: "You can not delete your own account",
+		}, status.Error(codes.FailedPrecondition, "an admin can not delete their own account")
+	}
+
+	shared, err := ownsSharedWorkspace(s.DB, user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the members of the workspaces")
+		return &pb.DeleteUserResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to count the members of the workspaces")
+	}
+	if shared {
+		return &pb.DeleteUserResponse{
+			Success: false,
+			Message: "The user owns workspaces that have other members",
+		}, errSharedWorkspace
+	}
+
+	err = s.purgeAccount(ctx, user.ID)
+	if errors.Is(err, errSharedWorkspace) {
+		return &pb.DeleteUserResponse{
+			Success: false,
+			Message: "The user owns workspaces that have other members",
+		}, errSharedWorkspace
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete the user")
+		return &pb.DeleteUserResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to delete the user")
+	}
+
+	log.Info().Str("event", "admin_delete_user").Uint("actor", actor.ID).Uint("user", user.ID).Msg("the user was deleted")
+	return &pb.DeleteUserResponse{
+		Success: true,
+		Message: "User deleted successfully",
+	}, nil
+}
+
+// SetRole is a gRPC endpoint to change the role of a user, the new role is in the access tokens of the user from
+// their next refresh and the admin service reads it from the database right away
+// returns Internal, PermissionDenied, NotFound, InvalidArgument, FailedPrecondition, nil
+func (s *AdminServer) SetRole(ctx context.Context, req *pb.SetRoleRequest) (*pb.SetRoleResponse, error) {
+	actor, err := s.authorize(ctx, enums.AdminRole)
+	if err != nil {
+		return &pb.SetRoleResponse{
+			Success: false,
+		}, err
+	}
+
+	role := enums.Role(req.Role)
+	if _, ok := roleRanks[role]; !ok {
+		return &pb.SetRoleResponse{
+			Success: false,
+			Message: "Invalid role",
+		}, status.Error(codes.InvalidArgument, "the role must be user, support or admin")
+	}
+
+	user, err := s.targetUser(req.UserId)
+	if err != nil {
+		return &pb.SetRoleResponse{
+			Success: false,
+		}, err
+	}
+	// an admin who takes away their own role could leave no one to give it back
+	if user.ID == actor.ID {
+		return &pb.SetRoleResponse{
+			Success: false,
+			Message: "You can not change your own role",
+		}, status.Error(codes.FailedPrecondition, "an admin can not change their own role")
+	}
+
+	err = s.DB.Model(user).Update("role", string(role)).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to change the role")
+		return &pb.SetRoleResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to change the role")
+	}
+
+	log.Info().
+		Str("event", "admin_set_role").
+		Uint("actor", actor.ID).
+		Uint("user", user.ID).
+		Str("role", string(role)).
+		Msg("the role of the user was changed")
+	return &pb.SetRoleResponse{
+		Success: true,
+		Message: "Role changed successfully",
+	}, nil
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/internal/lib"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// createTestStaff stores a user with the role and returns the context of the calls that they make to the admin
+// service through the gateway
+func createTestStaff(t *testing.T, s *Server, username string, role enums.Role) (*database.User, context.Context) {
+	t.Helper()
+
+	user := createTestUser(t, s, username, "password")
+	if err := s.DB.Model(user).Update("role", string(role)).Error; err != nil {
+		t.Fatalf("failed to set the role: %v", err)
+	}
+
+	return user, metadata.NewIncomingContext(
+		context.Background(),
+		metadata.Pairs(lib.ActorMetadataKey, fmt.Sprint(user.ID)),
+	)
+}
+
+func TestSupportCanNotActOnAdmins(t *testing.T) {
+	s := newTestServer(t)
+	admin := NewAdminServer(s)
+	boss, _ := createTestStaff(t, s, "boss", enums.AdminRole)
+	_, supportCtx := createTestStaff(t, s, "support", enums.SupportRole)
+	user := createTestUser(t, s, "jane", "password")
+
+	_, err := admin.ForcePasswordReset(supportCtx, &pb.ForcePasswordResetRequest{UserId: fmt.Sprint(boss.ID)})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("err = %v, want PermissionDenied for a password reset of an admin", err)
+	}
+	_, err = admin.UnlockAccount(supportCtx, &pb.UnlockAccountRequest{UserId: fmt.Sprint(boss.ID)})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("err = %v, want PermissionDenied for unlocking an admin", err)
+	}
+
+	_, err = admin.ForcePasswordReset(supportCtx, &pb.ForcePasswordResetRequest{UserId: fmt.Sprint(user.ID)})
+	if err != nil {
+		t.Fatalf("failed to force the password reset of a user: %v", err)
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	s := newTestServer(t)
+	admin := NewAdminServer(s)
+	boss, adminCtx := createTestStaff(t, s, "boss", enums.AdminRole)
+	_, supportCtx := createTestStaff(t, s, "support", enums.SupportRole)
+	user := createTestUser(t, s, "jane", "password")
+
+	_, err := admin.SetRole(supportCtx, &pb.SetRoleRequest{UserId: fmt.Sprint(user.ID), Role: string(enums.AdminRole)})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("err = %v, want PermissionDenied for support", err)
+	}
+	_, err = admin.SetRole(adminCtx, &pb.SetRoleRequest{UserId: fmt.Sprint(user.ID), Role: "owner"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("err = %v, want InvalidArgument for an unknown role", err)
+	}
+	_, err = admin.SetRole(adminCtx, &pb.SetRoleRequest{UserId: fmt.Sprint(boss.ID), Role: string(enums.UserRole)})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("err = %v, want FailedPrecondition for the own role", err)
+	}
+
+	_, err = admin.SetRole(adminCtx, &pb.SetRoleRequest{UserId: fmt.Sprint(user.ID), Role: string(enums.SupportRole)})
+	if err != nil {
+		t.Fatalf("failed to set the role: %v", err)
+	}
+	if err := s.DB.First(user, user.ID).Error; err != nil {
+		t.Fatalf("failed to get the user: %v", err)
+	}
+	if user.Role != string(enums.SupportRole) {
+		t.Fatalf("role = %q, want support", user.Role)
+	}
+}
+
+func TestDeleteUserKeepsTheOwnersOfSharedWorkspaces(t *testing.T) {
+	s := newTestServer(t)
+	admin := NewAdminServer(s)
+	_, adminCtx := createTestStaff(t, s, "boss", enums.AdminRole)
+	owner := createTestUser(t, s, "jane", "password")
+	member := createTestUser(t, s, "john", "password")
+
+	workspace := &database.Workspace{Name: "home", OwnerID: owner.ID}
+	if err := s.DB.Create(workspace).Error; err != nil {
+		t.Fatalf("failed to create the workspace: %v", err)
+	}
+	for _, m := range []database.WorkspaceMember{
+		{WorkspaceID: workspace.ID, UserID: owner.ID, Role: string(enums.Owner)},
+		{WorkspaceID: workspace.ID, UserID: member.ID, Role: string(enums.Member)},
+	} {
+		if err := s.DB.Create(&m).Error; err != nil {
+			t.Fatalf("failed to add the member: %v", err)
+		}
+	}
+
+	_, err := admin.DeleteUser(adminCtx, &pb.DeleteUserRequest{UserId: fmt.Sprint(owner.ID)})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("err = %v, want FailedPrecondition for the owner of a shared workspace", err)
+	}
+
+	_, err = admin.DeleteUser(adminCtx, &pb.DeleteUserRequest{UserId: fmt.Sprint(member.ID)})
+	if err != nil {
+		t.Fatalf("failed to delete the member: %v", err)
+	}
+	if err := s.DB.First(&database.User{}, member.ID).Error; err == nil {
+		t.Fatal("the member was not deleted")
+	}
+}
@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	maxAppPasswords = 10
+	// lastUsedInterval keeps clients that poll often from writing to the database on every request
+	lastUsedInterval = time.Minute
+)
+
+// hashAppPassword hashes the app password, dashes, spaces and the case are ignored so that the password
+// can be typed the way it is shown. The passwords are random so a fast hash is enough.
+func hashAppPassword(password string) string {
+	password = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(password))
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAppPassword generates a new app password in the xxxx-xxxx-xxxx-xxxx-xxxx-xxxx-xxxx-xxxx format
+func newAppPassword() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	encoded := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	groups := []string{}
+	for i := 0; i < len(encoded); i += 4 {
+		groups = append(groups, encoded[i:i+4])
+	}
+
+	return strings.Join(groups, "-"), nil
+}
+
+func appPasswordToPB(p *database.AppPassword) *pb.AppPassword {
+	res := &pb.AppPassword{
+		Id:        fmt.Sprint(p.ID),
+		Name:      p.Name,
+		CreatedAt: p.CreatedAt.Format(time.RFC3339),
+	}
+	if p.LastUsedAt != nil {
+		res.LastUsedAt = p.LastUsedAt.Format(time.RFC3339)
+	}
+
+	return res
+}
+
+// CreateAppPassword is a gRPC endpoint to create an app password, the password is only returned once
+// returns Internal, InvalidArgument, ResourceExhausted, nil
+func (s *Server) CreateAppPassword(ctx context.Context, req *pb.CreateAppPasswordRequest) (*pb.CreateAppPasswordResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.CreateAppPasswordResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 50 {
+		return &pb.CreateAppPasswordResponse{
+			Success: false,
+			Message: "Invalid name",
+		}, status.Error(codes.InvalidArgument, "the name must be between 1 and 50 characters")
+	}
+
+	var count int64
+	err = s.DB.Model(&database.AppPassword{}).Where("user_id = ?", userID).Count(&count).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the app passwords")
+		return &pb.CreateAppPasswordResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to count the app passwords")
+	}
+	if count >= maxAppPasswords {
+		return &pb.CreateAppPasswordResponse{
+			Success: false,
+			Message: "Too many app passwords",
+		}, status.Error(codes.ResourceExhausted, fmt.Sprintf("a user can not have more than %d app passwords", maxAppPasswords))
+	}
+
+	password, err := newAppPassword()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to generate the app password")
+		return &pb.CreateAppPasswordResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to generate the app password")
+	}
+
+	appPassword := &database.AppPassword{
+		UserID: uint(userID),
+		Name:   name,
+		Hash:   hashAppPassword(password),
+	}
+
+	err = s.DB.Create(appPassword).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the app password")
+		return &pb.CreateAppPasswordResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to create the app password")
+	}
+
+	return &pb.CreateAppPasswordResponse{
+		Success:     true,
+		Message:     "App password created successfully",
+		AppPassword: appPasswordToPB(appPassword),
+		Password:    password,
+	}, nil
+}
+
+// ListAppPasswords is a gRPC endpoint to list the app passwords of the user
+// returns Internal, nil
+func (s *Server) ListAppPasswords(ctx context.Context, req *pb.ListAppPasswordsRequest) (*pb.ListAppPasswordsResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.ListAppPasswordsResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	appPasswords := []*database.AppPassword{}
+
+	err = s.DB.Where("user_id = ?", userID).Order("id").Find(&appPasswords).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the app passwords")
+		return &pb.ListAppPasswordsResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the app passwords")
+	}
+
+	res := &pb.ListAppPasswordsResponse{
+		Success:      true,
+		AppPasswords: []*pb.AppPassword{},
+	}
+	for _, appPassword := range appPasswords {
+		res.AppPasswords = append(res.AppPasswords, appPasswordToPB(appPassword))
+	}
+
+	return res, nil
+}
+
+// RevokeAppPassword is a gRPC endpoint to revoke an app password of the user
+// returns Internal, NotFound, nil
+func (s *Server) RevokeAppPassword(ctx context.Context, req *pb.RevokeAppPasswordRequest) (*pb.RevokeAppPasswordResponse, error) {
+	id, err := strconv.ParseUint(req.Id, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse app password id")
+		return &pb.RevokeAppPasswordResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse app password id")
+	}
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.RevokeAppPasswordResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	result := s.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&database.AppPassword{})
+	if result.Error != nil {
+		log.Error().Err(result.Error).Msg("failed to revoke the app password")
+		return &pb.RevokeAppPasswordResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to revoke the app password")
+	}
+	if result.RowsAffected == 0 {
+		return &pb.RevokeAppPasswordResponse{
+			Success: false,
+			Message: "App password not found",
+		}, status.Error(codes.NotFound, "app password not found")
+	}
+
+	return &pb.RevokeAppPasswordResponse{
+		Success: true,
+		Message: "App password revoked successfully",
+	}, nil
+}
+
+// ValidateAppPassword is a gRPC endpoint to validate the username or email and the app password that a
+// client sent with basic authentication
+// returns Internal, Unauthenticated, nil
+func (s *Server) ValidateAppPassword(ctx context.Context, req *pb.ValidateAppPasswordRequest) (*pb.ValidateResponse, error) {
+	unauthenticated := func() (*pb.ValidateResponse, error) {
+		return &pb.ValidateResponse{
+			Success: false,
+			IsValid: false,
+		}, status.Error(codes.Unauthenticated, "invalid username or app password")
+	}
+
+	if req.Username == "" || req.Password == "" {
+		return unauthenticated()
+	}
+
+	appPassword := &database.AppPassword{}
+
+	err := s.DB.
+		Joins("JOIN users ON users.id = app_passwords.user_id AND users.deleted_at IS NULL AND users.disabled_at IS NULL AND users.deletion_scheduled_at IS NULL").
+		Where("app_passwords.hash = ? AND (users.username = ? OR users.email = ?)", hashAppPassword(req.Password), req.Username, req.Username).
+		First(appPassword).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return unauthenticated()
+		}
+
+		log.Error().Err(err).Msg("failed to get the app password")
+		return &pb.ValidateResponse{
+			Success: false,
+			IsValid: false,
+		}, status.Error(codes.Internal, "failed to get the app password")
+	}
+
+	now := time.Now()
+	if appPassword.LastUsedAt == nil || now.Sub(*appPassword.LastUsedAt) > lastUsedInterval {
+		err = s.DB.Model(appPassword).UpdateColumn("last_used_at", now).Error
+		if err != nil {
+			log.Error().Err(err).Msg("failed to update the last use of the app password")
+		}
+	}
+
+	return &pb.ValidateResponse{
+		Success: true,
+		IsValid: true,
+		UserId:  fmt.Sprint(appPassword.UserID),
+	}, nil
+}
@@ -0,0 +1,358 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/auth/tokens"
+	"github.com/VinukaThejana/todoapp/internal/database"
+	rdb "github.com/VinukaThejana/todoapp/internal/redis"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	// purgeInterval is the time between the runs that purge the accounts whose grace period is over
+	purgeInterval = time.Hour
+	// reauthWindow is how recent the login of a user who signs in with a provider has to be to confirm the
+	// deletion of the account in place of the password
+	reauthWindow = 10 * time.Minute
+	// sqliteTime is the format that SQLite's datetime function returns, it is used to compare times
+	// that were stored with different offsets
+	sqliteTime = "2006-01-02 15:04:05"
+)
+
+// errSharedWorkspace is returned when the account of a user who owns a workspace with other members would be
+// deleted, the workspace would take the todos of the other members with it
+var errSharedWorkspace = status.Error(
+	codes.FailedPrecondition,
+	"the owner of a workspace that has other members can not be deleted",
+)
+
+// gracePeriod returns the time between the deletion of an account and its purge
+func (s *Server) gracePeriod() time.Duration {
+	if s.E.AccountDeletionGracePeriod == 0 {
+		return 30 * 24 * time.Hour
+	}
+
+	return s.E.AccountDeletionGracePeriod
+}
+
+// DeleteAccount is a gRPC endpoint to delete the account of the user once they confirm it with their password,
+// the user is logged out everywhere and the account is purged after the grace period unless they log in again.
+// Users who sign in with a provider may not know their password, they confirm it by having logged in recently.
+// returns Internal, InvalidArgument, PermissionDenied, FailedPrecondition, nil
+func (s *Server) DeleteAccount(ctx context.Context, req *pb.DeleteAccountRequest) (*pb.DeleteAccountResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.DeleteAccountResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	user := &database.User{}
+	err = s.DB.First(user, userID).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to find the user")
+		return &pb.DeleteAccountResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to find the user")
+	}
+
+	err = s.confirmDeletion(user, req)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			return &pb.DeleteAccountResponse{
+				Success: false,
+				Message: "Invalid password",
+			}, err
+		case codes.PermissionDenied:
+			return &pb.DeleteAccountResponse{
+				Success: false,
+				Message: "Log in again to confirm the deletion of your account",
+			}, err
+		}
+
+		log.Error().Err(err).Msg("failed to confirm the deletion")
+		return &pb.DeleteAccountResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to confirm the deletion")
+	}
+
+	shared, err := ownsSharedWorkspace(s.DB, user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the members of the workspaces")
+		return &pb.DeleteAccountResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to count the members of the workspaces")
+	}
+	if shared {
+		return &pb.DeleteAccountResponse{
+			Success: false,
+			Message: "Remove the other members of your workspaces before deleting your account",
+		}, errSharedWorkspace
+	}
+
+	deleteAt := time.Now().Add(s.gracePeriod())
+	err = s.DB.Model(user).Update("deletion_scheduled_at", deleteAt).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to schedule the deletion of the account")
+		return &pb.DeleteAccountResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to delete the account")
+	}
+
+	err = tokens.NewRefreshToken(s.E, s.DB, s.R).RevokeAll(ctx, user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to revoke the sessions")
+		return &pb.DeleteAccountResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to revoke the sessions")
+	}
+
+	s.sendMail(
+		user.Email,
+		"Your account will be deleted",
+		fmt.Sprintf(
+			"Hi %s,\n\nYour account and all of its data will be deleted on %s.\n\nIf you change your mind, log in before then and your account will be restored.",
+			user.Name, deleteAt.UTC().Format(time.RFC1123),
+		),
+	)
+
+	log.Info().Str("event", "account_deletion_scheduled").Uint("user", user.ID).Time("delete_at", deleteAt).Msg("the account will be deleted")
+	return &pb.DeleteAccountResponse{
+		Success:  true,
+		Message:  "Account deleted, log in before it is purged to restore it",
+		DeleteAt: deleteAt.Format(time.RFC3339),
+	}, nil
+}
+
+// confirmDeletion checks that the user confirmed the deletion of their account with their password, or with a
+// login of the session that is at most reauthWindow old when they sign in with a provider. The password of the
+// users that were created at their first sign in with a provider is random, so they can not know it.
+func (s *Server) confirmDeletion(user *database.User, req *pb.DeleteAccountRequest) error {
+	if req.Password != "" {
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+			return status.Error(codes.InvalidArgument, "invalid password")
+		}
+		return nil
+	}
+
+	var identities int64
+	err := s.DB.Model(&database.Identity{}).Where("user_id = ?", user.ID).Count(&identities).Error
+	if err != nil {
+		return err
+	}
+	if identities == 0 {
+		return status.Error(codes.InvalidArgument, "invalid password")
+	}
+
+	session := &database.Session{}
+	err = s.DB.Where("id = ? AND user_id = ?", req.SessionId, user.ID).Limit(1).Find(session).Error
+	if err != nil {
+		return err
+	}
+	if session.ID == "" || time.Since(session.LoginAt) > reauthWindow {
+		return status.Error(codes.PermissionDenied, "log in again to confirm the deletion of the account")
+	}
+
+	return nil
+}
+
+// ownsSharedWorkspace returns true if the user owns a workspace that has other members
+func ownsSharedWorkspace(db *gorm.DB, userID uint) (bool, error) {
+	var shared int64
+	err := db.Model(&database.WorkspaceMember{}).
+		Joins("JOIN workspaces ON workspaces.id = workspace_members.workspace_id AND workspaces.deleted_at IS NULL").
+		Where("workspaces.owner_id = ? AND workspace_members.user_id <> ?", userID, userID).
+		Count(&shared).Error
+
+	return shared > 0, err
+}
+
+// restoreAccount cancels the deletion of the account of a user who logged in during the grace period
+func (s *Server) restoreAccount(user *database.User) error {
+	err := s.DB.Model(user).Update("deletion_scheduled_at", nil).Error
+	if err != nil {
+		return err
+	}
+
+	s.sendMail(
+		user.Email,
+		"Your account was restored",
+		fmt.Sprintf("Hi %s,\n\nYou logged in before your account was deleted so it has been restored.", user.Name),
+	)
+
+	log.Info().Str("event", "account_restored").Uint("user", user.ID).Msg("the deletion of the account was cancelled")
+	return nil
+}
+
+// purgeAccount deletes the user with all of their data, the workspaces that they own are deleted along with
+// the todos in them. The todos and dependencies that the user added to the workspaces of others are kept and
+// handed over to the owner of the workspace, they belong to the other members as much as to the user.
+// Members may have joined a workspace of the user since the deletion was requested, the account is then kept
+// and errSharedWorkspace is returned.
+func (s *Server) purgeAccount(ctx context.Context, userID uint) error {
+	err := tokens.NewRefreshToken(s.E, s.DB, s.R).RevokeAll(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke the sessions: %w", err)
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		shared, err := ownsSharedWorkspace(tx, userID)
+		if err != nil {
+			return err
+		}
+		if shared {
+			return errSharedWorkspace
+		}
+
+		workspaceIDs := []uint{}
+		err = tx.Unscoped().Model(&database.Workspace{}).Where("owner_id = ?", userID).Pluck("id", &workspaceIDs).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Model(&database.Todo{}).
+			Where("user_id = ? AND workspace_id <> 0", userID).
+			Update("user_id", gorm.Expr("(SELECT owner_id FROM workspaces WHERE workspaces.id = todos.workspace_id)")).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Model(&database.TodoDependency{}).
+			Where("user_id = ? AND workspace_id <> 0", userID).
+			Update("user_id", gorm.Expr("(SELECT owner_id FROM workspaces WHERE workspaces.id = todo_dependencies.workspace_id)")).Error
+		if err != nil {
+			return err
+		}
+
+		todoIDs := []uint{}
+		err = tx.Unscoped().Model(&database.Todo{}).
+			Where("(user_id = ? AND workspace_id = 0) OR workspace_id IN ?", userID, workspaceIDs).
+			Pluck("id", &todoIDs).Error
+		if err != nil {
+			return err
+		}
+		workflowIDs := []uint{}
+		err = tx.Unscoped().Model(&database.Workflow{}).Where("user_id = ?", userID).Pluck("id", &workflowIDs).Error
+		if err != nil {
+			return err
+		}
+
+		deletes := []struct {
+			model any
+			query string
+			args  []any
+		}{
+			{&database.TodoTag{}, "todo_id IN ?", []any{todoIDs}},
+			{&database.TodoDependency{}, "(user_id = ? AND workspace_id = 0) OR workspace_id IN ? OR todo_id IN ? OR blocked_by_id IN ?",
+				[]any{userID, workspaceIDs, todoIDs, todoIDs}},
+			{&database.Todo{}, "id IN ?", []any{todoIDs}},
+			{&database.WorkflowState{}, "workflow_id IN ?", []any{workflowIDs}},
+			{&database.WorkflowTransition{}, "workflow_id IN ?", []any{workflowIDs}},
+			{&database.Workflow{}, "id IN ?", []any{workflowIDs}},
+			{&database.WorkspaceMember{}, "user_id = ? OR workspace_id IN ?", []any{userID, workspaceIDs}},
+			{&database.WorkspaceInvite{}, "invited_by_id = ? OR workspace_id IN ?", []any{userID, workspaceIDs}},
+			{&database.ChangeSequence{}, "user_id = ? OR workspace_id IN ?", []any{userID, workspaceIDs}},
+			{&database.Workspace{}, "id IN ?", []any{workspaceIDs}},
+			{&database.Session{}, "user_id = ?", []any{userID}},
+			{&database.Filter{}, "user_id = ?", []any{userID}},
+			{&database.NotificationPreference{}, "user_id = ?", []any{userID}},
+			{&database.Notification{}, "user_id = ?", []any{userID}},
+			{&database.Delivery{}, "user_id = ?", []any{userID}},
+			{&database.AppPassword{}, "user_id = ?", []any{userID}},
+			{&database.PersonalAccessToken{}, "user_id = ?", []any{userID}},
+			{&database.Quota{}, "user_id = ?", []any{userID}},
+			{&database.TOTP{}, "user_id = ?", []any{userID}},
+			{&database.RecoveryCode{}, "user_id = ?", []any{userID}},
+			{&database.Passkey{}, "user_id = ?", []any{userID}},
+			{&database.Identity{}, "user_id = ?", []any{userID}},
+			{&database.User{}, "id = ?", []any{userID}},
+		}
+		for _, d := range deletes {
+			if err := tx.Unscoped().Where(d.query, d.args...).Delete(d.model).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the single-use tokens that were sent by email and the data export go with the account
+	keys := []string{}
+	for _, userKey := range []struct {
+		key      string
+		tokenKey func(hash string) string
+	}{
+		{rdb.EmailVerificationUserKey(userID), rdb.EmailVerificationKey},
+		{rdb.PasswordResetUserKey(userID), rdb.PasswordResetKey},
+		{rdb.DataExportUserKey(userID), rdb.DataExportKey},
+	} {
+		hash, err := s.R.Get(ctx, userKey.key).Result()
+		if err == nil {
+			keys = append(keys, userKey.tokenKey(hash))
+		}
+		keys = append(keys, userKey.key)
+	}
+	keys = append(keys, rdb.EmailVerificationCooldownKey(userID), rdb.DataExportCooldownKey(userID))
+
+	return s.R.Del(ctx, keys...).Err()
+}
+
+// PurgeAccounts purges the accounts whose grace period is over
+func (s *Server) PurgeAccounts(ctx context.Context) {
+	userIDs := []uint{}
+
+	err := s.DB.Model(&database.User{}).
+		Where(
+			"deletion_scheduled_at IS NOT NULL AND datetime(deletion_scheduled_at) <= ?",
+			time.Now().UTC().Format(sqliteTime),
+		).
+		Pluck("id", &userIDs).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the accounts to purge")
+		return
+	}
+
+	for _, userID := range userIDs {
+		err := s.purgeAccount(ctx, userID)
+		if errors.Is(err, errSharedWorkspace) {
+			// the account stays scheduled and is purged once the other members are gone
+			log.Warn().Str("event", "account_purge_skipped").Uint("user", userID).Msg("the account owns a workspace that has other members")
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Uint("user", userID).Msg("failed to purge the account")
+			continue
+		}
+
+		log.Info().Str("event", "account_purged").Uint("user", userID).Msg("the account was purged")
+	}
+}
+
+// Run purges the accounts whose grace period is over until the context is cancelled
+func (s *Server) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		s.PurgeAccounts(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
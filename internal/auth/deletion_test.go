@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// createTestProviderUser stores a user who signs in with a provider and a session that they logged in to at
+// loginAt
+func createTestProviderUser(t *testing.T, s *Server, username string, loginAt time.Time) (*database.User, string) {
+	t.Helper()
+
+	user := createTestUser(t, s, username, "random")
+	identity := &database.Identity{UserID: user.ID, Provider: "test", Subject: username}
+	if err := s.DB.Create(identity).Error; err != nil {
+		t.Fatalf("failed to create the identity: %v", err)
+	}
+	session := &database.Session{
+		ID:        "session-" + username,
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		UserID:    user.ID,
+		LoginAt:   loginAt,
+	}
+	if err := s.DB.Create(session).Error; err != nil {
+		t.Fatalf("failed to create the session: %v", err)
+	}
+
+	return user, session.ID
+}
+
+func TestDeleteAccountWithAFreshLogin(t *testing.T) {
+	s := newTestServer(t)
+	user, sessionID := createTestProviderUser(t, s, "jane", time.Now())
+
+	_, err := s.DeleteAccount(context.Background(), &pb.DeleteAccountRequest{
+		UserId:    fmt.Sprint(user.ID),
+		SessionId: sessionID,
+	})
+	if err != nil {
+		t.Fatalf("failed to delete the account with a fresh login: %v", err)
+	}
+}
+
+func TestDeleteAccountWithAStaleLogin(t *testing.T) {
+	s := newTestServer(t)
+	user, sessionID := createTestProviderUser(t, s, "jane", time.Now().Add(-2*reauthWindow))
+	password := createTestUser(t, s, "john", "password")
+
+	_, err := s.DeleteAccount(context.Background(), &pb.DeleteAccountRequest{
+		UserId:    fmt.Sprint(user.ID),
+		SessionId: sessionID,
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("err = %v, want PermissionDenied for a stale login", err)
+	}
+
+	_, err = s.DeleteAccount(context.Background(), &pb.DeleteAccountRequest{UserId: fmt.Sprint(password.ID)})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("err = %v, want InvalidArgument without the password of a user who has one", err)
+	}
+}
+
+func TestPurgeAccountHandsOverTodosInTheWorkspacesOfOthers(t *testing.T) {
+	s := newTestServer(t)
+	owner := createTestUser(t, s, "jane", "password")
+	user := createTestUser(t, s, "john", "password")
+
+	shared := &database.Workspace{Name: "home", OwnerID: owner.ID}
+	owned := &database.Workspace{Name: "side", OwnerID: user.ID}
+	for _, w := range []*database.Workspace{shared, owned} {
+		if err := s.DB.Create(w).Error; err != nil {
+			t.Fatalf("failed to create the workspace: %v", err)
+		}
+	}
+	if err := s.DB.Create(&database.WorkspaceMember{
+		WorkspaceID: shared.ID,
+		UserID:      user.ID,
+		Role:        string(enums.Member),
+	}).Error; err != nil {
+		t.Fatalf("failed to add the member: %v", err)
+	}
+
+	contributed := &database.Todo{Title: "shared", UserID: user.ID, WorkspaceID: shared.ID}
+	personal := &database.Todo{Title: "personal", UserID: user.ID}
+	inOwned := &database.Todo{Title: "owned", UserID: user.ID, WorkspaceID: owned.ID}
+	for _, todo := range []*database.Todo{contributed, personal, inOwned} {
+		if err := s.DB.Create(todo).Error; err != nil {
+			t.Fatalf("failed to create the todo: %v", err)
+		}
+	}
+
+	if err := s.purgeAccount(context.Background(), user.ID); err != nil {
+		t.Fatalf("failed to purge the account: %v", err)
+	}
+
+	kept := &database.Todo{}
+	if err := s.DB.First(kept, contributed.ID).Error; err != nil {
+		t.Fatalf("the todo in the workspace of another user was deleted: %v", err)
+	}
+	if kept.UserID != owner.ID {
+		t.Fatalf("user_id = %d, want the owner of the workspace %d", kept.UserID, owner.ID)
+	}
+	for _, todo := range []*database.Todo{personal, inOwned} {
+		if err := s.DB.Unscoped().First(&database.Todo{}, todo.ID).Error; err == nil {
+			t.Fatalf("the todo %q was not deleted", todo.Title)
+		}
+	}
+}
+
+func TestPurgeAccountsKeepsTheOwnerOfASharedWorkspace(t *testing.T) {
+	s := newTestServer(t)
+	owner := createTestUser(t, s, "jane", "password")
+	member := createTestUser(t, s, "john", "password")
+
+	deleteAt := time.Now().Add(-time.Minute)
+	if err := s.DB.Model(owner).Update("deletion_scheduled_at", deleteAt).Error; err != nil {
+		t.Fatalf("failed to schedule the deletion: %v", err)
+	}
+
+	// the member joined after the owner requested the deletion of their account
+	workspace := &database.Workspace{Name: "home", OwnerID: owner.ID}
+	if err := s.DB.Create(workspace).Error; err != nil {
+		t.Fatalf("failed to create the workspace: %v", err)
+	}
+	if err := s.DB.Create(&database.WorkspaceMember{
+		WorkspaceID: workspace.ID,
+		UserID:      member.ID,
+		Role:        string(enums.Member),
+	}).Error; err != nil {
+		t.Fatalf("failed to add the member: %v", err)
+	}
+
+	s.PurgeAccounts(context.Background())
+
+	if err := s.DB.First(&database.User{}, owner.ID).Error; err != nil {
+		t.Fatalf("the owner of a workspace that has other members was purged: %v", err)
+	}
+	if err := s.DB.First(&database.Workspace{}, workspace.ID).Error; err != nil {
+		t.Fatalf("the workspace of the member was purged: %v", err)
+	}
+
+	if err := s.DB.Where("user_id = ?", member.ID).Delete(&database.WorkspaceMember{}).Error; err != nil {
+		t.Fatalf("failed to remove the member: %v", err)
+	}
+	s.PurgeAccounts(context.Background())
+
+	if err := s.DB.First(&database.User{}, owner.ID).Error; err == nil {
+		t.Fatal("the owner was not purged once the other members were gone")
+	}
+}
@@ -0,0 +1,520 @@
+package auth
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	rdb "github.com/VinukaThejana/todoapp/internal/redis"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// exportCooldown is the time a user has to wait before another data export is made
+	exportCooldown = 10 * time.Minute
+	// maxExportSize is the largest archive of a data export, the archive is stored in a single Redis value and
+	// sent to the gateway in a single message that can be at most 64MB
+	maxExportSize = 32 << 20
+)
+
+var errExportLink = status.Error(codes.NotFound, "the link is invalid or has expired")
+
+// errExportTooLarge is returned when the archive of a data export would be larger than maxExportSize
+var errExportTooLarge = status.Error(codes.FailedPrecondition, "the data export is too large to be downloaded")
+
+type exportAccount struct {
+	ID            uint      `json:"id"`
+	Name          string    `json:"name"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Plan          string    `json:"plan"`
+	Role          string    `json:"role"`
+	TOTPEnabled   bool      `json:"totp_enabled"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type exportTodo struct {
+	ID          uint       `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Content     string     `json:"content"`
+	Completed   bool       `json:"completed"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	State       string     `json:"state,omitempty"`
+	Priority    string     `json:"priority,omitempty"`
+	Project     string     `json:"project,omitempty"`
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	Recurrence  string     `json:"recurrence,omitempty"`
+	Tags        []string   `json:"tags"`
+	BlockedBy   []uint     `json:"blocked_by"`
+	WorkspaceID uint       `json:"workspace_id,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+type exportState struct {
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+	Terminal bool   `json:"terminal"`
+}
+
+type exportTransition struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type exportWorkflow struct {
+	Name        string             `json:"name"`
+	States      []exportState      `json:"states"`
+	Transitions []exportTransition `json:"transitions"`
+}
+
+type exportFilter struct {
+	Name      string    `json:"name"`
+	Query     string    `json:"query"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportWorkspace struct {
+	ID       uint      `json:"id"`
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	Owner    bool      `json:"owner"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type exportPreferences struct {
+	Email      bool   `json:"email"`
+	Webhook    bool   `json:"webhook"`
+	Inbox      bool   `json:"inbox"`
+	WebhookURL string `json:"webhook_url,omitempty"`
+	QuietStart string `json:"quiet_start,omitempty"`
+	QuietEnd   string `json:"quiet_end,omitempty"`
+	TimeZone   string `json:"time_zone,omitempty"`
+}
+
+type exportNotification struct {
+	Kind       string     `json:"kind"`
+	Title      string     `json:"title"`
+	Body       string     `json:"body"`
+	ReadAt     *time.Time `json:"read_at,omitempty"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type exportDelivery struct {
+	Channel   string     `json:"channel"`
+	Kind      string     `json:"kind"`
+	Subject   string     `json:"subject"`
+	Body      string     `json:"body"`
+	Status    string     `json:"status"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type exportIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// exportFile is a file of the archive of a data export, the data is written as JSON
+type exportFile struct {
+	name string
+	data any
+}
+
+// exportFiles loads everything that is stored about the user, secrets like the password hash or the webhook
+// secret are left out
+func (s *Server) exportFiles(userID uint) ([]exportFile, error) {
+	user := &database.User{}
+	if err := s.DB.First(user, userID).Error; err != nil {
+		return nil, err
+	}
+	var totps int64
+	err := s.DB.Model(&database.TOTP{}).Where("user_id = ? AND confirmed_at IS NOT NULL", userID).Count(&totps).Error
+	if err != nil {
+		return nil, err
+	}
+	account := exportAccount{
+		ID:            user.ID,
+		Name:          user.Name,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Plan:          user.Plan,
+		Role:          user.Role,
+		TOTPEnabled:   totps > 0,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+	}
+
+	todoRows := []database.Todo{}
+	if err := s.DB.Preload("Tags").Where("user_id = ?", userID).Order("id").Find(&todoRows).Error; err != nil {
+		return nil, err
+	}
+	dependencies := []database.TodoDependency{}
+	if err := s.DB.Where("user_id = ?", userID).Find(&dependencies).Error; err != nil {
+		return nil, err
+	}
+	blockedBy := map[uint][]uint{}
+	for _, dependency := range dependencies {
+		blockedBy[dependency.TodoID] = append(blockedBy[dependency.TodoID], dependency.BlockedByID)
+	}
+	todos := []exportTodo{}
+	for _, todo := range todoRows {
+		t := exportTodo{
+			ID:          todo.ID,
+			Title:       todo.Title,
+			Description: todo.Description,
+			Content:     todo.Content,
+			Completed:   todo.Completed,
+			CompletedAt: todo.CompletedAt,
+			State:       todo.State,
+			Priority:    todo.Priority,
+			Project:     todo.Project,
+			DueAt:       todo.DueAt,
+			Recurrence:  todo.Recurrence,
+			Tags:        []string{},
+			BlockedBy:   []uint{},
+			WorkspaceID: todo.WorkspaceID,
+			CreatedAt:   todo.CreatedAt,
+			UpdatedAt:   todo.UpdatedAt,
+		}
+		for _, tag := range todo.Tags {
+			t.Tags = append(t.Tags, tag.Name)
+		}
+		t.BlockedBy = append(t.BlockedBy, blockedBy[todo.ID]...)
+		todos = append(todos, t)
+	}
+
+	var workflow *exportWorkflow
+	workflowRows := []database.Workflow{}
+	err = s.DB.Preload("States").Preload("Transitions").Where("user_id = ?", userID).Limit(1).Find(&workflowRows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range workflowRows {
+		workflow = &exportWorkflow{
+			Name:        row.Name,
+			States:      []exportState{},
+			Transitions: []exportTransition{},
+		}
+		for _, state := range row.States {
+			workflow.States = append(workflow.States, exportState{state.Name, state.Position, state.Terminal})
+		}
+		for _, transition := range row.Transitions {
+			workflow.Transitions = append(workflow.Transitions, exportTransition{transition.FromState, transition.ToState})
+		}
+	}
+
+	filterRows := []database.Filter{}
+	if err := s.DB.Where("user_id = ?", userID).Order("id").Find(&filterRows).Error; err != nil {
+		return nil, err
+	}
+	filters := []exportFilter{}
+	for _, filter := range filterRows {
+		filters = append(filters, exportFilter{filter.Name, filter.Query, filter.CreatedAt})
+	}
+
+	members := []database.WorkspaceMember{}
+	if err := s.DB.Preload("Workspace").Where("user_id = ?", userID).Order("id").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	workspaces := []exportWorkspace{}
+	for _, member := range members {
+		workspaces = append(workspaces, exportWorkspace{
+			ID:       member.WorkspaceID,
+			Name:     member.Workspace.Name,
+			Role:     member.Role,
+			Owner:    member.Workspace.OwnerID == userID,
+			JoinedAt: member.CreatedAt,
+		})
+	}
+
+	var preferences *exportPreferences
+	preferenceRows := []database.NotificationPreference{}
+	if err := s.DB.Where("user_id = ?", userID).Limit(1).Find(&preferenceRows).Error; err != nil {
+		return nil, err
+	}
+	for _, pref := range preferenceRows {
+		preferences = &exportPreferences{
+			Email:      pref.Email,
+			Webhook:    pref.Webhook,
+			Inbox:      pref.Inbox,
+			WebhookURL: pref.WebhookURL,
+			QuietStart: pref.QuietStart,
+			QuietEnd:   pref.QuietEnd,
+			TimeZone:   pref.TimeZone,
+		}
+	}
+
+	notificationRows := []database.Notification{}
+	if err := s.DB.Where("user_id = ?", userID).Order("id").Find(&notificationRows).Error; err != nil {
+		return nil, err
+	}
+	notifications := []exportNotification{}
+	for _, n := range notificationRows {
+		notifications = append(notifications, exportNotification{n.Kind, n.Title, n.Body, n.ReadAt, n.ArchivedAt, n.CreatedAt})
+	}
+
+	deliveryRows := []database.Delivery{}
+	if err := s.DB.Where("user_id = ?", userID).Order("id").Find(&deliveryRows).Error; err != nil {
+		return nil, err
+	}
+	deliveries := []exportDelivery{}
+	for _, d := range deliveryRows {
+		deliveries = append(deliveries, exportDelivery{d.Channel, d.Kind, d.Subject, d.Body, d.Status, d.SentAt, d.CreatedAt})
+	}
+
+	sessionRows, err := s.activeSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []*pb.Session{}
+	for i := range sessionRows {
+		sessions = append(sessions, sessionToPB(&sessionRows[i], ""))
+	}
+
+	appPasswordRows := []*database.AppPassword{}
+	if err := s.DB.Where("user_id = ?", userID).Order("id").Find(&appPasswordRows).Error; err != nil {
+		return nil, err
+	}
+	appPasswords := []*pb.AppPassword{}
+	for _, p := range appPasswordRows {
+		appPasswords = append(appPasswords, appPasswordToPB(p))
+	}
+
+	personalAccessTokenRows := []*database.PersonalAccessToken{}
+	if err := s.DB.Where("user_id = ?", userID).Order("id").Find(&personalAccessTokenRows).Error; err != nil {
+		return nil, err
+	}
+	personalAccessTokens := []*pb.PersonalAccessToken{}
+	for _, t := range personalAccessTokenRows {
+		personalAccessTokens = append(personalAccessTokens, personalAccessTokenToPB(t))
+	}
+
+	passkeyRows := []*database.Passkey{}
+	if err := s.DB.Where("user_id = ?", userID).Order("id").Find(&passkeyRows).Error; err != nil {
+		return nil, err
+	}
+	passkeys := []*pb.Passkey{}
+	for _, p := range passkeyRows {
+		passkeys = append(passkeys, passkeyToPB(p))
+	}
+
+	identityRows := []database.Identity{}
+	if err := s.DB.Where("user_id = ?", userID).Order("id").Find(&identityRows).Error; err != nil {
+		return nil, err
+	}
+	identities := []exportIdentity{}
+	for _, identity := range identityRows {
+		identities = append(identities, exportIdentity{identity.Provider, identity.Subject, identity.Email, identity.CreatedAt})
+	}
+
+	return []exportFile{
+		{"account.json", account},
+		{"todos.json", todos},
+		{"workflow.json", workflow},
+		{"filters.json", filters},
+		{"workspaces.json", workspaces},
+		{"notification_preferences.json", preferences},
+		{"notifications.json", notifications},
+		{"deliveries.json", deliveries},
+		{"sessions.json", sessions},
+		{"app_passwords.json", appPasswords},
+		{"personal_access_tokens.json", personalAccessTokens},
+		{"passkeys.json", passkeys},
+		{"identities.json", identities},
+	}, nil
+}
+
+// limitedBuffer is a buffer that fails the writes that would make it larger than max
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		return 0, errExportTooLarge
+	}
+
+	return b.Buffer.Write(p)
+}
+
+// exportArchive creates the zip archive of a data export of the user, errExportTooLarge is returned as soon as
+// the archive grows larger than maxSize
+func (s *Server) exportArchive(userID uint, maxSize int) ([]byte, error) {
+	files, err := s.exportFiles(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &limitedBuffer{max: maxSize}
+	zw := zip.NewWriter(buf)
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// signExport signs the download link of a data export so that the expiry in it can not be changed, the key is
+// derived from the session secret so that the signatures can not be mixed up with the session tokens
+func (s *Server) signExport(id string, expires int64) string {
+	key := hmac.New(sha256.New, []byte(s.E.SessionSecret))
+	key.Write([]byte("data_export"))
+
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	mac.Write([]byte(fmt.Sprintf("%s:%d", id, expires)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RequestDataExport is a gRPC endpoint to export all the data of the user, the archive can be downloaded from the
+// signed link in the response until it expires
+// returns Internal, ResourceExhausted, FailedPrecondition, nil
+func (s *Server) RequestDataExport(
+	ctx context.Context,
+	req *pb.RequestDataExportRequest,
+) (*pb.RequestDataExportResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.RequestDataExportResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	ok, err := s.R.SetNX(ctx, rdb.DataExportCooldownKey(uint(userID)), 1, exportCooldown).Result()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check the data export cooldown")
+		return &pb.RequestDataExportResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to export the data")
+	}
+	if !ok {
+		return &pb.RequestDataExportResponse{
+			Success: false,
+			Message: "Too many data exports",
+		}, status.Error(codes.ResourceExhausted, fmt.Sprintf("a data export can only be requested every %s", exportCooldown))
+	}
+
+	fail := func(err error, msg string) (*pb.RequestDataExportResponse, error) {
+		log.Error().Err(err).Msg(msg)
+		s.R.Del(ctx, rdb.DataExportCooldownKey(uint(userID)))
+		return &pb.RequestDataExportResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to export the data")
+	}
+
+	archive, err := s.exportArchive(uint(userID), maxExportSize)
+	if errors.Is(err, errExportTooLarge) {
+		// the cooldown is kept as the archive would be too large again right away
+		log.Warn().Str("event", "data_export_too_large").Uint64("user", userID).Msg("the data export is too large")
+		return &pb.RequestDataExportResponse{
+			Success: false,
+			Message: "Your data is too large to be exported, contact support",
+		}, errExportTooLarge
+	}
+	if err != nil {
+		return fail(err, "failed to create the data export")
+	}
+
+	id, err := newToken()
+	if err != nil {
+		return fail(err, "failed to generate the data export id")
+	}
+	hash := hashToken(id)
+	ttl := rdb.DataExportTTL(s.E)
+
+	previous, err := s.R.Get(ctx, rdb.DataExportUserKey(uint(userID))).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fail(err, "failed to get the previous data export")
+	}
+
+	pipe := s.R.TxPipeline()
+	if previous != "" {
+		pipe.Del(ctx, rdb.DataExportKey(previous))
+	}
+	pipe.Set(ctx, rdb.DataExportKey(hash), archive, ttl)
+	pipe.Set(ctx, rdb.DataExportUserKey(uint(userID)), hash, ttl)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return fail(err, "failed to save the data export")
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	expires := expiresAt.Unix()
+
+	log.Info().Str("event", "data_export").Uint64("user", userID).Int("size", len(archive)).Msg("the data was exported")
+	return &pb.RequestDataExportResponse{
+		Success:   true,
+		Message:   "Data export created successfully",
+		Url:       fmt.Sprintf("%s/account/export/%s?expires=%d&signature=%s", s.appURL(), id, expires, s.signExport(id, expires)),
+		ExpiresAt: expiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+// DownloadDataExport is a gRPC endpoint to get the archive of a data export with the signed link from
+// RequestDataExport, the link does not need the user to be logged in
+// returns Internal, NotFound, nil
+func (s *Server) DownloadDataExport(
+	ctx context.Context,
+	req *pb.DownloadDataExportRequest,
+) (*pb.DownloadDataExportResponse, error) {
+	signature := s.signExport(req.Id, req.Expires)
+	if !hmac.Equal([]byte(signature), []byte(req.Signature)) || time.Now().Unix() >= req.Expires {
+		return &pb.DownloadDataExportResponse{
+			Success: false,
+			Message: "Invalid or expired link",
+		}, errExportLink
+	}
+
+	archive, err := s.R.Get(ctx, rdb.DataExportKey(hashToken(req.Id))).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return &pb.DownloadDataExportResponse{
+				Success: false,
+				Message: "Invalid or expired link",
+			}, errExportLink
+		}
+
+		log.Error().Err(err).Msg("failed to get the data export")
+		return &pb.DownloadDataExportResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the data export")
+	}
+
+	return &pb.DownloadDataExportResponse{
+		Success: true,
+		Archive: archive,
+	}, nil
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+)
+
+func TestExportArchiveSize(t *testing.T) {
+	s := newTestServer(t)
+	user := createTestUser(t, s, "jane", "password")
+
+	// random content does not compress, so the archive is at least as large as it
+	content := make([]byte, 48<<10)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatalf("failed to generate the content: %v", err)
+	}
+	todo := &database.Todo{Title: "notes", Content: base64.StdEncoding.EncodeToString(content), UserID: user.ID}
+	if err := s.DB.Create(todo).Error; err != nil {
+		t.Fatalf("failed to create the todo: %v", err)
+	}
+
+	archive, err := s.exportArchive(user.ID, maxExportSize)
+	if err != nil {
+		t.Fatalf("failed to export the data: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("the archive can not be read: %v", err)
+	}
+	if len(zr.File) == 0 || zr.File[0].Name != "account.json" {
+		t.Fatalf("the archive has %d files, want the account to come first", len(zr.File))
+	}
+
+	_, err = s.exportArchive(user.ID, 32<<10)
+	if !errors.Is(err, errExportTooLarge) {
+		t.Fatalf("err = %v, want errExportTooLarge for an archive larger than the limit", err)
+	}
+}
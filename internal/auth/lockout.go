@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	rdb "github.com/VinukaThejana/todoapp/internal/redis"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"gorm.io/gorm"
+)
+
+const (
+	// freeLoginAttempts is the number of failures before the logins with the account are slowed down, so that a
+	// few typos do not slow anyone down
+	freeLoginAttempts = 2
+	maxLoginDelay     = 8 * time.Second
+)
+
+// the counters of the login metrics
+const (
+	metricFailedLogins    = "failed_logins"
+	metricBlockedLogins   = "blocked_logins"
+	metricAccountLockouts = "account_lockouts"
+	metricIPLockouts      = "ip_lockouts"
+)
+
+// dummyPassword is compared against when the account does not exist, so that the response takes as long as it
+// does for a wrong password
+var dummyPassword = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// errInvalidLogin is returned for both unknown accounts and wrong passwords so that it can not be used to find
+// out who has an account
+var errInvalidLogin = status.Error(codes.Unauthenticated, "invalid username or password")
+
+// loginAccount returns the key that the failed logins are counted under. It is the user when the account exists,
+// so that switching between the username and the email does not reset the count, and the hash of what was typed
+// in when it does not.
+func loginAccount(req *pb.LoginRequest, user *database.User, found bool) string {
+	if found {
+		return userAccount(user.ID)
+	}
+
+	sum := sha256.Sum256([]byte(strings.ToLower(req.GetUsername() + req.GetEmail())))
+	return hex.EncodeToString(sum[:])
+}
+
+// userAccount returns the key that the failed logins of an existing user are counted under
+func userAccount(userID uint) string {
+	return "user:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func (s *Server) maxLoginAttempts() int64 {
+	if s.E.LoginMaxAttempts == 0 {
+		return 5
+	}
+
+	return int64(s.E.LoginMaxAttempts)
+}
+
+func (s *Server) maxLoginAttemptsPerIP() int64 {
+	if s.E.LoginMaxAttemptsPerIP == 0 {
+		return 50
+	}
+
+	return int64(s.E.LoginMaxAttemptsPerIP)
+}
+
+func (s *Server) countLoginMetric(ctx context.Context, metric string) {
+	if err := s.R.HIncrBy(ctx, rdb.LoginMetricsKey, metric, 1).Err(); err != nil {
+		log.Error().Err(err).Str("metric", metric).Msg("failed to count the login metric")
+	}
+}
+
+// lockedOut returns how long the account or the IP address is still locked out for, it is 0 if neither is
+func (s *Server) lockedOut(ctx context.Context, account, ip string) (time.Duration, error) {
+	pipe := s.R.Pipeline()
+	accountTTL := pipe.PTTL(ctx, rdb.LockoutKey(account))
+	ipTTL := pipe.PTTL(ctx, rdb.LockoutIPKey(ip))
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if ip == "" {
+		return max(accountTTL.Val(), 0), nil
+	}
+
+	return max(accountTTL.Val(), ipTTL.Val(), 0), nil
+}
+
+// lockoutError is the ResourceExhausted status for a locked out login, the time to wait is in the details
+func lockoutError(wait time.Duration) error {
+	st := status.New(codes.ResourceExhausted, "too many failed logins, try again later")
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(wait),
+	})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// delayLogin slows down the logins with an account that has failed before, the delay doubles with every failure
+func (s *Server) delayLogin(ctx context.Context, account string) {
+	failures, err := s.R.Get(ctx, rdb.LoginFailuresKey(account)).Int64()
+	if err != nil || failures < freeLoginAttempts {
+		return
+	}
+
+	delay := min(time.Second<<(failures-freeLoginAttempts), maxLoginDelay)
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+	}
+}
+
+// failLogin counts a failed login with the account and from the IP address and locks them out once they go over
+// the limit
+func (s *Server) failLogin(ctx context.Context, account, ip string) {
+	s.countLoginMetric(ctx, metricFailedLogins)
+
+	lock := func(failuresKey, lockoutKey string, limit int64, metric, field, value string) {
+		ttl := rdb.LockoutTTL(s.E)
+
+		pipe := s.R.TxPipeline()
+		failures := pipe.Incr(ctx, failuresKey)
+		pipe.Expire(ctx, failuresKey, ttl)
+		_, err := pipe.Exec(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to count the failed login")
+			return
+		}
+		if failures.Val() < limit {
+			return
+		}
+
+		pipe = s.R.TxPipeline()
+		pipe.Set(ctx, lockoutKey, 1, ttl)
+		pipe.Del(ctx, failuresKey)
+		_, err = pipe.Exec(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to lock out the login")
+			return
+		}
+
+		s.countLoginMetric(ctx, metric)
+		log.Warn().
+			Str("event", "login_lockout").
+			Str(field, value).
+			Dur("duration", ttl).
+			Msg("locked out after too many failed logins")
+	}
+
+	lock(
+		rdb.LoginFailuresKey(account),
+		rdb.LockoutKey(account),
+		s.maxLoginAttempts(),
+		metricAccountLockouts,
+		"account", account,
+	)
+	if ip != "" {
+		lock(
+			rdb.LoginFailuresIPKey(ip),
+			rdb.LockoutIPKey(ip),
+			s.maxLoginAttemptsPerIP(),
+			metricIPLockouts,
+			"ip", ip,
+		)
+	}
+}
+
+// UnlockAccount is a gRPC endpoint to lift the lockout of a user after too many failed logins, the lockout of an
+// IP address is lifted as well when one is given
+// returns Internal, PermissionDenied, NotFound, nil
+func (s *AdminServer) UnlockAccount(ctx context.Context, req *pb.UnlockAccountRequest) (*pb.UnlockAccountResponse, error) {
+	actor, err := s.authorize(ctx, enums.AdminRole, enums.SupportRole)
+	if err != nil {
+		return &pb.UnlockAccountResponse{
+			Success: false,
+		}, err
+	}
+
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.UnlockAccountResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	user := database.User{}
+	err = s.DB.First(&user, userID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &pb.UnlockAccountResponse{
+				Success: false,
+				Message: "User not found",
+			}, status.Error(codes.NotFound, "user not found")
+		}
+
+		log.Error().Err(err).Msg("failed to get the user")
+		return &pb.UnlockAccountResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the user")
+	}
+	if err := outranks(actor, &user); err != nil {
+		return &pb.UnlockAccountResponse{
+			Success: false,
+		}, err
+	}
+
+	account := userAccount(user.ID)
+
+	pipe := s.R.Pipeline()
+	pipe.Del(ctx, rdb.LockoutKey(account), rdb.LoginFailuresKey(account))
+	if req.Ip != "" {
+		pipe.Del(ctx, rdb.LockoutIPKey(req.Ip), rdb.LoginFailuresIPKey(req.Ip))
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to unlock the account")
+		return &pb.UnlockAccountResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to unlock the account")
+	}
+
+	log.Info().
+		Str("event", "login_unlock").
+		Uint("actor", actor.ID).
+		Uint("user", user.ID).
+		Str("ip", req.Ip).
+		Msg("the account was unlocked")
+	return &pb.UnlockAccountResponse{
+		Success: true,
+		Message: "Account unlocked successfully",
+	}, nil
+}
+
+// LoginMetrics is a gRPC endpoint to get the number of failed logins and lockouts since the counters were started
+// returns Internal, PermissionDenied, nil
+func (s *AdminServer) LoginMetrics(ctx context.Context, req *pb.LoginMetricsRequest) (*pb.LoginMetricsResponse, error) {
+	_, err := s.authorize(ctx, enums.AdminRole, enums.SupportRole)
+	if err != nil {
+		return &pb.LoginMetricsResponse{
+			Success: false,
+		}, err
+	}
+
+	metrics, err := s.R.HGetAll(ctx, rdb.LoginMetricsKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Error().Err(err).Msg("failed to get the login metrics")
+		return &pb.LoginMetricsResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the login metrics")
+	}
+
+	count := func(metric string) int64 {
+		n, _ := strconv.ParseInt(metrics[metric], 10, 64)
+		return n
+	}
+
+	return &pb.LoginMetricsResponse{
+		Success:         true,
+		FailedLogins:    count(metricFailedLogins),
+		BlockedLogins:   count(metricBlockedLogins),
+		AccountLockouts: count(metricAccountLockouts),
+		IpLockouts:      count(metricIPLockouts),
+	}, nil
+}
+
+// checkPassword checks the password at login, the failures are counted and logins that are locked out are
+// turned away before the password is looked at
+func (s *Server) checkPassword(ctx context.Context, req *pb.LoginRequest, user *database.User, found bool) error {
+	account := loginAccount(req, user, found)
+	ip := clientOf(ctx).IP
+
+	wait, err := s.lockedOut(ctx, account, ip)
+	if err != nil {
+		// a Redis outage should not lock everyone out
+		log.Error().Err(err).Msg("failed to check the lockout")
+	}
+	if wait > 0 {
+		s.countLoginMetric(ctx, metricBlockedLogins)
+		return lockoutError(wait)
+	}
+
+	s.delayLogin(ctx, account)
+
+	hash := dummyPassword()
+	if found {
+		hash = []byte(user.Password)
+	}
+	err = bcrypt.CompareHashAndPassword(hash, []byte(req.Password))
+	if err != nil || !found {
+		s.failLogin(ctx, account, ip)
+		return errInvalidLogin
+	}
+
+	if err := s.R.Del(ctx, rdb.LoginFailuresKey(account)).Err(); err != nil {
+		log.Error().Err(err).Msg("failed to reset the failed logins")
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/internal/lib"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestLockoutCountsTheUsernameAndTheEmailTogether(t *testing.T) {
+	s := newTestServer(t)
+	s.E.LoginMaxAttempts = 2
+	user := createTestUser(t, s, "jane", "password")
+	ctx := context.Background()
+
+	for _, login := range []*pb.LoginRequest{
+		{Login: &pb.LoginRequest_Username{Username: user.Username}, Password: "wrong"},
+		{Login: &pb.LoginRequest_Email{Email: user.Email}, Password: "wrong"},
+	} {
+		if _, err := s.Login(ctx, login); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("err = %v, want Unauthenticated for a wrong password", err)
+		}
+	}
+
+	_, err := s.Login(ctx, &pb.LoginRequest{Login: &pb.LoginRequest_Username{Username: user.Username}, Password: "password"})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("err = %v, want ResourceExhausted once the account is locked out", err)
+	}
+
+	support := createTestUser(t, s, "support", "password")
+	s.DB.Model(support).Update("role", string(enums.SupportRole))
+	actorCtx := metadata.NewIncomingContext(ctx, metadata.Pairs(lib.ActorMetadataKey, fmt.Sprint(support.ID)))
+
+	_, err = NewAdminServer(s).UnlockAccount(actorCtx, &pb.UnlockAccountRequest{UserId: fmt.Sprint(user.ID)})
+	if err != nil {
+		t.Fatalf("failed to unlock the account: %v", err)
+	}
+
+	_, err = s.Login(ctx, &pb.LoginRequest{Login: &pb.LoginRequest_Email{Email: user.Email}, Password: "password"})
+	if err != nil {
+		t.Fatalf("failed to log in after the account was unlocked: %v", err)
+	}
+}
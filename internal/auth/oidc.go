@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	rdb "github.com/VinukaThejana/todoapp/internal/redis"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	// oidcStateTTL is the time the user has to sign in at the provider
+	oidcStateTTL = 10 * time.Minute
+	// oidcTimeout bounds the requests to the provider for the discovery document and the signing keys
+	oidcTimeout = 10 * time.Second
+)
+
+var (
+	errUnknownProvider = errors.New("unknown provider")
+	nonUsername        = regexp.MustCompile(`[^a-zA-Z0-9]`)
+)
+
+// oidcProviderConfig is a provider in OIDC_PROVIDERS, a JSON array like
+// [{"name":"google","issuer":"https://accounts.google.com","client_id":"...","client_secret":"..."}]
+type oidcProviderConfig struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+}
+
+// oidcProvider is a configured provider along with the endpoints from its discovery document
+type oidcProvider struct {
+	config   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// oidcState is kept in Redis while the user signs in at the provider
+type oidcState struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+// oidcClaims are the claims of the ID token that are used to find or create the user
+type oidcClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// oidcProvider returns the provider with the given name, the discovery document is only fetched the first time
+// the provider is used
+func (s *Server) oidcProvider(name string) (*oidcProvider, error) {
+	if provider, ok := s.oidcProviders.Load(name); ok {
+		return provider.(*oidcProvider), nil
+	}
+
+	if s.E.OIDCProviders == "" {
+		return nil, errUnknownProvider
+	}
+	configs := []oidcProviderConfig{}
+	err := json.Unmarshal([]byte(s.E.OIDCProviders), &configs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse OIDC_PROVIDERS: %w", err)
+	}
+
+	for _, c := range configs {
+		if c.Name != name {
+			continue
+		}
+
+		// the key set of the provider keeps the context to fetch new keys so it can not be the one of a request
+		ctx := oidc.ClientContext(context.Background(), &http.Client{Timeout: oidcTimeout})
+		discovered, err := oidc.NewProvider(ctx, c.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover the provider: %w", err)
+		}
+
+		scopes := c.Scopes
+		if len(scopes) == 0 {
+			scopes = []string{"profile", "email"}
+		}
+
+		provider := &oidcProvider{
+			config: &oauth2.Config{
+				ClientID:     c.ClientID,
+				ClientSecret: c.ClientSecret,
+				Endpoint:     discovered.Endpoint(),
+				RedirectURL:  fmt.Sprintf("%s/auth/oidc/%s/callback", s.appURL(), c.Name),
+				Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+			},
+			verifier: discovered.Verifier(&oidc.Config{
+				ClientID: c.ClientID,
+			}),
+		}
+		s.oidcProviders.Store(name, provider)
+
+		return provider, nil
+	}
+
+	return nil, errUnknownProvider
+}
+
+// oidcUser returns the user that the identity at the provider belongs to. An identity that was not seen before
+// is linked to the user with the same email when the provider has verified it, or else a new user is created.
+func (s *Server) oidcUser(provider, subject string, claims *oidcClaims) (*database.User, error) {
+	user := &database.User{}
+
+	identity := &database.Identity{}
+	err := s.DB.Preload("User").Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err == nil {
+		return &identity.User, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, status.Error(codes.PermissionDenied, "the provider has not verified the email address")
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("email = ?", claims.Email).First(&user).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if err == nil {
+			// whoever registered the account may not own the email, linking would let them in to the
+			// account of the person who signs in with the provider
+			if !user.EmailVerified {
+				return status.Error(codes.FailedPrecondition, "verify the email address of the existing account before signing in with the provider")
+			}
+		} else {
+			user, err = s.createOIDCUser(tx, claims)
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&database.Identity{
+			UserID:   user.ID,
+			Provider: provider,
+			Subject:  subject,
+			Email:    claims.Email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// createOIDCUser creates the user for a person who signs in with a provider for the first time, the password
+// can not be used until the user sets one with a password reset
+func (s *Server) createOIDCUser(tx *gorm.DB, claims *oidcClaims) (*database.User, error) {
+	name := claims.Name
+	if name == "" {
+		name = strings.Split(claims.Email, "@")[0]
+	}
+
+	base := claims.PreferredUsername
+	if base == "" {
+		base = strings.Split(claims.Email, "@")[0]
+	}
+	base = nonUsername.ReplaceAllString(base, "")
+	if len(base) > 10 {
+		base = base[:10]
+	}
+	if len(base) < 4 {
+		base = "user" + base
+	}
+
+	password, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	username := base
+	for i := 0; ; i++ {
+		var count int64
+		err = tx.Model(&database.User{}).Where("username = ?", username).Count(&count).Error
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			break
+		}
+		if i == 10 {
+			return nil, errors.New("failed to find a free username")
+		}
+
+		suffix, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+		username = base + strings.ToLower(nonUsername.ReplaceAllString(suffix, ""))[:5]
+	}
+
+	user := &database.User{
+		Name:          name,
+		Email:         claims.Email,
+		Username:      username,
+		Password:      string(hashedPassword),
+		EmailVerified: true,
+	}
+	err = tx.Create(&user).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// StartOIDCLogin is a gRPC endpoint to start a login with an OpenID Connect provider, the user is sent to the
+// returned URL and the state has to come back along with the code
+// returns Internal, NotFound, Unavailable, nil
+func (s *Server) StartOIDCLogin(ctx context.Context, req *pb.StartOIDCLoginRequest) (*pb.StartOIDCLoginResponse, error) {
+	provider, err := s.oidcProvider(req.Provider)
+	if err != nil {
+		if errors.Is(err, errUnknownProvider) {
+			return &pb.StartOIDCLoginResponse{
+				Success: false,
+				Message: "Provider not found",
+			}, status.Error(codes.NotFound, "provider not found")
+		}
+
+		log.Error().Err(err).Str("provider", req.Provider).Msg("failed to get the provider")
+		return &pb.StartOIDCLoginResponse{
+			Success: false,
+		}, status.Error(codes.Unavailable, "the provider is not available")
+	}
+
+	state, err := newToken()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the state")
+		return &pb.StartOIDCLoginResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to create the state")
+	}
+	nonce, err := newToken()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the nonce")
+		return &pb.StartOIDCLoginResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to create the nonce")
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	val, err := json.Marshal(&oidcState{
+		Provider: req.Provider,
+		Verifier: verifier,
+		Nonce:    nonce,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to encode the state")
+		return &pb.StartOIDCLoginResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to save the state")
+	}
+	err = s.R.Set(ctx, rdb.OIDCStateKey(hashToken(state)), val, oidcStateTTL).Err()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to save the state")
+		return &pb.StartOIDCLoginResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to save the state")
+	}
+
+	return &pb.StartOIDCLoginResponse{
+		Success: true,
+		Url:     provider.config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce)),
+		State:   state,
+	}, nil
+}
+
+// FinishOIDCLogin is a gRPC endpoint to complete a login with an OpenID Connect provider with the code that the
+// provider redirected back with, users with two-factor authentication still have to prove the second factor
+// returns Internal, NotFound, Unauthenticated, PermissionDenied, FailedPrecondition, Unavailable, nil
+func (s *Server) FinishOIDCLogin(ctx context.Context, req *pb.FinishOIDCLoginRequest) (*pb.LoginResponse, error) {
+	val, err := s.R.GetDel(ctx, rdb.OIDCStateKey(hashToken(req.State))).Bytes()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Error().Err(err).Msg("failed to get the state")
+		return &pb.LoginResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the state")
+	}
+
+	state := &oidcState{}
+	if err != nil || json.Unmarshal(val, state) != nil || state.Provider != req.Provider {
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Invalid or expired state",
+		}, status.Error(codes.Unauthenticated, "the state is invalid or has expired")
+	}
+
+	provider, err := s.oidcProvider(req.Provider)
+	if err != nil {
+		if errors.Is(err, errUnknownProvider) {
+			return &pb.LoginResponse{
+				Success: false,
+				Message: "Provider not found",
+			}, status.Error(codes.NotFound, "provider not found")
+		}
+
+		log.Error().Err(err).Str("provider", req.Provider).Msg("failed to get the provider")
+		return &pb.LoginResponse{
+			Success: false,
+		}, status.Error(codes.Unavailable, "the provider is not available")
+	}
+
+	ctx = oidc.ClientContext(ctx, &http.Client{Timeout: oidcTimeout})
+	token, err := provider.config.Exchange(ctx, req.Code, oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		log.Error().Err(err).Str("provider", req.Provider).Msg("failed to exchange the code")
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Invalid code",
+		}, status.Error(codes.Unauthenticated, "failed to exchange the code")
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Invalid ID token",
+		}, status.Error(codes.Unauthenticated, "the provider did not return an ID token")
+	}
+
+	idToken, err := provider.verifier.Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != state.Nonce {
+		log.Error().Err(err).Str("provider", req.Provider).Msg("failed to verify the ID token")
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Invalid ID token",
+		}, status.Error(codes.Unauthenticated, "invalid ID token")
+	}
+
+	claims := &oidcClaims{}
+	err = idToken.Claims(claims)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse the claims")
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Invalid ID token",
+		}, status.Error(codes.Unauthenticated, "invalid ID token")
+	}
+
+	user, err := s.oidcUser(req.Provider, idToken.Subject, claims)
+	if err != nil {
+		if st, ok := status.FromError(err); ok {
+			return &pb.LoginResponse{
+				Success: false,
+				Message: st.Message(),
+			}, err
+		}
+
+		log.Error().Err(err).Msg("failed to find the user")
+		return &pb.LoginResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to find the user")
+	}
+
+	return s.startSession(ctx, user)
+}
@@ -0,0 +1,416 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// authorization is a sign in at the mock issuer that waits for its code to be exchanged
+type authorization struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	claims      map[string]any
+}
+
+// mockIssuer is an OpenID Connect provider that signs in whoever the test asks it to, the token endpoint checks
+// the PKCE verifier like a real provider does
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]*authorization
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate the key: %v", err)
+	}
+
+	issuer := &mockIssuer{
+		key:   key,
+		codes: map[string]*authorization{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                issuer.URL,
+			"authorization_endpoint":                issuer.URL + "/authorize",
+			"token_endpoint":                        issuer.URL + "/token",
+			"jwks_uri":                              issuer.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]any{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   b64(key.N.Bytes()),
+				"e":   b64(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", issuer.token)
+
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+
+	return issuer
+}
+
+// provider returns the OIDC_PROVIDERS entry of the issuer
+func (i *mockIssuer) provider() string {
+	return fmt.Sprintf(`[{"name":"test","issuer":%q,"client_id":"todoapp","client_secret":"secret"}]`, i.URL)
+}
+
+// authorize signs in at the authorization URL with the claims and returns the code that the issuer redirects
+// back with, the nonce of the URL is put in the ID token unless the claims have one
+func (i *mockIssuer) authorize(t *testing.T, authURL string, claims map[string]any) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("failed to parse the authorization url: %v", err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("the authorization url does not use PKCE: %s", authURL)
+	}
+	if query.Get("nonce") == "" || query.Get("state") == "" {
+		t.Fatalf("the authorization url does not have a nonce and a state: %s", authURL)
+	}
+
+	idClaims := map[string]any{"nonce": query.Get("nonce")}
+	for k, v := range claims {
+		idClaims[k] = v
+	}
+
+	code, err := newToken()
+	if err != nil {
+		t.Fatalf("failed to create the code: %v", err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.codes[code] = &authorization{
+		clientID:    query.Get("client_id"),
+		redirectURI: query.Get("redirect_uri"),
+		challenge:   query.Get("code_challenge"),
+		claims:      idClaims,
+	}
+
+	return code
+}
+
+// token exchanges a code for the tokens, the code can only be used once
+func (i *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	fail := func() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+	}
+
+	if err := r.ParseForm(); err != nil {
+		fail()
+		return
+	}
+
+	i.mu.Lock()
+	auth, ok := i.codes[r.PostForm.Get("code")]
+	delete(i.codes, r.PostForm.Get("code"))
+	i.mu.Unlock()
+
+	clientID, _, _ := r.BasicAuth()
+	if clientID == "" {
+		clientID = r.PostForm.Get("client_id")
+	}
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || clientID != auth.clientID || r.PostForm.Get("redirect_uri") != auth.redirectURI || b64(verifier[:]) != auth.challenge {
+		fail()
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss": i.URL,
+		"aud": auth.clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for k, v := range auth.claims {
+		claims[k] = v
+	}
+
+	idToken, err := i.sign(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// sign returns the claims as a JWT that is signed with the key of the issuer
+func (i *mockIssuer) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + b64(signature), nil
+}
+
+// newOIDCServer returns an auth server with the mock issuer as the provider named test
+func newOIDCServer(t *testing.T) (*Server, *mockIssuer) {
+	t.Helper()
+
+	issuer := newMockIssuer(t)
+	s := newTestServer(t)
+	s.E.OIDCProviders = issuer.provider()
+
+	return s, issuer
+}
+
+// oidcLogin signs in at the issuer with the claims and finishes the login with the code
+func oidcLogin(t *testing.T, s *Server, issuer *mockIssuer, claims map[string]any) (*pb.LoginResponse, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	start, err := s.StartOIDCLogin(ctx, &pb.StartOIDCLoginRequest{Provider: "test"})
+	if err != nil {
+		t.Fatalf("failed to start the login: %v", err)
+	}
+
+	return s.FinishOIDCLogin(ctx, &pb.FinishOIDCLoginRequest{
+		Provider: "test",
+		State:    start.State,
+		Code:     issuer.authorize(t, start.Url, claims),
+	})
+}
+
+func TestOIDCLoginRoundTrip(t *testing.T) {
+	s, issuer := newOIDCServer(t)
+	ctx := context.Background()
+
+	start, err := s.StartOIDCLogin(ctx, &pb.StartOIDCLoginRequest{Provider: "test"})
+	if err != nil {
+		t.Fatalf("failed to start the login: %v", err)
+	}
+	if !strings.HasPrefix(start.Url, issuer.URL+"/authorize?") || !strings.Contains(start.Url, "state="+url.QueryEscape(start.State)) {
+		t.Fatalf("url = %s, want the authorization endpoint with the state", start.Url)
+	}
+
+	req := &pb.FinishOIDCLoginRequest{
+		Provider: "test",
+		State:    start.State,
+		Code: issuer.authorize(t, start.Url, map[string]any{
+			"sub":            "1",
+			"email":          "jane@example.com",
+			"email_verified": true,
+			"name":           "Jane",
+		}),
+	}
+	res, err := s.FinishOIDCLogin(ctx, req)
+	if err != nil {
+		t.Fatalf("failed to finish the login: %v", err)
+	}
+	if !res.Success || res.TokenSet == nil {
+		t.Fatalf("the login did not return the tokens: %v", res)
+	}
+
+	identity := &database.Identity{}
+	if err := s.DB.Preload("User").Where("provider = ? AND subject = ?", "test", "1").First(identity).Error; err != nil {
+		t.Fatalf("failed to get the identity: %v", err)
+	}
+	if identity.User.Email != "jane@example.com" || identity.User.Name != "Jane" {
+		t.Fatalf("user = %+v, want jane@example.com", identity.User)
+	}
+
+	// the state is gone once the login is finished
+	if _, err := s.FinishOIDCLogin(ctx, req); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("err = %v, want Unauthenticated for a state that was already used", err)
+	}
+}
+
+func TestOIDCLoginChecksTheVerifierAndTheNonce(t *testing.T) {
+	s, issuer := newOIDCServer(t)
+	ctx := context.Background()
+	claims := map[string]any{"sub": "1", "email": "jane@example.com", "email_verified": true}
+
+	first, err := s.StartOIDCLogin(ctx, &pb.StartOIDCLoginRequest{Provider: "test"})
+	if err != nil {
+		t.Fatalf("failed to start the login: %v", err)
+	}
+	second, err := s.StartOIDCLogin(ctx, &pb.StartOIDCLoginRequest{Provider: "test"})
+	if err != nil {
+		t.Fatalf("failed to start the login: %v", err)
+	}
+
+	// a code that was issued for another login does not match the verifier of the state
+	_, err = s.FinishOIDCLogin(ctx, &pb.FinishOIDCLoginRequest{
+		Provider: "test",
+		State:    second.State,
+		Code:     issuer.authorize(t, first.Url, claims),
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("err = %v, want Unauthenticated for a code of another login", err)
+	}
+
+	claims["nonce"] = "replayed"
+	_, err = oidcLogin(t, s, issuer, claims)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("err = %v, want Unauthenticated for an ID token with another nonce", err)
+	}
+
+	_, err = s.FinishOIDCLogin(ctx, &pb.FinishOIDCLoginRequest{Provider: "test", State: "unknown", Code: "code"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("err = %v, want Unauthenticated for an unknown state", err)
+	}
+
+	var identities int64
+	s.DB.Model(&database.Identity{}).Count(&identities)
+	if identities != 0 {
+		t.Fatalf("%d identities were linked by the rejected logins", identities)
+	}
+}
+
+func TestOIDCLinksOnlyVerifiedEmails(t *testing.T) {
+	s, issuer := newOIDCServer(t)
+	user := createTestUser(t, s, "jane", "password")
+
+	_, err := oidcLogin(t, s, issuer, map[string]any{"sub": "1", "email": user.Email, "email_verified": false})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("err = %v, want PermissionDenied for an email that the provider has not verified", err)
+	}
+
+	res, err := oidcLogin(t, s, issuer, map[string]any{"sub": "1", "email": user.Email, "email_verified": true})
+	if err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	if res.TokenSet == nil {
+		t.Fatalf("the login did not return the tokens: %v", res)
+	}
+
+	identity := &database.Identity{}
+	if err := s.DB.Where("provider = ? AND subject = ?", "test", "1").First(identity).Error; err != nil {
+		t.Fatalf("failed to get the identity: %v", err)
+	}
+	if identity.UserID != user.ID {
+		t.Fatalf("the identity was linked to user %d, want %d", identity.UserID, user.ID)
+	}
+
+	var users int64
+	s.DB.Model(&database.User{}).Count(&users)
+	if users != 1 {
+		t.Fatalf("there are %d users, want the existing user only", users)
+	}
+}
+
+func TestOIDCDoesNotLinkAnUnverifiedAccount(t *testing.T) {
+	s, issuer := newOIDCServer(t)
+	user := createTestUser(t, s, "jane", "password")
+	s.DB.Model(user).Update("email_verified", false)
+
+	_, err := oidcLogin(t, s, issuer, map[string]any{"sub": "1", "email": user.Email, "email_verified": true})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("err = %v, want FailedPrecondition for an account whose email is not verified", err)
+	}
+
+	var identities int64
+	s.DB.Model(&database.Identity{}).Count(&identities)
+	if identities != 0 {
+		t.Fatalf("%d identities were linked to the unverified account", identities)
+	}
+}
+
+func TestOIDCCreatesUsersWithFreeUsernames(t *testing.T) {
+	s, issuer := newOIDCServer(t)
+	taken := createTestUser(t, s, "janedoe", "password")
+
+	usernames := map[string]bool{taken.Username: true}
+	for i := 1; i <= 2; i++ {
+		sub := fmt.Sprint(i)
+		email := fmt.Sprintf("jane%d@example.org", i)
+
+		_, err := oidcLogin(t, s, issuer, map[string]any{
+			"sub":                sub,
+			"email":              email,
+			"email_verified":     true,
+			"preferred_username": "jane.doe",
+		})
+		if err != nil {
+			t.Fatalf("failed to log in: %v", err)
+		}
+
+		user := &database.User{}
+		if err := s.DB.Where("email = ?", email).First(user).Error; err != nil {
+			t.Fatalf("failed to get the user: %v", err)
+		}
+		if !strings.HasPrefix(user.Username, "janedoe") || len(user.Username) != len("janedoe")+5 {
+			t.Fatalf("username = %q, want janedoe with a suffix", user.Username)
+		}
+		if usernames[user.Username] {
+			t.Fatalf("username %q was given out twice", user.Username)
+		}
+		usernames[user.Username] = true
+
+		if !user.EmailVerified {
+			t.Fatal("the email of the provider was not marked as verified")
+		}
+	}
+}
+
+func TestCreateOIDCUserPadsShortUsernames(t *testing.T) {
+	s := newTestServer(t)
+
+	user, err := s.createOIDCUser(s.DB, &oidcClaims{Email: "jo@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("failed to create the user: %v", err)
+	}
+	if user.Username != "userjo" || user.Name != "jo" {
+		t.Fatalf("username = %q, name = %q, want userjo and jo", user.Username, user.Name)
+	}
+}
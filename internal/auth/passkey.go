@@ -0,0 +1,707 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	rdb "github.com/VinukaThejana/todoapp/internal/redis"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	// passkeyTimeout is the time the browser has to finish a passkey registration or login
+	passkeyTimeout = 5 * time.Minute
+	maxPasskeys    = 10
+	rpName         = "todoapp"
+)
+
+// passkeyUser is the user of a WebAuthn ceremony along with the passkeys they have registered
+type passkeyUser struct {
+	user     *database.User
+	passkeys []database.Passkey
+}
+
+// WebAuthnID returns the user handle, it is the id of the user so that the user can be found from the handle
+// that the authenticator returns at a login without a username
+func (u *passkeyUser) WebAuthnID() []byte {
+	return []byte(strconv.FormatUint(uint64(u.user.ID), 10))
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	return u.user.Name
+}
+
+func (u *passkeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := []webauthn.Credential{}
+	for _, passkey := range u.passkeys {
+		transports := []protocol.AuthenticatorTransport{}
+		for _, transport := range strings.Split(passkey.Transports, ",") {
+			if transport != "" {
+				transports = append(transports, protocol.AuthenticatorTransport(transport))
+			}
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              passkey.CredentialID,
+			PublicKey:       passkey.PublicKey,
+			AttestationType: passkey.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: passkey.BackupEligible,
+				BackupState:    passkey.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    passkey.AAGUID,
+				SignCount: passkey.SignCount,
+			},
+		})
+	}
+
+	return credentials
+}
+
+// passkey returns the passkey of the user with the given credential id
+func (u *passkeyUser) passkey(credentialID []byte) *database.Passkey {
+	for i := range u.passkeys {
+		if bytes.Equal(u.passkeys[i].CredentialID, credentialID) {
+			return &u.passkeys[i]
+		}
+	}
+
+	return nil
+}
+
+// ceremony is what is kept in Redis between the start and the end of a WebAuthn ceremony
+type ceremony struct {
+	Kind    enums.WebAuthnCeremony `json:"kind"`
+	UserID  uint                   `json:"user_id,omitempty"`
+	Name    string                 `json:"name,omitempty"`
+	Session webauthn.SessionData   `json:"session"`
+	// ChallengeToken is the token that Login returned when the passkey is used as the second factor
+	ChallengeToken string `json:"challenge_token,omitempty"`
+}
+
+// relyingParty returns the WebAuthn relying party, the domain is the relying party id and the origins default to
+// the URL of the API gateway
+func (s *Server) relyingParty() (*webauthn.WebAuthn, error) {
+	origins := []string{}
+	for _, origin := range strings.Split(s.E.WebAuthnOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		origins = append(origins, s.appURL())
+	}
+
+	name := s.E.WebAuthnRPName
+	if name == "" {
+		name = rpName
+	}
+
+	timeout := webauthn.TimeoutConfig{
+		Enforce:    true,
+		Timeout:    passkeyTimeout,
+		TimeoutUVD: passkeyTimeout,
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          s.E.Domain,
+		RPDisplayName: name,
+		RPOrigins:     origins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        timeout,
+			Registration: timeout,
+		},
+	})
+}
+
+// passkeyUser loads the user and their passkeys
+func (s *Server) passkeyUser(userID uint) (*passkeyUser, error) {
+	user := &database.User{}
+	err := s.DB.First(&user, userID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys := []database.Passkey{}
+	err = s.DB.Where("user_id = ?", userID).Order("id").Find(&passkeys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &passkeyUser{
+		user:     user,
+		passkeys: passkeys,
+	}, nil
+}
+
+// saveCeremony stores the ceremony under the challenge that is sent to the browser
+func (s *Server) saveCeremony(ctx context.Context, c *ceremony) error {
+	val, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	return s.R.Set(ctx, rdb.WebAuthnKey(c.Session.Challenge), val, passkeyTimeout).Err()
+}
+
+// takeCeremony returns the ceremony that the challenge was sent for and deletes it so that every challenge can
+// only be answered once, ok is false when the ceremony is unknown or has expired
+func (s *Server) takeCeremony(ctx context.Context, challenge string) (c *ceremony, ok bool, err error) {
+	val, err := s.R.GetDel(ctx, rdb.WebAuthnKey(challenge)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	c = &ceremony{}
+	err = json.Unmarshal(val, c)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return c, true, nil
+}
+
+// usePasskey stores the signature counter of the assertion, a counter that did not go up means that the passkey
+// may have been cloned and the login is rejected. The update only succeeds when the stored counter has not changed
+// since it was read so that the same assertion can not be used by concurrent requests.
+func (s *Server) usePasskey(passkey *database.Passkey, credential *webauthn.Credential) (bool, error) {
+	if credential.Authenticator.CloneWarning {
+		log.Warn().Uint("passkey", passkey.ID).Uint("user", passkey.UserID).Msg("the signature counter of the passkey did not go up")
+		return false, nil
+	}
+
+	res := s.DB.Model(&database.Passkey{}).
+		Where("id = ? AND sign_count = ?", passkey.ID, passkey.SignCount).
+		Updates(map[string]any{
+			"sign_count":   credential.Authenticator.SignCount,
+			"backup_state": credential.Flags.BackupState,
+			"last_used_at": time.Now(),
+		})
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return res.RowsAffected == 1, nil
+}
+
+func passkeyToPB(p *database.Passkey) *pb.Passkey {
+	res := &pb.Passkey{
+		Id:        fmt.Sprint(p.ID),
+		Name:      p.Name,
+		CreatedAt: p.CreatedAt.Format(time.RFC3339),
+		BackedUp:  p.BackupState,
+	}
+	if p.LastUsedAt != nil {
+		res.LastUsedAt = p.LastUsedAt.Format(time.RFC3339)
+	}
+
+	return res
+}
+
+// BeginPasskeyRegistration is a gRPC endpoint to start the registration of a passkey, the options are passed to
+// navigator.credentials.create in the browser
+// returns Internal, NotFound, ResourceExhausted, nil
+func (s *Server) BeginPasskeyRegistration(ctx context.Context, req *pb.BeginPasskeyRegistrationRequest) (*pb.BeginPasskeyResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.BeginPasskeyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	user, err := s.passkeyUser(uint(userID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &pb.BeginPasskeyResponse{
+				Success: false,
+			}, status.Error(codes.NotFound, "user not found")
+		}
+
+		log.Error().Err(err).Msg("failed to get the passkeys")
+		return &pb.BeginPasskeyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the passkeys")
+	}
+	if len(user.passkeys) >= maxPasskeys {
+		return &pb.BeginPasskeyResponse{
+			Success: false,
+			Message: fmt.Sprintf("Can not have more than %d passkeys", maxPasskeys),
+		}, status.Error(codes.ResourceExhausted, fmt.Sprintf("can not have more than %d passkeys", maxPasskeys))
+	}
+
+	rp, err := s.relyingParty()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the relying party")
+		return &pb.BeginPasskeyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to start the registration")
+	}
+
+	exclusions := []protocol.CredentialDescriptor{}
+	for _, credential := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	// a discoverable credential is needed so that the passkey can be used without a username
+	options, session, err := rp.BeginRegistration(
+		user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to start the registration")
+		return &pb.BeginPasskeyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to start the registration")
+	}
+
+	err = s.saveCeremony(ctx, &ceremony{
+		Kind:    enums.PasskeyRegistration,
+		UserID:  user.user.ID,
+		Name:    req.Name,
+		Session: *session,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to save the registration")
+		return &pb.BeginPasskeyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to start the registration")
+	}
+
+	res, err := json.Marshal(options)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to encode the options")
+		return &pb.BeginPasskeyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to start the registration")
+	}
+
+	return &pb.BeginPasskeyResponse{
+		Success: true,
+		Options: res,
+	}, nil
+}
+
+// FinishPasskeyRegistration is a gRPC endpoint to store the passkey that the browser created with the options
+// from BeginPasskeyRegistration
+// returns Internal, InvalidArgument, NotFound, AlreadyExists, nil
+func (s *Server) FinishPasskeyRegistration(ctx context.Context, req *pb.FinishPasskeyRegistrationRequest) (*pb.FinishPasskeyRegistrationResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.FinishPasskeyRegistrationResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		return &pb.FinishPasskeyRegistrationResponse{
+			Success: false,
+			Message: "Invalid credential",
+		}, status.Error(codes.InvalidArgument, "invalid credential")
+	}
+
+	c, ok, err := s.takeCeremony(ctx, parsed.Response.CollectedClientData.Challenge)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the registration")
+		return &pb.FinishPasskeyRegistrationResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the registration")
+	}
+	if !ok || c.Kind != enums.PasskeyRegistration || c.UserID != uint(userID) {
+		return &pb.FinishPasskeyRegistrationResponse{
+			Success: false,
+			Message: "Invalid or expired registration",
+		}, status.Error(codes.NotFound, "the registration is invalid or has expired")
+	}
+
+	user, err := s.passkeyUser(c.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the passkeys")
+		return &pb.FinishPasskeyRegistrationResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the passkeys")
+	}
+
+	rp, err := s.relyingParty()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the relying party")
+		return &pb.FinishPasskeyRegistrationResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to finish the registration")
+	}
+
+	credential, err := rp.CreateCredential(user, c.Session, parsed)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to verify the credential")
+		return &pb.FinishPasskeyRegistrationResponse{
+			Success: false,
+			Message: "Invalid credential",
+		}, status.Error(codes.InvalidArgument, "invalid credential")
+	}
+
+	transports := []string{}
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	passkey := &database.Passkey{
+		UserID:          c.UserID,
+		Name:            c.Name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      strings.Join(transports, ","),
+		AAGUID:          credential.Authenticator.AAGUID,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		SignCount:       credential.Authenticator.SignCount,
+	}
+	err = s.DB.Create(&passkey).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return &pb.FinishPasskeyRegistrationResponse{
+				Success: false,
+				Message: "Passkey is already registered",
+			}, status.Error(codes.AlreadyExists, "the passkey is already registered")
+		}
+
+		log.Error().Err(err).Msg("failed to save the passkey")
+		return &pb.FinishPasskeyRegistrationResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to save the passkey")
+	}
+
+	return &pb.FinishPasskeyRegistrationResponse{
+		Success: true,
+		Message: "Passkey registered successfully",
+		Passkey: passkeyToPB(passkey),
+	}, nil
+}
+
+// ListPasskeys is a gRPC endpoint to list the passkeys of the user
+// returns Internal, nil
+func (s *Server) ListPasskeys(ctx context.Context, req *pb.ListPasskeysRequest) (*pb.ListPasskeysResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.ListPasskeysResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	passkeys := []*database.Passkey{}
+
+	err = s.DB.Where("user_id = ?", userID).Order("id").Find(&passkeys).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the passkeys")
+		return &pb.ListPasskeysResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the passkeys")
+	}
+
+	res := &pb.ListPasskeysResponse{
+		Success:  true,
+		Passkeys: []*pb.Passkey{},
+	}
+	for _, passkey := range passkeys {
+		res.Passkeys = append(res.Passkeys, passkeyToPB(passkey))
+	}
+
+	return res, nil
+}
+
+// DeletePasskey is a gRPC endpoint to delete a passkey of the user
+// returns Internal, NotFound, nil
+func (s *Server) DeletePasskey(ctx context.Context, req *pb.DeletePasskeyRequest) (*pb.DeletePasskeyResponse, error) {
+	id, err := strconv.ParseUint(req.Id, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse passkey id")
+		return &pb.DeletePasskeyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse passkey id")
+	}
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.DeletePasskeyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	res := s.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&database.Passkey{})
+	if res.Error != nil {
+		log.Error().Err(res.Error).Msg("failed to delete the passkey")
+		return &pb.DeletePasskeyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to delete the passkey")
+	}
+	if res.RowsAffected == 0 {
+		return &pb.DeletePasskeyResponse{
+			Success: false,
+			Message: "Passkey not found",
+		}, status.Error(codes.NotFound, "passkey not found")
+	}
+
+	return &pb.DeletePasskeyResponse{
+		Success: true,
+		Message: "Passkey deleted successfully",
+	}, nil
+}
+
+// BeginPasskeyLogin is a gRPC endpoint to start a login with a passkey, the options are passed to
+// navigator.credentials.get in the browser. Without a challenge token the passkey logs in the user on its own,
+// with the challenge token from Login the passkey is the second factor.
+// returns Internal, Unauthenticated, FailedPrecondition, nil
+func (s *Server) BeginPasskeyLogin(ctx context.Context, req *pb.BeginPasskeyLoginRequest) (*pb.BeginPasskeyResponse, error) {
+	rp, err := s.relyingParty()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the relying party")
+		return &pb.BeginPasskeyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to start the login")
+	}
+
+	var (
+		options *protocol.CredentialAssertion
+		c       *ceremony
+	)
+
+	if req.ChallengeToken == "" {
+		// the passkey replaces the password so the authenticator has to verify the user
+		var session *webauthn.SessionData
+		options, session, err = rp.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to start the login")
+			return &pb.BeginPasskeyResponse{
+				Success: false,
+			}, status.Error(codes.Internal, "failed to start the login")
+		}
+
+		c = &ceremony{
+			Kind:    enums.PasskeyLogin,
+			Session: *session,
+		}
+	} else {
+		userID, ok, err := s.challengeUser(ctx, req.ChallengeToken)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get the challenge")
+			return &pb.BeginPasskeyResponse{
+				Success: false,
+			}, status.Error(codes.Internal, "failed to get the challenge")
+		}
+		if !ok {
+			return &pb.BeginPasskeyResponse{
+				Success: false,
+				Message: "Invalid or expired challenge",
+			}, errChallenge
+		}
+
+		user, err := s.passkeyUser(userID)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get the passkeys")
+			return &pb.BeginPasskeyResponse{
+				Success: false,
+			}, status.Error(codes.Internal, "failed to get the passkeys")
+		}
+		if len(user.passkeys) == 0 {
+			return &pb.BeginPasskeyResponse{
+				Success: false,
+				Message: "No passkeys registered",
+			}, status.Error(codes.FailedPrecondition, "the user has no passkeys")
+		}
+
+		var session *webauthn.SessionData
+		options, session, err = rp.BeginLogin(user)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to start the login")
+			return &pb.BeginPasskeyResponse{
+				Success: false,
+			}, status.Error(codes.Internal, "failed to start the login")
+		}
+
+		c = &ceremony{
+			Kind:           enums.PasskeySecondFactor,
+			UserID:         userID,
+			Session:        *session,
+			ChallengeToken: req.ChallengeToken,
+		}
+	}
+
+	err = s.saveCeremony(ctx, c)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to save the login")
+		return &pb.BeginPasskeyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to start the login")
+	}
+
+	res, err := json.Marshal(options)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to encode the options")
+		return &pb.BeginPasskeyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to start the login")
+	}
+
+	return &pb.BeginPasskeyResponse{
+		Success: true,
+		Options: res,
+	}, nil
+}
+
+// FinishPasskeyLogin is a gRPC endpoint to complete a login with the assertion that the browser returned for
+// the options from BeginPasskeyLogin
+// returns Internal, InvalidArgument, Unauthenticated, FailedPrecondition, PermissionDenied, nil
+func (s *Server) FinishPasskeyLogin(ctx context.Context, req *pb.FinishPasskeyLoginRequest) (*pb.LoginResponse, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Invalid credential",
+		}, status.Error(codes.InvalidArgument, "invalid credential")
+	}
+
+	c, ok, err := s.takeCeremony(ctx, parsed.Response.CollectedClientData.Challenge)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the login")
+		return &pb.LoginResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the login")
+	}
+	if !ok || (c.Kind != enums.PasskeyLogin && c.Kind != enums.PasskeySecondFactor) {
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Invalid or expired login",
+		}, status.Error(codes.Unauthenticated, "the login is invalid or has expired")
+	}
+
+	rp, err := s.relyingParty()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the relying party")
+		return &pb.LoginResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to finish the login")
+	}
+
+	var (
+		user       *passkeyUser
+		credential *webauthn.Credential
+	)
+
+	if c.Kind == enums.PasskeyLogin {
+		credential, err = rp.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			userID, err := strconv.ParseUint(string(userHandle), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			user, err = s.passkeyUser(uint(userID))
+			return user, err
+		}, c.Session, parsed)
+	} else {
+		user, err = s.passkeyUser(c.UserID)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get the passkeys")
+			return &pb.LoginResponse{
+				Success: false,
+			}, status.Error(codes.Internal, "failed to get the passkeys")
+		}
+
+		credential, err = rp.ValidateLogin(user, c.Session, parsed)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to verify the passkey")
+		if c.Kind == enums.PasskeySecondFactor {
+			s.failChallenge(ctx, c.ChallengeToken)
+		}
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Invalid passkey",
+		}, status.Error(codes.Unauthenticated, "invalid passkey")
+	}
+
+	passkey := user.passkey(credential.ID)
+	if passkey == nil {
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Invalid passkey",
+		}, status.Error(codes.Unauthenticated, "invalid passkey")
+	}
+
+	ok, err = s.usePasskey(passkey, credential)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to update the passkey")
+		return &pb.LoginResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to update the passkey")
+	}
+	if !ok {
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Invalid passkey",
+		}, status.Error(codes.Unauthenticated, "invalid passkey")
+	}
+
+	if c.Kind == enums.PasskeySecondFactor {
+		return s.completeLogin(ctx, c.ChallengeToken, c.UserID)
+	}
+
+	if user.user.DisabledAt != nil {
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Account is disabled",
+		}, errDisabled
+	}
+	if s.E.RequireVerifiedEmail && !user.user.EmailVerified {
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Email is not verified",
+		}, status.Error(codes.FailedPrecondition, "the email address is not verified")
+	}
+
+	tokenSet, err := s.issueTokens(ctx, user.user)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the tokens")
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Failed to create tokens",
+		}, status.Error(codes.Internal, "failed to create tokens")
+	}
+
+	return &pb.LoginResponse{
+		Success:  true,
+		Message:  "User logged in successfully",
+		TokenSet: tokenSet,
+	}, nil
+}
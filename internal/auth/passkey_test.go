@@ -0,0 +1,409 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the flags of the authenticator data
+const (
+	flagUserPresent        = 0x01
+	flagUserVerified       = 0x04
+	flagAttestedCredential = 0x40
+)
+
+// softAuthenticator is a software authenticator that answers the options of the relying party the way that a
+// browser and a platform authenticator would, it holds a single discoverable credential
+type softAuthenticator struct {
+	origin       string
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T, origin string) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate the key: %v", err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatalf("failed to generate the credential id: %v", err)
+	}
+
+	return &softAuthenticator{
+		origin:       origin,
+		key:          key,
+		credentialID: credentialID,
+	}
+}
+
+// publicKeyOptions is the part of the options of navigator.credentials.create and navigator.credentials.get
+// that the authenticator needs
+type publicKeyOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		RPID      string `json:"rpId"`
+		RP        struct {
+			ID string `json:"id"`
+		} `json:"rp"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	} `json:"publicKey"`
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// clientData returns the client data that the browser would collect for the ceremony
+func (a *softAuthenticator) clientData(t *testing.T, kind, challenge string) []byte {
+	t.Helper()
+
+	clientData, err := json.Marshal(map[string]any{
+		"type":        kind,
+		"challenge":   challenge,
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+	if err != nil {
+		t.Fatalf("failed to encode the client data: %v", err)
+	}
+
+	return clientData
+}
+
+// authenticatorData returns the authenticator data for the relying party with the signature counter
+func (a *softAuthenticator) authenticatorData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	return data
+}
+
+// create answers the options of BeginPasskeyRegistration with a new credential and an attestation of the
+// format none
+func (a *softAuthenticator) create(t *testing.T, raw []byte) []byte {
+	t.Helper()
+
+	options := publicKeyOptions{}
+	if err := json.Unmarshal(raw, &options); err != nil {
+		t.Fatalf("failed to decode the options: %v", err)
+	}
+	userHandle, err := base64.RawURLEncoding.DecodeString(options.PublicKey.User.ID)
+	if err != nil {
+		t.Fatalf("failed to decode the user handle: %v", err)
+	}
+	a.userHandle = userHandle
+
+	publicKey, err := webauthncbor.Marshal(map[int]any{
+		1:  2,  // the key type is EC2
+		3:  -7, // the algorithm is ES256
+		-1: 1,  // the curve is P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatalf("failed to encode the public key: %v", err)
+	}
+
+	authData := a.authenticatorData(options.PublicKey.RP.ID, flagUserPresent|flagUserVerified|flagAttestedCredential)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatalf("failed to encode the attestation: %v", err)
+	}
+
+	credential, err := json.Marshal(map[string]any{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64(a.clientData(t, "webauthn.create", options.PublicKey.Challenge)),
+			"attestationObject": b64(attestation),
+			"transports":        []string{"internal"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to encode the credential: %v", err)
+	}
+
+	return credential
+}
+
+// get answers the options of BeginPasskeyLogin with an assertion, the signature counter goes up with every
+// assertion like it does on a real authenticator
+func (a *softAuthenticator) get(t *testing.T, raw []byte) []byte {
+	t.Helper()
+
+	options := publicKeyOptions{}
+	if err := json.Unmarshal(raw, &options); err != nil {
+		t.Fatalf("failed to decode the options: %v", err)
+	}
+
+	a.signCount++
+	authData := a.authenticatorData(options.PublicKey.RPID, flagUserPresent|flagUserVerified)
+	clientData := a.clientData(t, "webauthn.get", options.PublicKey.Challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign the assertion: %v", err)
+	}
+
+	credential, err := json.Marshal(map[string]any{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(signature),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to encode the assertion: %v", err)
+	}
+
+	return credential
+}
+
+// registerPasskey registers a passkey of the authenticator for the user
+func registerPasskey(t *testing.T, s *Server, user *database.User, a *softAuthenticator) {
+	t.Helper()
+	ctx := context.Background()
+	userID := fmt.Sprint(user.ID)
+
+	begin, err := s.BeginPasskeyRegistration(ctx, &pb.BeginPasskeyRegistrationRequest{
+		UserId: userID,
+		Name:   "laptop",
+	})
+	if err != nil {
+		t.Fatalf("failed to begin the registration: %v", err)
+	}
+
+	_, err = s.FinishPasskeyRegistration(ctx, &pb.FinishPasskeyRegistrationRequest{
+		UserId:     userID,
+		Credential: a.create(t, begin.Options),
+	})
+	if err != nil {
+		t.Fatalf("failed to finish the registration: %v", err)
+	}
+}
+
+// passkeyLogin logs in with a passkey of the authenticator, the challenge token makes the passkey the second factor
+func passkeyLogin(t *testing.T, s *Server, a *softAuthenticator, challengeToken string) (*pb.LoginResponse, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	begin, err := s.BeginPasskeyLogin(ctx, &pb.BeginPasskeyLoginRequest{
+		ChallengeToken: challengeToken,
+	})
+	if err != nil {
+		t.Fatalf("failed to begin the login: %v", err)
+	}
+
+	return s.FinishPasskeyLogin(ctx, &pb.FinishPasskeyLoginRequest{
+		Credential: a.get(t, begin.Options),
+	})
+}
+
+func TestPasskeyRegistrationAndDiscoverableLogin(t *testing.T) {
+	s := newTestServer(t)
+	user := createTestUser(t, s, "jane", "password")
+	a := newSoftAuthenticator(t, s.appURL())
+
+	registerPasskey(t, s, user, a)
+
+	list, err := s.ListPasskeys(context.Background(), &pb.ListPasskeysRequest{UserId: fmt.Sprint(user.ID)})
+	if err != nil {
+		t.Fatalf("failed to list the passkeys: %v", err)
+	}
+	if len(list.Passkeys) != 1 || list.Passkeys[0].Name != "laptop" {
+		t.Fatalf("passkeys = %v, want the laptop passkey", list.Passkeys)
+	}
+
+	res, err := passkeyLogin(t, s, a, "")
+	if err != nil {
+		t.Fatalf("failed to log in with the passkey: %v", err)
+	}
+	if !res.Success || res.TokenSet == nil || res.TokenSet.AccessToken == "" {
+		t.Fatalf("the login did not return the tokens: %v", res)
+	}
+
+	passkey := &database.Passkey{}
+	if err := s.DB.Where("user_id = ?", user.ID).First(passkey).Error; err != nil {
+		t.Fatalf("failed to get the passkey: %v", err)
+	}
+	if passkey.SignCount != a.signCount || passkey.LastUsedAt == nil {
+		t.Fatalf("sign count = %d, last used at = %v, want %d and the time of the login", passkey.SignCount, passkey.LastUsedAt, a.signCount)
+	}
+}
+
+func TestPasskeyLoginRejectsASignCountThatDidNotGoUp(t *testing.T) {
+	s := newTestServer(t)
+	user := createTestUser(t, s, "jane", "password")
+	a := newSoftAuthenticator(t, s.appURL())
+
+	registerPasskey(t, s, user, a)
+	if _, err := passkeyLogin(t, s, a, ""); err != nil {
+		t.Fatalf("failed to log in with the passkey: %v", err)
+	}
+
+	// a clone of the authenticator starts from the counter that it was copied at
+	a.signCount--
+	_, err := passkeyLogin(t, s, a, "")
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("err = %v, want Unauthenticated", err)
+	}
+
+	passkey := &database.Passkey{}
+	if err := s.DB.Where("user_id = ?", user.ID).First(passkey).Error; err != nil {
+		t.Fatalf("failed to get the passkey: %v", err)
+	}
+	if passkey.SignCount != a.signCount {
+		t.Fatalf("sign count = %d, want it to stay at %d", passkey.SignCount, a.signCount)
+	}
+}
+
+func TestUsePasskeyRejectsAStaleCounter(t *testing.T) {
+	s := newTestServer(t)
+	user := createTestUser(t, s, "jane", "password")
+
+	passkey := &database.Passkey{
+		UserID:       user.ID,
+		Name:         "laptop",
+		CredentialID: []byte("credential"),
+		PublicKey:    []byte("key"),
+		SignCount:    5,
+	}
+	if err := s.DB.Create(passkey).Error; err != nil {
+		t.Fatalf("failed to create the passkey: %v", err)
+	}
+
+	// another request used the passkey since it was read
+	stale := *passkey
+	stale.SignCount = 4
+
+	ok, err := s.usePasskey(&stale, &webauthn.Credential{Authenticator: webauthn.Authenticator{SignCount: 6}})
+	if err != nil {
+		t.Fatalf("failed to use the passkey: %v", err)
+	}
+	if ok {
+		t.Fatal("the passkey was used with a stale counter")
+	}
+
+	ok, err = s.usePasskey(passkey, &webauthn.Credential{Authenticator: webauthn.Authenticator{SignCount: 6}})
+	if err != nil {
+		t.Fatalf("failed to use the passkey: %v", err)
+	}
+	if !ok {
+		t.Fatal("the passkey was not used with the current counter")
+	}
+}
+
+func TestPasskeyAsTheSecondFactor(t *testing.T) {
+	s := newTestServer(t)
+	user := createTestUser(t, s, "jane", "password")
+	a := newSoftAuthenticator(t, s.appURL())
+	ctx := context.Background()
+
+	registerPasskey(t, s, user, a)
+	confirmedAt := time.Now()
+	if err := s.DB.Create(&database.TOTP{UserID: user.ID, Secret: "secret", ConfirmedAt: &confirmedAt}).Error; err != nil {
+		t.Fatalf("failed to enable two-factor authentication: %v", err)
+	}
+
+	login, err := s.Login(ctx, &pb.LoginRequest{
+		Login:    &pb.LoginRequest_Username{Username: "jane"},
+		Password: "password",
+	})
+	if err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	if !login.SecondFactorRequired || login.ChallengeToken == "" || login.TokenSet != nil {
+		t.Fatalf("the login did not ask for the second factor: %v", login)
+	}
+
+	res, err := passkeyLogin(t, s, a, login.ChallengeToken)
+	if err != nil {
+		t.Fatalf("failed to prove the second factor with the passkey: %v", err)
+	}
+	if !res.Success || res.TokenSet == nil {
+		t.Fatalf("the second factor did not return the tokens: %v", res)
+	}
+
+	// the challenge token ends with the login
+	_, err = s.BeginPasskeyLogin(ctx, &pb.BeginPasskeyLoginRequest{ChallengeToken: login.ChallengeToken})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("err = %v, want Unauthenticated", err)
+	}
+}
+
+func TestPasskeyChallengeCanOnlyBeAnsweredOnce(t *testing.T) {
+	s := newTestServer(t)
+	user := createTestUser(t, s, "jane", "password")
+	a := newSoftAuthenticator(t, s.appURL())
+	ctx := context.Background()
+
+	begin, err := s.BeginPasskeyRegistration(ctx, &pb.BeginPasskeyRegistrationRequest{
+		UserId: fmt.Sprint(user.ID),
+		Name:   "laptop",
+	})
+	if err != nil {
+		t.Fatalf("failed to begin the registration: %v", err)
+	}
+	credential := a.create(t, begin.Options)
+	req := &pb.FinishPasskeyRegistrationRequest{UserId: fmt.Sprint(user.ID), Credential: credential}
+	if _, err := s.FinishPasskeyRegistration(ctx, req); err != nil {
+		t.Fatalf("failed to finish the registration: %v", err)
+	}
+	if _, err := s.FinishPasskeyRegistration(ctx, req); status.Code(err) != codes.NotFound {
+		t.Fatalf("err = %v, want NotFound for a registration that was already finished", err)
+	}
+
+	login, err := s.BeginPasskeyLogin(ctx, &pb.BeginPasskeyLoginRequest{})
+	if err != nil {
+		t.Fatalf("failed to begin the login: %v", err)
+	}
+	assertion := &pb.FinishPasskeyLoginRequest{Credential: a.get(t, login.Options)}
+	if _, err := s.FinishPasskeyLogin(ctx, assertion); err != nil {
+		t.Fatalf("failed to log in with the passkey: %v", err)
+	}
+	if _, err := s.FinishPasskeyLogin(ctx, assertion); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("err = %v, want Unauthenticated for a login that was already finished", err)
+	}
+}
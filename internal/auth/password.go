@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/auth/tokens"
+	"github.com/VinukaThejana/todoapp/internal/database"
+	rdb "github.com/VinukaThejana/todoapp/internal/redis"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxPasswordResets is the number of password resets that can be asked for an email in passwordResetWindow
+	maxPasswordResets   = 3
+	passwordResetWindow = time.Hour
+)
+
+// RequestPasswordReset is a gRPC endpoint to send a password reset link to the email of a user, the response
+// is the same whether or not the email belongs to a user so that it can not be used to find out who has an account
+// returns Internal, ResourceExhausted, nil
+func (s *Server) RequestPasswordReset(ctx context.Context, req *pb.RequestPasswordResetRequest) (*pb.RequestPasswordResetResponse, error) {
+	res := &pb.RequestPasswordResetResponse{
+		Success: true,
+		Message: "If the email belongs to an account a password reset link has been sent",
+	}
+
+	// the rate limit and the lookup use the same normalized email so that changing its case or padding it
+	// does not get around the limit, emails are stored with the case that they were registered with
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	// the limit is applied to every email, known or not, so hitting it does not tell anything about the email
+	rateKey := rdb.PasswordResetRateKey(hashToken(email))
+	pipe := s.R.TxPipeline()
+	count := pipe.Incr(ctx, rateKey)
+	pipe.ExpireNX(ctx, rateKey, passwordResetWindow)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the password resets")
+		return &pb.RequestPasswordResetResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to send the password reset email")
+	}
+	if count.Val() > maxPasswordResets {
+		return &pb.RequestPasswordResetResponse{
+			Success: false,
+			Message: "Too many password resets, try again later",
+		}, status.Error(codes.ResourceExhausted, "too many password resets for this email")
+	}
+
+	user := &database.User{}
+	err = s.DB.Where("LOWER(email) = ?", email).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return res, nil
+		}
+
+		log.Error().Err(err).Msg("failed to find the user")
+		return &pb.RequestPasswordResetResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to find the user")
+	}
+
+	err = s.sendPasswordReset(ctx, user, false)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the password reset token")
+		return &pb.RequestPasswordResetResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to send the password reset email")
+	}
+
+	return res, nil
+}
+
+// sendPasswordReset emails a password reset link to the user, forced resets were asked for by an admin and the
+// user can not log in with the old password anymore
+func (s *Server) sendPasswordReset(ctx context.Context, user *database.User, forced bool) error {
+	ttl := rdb.PasswordResetTTL(s.E)
+	token, err := s.issueToken(ctx, rdb.PasswordResetKey, rdb.PasswordResetUserKey(user.ID), user.ID, ttl)
+	if err != nil {
+		return err
+	}
+
+	body := "Hi %s,\n\nOpen the link below to choose a new password, it expires in %s.\n\n%s/auth/password/reset?token=%s\n\nIf you did not ask for a password reset you can ignore this email, your password has not been changed."
+	if forced {
+		body = "Hi %s,\n\nYou have to choose a new password before you can log in again, your old password does not work anymore. Open the link below to choose a new password, it expires in %s.\n\n%s/auth/password/reset?token=%s\n\nIf the link expires you can ask for another one with the forgot password form."
+	}
+	s.sendMail(user.Email, "Reset your password", fmt.Sprintf(body, user.Name, ttl, s.appURL(), token))
+
+	return nil
+}
+
+// ResetPassword is a gRPC endpoint to set a new password with the token from a password reset email, every
+// session of the user is revoked so that whoever knew the old password is logged out
+// returns Internal, NotFound, nil
+func (s *Server) ResetPassword(ctx context.Context, req *pb.ResetPasswordRequest) (*pb.ResetPasswordResponse, error) {
+	userID, ok, err := s.redeemToken(ctx, rdb.PasswordResetKey, req.Token)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the password reset token")
+		return &pb.ResetPasswordResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the password reset token")
+	}
+	if !ok {
+		return &pb.ResetPasswordResponse{
+			Success: false,
+			Message: "Invalid or expired token",
+		}, status.Error(codes.NotFound, "the token is invalid or has expired")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to hash the password")
+		return &pb.ResetPasswordResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to hash the password")
+	}
+
+	// the link was opened from the inbox of the user so the email is verified as well
+	err = s.DB.Model(&database.User{}).Where("id = ?", userID).Updates(map[string]any{
+		"password":                string(hashedPassword),
+		"email_verified":          true,
+		"password_reset_required": false,
+	}).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to update the password")
+		return &pb.ResetPasswordResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to update the password")
+	}
+
+	s.R.Del(ctx, rdb.PasswordResetUserKey(userID))
+
+	rt := tokens.NewRefreshToken(s.E, s.DB, s.R)
+	err = rt.RevokeAll(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to revoke the sessions")
+		return &pb.ResetPasswordResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to revoke the sessions")
+	}
+
+	return &pb.ResetPasswordResponse{
+		Success: true,
+		Message: "Password reset successfully",
+	}, nil
+}
@@ -0,0 +1,486 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/auth/totp"
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	rdb "github.com/VinukaThejana/todoapp/internal/redis"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer = "todoapp"
+	// challengeTTL is the time a user has to prove the second factor after the password was accepted
+	challengeTTL = 5 * time.Minute
+	// maxChallengeAttempts is the number of wrong codes after which the user has to log in again
+	maxChallengeAttempts = 5
+	recoveryCodes        = 10
+)
+
+// errChallenge is returned for challenge tokens that are unknown, expired or used up
+var errChallenge = status.Error(codes.Unauthenticated, "the challenge is invalid or has expired")
+
+// hashRecoveryCode hashes the recovery code, dashes, spaces and the case are ignored like for app passwords
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCode generates a recovery code in the xxxxx-xxxxx format
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	encoded := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return encoded[:5] + "-" + encoded[5:10], nil
+}
+
+// hasSecondFactor reports whether the user has to prove a second factor at login
+func (s *Server) hasSecondFactor(userID uint) (bool, error) {
+	var count int64
+	err := s.DB.Model(&database.TOTP{}).Where("user_id = ? AND confirmed_at IS NOT NULL", userID).Count(&count).Error
+	return count > 0, err
+}
+
+// secondFactors returns the methods that the user can prove the second factor with, it is empty when the user
+// does not have to prove a second factor. Passkeys are only offered once two-factor authentication is enabled
+// so that the recovery codes are there when the passkey is lost.
+func (s *Server) secondFactors(userID uint) ([]string, error) {
+	enabled, err := s.hasSecondFactor(userID)
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	var passkeys int64
+	err = s.DB.Model(&database.Passkey{}).Where("user_id = ?", userID).Count(&passkeys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	factors := []string{string(enums.TOTPFactor)}
+	if passkeys > 0 {
+		factors = append(factors, string(enums.PasskeyFactor))
+	}
+
+	return factors, nil
+}
+
+// createChallenge creates the challenge token that a user exchanges for the tokens of a session by proving the
+// second factor
+func (s *Server) createChallenge(ctx context.Context, userID uint) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.R.Set(ctx, rdb.SecondFactorKey(hashToken(token)), userID, challengeTTL).Err()
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// challengeUser returns the user that the challenge token was given to, ok is false when the challenge is
+// unknown or has expired
+func (s *Server) challengeUser(ctx context.Context, token string) (userID uint, ok bool, err error) {
+	val, err := s.R.Get(ctx, rdb.SecondFactorKey(hashToken(token))).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	id, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return uint(id), true, nil
+}
+
+// failChallenge counts a wrong attempt to complete the challenge and drops the challenge once there were too many
+func (s *Server) failChallenge(ctx context.Context, token string) {
+	hash := hashToken(token)
+
+	pipe := s.R.TxPipeline()
+	attempts := pipe.Incr(ctx, rdb.SecondFactorAttemptsKey(hash))
+	pipe.Expire(ctx, rdb.SecondFactorAttemptsKey(hash), challengeTTL)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the challenge attempts")
+		return
+	}
+
+	if attempts.Val() >= maxChallengeAttempts {
+		s.R.Del(ctx, rdb.SecondFactorKey(hash), rdb.SecondFactorAttemptsKey(hash))
+	}
+}
+
+// completeChallenge removes the challenge, it returns false when the challenge was already completed by a
+// concurrent request
+func (s *Server) completeChallenge(ctx context.Context, token string) (bool, error) {
+	hash := hashToken(token)
+
+	deleted, err := s.R.Del(ctx, rdb.SecondFactorKey(hash)).Result()
+	if err != nil {
+		return false, err
+	}
+	s.R.Del(ctx, rdb.SecondFactorAttemptsKey(hash))
+
+	return deleted == 1, nil
+}
+
+// checkSecondFactor checks a code from the authenticator app or a recovery code of the user, accepted codes
+// can not be used again
+func (s *Server) checkSecondFactor(userID uint, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+
+	credential := &database.TOTP{}
+	err := s.DB.Where("user_id = ? AND confirmed_at IS NOT NULL", userID).First(&credential).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if len(strings.ReplaceAll(code, " ", "")) == totp.Digits {
+		return s.useTOTP(credential, code)
+	}
+
+	res := s.DB.Model(&database.RecoveryCode{}).
+		Where("user_id = ? AND hash = ? AND used_at IS NULL", userID, hashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return res.RowsAffected == 1, nil
+}
+
+// useTOTP checks the code against the secret and stores its time step, the update only succeeds for steps after
+// the stored one so that concurrent requests can not use the same code twice
+func (s *Server) useTOTP(credential *database.TOTP, code string) (bool, error) {
+	step, ok := totp.Validate(credential.Secret, code, time.Now(), credential.LastStep)
+	if !ok {
+		return false, nil
+	}
+
+	res := s.DB.Model(&database.TOTP{}).
+		Where("id = ? AND last_step < ?", credential.ID, step).
+		Update("last_step", step)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return res.RowsAffected == 1, nil
+}
+
+// EnrollTOTP is a gRPC endpoint to start the enrolment of an authenticator app, the returned URI is shown as a
+// QR code and the enrolment has to be confirmed with a code from the app before it is used at login
+// returns Internal, NotFound, FailedPrecondition, nil
+func (s *Server) EnrollTOTP(ctx context.Context, req *pb.EnrollTOTPRequest) (*pb.EnrollTOTPResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.EnrollTOTPResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	user := &database.User{}
+	err = s.DB.First(&user, userID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &pb.EnrollTOTPResponse{
+				Success: false,
+			}, status.Error(codes.NotFound, "user not found")
+		}
+
+		log.Error().Err(err).Msg("failed to find the user")
+		return &pb.EnrollTOTPResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to find the user")
+	}
+
+	enabled, err := s.hasSecondFactor(user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check the second factor")
+		return &pb.EnrollTOTPResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to check the second factor")
+	}
+	if enabled {
+		return &pb.EnrollTOTPResponse{
+			Success: false,
+			Message: "Two-factor authentication is already enabled",
+		}, status.Error(codes.FailedPrecondition, "two-factor authentication is already enabled")
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the secret")
+		return &pb.EnrollTOTPResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to create the secret")
+	}
+
+	// an enrolment that was never confirmed is replaced
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&database.TOTP{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&database.TOTP{
+			UserID: user.ID,
+			Secret: secret,
+		}).Error
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to save the secret")
+		return &pb.EnrollTOTPResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to save the secret")
+	}
+
+	return &pb.EnrollTOTPResponse{
+		Success: true,
+		Message: "Scan the QR code with an authenticator app and confirm with a code",
+		Secret:  secret,
+		Uri:     totp.URI(totpIssuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmTOTP is a gRPC endpoint to confirm the enrolment of an authenticator app with a code from it, the
+// recovery codes are only returned once
+// returns Internal, NotFound, InvalidArgument, nil
+func (s *Server) ConfirmTOTP(ctx context.Context, req *pb.ConfirmTOTPRequest) (*pb.ConfirmTOTPResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.ConfirmTOTPResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	credential := &database.TOTP{}
+	err = s.DB.Where("user_id = ? AND confirmed_at IS NULL", userID).First(&credential).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &pb.ConfirmTOTPResponse{
+				Success: false,
+				Message: "No enrolment to confirm",
+			}, status.Error(codes.NotFound, "there is no enrolment to confirm")
+		}
+
+		log.Error().Err(err).Msg("failed to get the enrolment")
+		return &pb.ConfirmTOTPResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the enrolment")
+	}
+
+	ok, err := s.useTOTP(credential, req.Code)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check the code")
+		return &pb.ConfirmTOTPResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to check the code")
+	}
+	if !ok {
+		return &pb.ConfirmTOTPResponse{
+			Success: false,
+			Message: "Invalid code",
+		}, status.Error(codes.InvalidArgument, "invalid code")
+	}
+
+	codesPlain := []string{}
+	rows := []database.RecoveryCode{}
+	for i := 0; i < recoveryCodes; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to create the recovery codes")
+			return &pb.ConfirmTOTPResponse{
+				Success: false,
+			}, status.Error(codes.Internal, "failed to create the recovery codes")
+		}
+		codesPlain = append(codesPlain, code)
+		rows = append(rows, database.RecoveryCode{
+			UserID: uint(userID),
+			Hash:   hashRecoveryCode(code),
+		})
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&credential).Update("confirmed_at", &now).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&database.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to confirm the enrolment")
+		return &pb.ConfirmTOTPResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to confirm the enrolment")
+	}
+
+	return &pb.ConfirmTOTPResponse{
+		Success:       true,
+		Message:       "Two-factor authentication enabled, keep the recovery codes somewhere safe",
+		RecoveryCodes: codesPlain,
+	}, nil
+}
+
+// DisableTOTP is a gRPC endpoint to turn off two-factor authentication, a code from the authenticator app or a
+// recovery code is needed so that a stolen session alone is not enough
+// returns Internal, InvalidArgument, nil
+func (s *Server) DisableTOTP(ctx context.Context, req *pb.DisableTOTPRequest) (*pb.DisableTOTPResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.DisableTOTPResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	ok, err := s.checkSecondFactor(uint(userID), req.Code)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check the code")
+		return &pb.DisableTOTPResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to check the code")
+	}
+	if !ok {
+		return &pb.DisableTOTPResponse{
+			Success: false,
+			Message: "Invalid code",
+		}, status.Error(codes.InvalidArgument, "invalid code")
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&database.TOTP{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", userID).Delete(&database.RecoveryCode{}).Error
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to disable two-factor authentication")
+		return &pb.DisableTOTPResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to disable two-factor authentication")
+	}
+
+	return &pb.DisableTOTPResponse{
+		Success: true,
+		Message: "Two-factor authentication disabled",
+	}, nil
+}
+
+// VerifySecondFactor is a gRPC endpoint to complete a login with the challenge token that Login returned and a
+// code from the authenticator app or a recovery code
+// returns Internal, Unauthenticated, PermissionDenied, nil
+func (s *Server) VerifySecondFactor(ctx context.Context, req *pb.VerifySecondFactorRequest) (*pb.LoginResponse, error) {
+	userID, ok, err := s.challengeUser(ctx, req.ChallengeToken)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the challenge")
+		return &pb.LoginResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the challenge")
+	}
+	if !ok {
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Invalid or expired challenge",
+		}, errChallenge
+	}
+
+	ok, err = s.checkSecondFactor(userID, req.Code)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check the code")
+		return &pb.LoginResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to check the code")
+	}
+	if !ok {
+		s.failChallenge(ctx, req.ChallengeToken)
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Invalid code",
+		}, status.Error(codes.Unauthenticated, "invalid code")
+	}
+
+	return s.completeLogin(ctx, req.ChallengeToken, userID)
+}
+
+// completeLogin ends the challenge and issues the tokens of a new session of the user
+func (s *Server) completeLogin(ctx context.Context, challengeToken string, userID uint) (*pb.LoginResponse, error) {
+	ok, err := s.completeChallenge(ctx, challengeToken)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to complete the challenge")
+		return &pb.LoginResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to complete the challenge")
+	}
+	if !ok {
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Invalid or expired challenge",
+		}, errChallenge
+	}
+
+	user := &database.User{}
+	err = s.DB.First(&user, userID).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to find the user")
+		return &pb.LoginResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to find the user")
+	}
+	// the account can be disabled while the second factor is asked for
+	if user.DisabledAt != nil {
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Account is disabled",
+		}, errDisabled
+	}
+
+	tokenSet, err := s.issueTokens(ctx, user)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the tokens")
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Failed to create tokens",
+		}, status.Error(codes.Internal, "failed to create tokens")
+	}
+
+	return &pb.LoginResponse{
+		Success:  true,
+		Message:  "User logged in successfully",
+		TokenSet: tokenSet,
+	}, nil
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/mailer"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testKey returns a base64 encoded RSA key pair in the format of the environment variables of the tokens
+func testKey(t *testing.T) (private, public string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate the key: %v", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to encode the public key: %v", err)
+	}
+
+	private = base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}))
+	public = base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: publicKey,
+	}))
+
+	return private, public
+}
+
+// newTestServer returns an auth server that runs against an in-memory SQLite database and an in-memory Redis
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	e := &env.Env{
+		Domain:                "localhost",
+		AppURL:                "http://localhost:8080",
+		SessionSecret:         "secret",
+		AccessTokenExpiresIn:  15 * time.Minute,
+		RefreshTokenExpiresIn: time.Hour,
+	}
+	e.AccessTokenPrivateKey, e.AccessTokenPublicKey = testKey(t)
+	e.RefreshTokenPrivateKey, e.RefreshTokenPublicKey = testKey(t)
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		TranslateError: true,
+		Logger:         logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("failed to open the database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get the database: %v", err)
+	}
+	// every connection to file::memory: opens a new database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	for _, table := range database.Tables {
+		if err := db.AutoMigrate(table.Schema); err != nil {
+			t.Fatalf("failed to migrate the database: %v", err)
+		}
+	}
+
+	r := redis.NewClient(&redis.Options{
+		Addr: miniredis.RunT(t).Addr(),
+	})
+	t.Cleanup(func() { r.Close() })
+
+	return &Server{
+		E:      e,
+		DB:     db,
+		R:      r,
+		Mailer: &mailer.Log{},
+	}
+}
+
+// createTestUser stores a user that can log in with the password
+func createTestUser(t *testing.T, s *Server, username, password string) *database.User {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash the password: %v", err)
+	}
+
+	user := &database.User{
+		Name:          username,
+		Username:      username,
+		Email:         username + "@example.com",
+		EmailVerified: true,
+		Password:      string(hash),
+	}
+	if err := s.DB.Create(user).Error; err != nil {
+		t.Fatalf("failed to create the user: %v", err)
+	}
+
+	return user
+}
@@ -4,11 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/VinukaThejana/todoapp/internal/auth/tokens"
 	env "github.com/VinukaThejana/todoapp/internal/config"
 	"github.com/VinukaThejana/todoapp/internal/database"
-	rdb "github.com/VinukaThejana/todoapp/internal/redis"
+	"github.com/VinukaThejana/todoapp/internal/mailer"
 	pb "github.com/VinukaThejana/todoapp/pkg/auth"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
@@ -21,17 +22,22 @@ import (
 // Server is used to implement auth.AuthServiceServer
 type Server struct {
 	pb.UnimplementedAuthServiceServer
-	E  *env.Env
-	DB *gorm.DB
-	R  *redis.Client
+	E      *env.Env
+	DB     *gorm.DB
+	R      *redis.Client
+	Mailer mailer.Mailer
+
+	// oidcProviders caches the OpenID Connect providers by name once they were discovered
+	oidcProviders sync.Map
 }
 
 // NewServer creates a new auth server
 func NewServer(e *env.Env, db *gorm.DB, r *redis.Client) *Server {
 	return &Server{
-		E:  e,
-		DB: db,
-		R:  r,
+		E:      e,
+		DB:     db,
+		R:      r,
+		Mailer: mailer.New(e),
 	}
 }
 
@@ -70,6 +76,12 @@ func (s *Server) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.Reg
 		}, status.Error(codes.Internal, "internal server error")
 	}
 
+	// the account is created even if the email can not be sent, the user can ask for another one
+	err = s.sendVerification(ctx, user)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to send the verification email")
+	}
+
 	return &pb.RegisterResponse{
 		Success: true,
 		Message: "User registered successfully",
@@ -77,7 +89,7 @@ func (s *Server) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.Reg
 }
 
 // Login is a gRPC endpoint to login a user
-// returns Internal, NotFound, Unauthenticated, InvalidArgument, nil
+// returns Internal, Unauthenticated, ResourceExhausted, InvalidArgument, FailedPrecondition, PermissionDenied, nil
 func (s *Server) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
 	user := &database.User{}
 	var err error
@@ -94,69 +106,123 @@ func (s *Server) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResp
 		}, status.Error(codes.InvalidArgument, "must provide the username or email")
 	}
 
-	if err != nil {
+	found := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		log.Error().Err(err).Msg("failed to find the user")
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Internal server error",
+		}, status.Error(codes.Internal, "internal server error")
+	}
 
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return &pb.LoginResponse{
-				Success: false,
-				Message: "User not found",
-			}, status.Error(codes.NotFound, "user not found")
-		}
+	err = s.checkPassword(ctx, req, user, found)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to log in")
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Invalid username or password",
+		}, err
+	}
+	if user.PasswordResetRequired {
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Password reset required",
+		}, errPasswordResetRequired
+	}
+	if s.E.RequireVerifiedEmail && !user.EmailVerified {
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Email is not verified",
+		}, status.Error(codes.FailedPrecondition, "the email address is not verified")
+	}
+
+	return s.startSession(ctx, user)
+}
 
+// startSession asks for the second factor when the user has one or else issues the tokens of a new session,
+// it is called once the user has proven who they are
+func (s *Server) startSession(ctx context.Context, user *database.User) (*pb.LoginResponse, error) {
+	if user.DisabledAt != nil {
+		return &pb.LoginResponse{
+			Success: false,
+			Message: "Account is disabled",
+		}, errDisabled
+	}
+
+	secondFactors, err := s.secondFactors(user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check the second factor")
 		return &pb.LoginResponse{
 			Success: false,
 			Message: "Internal server error",
 		}, status.Error(codes.Internal, "internal server error")
 	}
+	if len(secondFactors) > 0 {
+		challengeToken, err := s.createChallenge(ctx, user.ID)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to create the challenge")
+			return &pb.LoginResponse{
+				Success: false,
+				Message: "Failed to create the challenge",
+			}, status.Error(codes.Internal, "failed to create the challenge")
+		}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
+		return &pb.LoginResponse{
+			Success:              true,
+			Message:              "Second factor required",
+			SecondFactorRequired: true,
+			ChallengeToken:       challengeToken,
+			SecondFactors:        secondFactors,
+		}, nil
+	}
+
+	tokenSet, err := s.issueTokens(ctx, user)
 	if err != nil {
-		log.Error().Err(err).Msg("not a valid password")
+		log.Error().Err(err).Msg("failed to create the tokens")
 		return &pb.LoginResponse{
 			Success: false,
-			Message: "Invalid password",
-		}, status.Error(codes.Unauthenticated, "invalid password")
+			Message: "Failed to create tokens",
+		}, status.Error(codes.Internal, "failed to create tokens")
+	}
+
+	return &pb.LoginResponse{
+		Success:  true,
+		Message:  "User logged in successfully",
+		TokenSet: tokenSet,
+	}, nil
+}
+
+// issueTokens creates the refresh, access and session tokens of a new session of the user, logging in restores
+// the account of a user who deleted it during the grace period
+func (s *Server) issueTokens(ctx context.Context, user *database.User) (*pb.TokenSet, error) {
+	if user.DeletionScheduledAt != nil {
+		if err := s.restoreAccount(user); err != nil {
+			return nil, fmt.Errorf("failed to restore the account: %w", err)
+		}
 	}
 
 	rt := tokens.NewRefreshToken(s.E, s.DB, s.R)
-	refreshToken, err := rt.Create(ctx, user.ID)
+	refreshToken, err := rt.Create(ctx, user.ID, clientOf(ctx))
 	if err != nil {
-		log.Error().Err(err).Msg("failed to create the refresh token")
-		return &pb.LoginResponse{
-			Success: false,
-			Message: "Failed to create refresh token",
-		}, status.Error(codes.Internal, "failed to create refresh token")
+		return nil, fmt.Errorf("failed to create the refresh token: %w", err)
 	}
 
 	at := tokens.NewAccessToken(s.E, s.DB, s.R)
 	accessToken, err := at.Create(ctx, user.ID, refreshToken.JTI, refreshToken.AccessTokenJTI)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to create the access token")
-		return &pb.LoginResponse{
-			Success: false,
-			Message: "Failed to create access token",
-		}, status.Error(codes.Internal, "failed to create access token")
+		return nil, fmt.Errorf("failed to create the access token: %w", err)
 	}
 
 	st := tokens.NewSessionToken(s.E, s.DB)
 	sessionToken, err := st.Create(ctx, user.ID, user.Email, user.Username, user.Name)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to create the session token")
-		return &pb.LoginResponse{
-			Success: false,
-			Message: "Failed to create session token",
-		}, status.Error(codes.Internal, "failed to create session token")
+		return nil, fmt.Errorf("failed to create the session token: %w", err)
 	}
 
-	return &pb.LoginResponse{
-		Success: true,
-		Message: "User logged in successfully",
-		TokenSet: &pb.TokenSet{
-			AccessToken:  accessToken.Token,
-			RefreshToken: refreshToken.Token,
-			SessionToken: sessionToken.Token,
-		},
+	return &pb.TokenSet{
+		AccessToken:  accessToken.Token,
+		RefreshToken: refreshToken.Token,
+		SessionToken: sessionToken.Token,
 	}, nil
 }
 
@@ -173,8 +239,11 @@ func (s *Server) Refresh(ctx context.Context, req *pb.RefreshRequest) (*pb.Refre
 		}, status.Error(codes.Unauthenticated, "invalid refresh token")
 	}
 
+	// the access token is created before the rotation is committed, a failure after the rotation would leave the
+	// client with a refresh token that was already rotated and no access token. It only replaces the access token
+	// of the session once the rotation is committed, so that a failed rotation keeps the client logged in.
 	at := tokens.NewAccessToken(s.E, s.DB, s.R)
-	accessToken, err := at.Create(ctx, rtd.Sub, rtd.JTI)
+	accessToken, err := at.Create(ctx, rtd.Sub, rtd.Family)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to create the access token")
 		return &pb.RefreshResponse{
@@ -183,10 +252,46 @@ func (s *Server) Refresh(ctx context.Context, req *pb.RefreshRequest) (*pb.Refre
 		}, status.Error(codes.Internal, "failed to create access token")
 	}
 
+	refreshToken, err := rt.Rotate(ctx, rtd)
+	if err != nil {
+		// the access token of a refused rotation must not be usable
+		if err := at.Revoke(ctx, accessToken.JTI); err != nil {
+			log.Error().Err(err).Msg("failed to revoke the access token")
+		}
+
+		if errors.Is(err, tokens.ErrRefreshTokenReused) {
+			log.Warn().
+				Str("event", "refresh_token_reuse").
+				Uint("user", rtd.Sub).
+				Str("session", rtd.Family).
+				Msg("a refresh token that was already rotated was used again, the session is revoked")
+			return &pb.RefreshResponse{
+				Success: false,
+				Message: "Invalid refresh token",
+			}, status.Error(codes.Unauthenticated, "invalid refresh token")
+		}
+
+		log.Error().Err(err).Msg("failed to rotate the refresh token")
+		return &pb.RefreshResponse{
+			Success: false,
+			Message: "Failed to rotate the refresh token",
+		}, status.Error(codes.Internal, "failed to rotate the refresh token")
+	}
+
+	err = at.Replace(ctx, rtd.Family, accessToken)
+	if err != nil {
+		// the rotation is committed and the client only has the new tokens, the previous access token expires
+		// on its own
+		log.Error().Err(err).Msg("failed to replace the access token of the session")
+	}
+
+	s.touchSession(rtd.Family)
+
 	return &pb.RefreshResponse{
-		Success:     true,
-		Message:     "Access token refreshed successfully",
-		AccessToken: accessToken.Token,
+		Success:      true,
+		Message:      "Access token refreshed successfully",
+		AccessToken:  accessToken.Token,
+		RefreshToken: refreshToken.Token,
 	}, nil
 }
 
@@ -203,18 +308,25 @@ func (s *Server) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.LogoutR
 		}, status.Error(codes.Unauthenticated, "invalid refresh token")
 	}
 
-	val := s.R.Get(ctx, rdb.RefreshTokenKey(rtd.JTI)).Val()
-	if val == "" {
+	latest, err := rt.Latest(ctx, rtd)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the latest refresh token")
 		return &pb.LogoutResponse{
 			Success: false,
-			Message: "Invalid refresh token",
-		}, status.Error(codes.Unauthenticated, "invalid refresh token")
+			Message: "Failed to delete tokens",
+		}, status.Error(codes.Internal, "failed to delete tokens")
+	}
+	if !latest {
+		log.Warn().
+			Str("event", "refresh_token_reuse").
+			Uint("user", rtd.Sub).
+			Str("session", rtd.Family).
+			Msg("a refresh token that was already rotated was used to log out, the session is revoked")
 	}
 
-	pipe := s.R.Pipeline()
-	pipe.Del(ctx, rdb.RefreshTokenKey(rtd.JTI))
-	pipe.Del(ctx, rdb.AccessTokenKey(val))
-	_, err = pipe.Exec(ctx)
+	// the whole session is revoked even with a token that was already rotated, so that the latest refresh token
+	// and access token stop working too
+	err = rt.Revoke(ctx, rtd.Family)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to delete tokens")
 		return &pb.LogoutResponse{
@@ -243,8 +355,40 @@ func (s *Server) Validate(ctx context.Context, req *pb.ValidateRequest) (*pb.Val
 	}
 
 	return &pb.ValidateResponse{
+		Success:   true,
+		IsValid:   true,
+		UserId:    fmt.Sprint(atd.Sub),
+		SessionId: atd.SessionID,
+		Role:      atd.Role,
+	}, nil
+}
+
+// JWKS is a gRPC endpoint to get the public keys that access tokens are verified with
+// returns Internal, nil
+func (s *Server) JWKS(ctx context.Context, req *pb.JWKSRequest) (*pb.JWKSResponse, error) {
+	ks, err := tokens.AccessKeyset(s.E)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load the access token keys")
+		return &pb.JWKSResponse{
+			Success: false,
+			Message: "Failed to load the keys",
+		}, status.Error(codes.Internal, "failed to load the keys")
+	}
+
+	keys := []*pb.JWK{}
+	for _, jwk := range ks.JWKS() {
+		keys = append(keys, &pb.JWK{
+			Kty: jwk.Kty,
+			Kid: jwk.Kid,
+			Use: jwk.Use,
+			Alg: jwk.Alg,
+			N:   jwk.N,
+			E:   jwk.E,
+		})
+	}
+
+	return &pb.JWKSResponse{
 		Success: true,
-		IsValid: true,
-		UserId:  fmt.Sprint(atd.Sub),
+		Keys:    keys,
 	}, nil
 }
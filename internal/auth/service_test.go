@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/VinukaThejana/todoapp/internal/auth/tokens"
+	rdb "github.com/VinukaThejana/todoapp/internal/redis"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRefreshRotatesTheRefreshToken(t *testing.T) {
+	s := newTestServer(t)
+	user := createTestUser(t, s, "jane", "password")
+	ctx := context.Background()
+
+	tokenSet, err := s.issueTokens(ctx, user)
+	if err != nil {
+		t.Fatalf("failed to create the tokens: %v", err)
+	}
+
+	res, err := s.Refresh(ctx, &pb.RefreshRequest{RefreshToken: tokenSet.RefreshToken})
+	if err != nil {
+		t.Fatalf("failed to refresh: %v", err)
+	}
+	if res.AccessToken == "" || res.RefreshToken == "" || res.RefreshToken == tokenSet.RefreshToken {
+		t.Fatalf("the refresh did not return a new access and refresh token: %v", res)
+	}
+
+	at := tokens.NewAccessToken(s.E, s.DB, s.R)
+	if _, err := at.Validate(ctx, res.AccessToken); err != nil {
+		t.Fatalf("the refreshed access token is not valid: %v", err)
+	}
+
+	// using the rotated refresh token again revokes the session along with the access token of the refused refresh
+	_, err = s.Refresh(ctx, &pb.RefreshRequest{RefreshToken: tokenSet.RefreshToken})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("err = %v, want Unauthenticated for a reused refresh token", err)
+	}
+	if _, err := at.Validate(ctx, res.AccessToken); err == nil {
+		t.Fatal("the access token is still valid after the session was revoked")
+	}
+	if _, err := s.Refresh(ctx, &pb.RefreshRequest{RefreshToken: res.RefreshToken}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("err = %v, want Unauthenticated for the refresh token of a revoked session", err)
+	}
+}
+
+func TestRefreshKeepsTheAccessTokenWhenTheRotationFails(t *testing.T) {
+	s := newTestServer(t)
+	user := createTestUser(t, s, "jane", "password")
+	ctx := context.Background()
+
+	tokenSet, err := s.issueTokens(ctx, user)
+	if err != nil {
+		t.Fatalf("failed to create the tokens: %v", err)
+	}
+	res, err := s.Refresh(ctx, &pb.RefreshRequest{RefreshToken: tokenSet.RefreshToken})
+	if err != nil {
+		t.Fatalf("failed to refresh: %v", err)
+	}
+
+	// the rotation fails once the latest refresh token of the family can not be found
+	rtd, err := tokens.NewRefreshToken(s.E, s.DB, s.R).Validate(ctx, res.RefreshToken)
+	if err != nil {
+		t.Fatalf("failed to validate the refresh token: %v", err)
+	}
+	if err := s.R.Del(ctx, rdb.RefreshFamilyKey(rtd.Family)).Err(); err != nil {
+		t.Fatalf("failed to delete the family: %v", err)
+	}
+
+	if _, err := s.Refresh(ctx, &pb.RefreshRequest{RefreshToken: res.RefreshToken}); err == nil {
+		t.Fatal("the refresh succeeded without the family of the refresh token")
+	}
+	if _, err := tokens.NewAccessToken(s.E, s.DB, s.R).Validate(ctx, res.AccessToken); err != nil {
+		t.Fatalf("the access token stopped working after a failed refresh: %v", err)
+	}
+}
+
+func TestLogoutWithARotatedRefreshToken(t *testing.T) {
+	s := newTestServer(t)
+	user := createTestUser(t, s, "jane", "password")
+	ctx := context.Background()
+
+	tokenSet, err := s.issueTokens(ctx, user)
+	if err != nil {
+		t.Fatalf("failed to create the tokens: %v", err)
+	}
+	res, err := s.Refresh(ctx, &pb.RefreshRequest{RefreshToken: tokenSet.RefreshToken})
+	if err != nil {
+		t.Fatalf("failed to refresh: %v", err)
+	}
+
+	if _, err := s.Logout(ctx, &pb.LogoutRequest{RefreshToken: tokenSet.RefreshToken}); err != nil {
+		t.Fatalf("failed to log out with the rotated refresh token: %v", err)
+	}
+
+	if _, err := tokens.NewAccessToken(s.E, s.DB, s.R).Validate(ctx, res.AccessToken); err == nil {
+		t.Fatal("the latest access token is still valid after logging out")
+	}
+	if _, err := s.Refresh(ctx, &pb.RefreshRequest{RefreshToken: res.RefreshToken}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("err = %v, want Unauthenticated for the latest refresh token after logging out", err)
+	}
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/auth/tokens"
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/lib"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// clientOf returns the client that made the request, the gateway passes it in the metadata
+func clientOf(ctx context.Context) database.Client {
+	client := database.Client{}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return client
+	}
+	if values := md.Get(lib.ClientIPMetadataKey); len(values) > 0 {
+		client.IP = values[0]
+	}
+	if values := md.Get(lib.UserAgentMetadataKey); len(values) > 0 {
+		client.UserAgent = values[0]
+	}
+	client.Device, client.Browser, client.OS = parseUserAgent(client.UserAgent)
+
+	return client
+}
+
+func sessionToPB(session *database.Session, current string) *pb.Session {
+	res := &pb.Session{
+		Id:        session.ID,
+		LoginAt:   session.LoginAt.Format(time.RFC3339),
+		ExpiresAt: time.Unix(session.ExpiresAt, 0).UTC().Format(time.RFC3339),
+		Current:   session.ID == current,
+		Ip:        session.Client.IP,
+		UserAgent: session.Client.UserAgent,
+		Device:    session.Client.Device,
+		Browser:   session.Client.Browser,
+		Os:        session.Client.OS,
+	}
+	if session.LastSeenAt != nil {
+		res.LastSeenAt = session.LastSeenAt.Format(time.RFC3339)
+	}
+
+	return res
+}
+
+// touchSession updates the last seen time of the session, failing to do so does not stop the refresh
+func (s *Server) touchSession(id string) {
+	err := s.DB.Model(&database.Session{}).Where("id = ?", id).Update("last_seen_at", time.Now()).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to update the last seen time of the session")
+	}
+}
+
+// activeSessions returns the sessions of the user that have not expired yet, the latest login first
+func (s *Server) activeSessions(userID uint) ([]database.Session, error) {
+	sessions := []database.Session{}
+	err := s.DB.
+		Where("user_id = ? AND expires_at > ?", userID, time.Now().Unix()).
+		Order("login_at DESC").
+		Find(&sessions).Error
+
+	return sessions, err
+}
+
+// ListSessions is a gRPC endpoint to list the sessions that the user is logged in with, the session that made
+// the request is marked as the current one
+// returns Internal, nil
+func (s *Server) ListSessions(ctx context.Context, req *pb.ListSessionsRequest) (*pb.ListSessionsResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.ListSessionsResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	sessions, err := s.activeSessions(uint(userID))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the sessions")
+		return &pb.ListSessionsResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the sessions")
+	}
+
+	res := &pb.ListSessionsResponse{
+		Success:  true,
+		Sessions: []*pb.Session{},
+	}
+	for _, session := range sessions {
+		res.Sessions = append(res.Sessions, sessionToPB(&session, req.SessionId))
+	}
+
+	return res, nil
+}
+
+// RevokeSession is a gRPC endpoint to log out a session of the user, its refresh and access tokens stop working
+// right away
+// returns Internal, NotFound, nil
+func (s *Server) RevokeSession(ctx context.Context, req *pb.RevokeSessionRequest) (*pb.RevokeSessionResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.RevokeSessionResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	var count int64
+	err = s.DB.Model(&database.Session{}).Where("id = ? AND user_id = ?", req.Id, userID).Count(&count).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the session")
+		return &pb.RevokeSessionResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the session")
+	}
+	if count == 0 {
+		return &pb.RevokeSessionResponse{
+			Success: false,
+			Message: "Session not found",
+		}, status.Error(codes.NotFound, "session not found")
+	}
+
+	err = tokens.NewRefreshToken(s.E, s.DB, s.R).Revoke(ctx, req.Id)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to revoke the session")
+		return &pb.RevokeSessionResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to revoke the session")
+	}
+
+	return &pb.RevokeSessionResponse{
+		Success: true,
+		Message: "Session revoked successfully",
+	}, nil
+}
+
+// RevokeAllOtherSessions is a gRPC endpoint to log out every session of the user except the one that made the
+// request
+// returns Internal, InvalidArgument, nil
+func (s *Server) RevokeAllOtherSessions(
+	ctx context.Context,
+	req *pb.RevokeAllOtherSessionsRequest,
+) (*pb.RevokeAllOtherSessionsResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.RevokeAllOtherSessionsResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+	// access tokens that were issued before they carried the session would log out the current session as well
+	if req.SessionId == "" {
+		return &pb.RevokeAllOtherSessionsResponse{
+			Success: false,
+			Message: "The current session is not known",
+		}, status.Error(codes.InvalidArgument, "the current session is not known")
+	}
+
+	sessions := []database.Session{}
+	err = s.DB.Where("user_id = ? AND id <> ?", userID, req.SessionId).Find(&sessions).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the sessions")
+		return &pb.RevokeAllOtherSessionsResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the sessions")
+	}
+
+	rt := tokens.NewRefreshToken(s.E, s.DB, s.R)
+	for _, session := range sessions {
+		if err := rt.Revoke(ctx, session.ID); err != nil {
+			log.Error().Err(err).Msg("failed to revoke the session")
+			return &pb.RevokeAllOtherSessionsResponse{
+				Success: false,
+			}, status.Error(codes.Internal, "failed to revoke the session")
+		}
+	}
+
+	return &pb.RevokeAllOtherSessionsResponse{
+		Success: true,
+		Message: "Sessions revoked successfully",
+		Revoked: int64(len(sessions)),
+	}, nil
+}
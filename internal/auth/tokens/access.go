@@ -2,11 +2,11 @@ package tokens
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
 	"time"
 
 	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/internal/database"
 	rdb "github.com/VinukaThejana/todoapp/internal/redis"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/oklog/ulid/v2"
@@ -33,6 +33,11 @@ func NewAccessToken(e *env.Env, db *gorm.DB, r *redis.Client) *AccessToken {
 // AccessTokenDetails is a struct to represent the details of an access token
 type AccessTokenDetails struct {
 	tokendetails
+	// SessionID is the id of the session that the access token was issued for
+	SessionID string
+	// Role is the role of the user when the access token was issued, a change of the role shows up with the
+	// next refresh
+	Role string
 }
 
 // Create creates a new access token
@@ -56,19 +61,26 @@ func (at *AccessToken) Create(
 	}
 	atd.ExpiresIn = now.Add(at.E.AccessTokenExpiresIn).Unix()
 	atd.Sub = userID
+	atd.SessionID = refreshTokenJTI
 
 	if !isRefreshTokenCreated {
-		val := at.R.Get(ctx, rdb.RefreshTokenKey(refreshTokenJTI)).Val()
-		if val == "" {
+		exists, err := at.R.Exists(ctx, rdb.RefreshTokenKey(refreshTokenJTI)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if exists == 0 {
 			return nil, errors.New("refresh token not found")
 		}
 	}
 
-	privateKey, err := base64.StdEncoding.DecodeString(at.E.AccessTokenPrivateKey)
+	user := database.User{}
+	err = at.DB.Select("id", "role").First(&user, userID).Error
 	if err != nil {
 		return nil, err
 	}
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(privateKey)
+	atd.Role = user.Role
+
+	ks, err := AccessKeyset(at.E)
 	if err != nil {
 		return nil, err
 	}
@@ -79,11 +91,10 @@ func (at *AccessToken) Create(
 	claims["iat"] = atd.Iat
 	claims["nbf"] = atd.Iat
 	claims["exp"] = atd.ExpiresIn
+	claims["sid"] = atd.SessionID
+	claims["role"] = atd.Role
 
-	atd.Token, err = jwt.NewWithClaims(
-		jwt.SigningMethodRS256,
-		claims,
-	).SignedString(key)
+	atd.Token, err = ks.sign(claims)
 	if err != nil {
 		return nil, err
 	}
@@ -92,20 +103,7 @@ func (at *AccessToken) Create(
 		return atd, nil
 	}
 
-	pipe := at.R.Pipeline()
-	pipe.Set(
-		ctx,
-		rdb.RefreshTokenKey(refreshTokenJTI),
-		atd.JTI,
-		redis.KeepTTL,
-	)
-	pipe.Set(
-		ctx,
-		rdb.AccessTokenKey(atd.JTI),
-		userID,
-		at.E.AccessTokenExpiresIn,
-	)
-	_, err = pipe.Exec(ctx)
+	err = at.R.Set(ctx, rdb.AccessTokenKey(atd.JTI), userID, at.E.AccessTokenExpiresIn).Err()
 	if err != nil {
 		return nil, err
 	}
@@ -113,6 +111,30 @@ func (at *AccessToken) Create(
 	return atd, nil
 }
 
+// Replace makes the access token the latest one of the session and revokes the one that it replaces, only the
+// latest access token of a session is kept so that revoking the session logs it out right away. It is called once
+// the refresh that the access token was created for has been committed, so that a failed refresh keeps the
+// previous access token working.
+func (at *AccessToken) Replace(ctx context.Context, refreshTokenJTI string, atd *AccessTokenDetails) error {
+	previousJTI, err := at.R.SetArgs(ctx, rdb.RefreshTokenKey(refreshTokenJTI), atd.JTI, redis.SetArgs{
+		KeepTTL: true,
+		Get:     true,
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	if previousJTI == "" || previousJTI == atd.JTI {
+		return nil
+	}
+
+	return at.R.Del(ctx, rdb.AccessTokenKey(previousJTI)).Err()
+}
+
+// Revoke revokes the access token with the given id before it expires
+func (at *AccessToken) Revoke(ctx context.Context, jti string) error {
+	return at.R.Del(ctx, rdb.AccessTokenKey(jti)).Err()
+}
+
 // Validate validates an access token
 func (at *AccessToken) Validate(
 	ctx context.Context,
@@ -120,35 +142,23 @@ func (at *AccessToken) Validate(
 ) (atd *AccessTokenDetails, err error) {
 	atd = &AccessTokenDetails{}
 
-	publicKey, err := base64.StdEncoding.DecodeString(at.E.AccessTokenPublicKey)
-	if err != nil {
-	}
+	ks, err := AccessKeyset(at.E)
 	if err != nil {
 		return nil, err
 	}
-	key, err := jwt.ParseRSAPublicKeyFromPEM(publicKey)
+
+	claims, err := ks.parse(token)
 	if err != nil {
 		return nil, err
 	}
 
-	parsedToken, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-
-		return key, nil
-	})
-
-	claims, ok := parsedToken.Claims.(jwt.MapClaims)
-	if !ok || !parsedToken.Valid {
-		return nil, errors.New("invalid token")
-	}
-
 	atd.Sub = uint(claims["sub"].(float64))
 	atd.JTI = claims["jti"].(string)
 	atd.Iat = int64(claims["iat"].(float64))
 	atd.ExpiresIn = int64(claims["exp"].(float64))
 	atd.Token = token
+	atd.SessionID, _ = claims["sid"].(string)
+	atd.Role, _ = claims["role"].(string)
 
 	val := at.R.Get(ctx, rdb.AccessTokenKey(atd.JTI)).Val()
 	if val == "" {
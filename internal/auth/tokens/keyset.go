@@ -0,0 +1,172 @@
+package tokens
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keysets caches the parsed keysets so that the keys are decoded once and not on every token
+var keysets sync.Map
+
+// Keyset is the key that signs new tokens along with every key that tokens are still verified with
+type Keyset struct {
+	kid    string
+	signer *rsa.PrivateKey
+	kids   []string
+	keys   map[string]*rsa.PublicKey
+}
+
+// JWK is the public part of a key in the JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string
+	Kid string
+	Use string
+	Alg string
+	N   string
+	E   string
+}
+
+// AccessKeyset returns the keyset of the access tokens
+func AccessKeyset(e *env.Env) (*Keyset, error) {
+	return loadKeyset(e.AccessTokenPrivateKey, e.AccessTokenPublicKey, e.AccessTokenVerificationKeys)
+}
+
+// RefreshKeyset returns the keyset of the refresh tokens
+func RefreshKeyset(e *env.Env) (*Keyset, error) {
+	return loadKeyset(e.RefreshTokenPrivateKey, e.RefreshTokenPublicKey, e.RefreshTokenVerificationKeys)
+}
+
+// loadKeyset parses the base64 encoded PEM keys, verificationKeys is a comma separated list of the public keys
+// that were used to sign tokens before the current key
+func loadKeyset(privateKey, publicKey, verificationKeys string) (*Keyset, error) {
+	cacheKey := privateKey + "," + publicKey + "," + verificationKeys
+	if ks, ok := keysets.Load(cacheKey); ok {
+		return ks.(*Keyset), nil
+	}
+
+	pem, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &Keyset{
+		signer: signer,
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+	for i, encoded := range append([]string{publicKey}, strings.Split(verificationKeys, ",")...) {
+		encoded = strings.TrimSpace(encoded)
+		if encoded == "" {
+			continue
+		}
+
+		pem, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+
+		kid := thumbprint(key)
+		if i == 0 {
+			if !key.Equal(signer.Public()) {
+				return nil, errors.New("the public key does not match the private key")
+			}
+			ks.kid = kid
+		}
+		if _, ok := ks.keys[kid]; ok {
+			continue
+		}
+		ks.kids = append(ks.kids, kid)
+		ks.keys[kid] = key
+	}
+
+	actual, _ := keysets.LoadOrStore(cacheKey, ks)
+	return actual.(*Keyset), nil
+}
+
+// thumbprint is the JWK thumbprint (RFC 7638) of the key, it is used as the kid so that the same key always has
+// the same id without having to configure one
+func thumbprint(key *rsa.PublicKey) string {
+	jwk := `{"e":"` + encodeInt(big.NewInt(int64(key.E))) + `","kty":"RSA","n":"` + encodeInt(key.N) + `"}`
+	sum := sha256.Sum256([]byte(jwk))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func encodeInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// sign signs the claims with the current key and stamps its kid on the header
+func (ks *Keyset) sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = ks.kid
+
+	return token.SignedString(ks.signer)
+}
+
+// parse verifies the token with the key that its kid points to, tokens that were signed before the kid was
+// stamped on them are tried against every key
+func (ks *Keyset) parse(token string) (jwt.MapClaims, error) {
+	parsedToken, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+
+		kid, ok := t.Header["kid"].(string)
+		if !ok {
+			set := jwt.VerificationKeySet{}
+			for _, id := range ks.kids {
+				set.Keys = append(set.Keys, ks.keys[id])
+			}
+			return set, nil
+		}
+
+		key, ok := ks.keys[kid]
+		if !ok {
+			return nil, errors.New("unknown key")
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok || !parsedToken.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
+
+// JWKS returns every key that tokens are verified with, the current key first
+func (ks *Keyset) JWKS() []JWK {
+	jwks := make([]JWK, 0, len(ks.kids))
+	for _, kid := range ks.kids {
+		key := ks.keys[kid]
+		jwks = append(jwks, JWK{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			N:   encodeInt(key.N),
+			E:   encodeInt(big.NewInt(int64(key.E))),
+		})
+	}
+
+	return jwks
+}
@@ -2,7 +2,6 @@ package tokens
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
 	"time"
 
@@ -26,8 +25,28 @@ type RefreshToken struct {
 type RefreshTokenDetails struct {
 	tokendetails
 	AccessTokenJTI string
+	// Family is the jti of the first refresh token of the session, it stays the same when the token is rotated
+	Family string
 }
 
+// ErrRefreshTokenReused is returned when a refresh token that was already rotated is presented again, the whole
+// family is revoked since either the owner or someone who stole the token is holding on to an old one
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// rotateScript replaces the latest refresh token of a family only if the presented token is the latest one, so
+// that two refreshes with the same token can not both succeed
+var rotateScript = redis.NewScript(`
+local latest = redis.call("GET", KEYS[1])
+if not latest then
+	return -1
+end
+if latest ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[2], "KEEPTTL")
+return 1
+`)
+
 // NewRefreshToken creates a new refresh token
 func NewRefreshToken(e *env.Env, db *gorm.DB, rdb *redis.Client) *RefreshToken {
 	return &RefreshToken{
@@ -37,22 +56,23 @@ func NewRefreshToken(e *env.Env, db *gorm.DB, rdb *redis.Client) *RefreshToken {
 	}
 }
 
-// Create creates a new refresh token
-func (rt *RefreshToken) Create(ctx context.Context, userID uint) (rtd *RefreshTokenDetails, err error) {
+// Create creates a new refresh token along with the session of the client that logged in
+func (rt *RefreshToken) Create(
+	ctx context.Context,
+	userID uint,
+	client database.Client,
+) (rtd *RefreshTokenDetails, err error) {
 	rtd = &RefreshTokenDetails{}
 	now := time.Now()
 
 	rtd.Iat = now.Unix()
 	rtd.JTI = ulid.Make().String()
+	rtd.Family = rtd.JTI
 	rtd.AccessTokenJTI = ulid.Make().String()
 	rtd.ExpiresIn = now.Add(rt.E.RefreshTokenExpiresIn).Unix()
 	rtd.Sub = userID
 
-	privateKey, err := base64.StdEncoding.DecodeString(rt.E.RefreshTokenPrivateKey)
-	if err != nil {
-		return nil, err
-	}
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(privateKey)
+	ks, err := RefreshKeyset(rt.E)
 	if err != nil {
 		return nil, err
 	}
@@ -63,8 +83,9 @@ func (rt *RefreshToken) Create(ctx context.Context, userID uint) (rtd *RefreshTo
 	claims["iat"] = rtd.Iat
 	claims["nbf"] = rtd.Iat
 	claims["exp"] = rtd.ExpiresIn
+	claims["fam"] = rtd.Family
 
-	rtd.Token, err = jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	rtd.Token, err = ks.sign(claims)
 	if err != nil {
 		return nil, err
 	}
@@ -74,6 +95,7 @@ func (rt *RefreshToken) Create(ctx context.Context, userID uint) (rtd *RefreshTo
 		LoginAt:   now,
 		ExpiresAt: rtd.ExpiresIn,
 		UserID:    userID,
+		Client:    client,
 	}
 	err = rt.DB.Create(&session).Error
 	if err != nil {
@@ -102,6 +124,12 @@ func (rt *RefreshToken) Create(ctx context.Context, userID uint) (rtd *RefreshTo
 		rtd.AccessTokenJTI,
 		rt.E.RefreshTokenExpiresIn,
 	)
+	pipe.Set(
+		ctx,
+		rdb.RefreshFamilyKey(rtd.Family),
+		rtd.JTI,
+		rt.E.RefreshTokenExpiresIn,
+	)
 	pipe.Set(
 		ctx,
 		rdb.AccessTokenKey(rtd.AccessTokenJTI),
@@ -120,37 +148,143 @@ func (rt *RefreshToken) Create(ctx context.Context, userID uint) (rtd *RefreshTo
 func (rt *RefreshToken) Validate(ctx context.Context, token string) (rtd *RefreshTokenDetails, err error) {
 	rtd = &RefreshTokenDetails{}
 
-	publicKey, err := base64.StdEncoding.DecodeString(rt.E.RefreshTokenPublicKey)
+	ks, err := RefreshKeyset(rt.E)
 	if err != nil {
 		return nil, err
 	}
-	key, err := jwt.ParseRSAPublicKeyFromPEM(publicKey)
+
+	claims, err := ks.parse(token)
 	if err != nil {
 		return nil, err
 	}
 
-	parsedToken, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-
-		return key, nil
-	})
-
-	claims, ok := parsedToken.Claims.(jwt.MapClaims)
-	if !ok || !parsedToken.Valid {
-		return nil, errors.New("invalid token")
-	}
-
 	rtd.Sub = uint(claims["sub"].(float64))
 	rtd.JTI = claims["jti"].(string)
 	rtd.Iat = int64(claims["iat"].(float64))
 	rtd.ExpiresIn = int64(claims["exp"].(float64))
+	rtd.Token = token
+
+	// tokens that were issued before the rotation were not given a family
+	rtd.Family, _ = claims["fam"].(string)
+	if rtd.Family == "" {
+		rtd.Family = rtd.JTI
+	}
 
-	val := rt.R.Get(ctx, rdb.RefreshTokenKey(rtd.JTI)).Val()
+	val := rt.R.Get(ctx, rdb.RefreshTokenKey(rtd.Family)).Val()
 	if val == "" {
 		return nil, errors.New("invalid token")
 	}
 
 	return rtd, nil
 }
+
+// Rotate issues the refresh token that replaces the given validated token, the new token keeps the family and
+// the expiry so that rotating does not extend the session
+func (rt *RefreshToken) Rotate(ctx context.Context, current *RefreshTokenDetails) (rtd *RefreshTokenDetails, err error) {
+	rtd = &RefreshTokenDetails{}
+
+	rtd.Iat = time.Now().Unix()
+	rtd.JTI = ulid.Make().String()
+	rtd.Family = current.Family
+	rtd.ExpiresIn = current.ExpiresIn
+	rtd.Sub = current.Sub
+
+	ks, err := RefreshKeyset(rt.E)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := make(jwt.MapClaims)
+	claims["sub"] = rtd.Sub
+	claims["jti"] = rtd.JTI
+	claims["iat"] = rtd.Iat
+	claims["nbf"] = rtd.Iat
+	claims["exp"] = rtd.ExpiresIn
+	claims["fam"] = rtd.Family
+
+	rtd.Token, err = ks.sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if current.JTI == current.Family {
+		ttl, err := rt.R.TTL(ctx, rdb.RefreshTokenKey(current.Family)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ttl > 0 {
+			// the first rotation of a session that started before refresh tokens had families
+			err = rt.R.SetNX(ctx, rdb.RefreshFamilyKey(current.Family), current.JTI, ttl).Err()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	res, err := rotateScript.Run(ctx, rt.R, []string{rdb.RefreshFamilyKey(rtd.Family)}, current.JTI, rtd.JTI).Int()
+	if err != nil {
+		return nil, err
+	}
+
+	switch res {
+	case -1:
+		return nil, errors.New("invalid token")
+	case 0:
+		if err := rt.Revoke(ctx, rtd.Family); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	return rtd, nil
+}
+
+// Latest checks if the validated token is the latest refresh token of its family, a token that was already
+// rotated is still valid until its family is revoked
+func (rt *RefreshToken) Latest(ctx context.Context, current *RefreshTokenDetails) (bool, error) {
+	latest, err := rt.R.Get(ctx, rdb.RefreshFamilyKey(current.Family)).Result()
+	if errors.Is(err, redis.Nil) {
+		// sessions that started before refresh tokens had families have no latest token until the first rotation
+		return current.JTI == current.Family, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return latest == current.JTI, nil
+}
+
+// Revoke revokes the session with the given id, the family of its refresh tokens, along with its latest access token
+func (rt *RefreshToken) Revoke(ctx context.Context, jti string) error {
+	val := rt.R.Get(ctx, rdb.RefreshTokenKey(jti)).Val()
+
+	pipe := rt.R.Pipeline()
+	pipe.Del(ctx, rdb.RefreshTokenKey(jti))
+	pipe.Del(ctx, rdb.RefreshFamilyKey(jti))
+	if val != "" {
+		pipe.Del(ctx, rdb.AccessTokenKey(val))
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return rt.DB.Where("id = ?", jti).Delete(&database.Session{}).Error
+}
+
+// RevokeAll revokes every session of the user
+func (rt *RefreshToken) RevokeAll(ctx context.Context, userID uint) error {
+	sessions := []database.Session{}
+	err := rt.DB.Where("user_id = ?", userID).Find(&sessions).Error
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if err := rt.Revoke(ctx, session.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
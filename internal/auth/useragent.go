@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"strings"
+)
+
+// browsers are matched in order since most browsers also claim to be the ones they are built on, Edge and Opera
+// say that they are Chrome and every Chromium browser says that it is Safari
+var browsers = []struct {
+	token string
+	name  string
+}{
+	{"Edg/", "Edge"},
+	{"EdgiOS/", "Edge"},
+	{"EdgA/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Vivaldi/", "Vivaldi"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Version/", "Safari"},
+}
+
+// parseUserAgent returns the kind of device, the browser and the OS from the user agent, it only knows the
+// common browsers so clients that are not browsers are named after the first product in the user agent
+func parseUserAgent(userAgent string) (device, browser, os string) {
+	if userAgent == "" {
+		return "", "", ""
+	}
+
+	switch {
+	case strings.Contains(userAgent, "iPhone"):
+		os, device = "iOS", "Phone"
+	case strings.Contains(userAgent, "iPad"):
+		os, device = "iPadOS", "Tablet"
+	case strings.Contains(userAgent, "Android"):
+		os, device = "Android", "Tablet"
+		if strings.Contains(userAgent, "Mobile") {
+			device = "Phone"
+		}
+	case strings.Contains(userAgent, "Windows"):
+		os, device = "Windows", "Desktop"
+	case strings.Contains(userAgent, "Macintosh"), strings.Contains(userAgent, "Mac OS X"):
+		os, device = "macOS", "Desktop"
+	case strings.Contains(userAgent, "CrOS"):
+		os, device = "ChromeOS", "Desktop"
+	case strings.Contains(userAgent, "Linux"):
+		os, device = "Linux", "Desktop"
+	}
+
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	if browser == "" || !strings.HasPrefix(userAgent, "Mozilla/") {
+		browser, _, _ = strings.Cut(userAgent, "/")
+		browser, _, _ = strings.Cut(browser, " ")
+		if len(browser) > 50 {
+			browser = browser[:50]
+		}
+	}
+	if device == "" && strings.Contains(strings.ToLower(userAgent), "bot") {
+		device = "Bot"
+	}
+
+	return device, browser, os
+}
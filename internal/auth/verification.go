@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	rdb "github.com/VinukaThejana/todoapp/internal/redis"
+	pb "github.com/VinukaThejana/todoapp/pkg/auth"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	// verificationCooldown is the time a user has to wait before another verification email is sent
+	verificationCooldown = time.Minute
+	// mailTimeout bounds the time spent on sending an email after the request has been answered
+	mailTimeout = 30 * time.Second
+)
+
+// newToken generates a random token for links that are sent by email
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes a token before it is used in a key so that the tokens can not be read from Redis
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// appURL returns the URL of the API gateway that the links in the emails point to
+func (s *Server) appURL() string {
+	if s.E.AppURL != "" {
+		return s.E.AppURL
+	}
+
+	return fmt.Sprintf("http://%s:%s", s.E.Domain, s.E.APIGatewayPort)
+}
+
+// sendMail sends the email in the background so that a slow mail server does not hold up the request
+func (s *Server) sendMail(to, subject, body string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+
+		if err := s.Mailer.Send(ctx, to, subject, body); err != nil {
+			log.Error().Err(err).Str("subject", subject).Msg("failed to send the email")
+		}
+	}()
+}
+
+// issueToken creates a single-use token for the user that is stored under tokenKey with the hash of the token,
+// the hash is also stored under userKey so that the token the user got before stops working
+func (s *Server) issueToken(
+	ctx context.Context,
+	tokenKey func(hash string) string,
+	userKey string,
+	userID uint,
+	ttl time.Duration,
+) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	hash := hashToken(token)
+
+	previous, err := s.R.Get(ctx, userKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", err
+	}
+
+	pipe := s.R.TxPipeline()
+	if previous != "" {
+		pipe.Del(ctx, tokenKey(previous))
+	}
+	pipe.Set(ctx, tokenKey(hash), userID, ttl)
+	pipe.Set(ctx, userKey, hash, ttl)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// redeemToken returns the user of a token that was created with issueToken, the token is deleted so that it
+// can only be used once. ok is false when the token is invalid or has expired.
+func (s *Server) redeemToken(ctx context.Context, tokenKey func(hash string) string, token string) (userID uint, ok bool, err error) {
+	val, err := s.R.GetDel(ctx, tokenKey(hashToken(token))).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	id, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return uint(id), true, nil
+}
+
+// sendVerification creates a new verification token for the user and sends it to their email address,
+// the tokens that were sent before stop working
+func (s *Server) sendVerification(ctx context.Context, user *database.User) error {
+	ttl := rdb.EmailVerificationTTL(s.E)
+	token, err := s.issueToken(ctx, rdb.EmailVerificationKey, rdb.EmailVerificationUserKey(user.ID), user.ID, ttl)
+	if err != nil {
+		return err
+	}
+
+	s.sendMail(
+		user.Email,
+		"Verify your email address",
+		fmt.Sprintf(
+			"Hi %s,\n\nOpen the link below to verify your email address, it expires in %s.\n\n%s/auth/verify-email?token=%s\n\nIf you did not sign up you can ignore this email.",
+			user.Name, ttl, s.appURL(), token,
+		),
+	)
+
+	return nil
+}
+
+// VerifyEmail is a gRPC endpoint to verify the email address of a user with the token that was sent to it,
+// each token can only be used once
+// returns Internal, NotFound, nil
+func (s *Server) VerifyEmail(ctx context.Context, req *pb.VerifyEmailRequest) (*pb.VerifyEmailResponse, error) {
+	id, ok, err := s.redeemToken(ctx, rdb.EmailVerificationKey, req.Token)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the verification token")
+		return &pb.VerifyEmailResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the verification token")
+	}
+	if !ok {
+		return &pb.VerifyEmailResponse{
+			Success: false,
+			Message: "Invalid or expired token",
+		}, status.Error(codes.NotFound, "the token is invalid or has expired")
+	}
+
+	err = s.DB.Model(&database.User{}).Where("id = ?", id).Update("email_verified", true).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to verify the email")
+		return &pb.VerifyEmailResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to verify the email")
+	}
+
+	s.R.Del(ctx, rdb.EmailVerificationUserKey(id))
+
+	return &pb.VerifyEmailResponse{
+		Success: true,
+		Message: "Email verified successfully",
+	}, nil
+}
+
+// ResendVerification is a gRPC endpoint to send a new verification email, the response is the same whether
+// or not the email belongs to an unverified user so that it can not be used to find out who has an account
+// returns Internal, nil
+func (s *Server) ResendVerification(ctx context.Context, req *pb.ResendVerificationRequest) (*pb.ResendVerificationResponse, error) {
+	res := &pb.ResendVerificationResponse{
+		Success: true,
+		Message: "If the email belongs to an unverified account a verification email has been sent",
+	}
+
+	user := &database.User{}
+	err := s.DB.Where("email = ?", req.Email).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return res, nil
+		}
+
+		log.Error().Err(err).Msg("failed to find the user")
+		return &pb.ResendVerificationResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to find the user")
+	}
+	if user.EmailVerified {
+		return res, nil
+	}
+
+	ok, err := s.R.SetNX(ctx, rdb.EmailVerificationCooldownKey(user.ID), 1, verificationCooldown).Result()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check the verification cooldown")
+		return &pb.ResendVerificationResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to send the verification email")
+	}
+	if !ok {
+		return res, nil
+	}
+
+	err = s.sendVerification(ctx, user)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to send the verification email")
+		return &pb.ResendVerificationResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to send the verification email")
+	}
+
+	return res, nil
+}
@@ -1,6 +1,9 @@
 package env
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
 	"time"
 
 	environ "github.com/VinukaThejana/env"
@@ -8,27 +11,85 @@ import (
 
 // Env is the struct that holds the environment variables
 type Env struct {
-	Domain                 string        `mapstructure:"DOMAIN" validate:"required"`
-	AuthgRPCDomain         string        `mapstructure:"AUTH_GRPC_DOMAIN" validate:"required"`
-	AuthgRPCPort           string        `mapstructure:"AUTH_GRPC_PORT" validate:"required"`
-	TodoGRPCDomain         string        `mapstructure:"TODO_GRPC_DOMAIN" validate:"required"`
-	TodogRPCPort           string        `mapstructure:"TODO_GRPC_PORT" validate:"required"`
-	APIGatewayPort         string        `mapstructure:"API_GATEWAY_PORT" validate:"required"`
-	DatabaseURL            string        `mapstructure:"DATABASE_URL" validate:"required"`
-	RedisURL               string        `mapstructure:"REDIS_URL" validate:"required"`
-	RedisPassword          string        `mapstructure:"REDIS_PASSWORD" validate:"required"`
-	RefreshTokenMaxAge     string        `mapstructure:"REFRESH_TOKEN_MAX_AGE" validate:"required"`
-	RefreshTokenPrivateKey string        `mapstructure:"REFRESH_TOKEN_PRIVATE_KEY" validate:"required"`
-	RefreshTokenPublicKey  string        `mapstructure:"REFRESH_TOKEN_PUBLIC_KEY" validate:"required"`
-	AccessTokenMaxAge      string        `mapstructure:"ACCESS_TOKEN_MAX_AGE" validate:"required"`
-	AccessTokenPrivateKey  string        `mapstructure:"ACCESS_TOKEN_PRIVATE_KEY" validate:"required"`
-	AccessTokenPublicKey   string        `mapstructure:"ACCESS_TOKEN_PUBLIC_KEY" validate:"required"`
-	Environ                string        `mapstructure:"ENVIRON" validate:"required,oneof=dev stg prod"`
-	SessionSecret          string        `mapstructure:"SESSION_SECRET" validate:"required"`
-	AccessTokenExpiresIn   time.Duration `mapstructure:"ACCESS_TOKEN_EXPIRES_IN" validate:"required"`
-	RefreshTokenExpiresIn  time.Duration `mapstructure:"REFRESH_TOKEN_EXPIRES_IN" validate:"required"`
+	Domain                       string        `mapstructure:"DOMAIN" validate:"required"`
+	AuthgRPCDomain               string        `mapstructure:"AUTH_GRPC_DOMAIN" validate:"required"`
+	AuthgRPCPort                 string        `mapstructure:"AUTH_GRPC_PORT" validate:"required"`
+	TodoGRPCDomain               string        `mapstructure:"TODO_GRPC_DOMAIN" validate:"required"`
+	TodogRPCPort                 string        `mapstructure:"TODO_GRPC_PORT" validate:"required"`
+	NotificationGRPCDomain       string        `mapstructure:"NOTIFICATION_GRPC_DOMAIN"`
+	NotificationGRPCPort         string        `mapstructure:"NOTIFICATION_GRPC_PORT"`
+	APIGatewayPort               string        `mapstructure:"API_GATEWAY_PORT" validate:"required"`
+	DatabaseURL                  string        `mapstructure:"DATABASE_URL" validate:"required"`
+	RedisURL                     string        `mapstructure:"REDIS_URL" validate:"required"`
+	RedisPassword                string        `mapstructure:"REDIS_PASSWORD" validate:"required"`
+	RefreshTokenMaxAge           string        `mapstructure:"REFRESH_TOKEN_MAX_AGE" validate:"required"`
+	RefreshTokenPrivateKey       string        `mapstructure:"REFRESH_TOKEN_PRIVATE_KEY" validate:"required"`
+	RefreshTokenPublicKey        string        `mapstructure:"REFRESH_TOKEN_PUBLIC_KEY" validate:"required"`
+	RefreshTokenVerificationKeys string        `mapstructure:"REFRESH_TOKEN_VERIFICATION_KEYS"`
+	AccessTokenMaxAge            string        `mapstructure:"ACCESS_TOKEN_MAX_AGE" validate:"required"`
+	AccessTokenPrivateKey        string        `mapstructure:"ACCESS_TOKEN_PRIVATE_KEY" validate:"required"`
+	AccessTokenPublicKey         string        `mapstructure:"ACCESS_TOKEN_PUBLIC_KEY" validate:"required"`
+	AccessTokenVerificationKeys  string        `mapstructure:"ACCESS_TOKEN_VERIFICATION_KEYS"`
+	Environ                      string        `mapstructure:"ENVIRON" validate:"required,oneof=dev stg prod"`
+	SessionSecret                string        `mapstructure:"SESSION_SECRET" validate:"required"`
+	AccessTokenExpiresIn         time.Duration `mapstructure:"ACCESS_TOKEN_EXPIRES_IN" validate:"required"`
+	RefreshTokenExpiresIn        time.Duration `mapstructure:"REFRESH_TOKEN_EXPIRES_IN" validate:"required"`
+	BlockedPolicy                string        `mapstructure:"BLOCKED_POLICY" validate:"omitempty,oneof=reject warn"`
+	StatsCacheTTL                time.Duration `mapstructure:"STATS_CACHE_TTL"`
+	SMTPHost                     string        `mapstructure:"SMTP_HOST"`
+	SMTPPort                     string        `mapstructure:"SMTP_PORT"`
+	SMTPUsername                 string        `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword                 string        `mapstructure:"SMTP_PASSWORD"`
+	SMTPFrom                     string        `mapstructure:"SMTP_FROM" validate:"omitempty,email"`
+	NotificationInterval         time.Duration `mapstructure:"NOTIFICATION_INTERVAL"`
+	AppURL                       string        `mapstructure:"APP_URL" validate:"omitempty,url"`
+	EmailVerificationTTL         time.Duration `mapstructure:"EMAIL_VERIFICATION_TTL"`
+	RequireVerifiedEmail         bool          `mapstructure:"REQUIRE_VERIFIED_EMAIL"`
+	PasswordResetTTL             time.Duration `mapstructure:"PASSWORD_RESET_TTL"`
+	WebAuthnRPName               string        `mapstructure:"WEBAUTHN_RP_NAME"`
+	WebAuthnOrigins              string        `mapstructure:"WEBAUTHN_ORIGINS"`
+	OIDCProviders                string        `mapstructure:"OIDC_PROVIDERS"`
+	TrustedProxies               string        `mapstructure:"TRUSTED_PROXIES"`
+	LoginMaxAttempts             int           `mapstructure:"LOGIN_MAX_ATTEMPTS"`
+	LoginMaxAttemptsPerIP        int           `mapstructure:"LOGIN_MAX_ATTEMPTS_PER_IP"`
+	LoginLockoutDuration         time.Duration `mapstructure:"LOGIN_LOCKOUT_DURATION"`
+	RateLimitAuth                int           `mapstructure:"RATE_LIMIT_AUTH"`
+	RateLimitAuthWindow          time.Duration `mapstructure:"RATE_LIMIT_AUTH_WINDOW"`
+	RateLimitTodo                int           `mapstructure:"RATE_LIMIT_TODO"`
+	RateLimitTodoWindow          time.Duration `mapstructure:"RATE_LIMIT_TODO_WINDOW"`
+	RateLimitCalDAV              int           `mapstructure:"RATE_LIMIT_CALDAV"`
+	RateLimitCalDAVWindow        time.Duration `mapstructure:"RATE_LIMIT_CALDAV_WINDOW"`
+	RateLimitAppPassword         int           `mapstructure:"RATE_LIMIT_APP_PASSWORD"`
+	RateLimitAppPasswordWindow   time.Duration `mapstructure:"RATE_LIMIT_APP_PASSWORD_WINDOW"`
+	AccountDeletionGracePeriod   time.Duration `mapstructure:"ACCOUNT_DELETION_GRACE_PERIOD"`
+	DataExportTTL                time.Duration `mapstructure:"DATA_EXPORT_TTL"`
 }
 
 func (e *Env) Load(path ...string) {
 	environ.Load(e, path...)
 }
+
+// Require checks that the given settings are set, Load only validates the settings that every service needs
+// so each service requires the settings of the services that it serves or dials
+func (e *Env) Require(names ...string) error {
+	v := reflect.ValueOf(e).Elem()
+
+	missing := []string{}
+	for _, name := range names {
+		set := false
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).Tag.Get("mapstructure") == name {
+				set = !v.Field(i).IsZero()
+				break
+			}
+		}
+		if !set {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the required settings %s are not set", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
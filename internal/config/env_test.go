@@ -0,0 +1,17 @@
+package env
+
+import "testing"
+
+func TestRequire(t *testing.T) {
+	e := &Env{NotificationGRPCPort: "50053"}
+
+	if err := e.Require("NOTIFICATION_GRPC_PORT"); err != nil {
+		t.Fatalf("err = %v, want the set setting to pass", err)
+	}
+
+	err := e.Require("NOTIFICATION_GRPC_DOMAIN", "NOTIFICATION_GRPC_PORT", "NO_SUCH_SETTING")
+	want := "the required settings NOTIFICATION_GRPC_DOMAIN, NO_SUCH_SETTING are not set"
+	if err == nil || err.Error() != want {
+		t.Fatalf("err = %v, want %q", err, want)
+	}
+}
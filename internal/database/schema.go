@@ -24,6 +24,90 @@ var Tables = []struct {
 		Name:   "sessions",
 		Schema: Session{},
 	},
+	{
+		Name:   "workflows",
+		Schema: Workflow{},
+	},
+	{
+		Name:   "workflow_states",
+		Schema: WorkflowState{},
+	},
+	{
+		Name:   "workflow_transitions",
+		Schema: WorkflowTransition{},
+	},
+	{
+		Name:   "todo_dependencies",
+		Schema: TodoDependency{},
+	},
+	{
+		Name:   "todo_tags",
+		Schema: TodoTag{},
+	},
+	{
+		Name:   "filters",
+		Schema: Filter{},
+	},
+	{
+		Name:   "notification_preferences",
+		Schema: NotificationPreference{},
+	},
+	{
+		Name:   "notifications",
+		Schema: Notification{},
+	},
+	{
+		Name:   "deliveries",
+		Schema: Delivery{},
+	},
+	{
+		Name:   "app_passwords",
+		Schema: AppPassword{},
+	},
+	{
+		Name:   "change_sequences",
+		Schema: ChangeSequence{},
+	},
+	{
+		Name:   "workspaces",
+		Schema: Workspace{},
+	},
+	{
+		Name:   "workspace_members",
+		Schema: WorkspaceMember{},
+	},
+	{
+		Name:   "workspace_invites",
+		Schema: WorkspaceInvite{},
+	},
+	{
+		Name:   "plans",
+		Schema: Plan{},
+	},
+	{
+		Name:   "quotas",
+		Schema: Quota{},
+	},
+	{
+		Name:   "totps",
+		Schema: TOTP{},
+	},
+	{
+		Name:   "recovery_codes",
+		Schema: RecoveryCode{},
+	},
+	{
+		Name:   "passkeys",
+		Schema: Passkey{},
+	},
+	{
+		Name:   "identities",
+		Schema: Identity{},
+	},
+	{
+		Name:   "personal_access_tokens",
+		Schema: PersonalAccessToken{},
+	},
 }
 
 // User is a model for the user table
@@ -33,6 +117,17 @@ type User struct {
 	Email    string `gorm:"type:varchar(255);uniqueIndex"`
 	Username string `gorm:"type:varchar(100);uniqueIndex"`
 	Password string `gorm:"not null"`
+	Plan     string `gorm:"type:varchar(20);not null;default:'free'"`
+	// EmailVerified is set once the user opens the link that was sent to their email address
+	EmailVerified bool   `gorm:"not null;default:false"`
+	Role          string `gorm:"type:varchar(20);not null;default:'user'"`
+	// DisabledAt is set while an admin has disabled the account, the user can not log in until it is enabled
+	DisabledAt *time.Time
+	// PasswordResetRequired keeps the user from logging in with the password until they reset it
+	PasswordResetRequired bool `gorm:"not null;default:false"`
+	// DeletionScheduledAt is set when the user deletes their account, logging in before then restores the account
+	// and after it the account is purged with all of its data
+	DeletionScheduledAt *time.Time `gorm:"index"`
 }
 
 // Todo is a model for the todo table
@@ -42,8 +137,32 @@ type Todo struct {
 	Description string `gorm:"not null"`
 	Content     string `gorm:"not null"`
 	Completed   bool   `gorm:"not null"`
-	UserID      uint   `gorm:"not null"`
-	User        User   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	CompletedAt *time.Time
+	State       string     `gorm:"type:varchar(50);not null;default:''"`
+	Priority    string     `gorm:"type:varchar(10);not null;default:''"`
+	Project     string     `gorm:"type:varchar(50);not null;default:'';index"`
+	DueAt       *time.Time `gorm:"index"`
+	Recurrence  string     `gorm:"type:varchar(100);not null;default:''"`
+	UserID      uint       `gorm:"not null;index"`
+	User        User       `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Tags        []TodoTag  `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// CalendarUID and CalendarName are the iCalendar UID and the resource name of todos created over CalDAV
+	CalendarUID  string `gorm:"type:varchar(255);not null;default:''"`
+	CalendarName string `gorm:"type:varchar(255);not null;default:''"`
+	// Revision is the change sequence of the user or the workspace at the last change of the todo, including its deletion
+	Revision uint64 `gorm:"not null;default:0;index"`
+	// WorkspaceID is the workspace that the todo belongs to, personal todos do not belong to any workspace
+	WorkspaceID uint `gorm:"not null;default:0;index"`
+}
+
+// Client is the client that a session was started from as the gateway saw it, the device, the browser and
+// the OS are parsed from the user agent
+type Client struct {
+	IP        string `gorm:"type:varchar(45);not null;default:''"`
+	UserAgent string `gorm:"type:varchar(512);not null;default:''"`
+	Device    string `gorm:"type:varchar(50);not null;default:''"`
+	Browser   string `gorm:"type:varchar(50);not null;default:''"`
+	OS        string `gorm:"type:varchar(50);not null;default:''"`
 }
 
 // Session is a model for the session table
@@ -52,5 +171,261 @@ type Session struct {
 	ExpiresAt int64  `gorm:"not null"`
 	UserID    uint   `gorm:"not null"`
 	LoginAt   time.Time
-	User      User `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	User      User   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Client    Client `gorm:"embedded"`
+	// LastSeenAt is the last time that the access token of the session was refreshed
+	LastSeenAt *time.Time
+}
+
+// Workflow is a model for the workflow table, each user can have a single workflow
+type Workflow struct {
+	gorm.Model
+	Name        string               `gorm:"not null"`
+	UserID      uint                 `gorm:"not null;uniqueIndex"`
+	User        User                 `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	States      []WorkflowState      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Transitions []WorkflowTransition `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+// WorkflowState is a model for the workflow state table
+type WorkflowState struct {
+	ID         uint   `gorm:"primarykey"`
+	WorkflowID uint   `gorm:"not null;uniqueIndex:idx_workflow_state_name"`
+	Name       string `gorm:"type:varchar(50);not null;uniqueIndex:idx_workflow_state_name"`
+	Position   int    `gorm:"not null"`
+	Terminal   bool   `gorm:"not null"`
+}
+
+// WorkflowTransition is a model for the workflow transition table
+type WorkflowTransition struct {
+	ID         uint   `gorm:"primarykey"`
+	WorkflowID uint   `gorm:"not null;index"`
+	FromState  string `gorm:"type:varchar(50);not null"`
+	ToState    string `gorm:"type:varchar(50);not null"`
+}
+
+// TodoDependency is a model for the todo dependency table, the todo is blocked until the blocker is completed
+type TodoDependency struct {
+	ID          uint `gorm:"primarykey"`
+	TodoID      uint `gorm:"not null;uniqueIndex:idx_todo_dependency"`
+	BlockedByID uint `gorm:"not null;uniqueIndex:idx_todo_dependency;index"`
+	UserID      uint `gorm:"not null;index"`
+	WorkspaceID uint `gorm:"not null;default:0;index"`
+	Todo        Todo `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	BlockedBy   Todo `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	User        User `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+// TodoTag is a model for the todo tag table
+type TodoTag struct {
+	ID     uint   `gorm:"primarykey"`
+	TodoID uint   `gorm:"not null;uniqueIndex:idx_todo_tag"`
+	Name   string `gorm:"type:varchar(50);not null;uniqueIndex:idx_todo_tag;index"`
+}
+
+// Filter is a model for the saved filter table, the query is written in the query language of the filter package
+type Filter struct {
+	gorm.Model
+	Name   string `gorm:"type:varchar(50);not null;uniqueIndex:idx_filter_name"`
+	Query  string `gorm:"type:varchar(1000);not null"`
+	UserID uint   `gorm:"not null;uniqueIndex:idx_filter_name"`
+	User   User   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+// NotificationPreference is a model for the notification preference table, quiet hours are given as HH:MM
+// in the time zone of the user and only hold back the email and webhook channels
+type NotificationPreference struct {
+	gorm.Model
+	UserID        uint   `gorm:"not null;uniqueIndex"`
+	User          User   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Email         bool   `gorm:"not null"`
+	Webhook       bool   `gorm:"not null"`
+	Inbox         bool   `gorm:"not null"`
+	WebhookURL    string `gorm:"type:varchar(255)"`
+	WebhookSecret string `gorm:"type:varchar(64)"`
+	QuietStart    string `gorm:"type:varchar(5)"`
+	QuietEnd      string `gorm:"type:varchar(5)"`
+	TimeZone      string `gorm:"type:varchar(64)"`
+}
+
+// Notification is a model for the notification table, these are the items of the in-app inbox
+type Notification struct {
+	gorm.Model
+	UserID     uint   `gorm:"not null;index"`
+	User       User   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Kind       string `gorm:"type:varchar(20);not null"`
+	Title      string `gorm:"not null"`
+	Body       string `gorm:"not null"`
+	ReadAt     *time.Time
+	ArchivedAt *time.Time `gorm:"index"`
+}
+
+// Delivery is a model for the delivery table, it tracks the delivery of a notification through a single channel
+type Delivery struct {
+	gorm.Model
+	UserID    uint      `gorm:"not null;index"`
+	User      User      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Channel   string    `gorm:"type:varchar(20);not null"`
+	Kind      string    `gorm:"type:varchar(20);not null"`
+	Key       string    `gorm:"type:varchar(100);index"`
+	Subject   string    `gorm:"not null"`
+	Body      string    `gorm:"not null"`
+	Status    string    `gorm:"type:varchar(20);not null;index"`
+	Attempts  int       `gorm:"not null"`
+	LastError string    `gorm:"type:varchar(255)"`
+	SendAfter time.Time `gorm:"not null;index"`
+	SentAt    *time.Time
+}
+
+// AppPassword is a model for the app password table, app passwords let clients like CalDAV apps sign in
+// with basic authentication without knowing the password of the user. Only the SHA-256 hash is stored.
+type AppPassword struct {
+	gorm.Model
+	UserID     uint   `gorm:"not null;index"`
+	User       User   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Name       string `gorm:"type:varchar(50);not null"`
+	Hash       string `gorm:"type:varchar(64);not null;uniqueIndex"`
+	LastUsedAt *time.Time
+}
+
+// ChangeSequence is a model for the change sequence table, it counts the changes to the personal todos of a
+// user or to the todos of a workspace so that offline clients can ask for the changes since the last sync
+type ChangeSequence struct {
+	UserID      uint   `gorm:"primarykey;autoIncrement:false"`
+	WorkspaceID uint   `gorm:"primarykey;autoIncrement:false"`
+	Value       uint64 `gorm:"not null"`
+}
+
+// Workspace is a model for the workspace table, the members of a workspace share its todos
+type Workspace struct {
+	gorm.Model
+	Name    string `gorm:"type:varchar(50);not null"`
+	OwnerID uint   `gorm:"not null;index"`
+	Owner   User   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+// WorkspaceMember is a model for the workspace member table
+type WorkspaceMember struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	WorkspaceID uint      `gorm:"not null;uniqueIndex:idx_workspace_member"`
+	UserID      uint      `gorm:"not null;uniqueIndex:idx_workspace_member;index"`
+	Role        string    `gorm:"type:varchar(20);not null"`
+	Workspace   Workspace `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	User        User      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+// WorkspaceInvite is a model for the workspace invite table, invites can only be used once and only the
+// SHA-256 hash of the code is stored. Invites with an email can only be used by the user with that email.
+type WorkspaceInvite struct {
+	gorm.Model
+	WorkspaceID  uint      `gorm:"not null;index"`
+	Workspace    Workspace `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	InvitedByID  uint      `gorm:"not null"`
+	InvitedBy    User      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Email        string    `gorm:"type:varchar(255);not null;default:''"`
+	Role         string    `gorm:"type:varchar(20);not null"`
+	Hash         string    `gorm:"type:varchar(64);not null;uniqueIndex"`
+	ExpiresAt    time.Time `gorm:"not null"`
+	AcceptedAt   *time.Time
+	AcceptedByID *uint
+}
+
+// QuotaLimits are the limits that a plan or a quota overrides, a nil limit keeps the value it would
+// otherwise have and a limit of 0 means that there is no limit
+type QuotaLimits struct {
+	Todos          *int64
+	ContentSize    *int64
+	Storage        *int64
+	RequestsPerDay *int64
+}
+
+// Plan is a model for the plan table, a row overrides the built in limits of the plan with the same name
+type Plan struct {
+	Name      string      `gorm:"type:varchar(20);primarykey"`
+	Limits    QuotaLimits `gorm:"embedded;embeddedPrefix:max_"`
+	UpdatedAt time.Time
+}
+
+// Quota is a model for the quota table, a row overrides the limits of the plan for a single user
+type Quota struct {
+	UserID    uint        `gorm:"primarykey;autoIncrement:false"`
+	User      User        `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Limits    QuotaLimits `gorm:"embedded;embeddedPrefix:max_"`
+	UpdatedAt time.Time
+}
+
+// TOTP is a model for the TOTP table, it holds the authenticator app secret of a user. The second factor is
+// only asked for at login once the user has confirmed the enrolment with a code from the app.
+type TOTP struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	UserID      uint   `gorm:"not null;uniqueIndex"`
+	User        User   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Secret      string `gorm:"type:varchar(64);not null"`
+	ConfirmedAt *time.Time
+	// LastStep is the time step of the last code that was accepted, codes of older steps are rejected so
+	// that a code can not be used twice
+	LastStep int64 `gorm:"not null;default:0"`
+}
+
+// RecoveryCode is a model for the recovery code table, recovery codes replace the authenticator app when it is
+// lost and can only be used once. Only the SHA-256 hash is stored.
+type RecoveryCode struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"not null;index"`
+	User      User   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Hash      string `gorm:"type:varchar(64);not null"`
+	UsedAt    *time.Time
+}
+
+// Passkey is a model for the passkey table, it holds a WebAuthn credential that a user registered with an
+// authenticator. Passkeys can be used to log in without a password or as the second factor after one.
+type Passkey struct {
+	ID              uint `gorm:"primarykey"`
+	CreatedAt       time.Time
+	UserID          uint   `gorm:"not null;index"`
+	User            User   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Name            string `gorm:"type:varchar(50);not null"`
+	CredentialID    []byte `gorm:"not null;uniqueIndex"`
+	PublicKey       []byte `gorm:"not null"`
+	AttestationType string `gorm:"type:varchar(32)"`
+	// Transports is the comma separated list of the transports that the authenticator supports
+	Transports     string `gorm:"type:varchar(100)"`
+	AAGUID         []byte
+	BackupEligible bool `gorm:"not null;default:false"`
+	BackupState    bool `gorm:"not null;default:false"`
+	// SignCount is the signature counter of the last assertion, an assertion with a counter that is not
+	// higher means that the authenticator may have been cloned
+	SignCount  uint32 `gorm:"not null;default:0"`
+	LastUsedAt *time.Time
+}
+
+// Identity is a model for the identity table, it links an account at an external OpenID Connect provider to a
+// user so that the user can sign in with the provider
+type Identity struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"not null;index"`
+	User      User   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Provider  string `gorm:"type:varchar(50);not null;uniqueIndex:idx_identity_subject"`
+	// Subject is the id of the account at the provider, it does not change when the email does
+	Subject string `gorm:"type:varchar(255);not null;uniqueIndex:idx_identity_subject"`
+	Email   string `gorm:"type:varchar(255)"`
+}
+
+// PersonalAccessToken is a model for the personal access token table, personal access tokens let scripts and
+// integrations use the API without logging in. They only grant the scopes that they were created with and only
+// the SHA-256 hash is stored.
+type PersonalAccessToken struct {
+	gorm.Model
+	UserID uint   `gorm:"not null;index"`
+	User   User   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Name   string `gorm:"type:varchar(50);not null"`
+	Hash   string `gorm:"type:varchar(64);not null;uniqueIndex"`
+	// Scopes is the comma separated list of the scopes that the token grants
+	Scopes     string `gorm:"type:varchar(255);not null"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
 }
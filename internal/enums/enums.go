@@ -12,3 +12,161 @@ const (
 	// Prd represents the production environment
 	Prd Env = "prd"
 )
+
+// BlockedPolicy represents what happens when a todo that is blocked by open todos is completed
+type BlockedPolicy string
+
+const (
+	// Reject rejects completing a todo that has open blockers
+	Reject BlockedPolicy = "reject"
+	// Warn completes the todo but warns the user about the open blockers
+	Warn BlockedPolicy = "warn"
+)
+
+// Priority represents the priority of a todo
+type Priority string
+
+const (
+	// Low represents a todo that can wait
+	Low Priority = "low"
+	// Medium represents a todo with a normal priority
+	Medium Priority = "medium"
+	// High represents a todo that should be done soon
+	High Priority = "high"
+	// Urgent represents a todo that must be done first
+	Urgent Priority = "urgent"
+)
+
+// Channel represents a channel that notifications are delivered through
+type Channel string
+
+const (
+	// Email delivers notifications as emails over SMTP
+	Email Channel = "email"
+	// Webhook delivers notifications to an URL chosen by the user
+	Webhook Channel = "webhook"
+	// Inbox delivers notifications to the in-app inbox
+	Inbox Channel = "inbox"
+)
+
+// NotificationKind represents what a notification is about
+type NotificationKind string
+
+const (
+	// Reminder reminds the user about a todo that is due soon
+	Reminder NotificationKind = "reminder"
+	// Overdue tells the user that a todo is past its due date
+	Overdue NotificationKind = "overdue"
+	// Share tells the user that something was shared with them
+	Share NotificationKind = "share"
+	// Mention tells the user that they were mentioned
+	Mention NotificationKind = "mention"
+)
+
+// DeliveryStatus represents the state of the delivery of a notification through a channel
+type DeliveryStatus string
+
+const (
+	// Pending deliveries are waiting to be sent, either for the first time or for a retry
+	Pending DeliveryStatus = "pending"
+	// Sent deliveries were accepted by the channel
+	Sent DeliveryStatus = "sent"
+	// Failed deliveries have run out of attempts
+	Failed DeliveryStatus = "failed"
+)
+
+// ConflictReason represents why a change sent by an offline client was not applied
+type ConflictReason string
+
+const (
+	// Modified means that the todo was changed on the server after the last sync of the client
+	Modified ConflictReason = "modified"
+	// Deleted means that the todo was deleted on the server
+	Deleted ConflictReason = "deleted"
+	// Missing means that the todo does not exist
+	Missing ConflictReason = "missing"
+	// Rejected means that the change is not valid, the message tells what is wrong with it
+	Rejected ConflictReason = "rejected"
+)
+
+// WorkspaceRole represents the role of a member of a workspace
+type WorkspaceRole string
+
+const (
+	// Owner created the workspace and can do everything in it
+	Owner WorkspaceRole = "owner"
+	// Admin can manage the members and the todos of the workspace
+	Admin WorkspaceRole = "admin"
+	// Member can manage the todos of the workspace
+	Member WorkspaceRole = "member"
+	// Viewer can only see the todos of the workspace
+	Viewer WorkspaceRole = "viewer"
+)
+
+// Plan represents the plan of a user, the plan decides the default quotas of the user
+type Plan string
+
+const (
+	// Free is the plan that every user starts with
+	Free Plan = "free"
+	// Pro is the paid plan with higher limits
+	Pro Plan = "pro"
+)
+
+// QuotaSubject represents a resource whose usage is limited by the quotas of a user
+type QuotaSubject string
+
+const (
+	// TodoCount is the number of todos that a user has created
+	TodoCount QuotaSubject = "todos"
+	// ContentSize is the size in bytes of the title, description and content of a single todo
+	ContentSize QuotaSubject = "content_size"
+	// Storage is the size in bytes of all the todos that a user has created
+	Storage QuotaSubject = "storage"
+	// DailyRequests is the number of API requests that a user has made since midnight UTC
+	DailyRequests QuotaSubject = "requests_per_day"
+)
+
+// SecondFactor represents a method that a user can prove the second factor at login with
+type SecondFactor string
+
+const (
+	// TOTPFactor is a code from an authenticator app or a recovery code
+	TOTPFactor SecondFactor = "totp"
+	// PasskeyFactor is an assertion from a registered passkey
+	PasskeyFactor SecondFactor = "passkey"
+)
+
+// WebAuthnCeremony represents the kind of a WebAuthn ceremony that is in progress
+type WebAuthnCeremony string
+
+const (
+	// PasskeyRegistration adds a passkey to the account of a signed in user
+	PasskeyRegistration WebAuthnCeremony = "registration"
+	// PasskeyLogin logs in a user without a password, the passkey identifies the user
+	PasskeyLogin WebAuthnCeremony = "login"
+	// PasskeySecondFactor completes a login of a user who has given the password
+	PasskeySecondFactor WebAuthnCeremony = "second_factor"
+)
+
+// Scope represents what a personal access token is allowed to do
+type Scope string
+
+const (
+	// TodoRead allows reading the todos
+	TodoRead Scope = "todo:read"
+	// TodoWrite allows creating, changing and deleting the todos
+	TodoWrite Scope = "todo:write"
+)
+
+// Role represents what a user is allowed to do outside of their own account
+type Role string
+
+const (
+	// UserRole is the role that every user starts with
+	UserRole Role = "user"
+	// SupportRole can look up the users and help them back into their accounts
+	SupportRole Role = "support"
+	// AdminRole can do everything that support can and disable or delete the accounts of the users
+	AdminRole Role = "admin"
+)
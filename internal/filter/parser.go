@@ -0,0 +1,279 @@
+package filter
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	maxQueryLength = 1000
+	maxDepth       = 32
+)
+
+type fieldType int
+
+const (
+	textField fieldType = iota
+	boolField
+	dateField
+	tagField
+)
+
+// fields maps the fields of the query language to their types
+var fields = map[string]fieldType{
+	"title":       textField,
+	"description": textField,
+	"content":     textField,
+	"state":       textField,
+	"priority":    textField,
+	"project":     textField,
+	"tag":         tagField,
+	"completed":   boolField,
+	"due":         dateField,
+	"created":     dateField,
+	"updated":     dateField,
+	"done":        dateField,
+}
+
+// predicates are the bare words that can be used as conditions
+var predicates = map[string]bool{
+	"open":      true,
+	"completed": true,
+	"blocked":   true,
+	"blocking":  true,
+	"overdue":   true,
+	"recurring": true,
+}
+
+// Node is a node of a parsed query
+type Node interface {
+	node()
+}
+
+// Binary is a node that combines two nodes with and or or
+type Binary struct {
+	Op    string
+	Left  Node
+	Right Node
+}
+
+// Not is a node that negates another node
+type Not struct {
+	Expr Node
+}
+
+// Comparison is a node that compares a field with a value
+type Comparison struct {
+	Field string
+	Op    string
+	Value string
+	Pos   int
+}
+
+// Predicate is a node for a bare word condition like open or blocked
+type Predicate struct {
+	Name string
+	Pos  int
+}
+
+func (*Binary) node()     {}
+func (*Not) node()        {}
+func (*Comparison) node() {}
+func (*Predicate) node()  {}
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+// Parse parses the query, the returned error is an *Error that carries the position of the problem
+func Parse(src string) (Node, error) {
+	if len([]rune(src)) > maxQueryLength {
+		return nil, errorf(maxQueryLength, "the query must not be longer than %d characters", maxQueryLength)
+	}
+
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, errorf(0, "the query is empty")
+	}
+
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, errorf(next.pos, "unexpected %q", next.text)
+	}
+
+	return node, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+// parseOr parses: and ("or" and)*
+func (p *parser) parseOr() (Node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{Op: "or", Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+// parseAnd parses: not ("and" not)*
+func (p *parser) parseAnd() (Node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{Op: "and", Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+// parseNot parses: "not" not | primary
+func (p *parser) parseNot() (Node, error) {
+	if p.peek().kind != tokenNot {
+		return p.parsePrimary()
+	}
+
+	t := p.next()
+	p.depth++
+	if p.depth > maxDepth {
+		return nil, errorf(t.pos, "the query is nested too deeply")
+	}
+	expr, err := p.parseNot()
+	p.depth--
+	if err != nil {
+		return nil, err
+	}
+
+	return &Not{Expr: expr}, nil
+}
+
+// parsePrimary parses: "(" or ")" | field op value | predicate
+func (p *parser) parsePrimary() (Node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenLParen:
+		p.depth++
+		if p.depth > maxDepth {
+			return nil, errorf(t.pos, "the query is nested too deeply")
+		}
+		node, err := p.parseOr()
+		p.depth--
+		if err != nil {
+			return nil, err
+		}
+
+		closing := p.next()
+		if closing.kind != tokenRParen {
+			return nil, errorf(closing.pos, "expected ) to close the ( at position %d", t.pos)
+		}
+		return node, nil
+	case tokenWord:
+		name := strings.ToLower(t.text)
+		if p.peek().kind != tokenOp {
+			if predicates[name] {
+				return &Predicate{Name: name, Pos: t.pos}, nil
+			}
+			if _, ok := fields[name]; ok {
+				return nil, errorf(p.peek().pos, "expected an operator after %s", name)
+			}
+			return nil, errorf(t.pos, "unknown condition %q", t.text)
+		}
+
+		fieldType, ok := fields[name]
+		if !ok {
+			return nil, errorf(t.pos, "unknown field %q", t.text)
+		}
+
+		op := p.next()
+		value := p.next()
+		if value.kind != tokenWord && value.kind != tokenString {
+			return nil, errorf(value.pos, "expected a value after %s", op.text)
+		}
+
+		if err := check(fieldType, name, op, value); err != nil {
+			return nil, err
+		}
+
+		return &Comparison{
+			Field: name,
+			Op:    op.text,
+			Value: value.text,
+			Pos:   t.pos,
+		}, nil
+	case tokenEOF:
+		return nil, errorf(t.pos, "unexpected end of the query")
+	default:
+		return nil, errorf(t.pos, "unexpected %q", t.text)
+	}
+}
+
+// check checks that the operator and the value can be used with the field
+func check(fieldType fieldType, name string, op token, value token) error {
+	switch fieldType {
+	case textField, tagField:
+		switch op.text {
+		case "=", "!=", "~":
+		default:
+			return errorf(op.pos, "%s can not be used with %s", op.text, name)
+		}
+	case boolField:
+		switch op.text {
+		case "=", "!=":
+		default:
+			return errorf(op.pos, "%s can not be used with %s", op.text, name)
+		}
+		switch strings.ToLower(value.text) {
+		case "true", "false":
+		default:
+			return errorf(value.pos, "%s must be true or false", name)
+		}
+	case dateField:
+		if op.text == "~" {
+			return errorf(op.pos, "~ can not be used with %s", name)
+		}
+		if _, _, ok := resolveDate(value.text, time.Now()); !ok {
+			return errorf(value.pos, "%q is not a valid date", value.text)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package filter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// format prints the parsed query with every binary and not node in parentheses
+func format(node Node) string {
+	switch n := node.(type) {
+	case *Binary:
+		return "(" + format(n.Left) + " " + n.Op + " " + format(n.Right) + ")"
+	case *Not:
+		return "(not " + format(n.Expr) + ")"
+	case *Comparison:
+		return n.Field + " " + n.Op + " " + n.Value
+	case *Predicate:
+		return n.Name
+	}
+
+	return "?"
+}
+
+func TestParsePrecedence(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"open", "open"},
+		{"open or blocked and overdue", "(open or (blocked and overdue))"},
+		{"open and blocked or overdue", "((open and blocked) or overdue)"},
+		{"open or blocked or overdue", "((open or blocked) or overdue)"},
+		{"not open and blocked", "((not open) and blocked)"},
+		{"not (open and blocked)", "(not (open and blocked))"},
+		{"not not open", "(not (not open))"},
+		{"(open or blocked) and overdue", "((open or blocked) and overdue)"},
+		{"((open))", "open"},
+		{`OPEN And Title = "Q4 Report"`, "(open and title = Q4 Report)"},
+		{"priority=high or priority=urgent", "(priority = high or priority = urgent)"},
+		{"due <= week_end and not completed", "(due <= week_end and (not completed))"},
+		{`title ~ "say \"hi\""`, `title ~ say "hi"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			node, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if got := format(node); got != tt.want {
+				t.Fatalf("Parse() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+		msg   string
+	}{
+		{"", 0, "the query is empty"},
+		{"   ", 0, "the query is empty"},
+		{"open and", 8, "unexpected end of the query"},
+		{"open blocked", 5, `unexpected "blocked"`},
+		{"open )", 5, `unexpected ")"`},
+		{"(open", 5, "expected ) to close the ( at position 0"},
+		{"open and (blocked or (overdue)", 30, "expected ) to close the ( at position 9"},
+		{"foo = x", 0, `unknown field "foo"`},
+		{"open and bar", 9, `unknown condition "bar"`},
+		{`title "x"`, 6, "expected an operator after title"},
+		{"title =", 7, "expected a value after ="},
+		{"title = (", 8, "expected a value after ="},
+		{"due ~ today", 4, "~ can not be used with due"},
+		{"tag < work", 4, "< can not be used with tag"},
+		{"completed = maybe", 12, "completed must be true or false"},
+		{"due < nextweek", 6, `"nextweek" is not a valid date`},
+		{`title = "abc`, 8, "unterminated string"},
+		{"open ! blocked", 5, "expected = after !"},
+		{"open $", 5, `unexpected character '$'`},
+		// positions count runes and not bytes
+		{`title ~ "héllo" $`, 16, `unexpected character '$'`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := Parse(tt.query)
+
+			var perr *Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("err = %v, want an *Error", err)
+			}
+			if perr.Pos != tt.pos || perr.Msg != tt.msg {
+				t.Fatalf("err = %d %q, want %d %q", perr.Pos, perr.Msg, tt.pos, tt.msg)
+			}
+		})
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	longest := "open" + strings.Repeat(" ", maxQueryLength-len("open"))
+	if _, err := Parse(longest); err != nil {
+		t.Fatalf("failed to parse a query of the maximum length: %v", err)
+	}
+
+	// the length is counted in runes
+	_, err := Parse(`title ~ "` + strings.Repeat("é", maxQueryLength-len(`title ~ ""`)) + `"`)
+	if err != nil {
+		t.Fatalf("failed to parse a query of the maximum length in runes: %v", err)
+	}
+
+	var perr *Error
+	_, err = Parse(longest + " ")
+	if !errors.As(err, &perr) || perr.Pos != maxQueryLength {
+		t.Fatalf("err = %v, want an error at position %d for a query that is too long", err, maxQueryLength)
+	}
+
+	deepest := strings.Repeat("(", maxDepth) + "open" + strings.Repeat(")", maxDepth)
+	if _, err := Parse(deepest); err != nil {
+		t.Fatalf("failed to parse a query of the maximum depth: %v", err)
+	}
+
+	tests := []struct {
+		query string
+		pos   int
+	}{
+		{strings.Repeat("(", maxDepth+1) + "open" + strings.Repeat(")", maxDepth+1), maxDepth},
+		{strings.Repeat("not ", maxDepth+1) + "open", 4 * maxDepth},
+		{strings.Repeat("not (", maxDepth/2+1) + "open" + strings.Repeat(")", maxDepth/2+1), 5 * (maxDepth / 2)},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+		if !errors.As(err, &perr) || perr.Pos != tt.pos || perr.Msg != "the query is nested too deeply" {
+			t.Fatalf("err = %v, want the query to be nested too deeply at position %d", err, tt.pos)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqliteTime is the format that SQLite's datetime function returns, columns are normalised with it
+// so that times stored with different offsets can be compared
+const sqliteTime = "2006-01-02 15:04:05"
+
+// columns maps the fields of the query language to the columns of the todos table
+var columns = map[string]string{
+	"title":       "todos.title",
+	"description": "todos.description",
+	"content":     "todos.content",
+	"state":       "todos.state",
+	"priority":    "todos.priority",
+	"project":     "todos.project",
+	"completed":   "todos.completed",
+	"due":         "todos.due_at",
+	"created":     "todos.created_at",
+	"updated":     "todos.updated_at",
+	"done":        "todos.completed_at",
+}
+
+// SQL translates the parsed query to a SQL condition on the todos table, values are never inlined in
+// the condition and are returned as the arguments. Relative dates are resolved against now in its location.
+func SQL(node Node, now time.Time) (string, []any) {
+	switch n := node.(type) {
+	case *Binary:
+		left, leftArgs := SQL(n.Left, now)
+		right, rightArgs := SQL(n.Right, now)
+		return fmt.Sprintf("(%s %s %s)", left, strings.ToUpper(n.Op), right), append(leftArgs, rightArgs...)
+	case *Not:
+		expr, args := SQL(n.Expr, now)
+		return fmt.Sprintf("(NOT %s)", expr), args
+	case *Predicate:
+		return predicate(n.Name, now)
+	case *Comparison:
+		return comparison(n, now)
+	}
+
+	return "1 = 1", nil
+}
+
+// predicate translates the bare word conditions
+func predicate(name string, now time.Time) (string, []any) {
+	switch name {
+	case "open":
+		return "todos.completed = ?", []any{false}
+	case "completed":
+		return "todos.completed = ?", []any{true}
+	case "blocked":
+		return "EXISTS (SELECT 1 FROM todo_dependencies " +
+			"JOIN todos blocker ON blocker.id = todo_dependencies.blocked_by_id AND blocker.deleted_at IS NULL " +
+			"WHERE todo_dependencies.todo_id = todos.id AND blocker.completed = ?)", []any{false}
+	case "blocking":
+		return "EXISTS (SELECT 1 FROM todo_dependencies " +
+			"JOIN todos blocked ON blocked.id = todo_dependencies.todo_id AND blocked.deleted_at IS NULL " +
+			"WHERE todo_dependencies.blocked_by_id = todos.id AND blocked.completed = ?)", []any{false}
+	case "overdue":
+		return "(todos.completed = ? AND todos.due_at IS NOT NULL AND datetime(todos.due_at) < ?)",
+			[]any{false, now.UTC().Format(sqliteTime)}
+	case "recurring":
+		return "todos.recurrence <> ''", nil
+	}
+
+	return "1 = 1", nil
+}
+
+// comparison translates a comparison between a field and a value
+func comparison(c *Comparison, now time.Time) (string, []any) {
+	switch fields[c.Field] {
+	case tagField:
+		condition := "todo_tags.name = ?"
+		value := strings.ToLower(c.Value)
+		if c.Op == "~" {
+			condition = `todo_tags.name LIKE ? ESCAPE '\'`
+			value = like(value)
+		}
+
+		sql := fmt.Sprintf("EXISTS (SELECT 1 FROM todo_tags WHERE todo_tags.todo_id = todos.id AND %s)", condition)
+		if c.Op == "!=" {
+			sql = "NOT " + sql
+		}
+		return sql, []any{value}
+	case boolField:
+		value := strings.ToLower(c.Value) == "true"
+		if c.Op == "!=" {
+			value = !value
+		}
+		return fmt.Sprintf("%s = ?", columns[c.Field]), []any{value}
+	case dateField:
+		start, end, _ := resolveDate(c.Value, now)
+		column := fmt.Sprintf("datetime(%s)", columns[c.Field])
+		s, e := start.UTC().Format(sqliteTime), end.UTC().Format(sqliteTime)
+
+		condition := ""
+		args := []any{}
+		switch c.Op {
+		case "=":
+			condition, args = fmt.Sprintf("%s >= ? AND %s < ?", column, column), []any{s, e}
+		case "!=":
+			condition, args = fmt.Sprintf("(%s < ? OR %s >= ?)", column, column), []any{s, e}
+		case "<":
+			condition, args = fmt.Sprintf("%s < ?", column), []any{s}
+		case "<=":
+			condition, args = fmt.Sprintf("%s < ?", column), []any{e}
+		case ">":
+			condition, args = fmt.Sprintf("%s >= ?", column), []any{e}
+		case ">=":
+			condition, args = fmt.Sprintf("%s >= ?", column), []any{s}
+		}
+
+		return fmt.Sprintf("(%s IS NOT NULL AND %s)", columns[c.Field], condition), args
+	default:
+		switch c.Op {
+		case "~":
+			return fmt.Sprintf(`%s LIKE ? ESCAPE '\'`, columns[c.Field]), []any{like(c.Value)}
+		case "!=":
+			return fmt.Sprintf("%s <> ?", columns[c.Field]), []any{c.Value}
+		default:
+			return fmt.Sprintf("%s = ?", columns[c.Field]), []any{c.Value}
+		}
+	}
+}
+
+// like escapes the value so that it is matched literally anywhere in a LIKE pattern
+func like(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(value) + "%"
+}
+
+// resolveDate resolves the value to a time range [start, end), days cover the whole day in the location
+// of now while instants like now and +4h have an empty range
+func resolveDate(value string, now time.Time) (start time.Time, end time.Time, ok bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	dayRange := func(day time.Time) (time.Time, time.Time, bool) {
+		return day, day.AddDate(0, 0, 1), true
+	}
+
+	switch strings.ToLower(value) {
+	case "now":
+		return now, now, true
+	case "today":
+		return dayRange(today)
+	case "tomorrow":
+		return dayRange(today.AddDate(0, 0, 1))
+	case "yesterday":
+		return dayRange(today.AddDate(0, 0, -1))
+	case "week_start":
+		// weeks start on monday
+		return dayRange(today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7)))
+	case "week_end":
+		return dayRange(today.AddDate(0, 0, 6-((int(today.Weekday())+6)%7)))
+	case "month_start":
+		return dayRange(time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location()))
+	case "month_end":
+		return dayRange(time.Date(today.Year(), today.Month()+1, 0, 0, 0, 0, 0, today.Location()))
+	}
+
+	if date, err := time.ParseInLocation(time.DateOnly, value, now.Location()); err == nil {
+		return dayRange(date)
+	}
+
+	// offsets like +3d, -2w, +1m and +4h
+	if len(value) >= 3 && (value[0] == '+' || value[0] == '-') {
+		amount, err := strconv.Atoi(value[1 : len(value)-1])
+		if err != nil || amount < 0 || amount > 10000 {
+			return start, end, false
+		}
+		if value[0] == '-' {
+			amount = -amount
+		}
+
+		switch value[len(value)-1] {
+		case 'h':
+			instant := now.Add(time.Duration(amount) * time.Hour)
+			return instant, instant, true
+		case 'd':
+			return dayRange(today.AddDate(0, 0, amount))
+		case 'w':
+			return dayRange(today.AddDate(0, 0, 7*amount))
+		case 'm':
+			return dayRange(today.AddDate(0, amount, 0))
+		case 'y':
+			return dayRange(today.AddDate(amount, 0, 0))
+		}
+	}
+
+	return start, end, false
+}
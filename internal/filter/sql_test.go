@@ -0,0 +1,279 @@
+package filter
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// now is a Wednesday afternoon in a time zone with a half hour offset
+var now = time.Date(2024, time.October, 2, 15, 30, 0, 0, time.FixedZone("IST", 5*60*60+30*60))
+
+// day returns the midnight of the given day in the location of now
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, now.Location())
+}
+
+func TestResolveDate(t *testing.T) {
+	tests := []struct {
+		value string
+		start time.Time
+		end   time.Time
+	}{
+		{"now", now, now},
+		{"today", day(2024, time.October, 2), day(2024, time.October, 3)},
+		{"Tomorrow", day(2024, time.October, 3), day(2024, time.October, 4)},
+		{"yesterday", day(2024, time.October, 1), day(2024, time.October, 2)},
+		{"week_start", day(2024, time.September, 30), day(2024, time.October, 1)},
+		{"week_end", day(2024, time.October, 6), day(2024, time.October, 7)},
+		{"month_start", day(2024, time.October, 1), day(2024, time.October, 2)},
+		{"month_end", day(2024, time.October, 31), day(2024, time.November, 1)},
+		{"2024-12-24", day(2024, time.December, 24), day(2024, time.December, 25)},
+		{"+3d", day(2024, time.October, 5), day(2024, time.October, 6)},
+		{"-2w", day(2024, time.September, 18), day(2024, time.September, 19)},
+		{"+1m", day(2024, time.November, 2), day(2024, time.November, 3)},
+		{"-1y", day(2023, time.October, 2), day(2023, time.October, 3)},
+		{"+4h", now.Add(4 * time.Hour), now.Add(4 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			start, end, ok := resolveDate(tt.value, now)
+			if !ok {
+				t.Fatalf("failed to resolve %q", tt.value)
+			}
+			if !start.Equal(tt.start) || !end.Equal(tt.end) {
+				t.Fatalf("resolveDate() = [%v, %v), want [%v, %v)", start, end, tt.start, tt.end)
+			}
+		})
+	}
+
+	// weeks start on monday, so on a sunday the week is nearly over
+	sunday := time.Date(2024, time.October, 6, 12, 0, 0, 0, now.Location())
+	start, _, _ := resolveDate("week_start", sunday)
+	end, _, _ := resolveDate("week_end", sunday)
+	if !start.Equal(day(2024, time.September, 30)) || !end.Equal(day(2024, time.October, 6)) {
+		t.Fatalf("the week of a sunday is %v to %v, want the monday before to the sunday itself", start, end)
+	}
+
+	for _, value := range []string{"", "next", "3d", "+d", "+3x", "+-3d", "+10001d", "2024-13-01", "2024-10-5"} {
+		if _, _, ok := resolveDate(value, now); ok {
+			t.Errorf("resolveDate(%q) is valid, want it to be rejected", value)
+		}
+	}
+}
+
+func TestSQLDates(t *testing.T) {
+	tests := []struct {
+		query string
+		sql   string
+		args  []any
+	}{
+		{
+			"due = today",
+			"(todos.due_at IS NOT NULL AND datetime(todos.due_at) >= ? AND datetime(todos.due_at) < ?)",
+			[]any{"2024-10-01 18:30:00", "2024-10-02 18:30:00"},
+		},
+		{
+			"due <= week_end",
+			"(todos.due_at IS NOT NULL AND datetime(todos.due_at) < ?)",
+			[]any{"2024-10-06 18:30:00"},
+		},
+		{
+			"created > -1d",
+			"(todos.created_at IS NOT NULL AND datetime(todos.created_at) >= ?)",
+			[]any{"2024-10-01 18:30:00"},
+		},
+		{
+			"done != 2024-10-05",
+			"(todos.completed_at IS NOT NULL AND (datetime(todos.completed_at) < ? OR datetime(todos.completed_at) >= ?))",
+			[]any{"2024-10-04 18:30:00", "2024-10-05 18:30:00"},
+		},
+		{
+			"updated >= +4h",
+			"(todos.updated_at IS NOT NULL AND datetime(todos.updated_at) >= ?)",
+			[]any{"2024-10-02 14:00:00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			node, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			sql, args := SQL(node, now)
+			if sql != tt.sql || !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("SQL() = %s %v, want %s %v", sql, args, tt.sql, tt.args)
+			}
+		})
+	}
+}
+
+func TestLike(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"report", `%report%`},
+		{"50%", `%50\%%`},
+		{"a_b", `%a\_b%`},
+		{`c:\dir`, `%c:\\dir%`},
+		{`\%_`, `%\\\%\_%`},
+	}
+
+	for _, tt := range tests {
+		if got := like(tt.value); got != tt.want {
+			t.Errorf("like(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSQLOnlyBindsValues(t *testing.T) {
+	values := []string{
+		`x' OR 1=1 --`,
+		`"; DROP TABLE todos; --`,
+		`) OR (1 = 1`,
+		`%' ESCAPE '`,
+		`?`,
+	}
+
+	for _, value := range values {
+		quoted := `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+		query := "title = " + quoted + " or title ~ " + quoted + " or tag != " + quoted +
+			" or tag ~ " + quoted + " or project != " + quoted + " or open or recurring or overdue"
+
+		node, err := Parse(query)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", query, err)
+		}
+
+		sql, args := SQL(node, now)
+		if strings.Count(sql, "?") != len(args) {
+			t.Fatalf("%d placeholders for %d arguments in %s", strings.Count(sql, "?"), len(args), sql)
+		}
+
+		// the only quotes in the condition are the escape character of LIKE and the empty recurrence
+		rest := strings.NewReplacer(`ESCAPE '\'`, "", "<> ''", "").Replace(sql)
+		if strings.ContainsAny(rest, `'";`) || strings.Contains(rest, "--") {
+			t.Fatalf("the value %q reached the condition: %s", value, sql)
+		}
+
+		found := false
+		for _, arg := range args {
+			if arg == value {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("the value %q is not one of the arguments %v", value, args)
+		}
+	}
+}
+
+// newTestDB opens an in-memory database with the tables of the app
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		TranslateError: true,
+		Logger:         logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("failed to open the database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get the database: %v", err)
+	}
+	// every connection to file::memory: opens a new database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	for _, table := range database.Tables {
+		if err := db.AutoMigrate(table.Schema); err != nil {
+			t.Fatalf("failed to migrate the database: %v", err)
+		}
+	}
+
+	return db
+}
+
+func TestSQLMatches(t *testing.T) {
+	db := newTestDB(t)
+
+	// due at 09:00 and 20:00 UTC, which is today and tomorrow in the time zone of now
+	today := time.Date(2024, time.October, 2, 14, 30, 0, 0, now.Location())
+	tomorrow := time.Date(2024, time.October, 2, 20, 0, 0, 0, time.UTC)
+	todos := []struct {
+		title string
+		due   *time.Time
+		tags  []string
+	}{
+		{"50% off", &today, []string{"sale"}},
+		{"500 off", &tomorrow, []string{"sale_2024"}},
+		{"a_b", nil, []string{"sale-2024"}},
+		{"axb", nil, nil},
+		{`c:\dir`, nil, nil},
+		{"x' OR 1=1 --", nil, nil},
+	}
+	for _, todo := range todos {
+		row := &database.Todo{Title: todo.title, DueAt: todo.due, UserID: 1}
+		if err := db.Create(row).Error; err != nil {
+			t.Fatalf("failed to create the todo: %v", err)
+		}
+		for _, tag := range todo.tags {
+			if err := db.Create(&database.TodoTag{TodoID: row.ID, Name: tag}).Error; err != nil {
+				t.Fatalf("failed to create the tag: %v", err)
+			}
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`title ~ "%"`, []string{"50% off"}},
+		{`title ~ "_"`, []string{"a_b"}},
+		{`title ~ "\\"`, []string{`c:\dir`}},
+		{`title = "x' OR 1=1 --"`, []string{"x' OR 1=1 --"}},
+		{`title ~ "' or 1=1"`, []string{"x' OR 1=1 --"}},
+		{`tag = sale`, []string{"50% off"}},
+		{`tag ~ "_"`, []string{"500 off"}},
+		{`tag ~ sale and not tag ~ "_"`, []string{"50% off", "a_b"}},
+		{`due = today`, []string{"50% off"}},
+		{`due = tomorrow`, []string{"500 off"}},
+		{`due <= week_end and title ~ off`, []string{"50% off", "500 off"}},
+		{`overdue`, []string{"50% off"}},
+		{`(title ~ a or title ~ x) and not title ~ "'"`, []string{"a_b", "axb"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			node, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			condition, args := SQL(node, now)
+			titles := []string{}
+			err = db.Model(&database.Todo{}).Where(condition, args...).Pluck("title", &titles).Error
+			if err != nil {
+				t.Fatalf("failed to run %s: %v", condition, err)
+			}
+
+			sort.Strings(titles)
+			if !reflect.DeepEqual(titles, tt.want) {
+				t.Fatalf("matched %q, want %q", titles, tt.want)
+			}
+		})
+	}
+}
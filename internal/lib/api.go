@@ -73,3 +73,16 @@ func WrapMiddlewareWAuth(
 		return m(h, acm, e, db, rdb)
 	}
 }
+
+// WrapHandlerWNotificationClient wraps the handler function with the environment, database, Redis client, and notification service client.
+func WrapHandlerWNotificationClient(
+	h func(http.ResponseWriter, *http.Request, *grpc.NotificationClientManager, *env.Env, *gorm.DB, *redis.Client),
+	ncm *grpc.NotificationClientManager,
+	e *env.Env,
+	db *gorm.DB,
+	rdb *redis.Client,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, ncm, e, db, rdb)
+	}
+}
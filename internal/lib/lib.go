@@ -1,2 +1,6 @@
 // Package lib contains various utility functions for the application.
 package lib
+
+// PersonalAccessTokenPrefix tells personal access tokens apart from the access tokens in the Authorization header,
+// it also makes them easy to find for secret scanners
+const PersonalAccessTokenPrefix = "tdp_"
@@ -0,0 +1,13 @@
+package lib
+
+// WorkspaceMetadataKey is the gRPC metadata key that the gateway uses to pass the active workspace to the todo service
+const WorkspaceMetadataKey = "x-workspace-id"
+
+// ClientIPMetadataKey is the gRPC metadata key that the gateway uses to pass the IP address of the client
+const ClientIPMetadataKey = "x-client-ip"
+
+// UserAgentMetadataKey is the gRPC metadata key that the gateway uses to pass the user agent of the client
+const UserAgentMetadataKey = "x-client-user-agent"
+
+// ActorMetadataKey is the gRPC metadata key that the gateway uses to pass the user who is calling the admin service
+const ActorMetadataKey = "x-actor-id"
@@ -0,0 +1,98 @@
+// Package mailer sends plain text emails, the mailer is chosen from the configuration so that the
+// services do not have to care whether the emails go to a SMTP server or only to the logs
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/rs/zerolog/log"
+)
+
+// Mailer sends a plain text email to a single recipient
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// New returns the SMTP mailer when a SMTP server is configured and the log mailer otherwise
+func New(e *env.Env) Mailer {
+	if e.SMTPHost == "" {
+		return &Log{}
+	}
+
+	return &SMTP{
+		Host:     e.SMTPHost,
+		Port:     e.SMTPPort,
+		Username: e.SMTPUsername,
+		Password: e.SMTPPassword,
+		From:     e.SMTPFrom,
+	}
+}
+
+// SMTP sends the emails over SMTP, authentication is skipped when no username is given so that it can be
+// used with local SMTP servers like MailHog
+type SMTP struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send sends the email
+func (m *SMTP) Send(ctx context.Context, to, subject, body string) error {
+	if to == "" {
+		return fmt.Errorf("the recipient does not have an email address")
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, m.From, []string{to}, m.compose(to, subject, body))
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+// compose builds the raw email
+func (m *SMTP) compose(to, subject, body string) []byte {
+	// header values must not contain line breaks or they could be used to inject headers
+	clean := strings.NewReplacer("\r", "", "\n", " ")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", clean.Replace(to))
+	fmt.Fprintf(&b, "Subject: %s\r\n", clean.Replace(subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	return []byte(b.String())
+}
+
+// Log writes the emails to the log instead of sending them, it is meant for development where the
+// links in the emails can be copied from the output
+type Log struct{}
+
+// Send logs the email
+func (m *Log) Send(ctx context.Context, to, subject, body string) error {
+	log.Info().Str("to", to).Str("subject", subject).Msg(body)
+	return nil
+}
@@ -0,0 +1,28 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/internal/mailer"
+)
+
+// EmailNotifier delivers messages as plain text emails through the mailer
+type EmailNotifier struct {
+	Mailer mailer.Mailer
+}
+
+// Channel returns the channel of the notifier
+func (n *EmailNotifier) Channel() enums.Channel {
+	return enums.Email
+}
+
+// Send sends the message to the email address of the recipient
+func (n *EmailNotifier) Send(ctx context.Context, to *Recipient, msg *Message) error {
+	if to.Email == "" {
+		return fmt.Errorf("the recipient does not have an email address")
+	}
+
+	return n.Mailer.Send(ctx, to.Email, msg.Subject, msg.Body)
+}
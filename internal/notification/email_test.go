@@ -0,0 +1,139 @@
+package notification
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/mailer"
+)
+
+// smtpMail is an email that was received by the SMTP stand-in
+type smtpMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpServer starts a SMTP stand-in that speaks just enough of the protocol for net/smtp, the emails that it
+// receives are sent to the returned channel
+func smtpServer(t *testing.T) (string, <-chan smtpMail) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	mails := make(chan smtpMail, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+
+		mail := smtpMail{}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(line)
+
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				mail.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				reply("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				mail.to = append(mail.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 end data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				mail.data = data.String()
+				reply("250 OK")
+				mails <- mail
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return ln.Addr().String(), mails
+}
+
+func TestEmailNotifierSendsOverSMTP(t *testing.T) {
+	addr, mails := smtpServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+
+	n := &EmailNotifier{
+		Mailer: &mailer.SMTP{
+			Host: host,
+			Port: port,
+			From: "todoapp@example.com",
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := n.Send(ctx, &Recipient{Email: "jane@example.com"}, &Message{
+		Subject: "Due soon\r\nBcc: someone@example.com",
+		Body:    "Buy milk is due in an hour",
+	})
+	if err != nil {
+		t.Fatalf("failed to send the email: %v", err)
+	}
+
+	select {
+	case mail := <-mails:
+		if mail.from != "todoapp@example.com" {
+			t.Errorf("from = %q, want %q", mail.from, "todoapp@example.com")
+		}
+		if len(mail.to) != 1 || mail.to[0] != "jane@example.com" {
+			t.Errorf("to = %v, want [jane@example.com]", mail.to)
+		}
+		if !strings.Contains(mail.data, "Subject: Due soon Bcc: someone@example.com\r\n") {
+			t.Errorf("the subject was not sanitized:\n%s", mail.data)
+		}
+		if !strings.Contains(mail.data, "\r\n\r\nBuy milk is due in an hour\r\n") {
+			t.Errorf("the body is missing:\n%s", mail.data)
+		}
+	case <-ctx.Done():
+		t.Fatal("the SMTP server did not receive the email")
+	}
+}
+
+func TestEmailNotifierRequiresAnAddress(t *testing.T) {
+	n := &EmailNotifier{Mailer: &mailer.SMTP{Host: "127.0.0.1", Port: "1"}}
+
+	err := n.Send(context.Background(), &Recipient{}, &Message{Subject: "s", Body: "b"})
+	if err == nil {
+		t.Fatal("expected an error for a recipient without an email address")
+	}
+}
@@ -0,0 +1,315 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	rdb "github.com/VinukaThejana/todoapp/internal/redis"
+	pb "github.com/VinukaThejana/todoapp/pkg/notification"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxIDs          = 100
+)
+
+// InboxNotifier delivers messages to the in-app inbox and publishes them to the notification channel
+// of the user so that connected clients get them right away
+type InboxNotifier struct {
+	DB *gorm.DB
+	R  *redis.Client
+}
+
+// Channel returns the channel of the notifier
+func (n *InboxNotifier) Channel() enums.Channel {
+	return enums.Inbox
+}
+
+// Send stores the message in the inbox of the recipient
+func (n *InboxNotifier) Send(ctx context.Context, to *Recipient, msg *Message) error {
+	notification := &database.Notification{
+		UserID: to.UserID,
+		Kind:   string(msg.Kind),
+		Title:  msg.Subject,
+		Body:   msg.Body,
+	}
+
+	err := n.DB.WithContext(ctx).Create(notification).Error
+	if err != nil {
+		return err
+	}
+
+	// the notification is already stored, clients that miss the message will see it when they load the inbox
+	payload, err := json.Marshal(notificationToPB(notification))
+	if err == nil {
+		err = n.R.Publish(ctx, rdb.NotificationChannel(to.UserID), payload).Err()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to publish the notification")
+	}
+
+	return nil
+}
+
+func notificationToPB(n *database.Notification) *pb.Notification {
+	return &pb.Notification{
+		Id:        fmt.Sprint(n.ID),
+		Kind:      n.Kind,
+		Title:     n.Title,
+		Body:      n.Body,
+		Read:      n.ReadAt != nil,
+		Archived:  n.ArchivedAt != nil,
+		CreatedAt: n.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// unreadCount returns the number of unread notifications in the inbox of the user, archived notifications are not counted
+func (s *Server) unreadCount(userID uint) (int64, error) {
+	var count int64
+
+	err := s.DB.Model(&database.Notification{}).
+		Where("user_id = ? AND read_at IS NULL AND archived_at IS NULL", userID).
+		Count(&count).Error
+
+	return count, err
+}
+
+// parseIDs parses the notification ids that are sent by the client
+func parseIDs(ids []string) ([]uint64, error) {
+	if len(ids) == 0 || len(ids) > maxIDs {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("between 1 and %d ids must be given", maxIDs))
+	}
+
+	parsed := make([]uint64, 0, len(ids))
+	for _, id := range ids {
+		n, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid id %q", id))
+		}
+		parsed = append(parsed, n)
+	}
+
+	return parsed, nil
+}
+
+// ListInbox is a gRPC endpoint to get a page of the inbox of the user, newest first. The page token is
+// the id of the last notification of the previous page.
+// returns Internal, InvalidArgument, nil
+func (s *Server) ListInbox(ctx context.Context, req *pb.ListInboxRequest) (*pb.ListInboxResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.ListInboxResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	tx := s.DB.Where("user_id = ?", userID)
+	if req.PageToken != "" {
+		after, err := strconv.ParseUint(req.PageToken, 10, 64)
+		if err != nil {
+			return &pb.ListInboxResponse{
+				Success: false,
+				Message: "Invalid page token",
+			}, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		tx = tx.Where("id < ?", after)
+	}
+	if req.Archived {
+		tx = tx.Where("archived_at IS NOT NULL")
+	} else {
+		tx = tx.Where("archived_at IS NULL")
+	}
+	if req.UnreadOnly {
+		tx = tx.Where("read_at IS NULL")
+	}
+
+	notifications := []*database.Notification{}
+
+	// one more than the page size is loaded to know if there is a next page
+	err = tx.Order("id DESC").Limit(pageSize + 1).Find(&notifications).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the notifications")
+		return &pb.ListInboxResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the notifications")
+	}
+
+	unread, err := s.unreadCount(uint(userID))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the unread notifications")
+		return &pb.ListInboxResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to count the unread notifications")
+	}
+
+	res := &pb.ListInboxResponse{
+		Success:       true,
+		Notifications: []*pb.Notification{},
+		UnreadCount:   unread,
+	}
+	if len(notifications) > pageSize {
+		notifications = notifications[:pageSize]
+		res.NextPageToken = fmt.Sprint(notifications[pageSize-1].ID)
+	}
+	for _, n := range notifications {
+		res.Notifications = append(res.Notifications, notificationToPB(n))
+	}
+
+	return res, nil
+}
+
+// UnreadCount is a gRPC endpoint to get the number of unread notifications of the user
+// returns Internal, nil
+func (s *Server) UnreadCount(ctx context.Context, req *pb.UnreadCountRequest) (*pb.UnreadCountResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.UnreadCountResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	unread, err := s.unreadCount(uint(userID))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the unread notifications")
+		return &pb.UnreadCountResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to count the unread notifications")
+	}
+
+	return &pb.UnreadCountResponse{
+		Success:     true,
+		UnreadCount: unread,
+	}, nil
+}
+
+// markRead marks the unread notifications of the user that match the condition as read
+func (s *Server) markRead(userID uint, ids []uint64) (*pb.MarkReadResponse, error) {
+	tx := s.DB.Model(&database.Notification{}).Where("user_id = ? AND read_at IS NULL", userID)
+	if ids != nil {
+		tx = tx.Where("id IN ?", ids)
+	}
+
+	result := tx.Update("read_at", time.Now())
+	if result.Error != nil {
+		log.Error().Err(result.Error).Msg("failed to mark the notifications as read")
+		return &pb.MarkReadResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to mark the notifications as read")
+	}
+
+	unread, err := s.unreadCount(userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the unread notifications")
+		return &pb.MarkReadResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to count the unread notifications")
+	}
+
+	return &pb.MarkReadResponse{
+		Success:     true,
+		Updated:     result.RowsAffected,
+		UnreadCount: unread,
+	}, nil
+}
+
+// MarkRead is a gRPC endpoint to mark notifications of the user as read, notifications that are
+// already read or that belong to someone else are skipped
+// returns Internal, InvalidArgument, nil
+func (s *Server) MarkRead(ctx context.Context, req *pb.MarkReadRequest) (*pb.MarkReadResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.MarkReadResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	ids, err := parseIDs(req.Ids)
+	if err != nil {
+		return &pb.MarkReadResponse{
+			Success: false,
+			Message: "Invalid ids",
+		}, err
+	}
+
+	return s.markRead(uint(userID), ids)
+}
+
+// MarkAllRead is a gRPC endpoint to mark every notification of the user as read
+// returns Internal, nil
+func (s *Server) MarkAllRead(ctx context.Context, req *pb.MarkAllReadRequest) (*pb.MarkReadResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.MarkReadResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	return s.markRead(uint(userID), nil)
+}
+
+// Archive is a gRPC endpoint to move notifications of the user out of the inbox
+// returns Internal, InvalidArgument, nil
+func (s *Server) Archive(ctx context.Context, req *pb.ArchiveRequest) (*pb.ArchiveResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.ArchiveResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	ids, err := parseIDs(req.Ids)
+	if err != nil {
+		return &pb.ArchiveResponse{
+			Success: false,
+			Message: "Invalid ids",
+		}, err
+	}
+
+	result := s.DB.Model(&database.Notification{}).
+		Where("user_id = ? AND archived_at IS NULL AND id IN ?", userID, ids).
+		Update("archived_at", time.Now())
+	if result.Error != nil {
+		log.Error().Err(result.Error).Msg("failed to archive the notifications")
+		return &pb.ArchiveResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to archive the notifications")
+	}
+
+	unread, err := s.unreadCount(uint(userID))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the unread notifications")
+		return &pb.ArchiveResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to count the unread notifications")
+	}
+
+	return &pb.ArchiveResponse{
+		Success:     true,
+		Updated:     result.RowsAffected,
+		UnreadCount: unread,
+	}, nil
+}
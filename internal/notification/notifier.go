@@ -0,0 +1,31 @@
+// Package notification implements the notification service, notifications are rendered from templates and
+// delivered through the channels that the user has enabled
+package notification
+
+import (
+	"context"
+
+	"github.com/VinukaThejana/todoapp/internal/enums"
+)
+
+// Recipient is the user that a notification is delivered to
+type Recipient struct {
+	UserID        uint
+	Name          string
+	Email         string
+	WebhookURL    string
+	WebhookSecret string
+}
+
+// Message is a rendered notification
+type Message struct {
+	Kind    enums.NotificationKind
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages through a single channel
+type Notifier interface {
+	Channel() enums.Channel
+	Send(ctx context.Context, to *Recipient, msg *Message) error
+}
@@ -0,0 +1,246 @@
+package notification
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	pb "github.com/VinukaThejana/todoapp/pkg/notification"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// defaultPreferences returns the preferences of users that have not set any
+func defaultPreferences(userID uint) *database.NotificationPreference {
+	return &database.NotificationPreference{
+		UserID: userID,
+		Email:  true,
+		Inbox:  true,
+	}
+}
+
+// loadPreferences loads the preferences of the user or the default preferences
+func (s *Server) loadPreferences(userID uint) (*database.NotificationPreference, error) {
+	pref := &database.NotificationPreference{}
+
+	err := s.DB.Where("user_id = ?", userID).First(pref).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return defaultPreferences(userID), nil
+		}
+		return nil, err
+	}
+
+	return pref, nil
+}
+
+// enabled checks if the channel is enabled in the preferences
+func enabled(pref *database.NotificationPreference, channel enums.Channel) bool {
+	switch channel {
+	case enums.Email:
+		return pref.Email
+	case enums.Webhook:
+		return pref.Webhook && pref.WebhookURL != ""
+	case enums.Inbox:
+		return pref.Inbox
+	}
+
+	return false
+}
+
+// parseClock parses a HH:MM time to the minutes since midnight
+func parseClock(clock string) (int, bool) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, false
+	}
+
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// quietUntil returns the end of the quiet hours when now falls into them, the quiet hours can span midnight
+func quietUntil(pref *database.NotificationPreference, now time.Time) (time.Time, bool) {
+	start, ok := parseClock(pref.QuietStart)
+	if !ok {
+		return now, false
+	}
+	end, ok := parseClock(pref.QuietEnd)
+	if !ok || start == end {
+		return now, false
+	}
+
+	loc := time.UTC
+	if pref.TimeZone != "" {
+		if l, err := time.LoadLocation(pref.TimeZone); err == nil {
+			loc = l
+		}
+	}
+
+	local := now.In(loc)
+	minutes := local.Hour()*60 + local.Minute()
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	endToday := midnight.Add(time.Duration(end) * time.Minute)
+
+	if start < end {
+		if minutes >= start && minutes < end {
+			return endToday, true
+		}
+		return now, false
+	}
+
+	if minutes >= start {
+		return midnight.AddDate(0, 0, 1).Add(time.Duration(end) * time.Minute), true
+	}
+	if minutes < end {
+		return endToday, true
+	}
+
+	return now, false
+}
+
+func preferencesToPB(pref *database.NotificationPreference) *pb.Preferences {
+	return &pb.Preferences{
+		Email:         pref.Email,
+		Webhook:       pref.Webhook,
+		Inbox:         pref.Inbox,
+		WebhookUrl:    pref.WebhookURL,
+		WebhookSecret: pref.WebhookSecret,
+		QuietStart:    pref.QuietStart,
+		QuietEnd:      pref.QuietEnd,
+		TimeZone:      pref.TimeZone,
+	}
+}
+
+// GetPreferences is a gRPC endpoint to get the notification preferences of the user
+// returns Internal, nil
+func (s *Server) GetPreferences(ctx context.Context, req *pb.GetPreferencesRequest) (*pb.GetPreferencesResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.GetPreferencesResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	pref, err := s.loadPreferences(uint(userID))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the preferences")
+		return &pb.GetPreferencesResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the preferences")
+	}
+
+	return &pb.GetPreferencesResponse{
+		Success:     true,
+		Preferences: preferencesToPB(pref),
+	}, nil
+}
+
+// SetPreferences is a gRPC endpoint to set the notification preferences of the user, the webhook secret
+// is generated by the server and can not be set
+// returns Internal, InvalidArgument, nil
+func (s *Server) SetPreferences(ctx context.Context, req *pb.SetPreferencesRequest) (*pb.SetPreferencesResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.SetPreferencesResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	in := req.Preferences
+	if in == nil {
+		in = &pb.Preferences{}
+	}
+
+	invalid := func(msg string) (*pb.SetPreferencesResponse, error) {
+		return &pb.SetPreferencesResponse{
+			Success: false,
+			Message: "Invalid preferences",
+		}, status.Error(codes.InvalidArgument, msg)
+	}
+
+	if (in.QuietStart == "") != (in.QuietEnd == "") {
+		return invalid("both the start and the end of the quiet hours must be given")
+	}
+	if in.QuietStart != "" {
+		if _, ok := parseClock(in.QuietStart); !ok {
+			return invalid("the start of the quiet hours must be in the HH:MM format")
+		}
+		if _, ok := parseClock(in.QuietEnd); !ok {
+			return invalid("the end of the quiet hours must be in the HH:MM format")
+		}
+	}
+	if in.TimeZone != "" {
+		if _, err := time.LoadLocation(in.TimeZone); err != nil {
+			return invalid("invalid time zone")
+		}
+	}
+	if in.Webhook && in.WebhookUrl == "" {
+		return invalid("a webhook url is required to enable webhooks")
+	}
+	if in.WebhookUrl != "" {
+		u, err := url.Parse(in.WebhookUrl)
+		if err != nil || u.Host == "" || len(in.WebhookUrl) > 255 {
+			return invalid("invalid webhook url")
+		}
+		// plain http is only allowed while developing against local receivers
+		if u.Scheme != "https" && !(u.Scheme == "http" && s.E.Environ == string(enums.Dev)) {
+			return invalid("the webhook url must use https")
+		}
+		// the addresses that host names resolve to are checked when the webhook is sent
+		addr, err := netip.ParseAddr(u.Hostname())
+		if err == nil && !PublicAddr(addr) && s.E.Environ != string(enums.Dev) {
+			return invalid("the webhook url must point to a public address")
+		}
+	}
+
+	pref, err := s.loadPreferences(uint(userID))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the preferences")
+		return &pb.SetPreferencesResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the preferences")
+	}
+
+	pref.Email = in.Email
+	pref.Webhook = in.Webhook
+	pref.Inbox = in.Inbox
+	pref.WebhookURL = in.WebhookUrl
+	pref.QuietStart = in.QuietStart
+	pref.QuietEnd = in.QuietEnd
+	pref.TimeZone = in.TimeZone
+
+	if pref.WebhookURL != "" && pref.WebhookSecret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Error().Err(err).Msg("failed to generate the webhook secret")
+			return &pb.SetPreferencesResponse{
+				Success: false,
+			}, status.Error(codes.Internal, "failed to generate the webhook secret")
+		}
+		pref.WebhookSecret = hex.EncodeToString(secret)
+	}
+
+	err = s.DB.Save(pref).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to save the preferences")
+		return &pb.SetPreferencesResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to save the preferences")
+	}
+
+	return &pb.SetPreferencesResponse{
+		Success:     true,
+		Preferences: preferencesToPB(pref),
+	}, nil
+}
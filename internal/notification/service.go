@@ -0,0 +1,405 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/internal/mailer"
+	pb "github.com/VinukaThejana/todoapp/pkg/notification"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	maxAttempts     = 5
+	dispatchBatch   = 100
+	sendTimeout     = 15 * time.Second
+	maxDeliveries   = 100
+	defaultInterval = 30 * time.Second
+	// sqliteTime is the format that SQLite's datetime function returns, it is used to compare times
+	// that were stored with different offsets
+	sqliteTime = "2006-01-02 15:04:05"
+)
+
+var (
+	// ErrUserNotFound is returned when the user to notify does not exist
+	ErrUserNotFound = errors.New("user not found")
+	// ErrInvalidMessage is returned when the notification can not be rendered from the given data
+	ErrInvalidMessage = errors.New("invalid message")
+)
+
+// Server is used to implement notification.NotificationServiceServer
+type Server struct {
+	pb.UnimplementedNotificationServiceServer
+	E         *env.Env
+	DB        *gorm.DB
+	R         *redis.Client
+	Notifiers map[enums.Channel]Notifier
+}
+
+// New creates the notification server with the notifiers that are configured, email is only
+// available when a SMTP server is configured
+func New(e *env.Env, db *gorm.DB, r *redis.Client) *Server {
+	notifiers := []Notifier{
+		&InboxNotifier{DB: db, R: r},
+		&WebhookNotifier{AllowPrivate: e.Environ == string(enums.Dev)},
+	}
+	if e.SMTPHost != "" {
+		notifiers = append(notifiers, &EmailNotifier{
+			Mailer: mailer.New(e),
+		})
+	}
+
+	s := &Server{
+		E:         e,
+		DB:        db,
+		R:         r,
+		Notifiers: map[enums.Channel]Notifier{},
+	}
+	for _, n := range notifiers {
+		s.Notifiers[n.Channel()] = n
+	}
+
+	return s
+}
+
+func recipient(user *database.User, pref *database.NotificationPreference) *Recipient {
+	return &Recipient{
+		UserID:        user.ID,
+		Name:          user.Name,
+		Email:         user.Email,
+		WebhookURL:    pref.WebhookURL,
+		WebhookSecret: pref.WebhookSecret,
+	}
+}
+
+// Notify renders the notification and queues a delivery for every channel the user has enabled, deliveries
+// that are not held back by the quiet hours are sent right away. A non empty key makes the call idempotent,
+// the deliveries of an earlier call with the same key are returned instead.
+func (s *Server) Notify(ctx context.Context, userID uint, kind enums.NotificationKind, data map[string]string, key string) ([]*database.Delivery, error) {
+	deliveries := []*database.Delivery{}
+
+	if key != "" {
+		err := s.DB.Where("user_id = ? AND key = ?", userID, key).Find(&deliveries).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(deliveries) > 0 {
+			return deliveries, nil
+		}
+	}
+
+	user := &database.User{}
+	err := s.DB.First(user, userID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	values := map[string]string{
+		"name": user.Name,
+	}
+	for k, v := range data {
+		values[k] = v
+	}
+
+	msg, err := render(kind, values)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+
+	pref, err := s.loadPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, channel := range []enums.Channel{enums.Inbox, enums.Email, enums.Webhook} {
+		if _, ok := s.Notifiers[channel]; !ok || !enabled(pref, channel) {
+			continue
+		}
+
+		sendAfter := now
+		// the inbox is silent so it is never held back
+		if until, quiet := quietUntil(pref, now); quiet && channel != enums.Inbox {
+			sendAfter = until
+		}
+
+		deliveries = append(deliveries, &database.Delivery{
+			UserID:    userID,
+			Channel:   string(channel),
+			Kind:      string(kind),
+			Key:       key,
+			Subject:   msg.Subject,
+			Body:      msg.Body,
+			Status:    string(enums.Pending),
+			SendAfter: sendAfter,
+		})
+	}
+	if len(deliveries) == 0 {
+		return deliveries, nil
+	}
+
+	err = s.DB.Create(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	to := recipient(user, pref)
+	for _, delivery := range deliveries {
+		if !delivery.SendAfter.After(now) {
+			s.deliver(ctx, delivery, to)
+		}
+	}
+
+	return deliveries, nil
+}
+
+// deliver sends a pending delivery and records the outcome, failed deliveries are retried with an
+// exponential backoff until they run out of attempts
+func (s *Server) deliver(ctx context.Context, delivery *database.Delivery, to *Recipient) {
+	notifier, ok := s.Notifiers[enums.Channel(delivery.Channel)]
+
+	err := fmt.Errorf("the %s channel is not available", delivery.Channel)
+	if ok {
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err = notifier.Send(sendCtx, to, &Message{
+			Kind:    enums.NotificationKind(delivery.Kind),
+			Subject: delivery.Subject,
+			Body:    delivery.Body,
+		})
+		cancel()
+	}
+
+	delivery.Attempts++
+	if err == nil {
+		now := time.Now()
+		delivery.Status = string(enums.Sent)
+		delivery.SentAt = &now
+		delivery.LastError = ""
+	} else {
+		log.Error().Err(err).Msg(fmt.Sprintf("failed to deliver the notification through %s", delivery.Channel))
+
+		delivery.LastError = err.Error()
+		if len(delivery.LastError) > 255 {
+			delivery.LastError = delivery.LastError[:255]
+		}
+		if delivery.Attempts >= maxAttempts {
+			delivery.Status = string(enums.Failed)
+		} else {
+			delivery.SendAfter = time.Now().Add(time.Minute << delivery.Attempts)
+		}
+	}
+
+	err = s.DB.Save(delivery).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to save the delivery")
+	}
+}
+
+// Dispatch sends the pending deliveries that are due
+func (s *Server) Dispatch(ctx context.Context) {
+	deliveries := []*database.Delivery{}
+
+	err := s.DB.
+		Where("status = ? AND datetime(send_after) <= ?", enums.Pending, time.Now().UTC().Format(sqliteTime)).
+		Order("send_after").
+		Limit(dispatchBatch).
+		Find(&deliveries).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the pending deliveries")
+		return
+	}
+
+	recipients := map[uint]*Recipient{}
+	for _, delivery := range deliveries {
+		to, ok := recipients[delivery.UserID]
+		if !ok {
+			user := &database.User{}
+			if err := s.DB.First(user, delivery.UserID).Error; err != nil {
+				log.Error().Err(err).Msg("failed to get the user")
+				continue
+			}
+			pref, err := s.loadPreferences(delivery.UserID)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to get the preferences")
+				continue
+			}
+
+			to = recipient(user, pref)
+			recipients[delivery.UserID] = to
+		}
+
+		s.deliver(ctx, delivery, to)
+	}
+}
+
+// remindOverdue notifies the users about the todos that became overdue since the given time
+func (s *Server) remindOverdue(ctx context.Context, since time.Time, now time.Time) {
+	todos := []*database.Todo{}
+
+	err := s.DB.
+		Where(
+			"completed = ? AND due_at IS NOT NULL AND datetime(due_at) > ? AND datetime(due_at) <= ?",
+			false, since.UTC().Format(sqliteTime), now.UTC().Format(sqliteTime),
+		).
+		Find(&todos).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the overdue todos")
+		return
+	}
+
+	for _, todo := range todos {
+		_, err := s.Notify(ctx, todo.UserID, enums.Overdue, map[string]string{
+			"title":  todo.Title,
+			"due_at": todo.DueAt.Format(time.RFC1123),
+		}, fmt.Sprintf("overdue:%d:%d", todo.ID, todo.DueAt.Unix()))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to notify about the overdue todo")
+		}
+	}
+}
+
+// Run looks for overdue todos and dispatches the pending deliveries until the context is cancelled
+func (s *Server) Run(ctx context.Context) {
+	interval := s.E.NotificationInterval
+	if interval == 0 {
+		interval = defaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// todos that became overdue while the service was down are picked up as long as it was not down
+	// for more than a day, the keys of the deliveries make sure that nobody is notified twice
+	since := time.Now().Add(-24 * time.Hour)
+	for {
+		now := time.Now()
+		s.remindOverdue(ctx, since, now)
+		since = now
+
+		s.Dispatch(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func deliveryToPB(delivery *database.Delivery) *pb.Delivery {
+	res := &pb.Delivery{
+		Id:        fmt.Sprint(delivery.ID),
+		Channel:   delivery.Channel,
+		Kind:      delivery.Kind,
+		Subject:   delivery.Subject,
+		Status:    delivery.Status,
+		Attempts:  int32(delivery.Attempts),
+		LastError: delivery.LastError,
+		SendAfter: delivery.SendAfter.Format(time.RFC3339),
+	}
+	if delivery.SentAt != nil {
+		res.SentAt = delivery.SentAt.Format(time.RFC3339)
+	}
+
+	return res
+}
+
+// Send is a gRPC endpoint to notify a user, it is meant to be called by the other services
+// returns Internal, InvalidArgument, NotFound, nil
+func (s *Server) Send(ctx context.Context, req *pb.SendRequest) (*pb.SendResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.SendResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	if len(req.Key) > 100 {
+		return &pb.SendResponse{
+			Success: false,
+			Message: "Invalid key",
+		}, status.Error(codes.InvalidArgument, "the key must not be longer than 100 characters")
+	}
+
+	deliveries, err := s.Notify(ctx, uint(userID), enums.NotificationKind(req.Kind), req.Data, req.Key)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return &pb.SendResponse{
+				Success: false,
+				Message: "User not found",
+			}, status.Error(codes.NotFound, "user not found")
+		}
+		if errors.Is(err, ErrInvalidMessage) {
+			return &pb.SendResponse{
+				Success: false,
+				Message: "Invalid message",
+			}, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		log.Error().Err(err).Msg("failed to send the notification")
+		return &pb.SendResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to send the notification")
+	}
+
+	res := &pb.SendResponse{
+		Success:    true,
+		Deliveries: []*pb.Delivery{},
+	}
+	for _, delivery := range deliveries {
+		res.Deliveries = append(res.Deliveries, deliveryToPB(delivery))
+	}
+
+	return res, nil
+}
+
+// ListDeliveries is a gRPC endpoint to get the latest deliveries of the notifications of the user
+// returns Internal, nil
+func (s *Server) ListDeliveries(ctx context.Context, req *pb.ListDeliveriesRequest) (*pb.ListDeliveriesResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.ListDeliveriesResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	limit := int(req.Limit)
+	if limit <= 0 || limit > maxDeliveries {
+		limit = maxDeliveries
+	}
+
+	deliveries := []*database.Delivery{}
+
+	err = s.DB.Where("user_id = ?", userID).Order("id DESC").Limit(limit).Find(&deliveries).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the deliveries")
+		return &pb.ListDeliveriesResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the deliveries")
+	}
+
+	res := &pb.ListDeliveriesResponse{
+		Success:    true,
+		Deliveries: []*pb.Delivery{},
+	}
+	for _, delivery := range deliveries {
+		res.Deliveries = append(res.Deliveries, deliveryToPB(delivery))
+	}
+
+	return res, nil
+}
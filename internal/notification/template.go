@@ -0,0 +1,78 @@
+package notification
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/VinukaThejana/todoapp/internal/enums"
+)
+
+type messageTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+func newTemplate(kind enums.NotificationKind, subject string, body string) messageTemplate {
+	return messageTemplate{
+		subject: template.Must(template.New(string(kind) + "_subject").Option("missingkey=error").Parse(subject)),
+		body:    template.Must(template.New(string(kind) + "_body").Option("missingkey=error").Parse(body)),
+	}
+}
+
+// templates holds the templates of each kind of notification, the data of a notification must
+// contain every key that the template of its kind uses
+var templates = map[enums.NotificationKind]messageTemplate{
+	enums.Reminder: newTemplate(
+		enums.Reminder,
+		`Reminder: {{.title}}`,
+		`Hi {{.name}},
+
+Your todo "{{.title}}" is due {{.due_at}}.`,
+	),
+	enums.Overdue: newTemplate(
+		enums.Overdue,
+		`Overdue: {{.title}}`,
+		`Hi {{.name}},
+
+Your todo "{{.title}}" was due {{.due_at}} and it is not completed yet.`,
+	),
+	enums.Share: newTemplate(
+		enums.Share,
+		`{{.from}} shared "{{.title}}" with you`,
+		`Hi {{.name}},
+
+{{.from}} shared "{{.title}}" with you.`,
+	),
+	enums.Mention: newTemplate(
+		enums.Mention,
+		`{{.from}} mentioned you in "{{.title}}"`,
+		`Hi {{.name}},
+
+{{.from}} mentioned you in "{{.title}}":
+
+{{.text}}`,
+	),
+}
+
+// render renders the notification of the given kind with the data
+func render(kind enums.NotificationKind, data map[string]string) (*Message, error) {
+	tmpl, ok := templates[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown notification kind %q", kind)
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.body.Execute(&body, data); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Kind:    kind,
+		Subject: subject.String(),
+		Body:    body.String(),
+	}, nil
+}
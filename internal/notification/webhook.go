@@ -0,0 +1,147 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/enums"
+)
+
+// SignatureHeader is the header that carries the HMAC-SHA256 signature of the webhook payload,
+// it is computed with the webhook secret of the user so that the receiver can verify the sender
+const SignatureHeader = "X-Todoapp-Signature"
+
+// reservedPrefixes are the ranges that are not reachable on the internet but are not caught by the checks of
+// netip.Addr, like the shared address space of carrier-grade NATs
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// PublicAddr returns true if the address can be reached on the internet, webhooks are not sent anywhere else so
+// that they can not be used to reach the services on the network of the notification service
+func PublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dialPublic is the control hook of the dialer of the webhook client, the address is checked after it was
+// resolved so that a host name that points at a private address is rejected as well
+func dialPublic(network, address string, c syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !PublicAddr(ap.Addr()) {
+		return fmt.Errorf("%s is not a public address", ap.Addr())
+	}
+
+	return nil
+}
+
+// WebhookNotifier delivers messages as JSON to the webhook URL of the recipient, AllowPrivate lets the webhooks
+// reach private addresses while developing against local receivers
+type WebhookNotifier struct {
+	Client       *http.Client
+	AllowPrivate bool
+}
+
+// client returns the HTTP client of the webhooks, redirects are not followed since they could lead anywhere
+func (n *WebhookNotifier) client() *http.Client {
+	if n.Client != nil {
+		return n.Client
+	}
+
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+	}
+	if !n.AllowPrivate {
+		dialer.Control = dialPublic
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return errors.New("the webhook redirected, redirects are not followed")
+		},
+	}
+}
+
+type webhookPayload struct {
+	Kind    string `json:"kind"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	SentAt  string `json:"sent_at"`
+}
+
+// Channel returns the channel of the notifier
+func (n *WebhookNotifier) Channel() enums.Channel {
+	return enums.Webhook
+}
+
+// Send posts the message to the webhook URL of the recipient
+func (n *WebhookNotifier) Send(ctx context.Context, to *Recipient, msg *Message) error {
+	if to.WebhookURL == "" {
+		return fmt.Errorf("the recipient does not have a webhook url")
+	}
+
+	payload, err := json.Marshal(webhookPayload{
+		Kind:    string(msg.Kind),
+		Subject: msg.Subject,
+		Body:    msg.Body,
+		SentAt:  time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, to.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, []byte(to.WebhookSecret))
+	mac.Write(payload)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	res, err := n.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("the webhook responded with %d", res.StatusCode)
+	}
+
+	return nil
+}
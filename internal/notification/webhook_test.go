@@ -0,0 +1,94 @@
+package notification
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := PublicAddr(netip.MustParseAddr(tt.addr)); got != tt.public {
+			t.Errorf("PublicAddr(%s) = %v, want %v", tt.addr, got, tt.public)
+		}
+	}
+}
+
+func TestWebhookNotifierRejectsPrivateAddresses(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	n := &WebhookNotifier{}
+	err := n.Send(context.Background(), &Recipient{WebhookURL: srv.URL, WebhookSecret: "secret"}, &Message{Subject: "s"})
+	if err == nil || !strings.Contains(err.Error(), "not a public address") {
+		t.Fatalf("expected the private address to be rejected, got %v", err)
+	}
+	if called {
+		t.Fatal("the webhook reached the private address")
+	}
+}
+
+func TestWebhookNotifierDoesNotFollowRedirects(t *testing.T) {
+	followed := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed = true
+	}))
+	defer target.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+
+	n := &WebhookNotifier{AllowPrivate: true}
+	err := n.Send(context.Background(), &Recipient{WebhookURL: srv.URL, WebhookSecret: "secret"}, &Message{Subject: "s"})
+	if err == nil {
+		t.Fatal("expected the redirect to be refused")
+	}
+	if followed {
+		t.Fatal("the redirect was followed")
+	}
+}
+
+func TestWebhookNotifierSignsThePayload(t *testing.T) {
+	signature := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer srv.Close()
+
+	n := &WebhookNotifier{AllowPrivate: true}
+	err := n.Send(context.Background(), &Recipient{WebhookURL: srv.URL, WebhookSecret: "secret"}, &Message{Subject: "s"})
+	if err != nil {
+		t.Fatalf("failed to send the webhook: %v", err)
+	}
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Fatalf("signature = %q, want a sha256 signature", signature)
+	}
+}
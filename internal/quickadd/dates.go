@@ -0,0 +1,207 @@
+package quickadd
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/VinukaThejana/todoapp/internal/enums"
+)
+
+var priorities = map[string]enums.Priority{
+	"low":    enums.Low,
+	"medium": enums.Medium,
+	"med":    enums.Medium,
+	"high":   enums.High,
+	"urgent": enums.Urgent,
+	"1":      enums.Urgent,
+	"2":      enums.High,
+	"3":      enums.Medium,
+	"4":      enums.Low,
+}
+
+var adverbs = map[string]string{
+	"daily":    "DAILY",
+	"weekly":   "WEEKLY",
+	"monthly":  "MONTHLY",
+	"yearly":   "YEARLY",
+	"annually": "YEARLY",
+}
+
+var units = map[string]string{
+	"day":   "DAILY",
+	"week":  "WEEKLY",
+	"month": "MONTHLY",
+	"year":  "YEARLY",
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"sun":       time.Sunday,
+	"monday":    time.Monday,
+	"mon":       time.Monday,
+	"tuesday":   time.Tuesday,
+	"tue":       time.Tuesday,
+	"tues":      time.Tuesday,
+	"wednesday": time.Wednesday,
+	"wed":       time.Wednesday,
+	"thursday":  time.Thursday,
+	"thu":       time.Thursday,
+	"thur":      time.Thursday,
+	"thurs":     time.Thursday,
+	"friday":    time.Friday,
+	"fri":       time.Friday,
+	"saturday":  time.Saturday,
+	"sat":       time.Saturday,
+}
+
+var months = map[string]time.Month{
+	"january":   time.January,
+	"jan":       time.January,
+	"february":  time.February,
+	"feb":       time.February,
+	"march":     time.March,
+	"mar":       time.March,
+	"april":     time.April,
+	"apr":       time.April,
+	"may":       time.May,
+	"june":      time.June,
+	"jun":       time.June,
+	"july":      time.July,
+	"jul":       time.July,
+	"august":    time.August,
+	"aug":       time.August,
+	"september": time.September,
+	"sep":       time.September,
+	"sept":      time.September,
+	"october":   time.October,
+	"oct":       time.October,
+	"november":  time.November,
+	"nov":       time.November,
+	"december":  time.December,
+	"dec":       time.December,
+}
+
+// ambiguous are the abbreviations that are also ordinary words, they are only taken as a date after a word like
+// "on" or "by" or next to another date or time so that titles like "sun cream" or "may the force" are kept
+var ambiguous = map[string]bool{
+	"sun": true,
+	"wed": true,
+	"thu": true,
+	"sat": true,
+	"mar": true,
+	"may": true,
+}
+
+// isName checks if the given string can be used as a tag or a project name
+func isName(s string) bool {
+	if s == "" || len(s) > 50 {
+		return false
+	}
+
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseNumber parses a small positive number
+func parseNumber(s string) (int, bool) {
+	if s == "" || len(s) > 4 {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// parseOrdinal parses numbers like 1, 1st, 2nd, 3rd and 4th
+func parseOrdinal(s string) (int, bool) {
+	for _, suffix := range []string{"st", "nd", "rd", "th"} {
+		if strings.HasSuffix(s, suffix) {
+			s = strings.TrimSuffix(s, suffix)
+			break
+		}
+	}
+
+	return parseNumber(s)
+}
+
+// validDay checks if the day exists in the given month, February is checked against a leap year
+func validDay(year int, month time.Month, day int) bool {
+	if day < 1 {
+		return false
+	}
+	if month == time.February {
+		return day <= 29
+	}
+
+	return day <= daysIn(year, month)
+}
+
+// daysIn returns the number of days in the given month
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// upcoming returns the next occurrence of the given month and day that is not in the past
+func upcoming(today time.Time, month time.Month, day int) time.Time {
+	for year := today.Year(); ; year++ {
+		if day > daysIn(year, month) {
+			continue
+		}
+
+		date := time.Date(year, month, day, 0, 0, 0, 0, today.Location())
+		if !date.Before(today) {
+			return date
+		}
+	}
+}
+
+// nextWeekday returns the next given weekday after today
+func nextWeekday(today time.Time, weekday time.Weekday) time.Time {
+	days := (int(weekday) - int(today.Weekday()) + 7) % 7
+	if days == 0 {
+		days = 7
+	}
+
+	return today.AddDate(0, 0, days)
+}
+
+// nextWeekdayIncluding returns the first day starting from today that falls on one of the weekdays
+func nextWeekdayIncluding(today time.Time, weekdays []time.Weekday) time.Time {
+	for days := 0; days < 7; days++ {
+		date := today.AddDate(0, 0, days)
+		for _, weekday := range weekdays {
+			if date.Weekday() == weekday {
+				return date
+			}
+		}
+	}
+
+	return today
+}
+
+// nextMonthDay returns the first date starting from today that falls on the given day of the month,
+// months that do not have that day are skipped
+func nextMonthDay(today time.Time, day int) time.Time {
+	for months := 0; ; months++ {
+		first := time.Date(today.Year(), today.Month()+time.Month(months), 1, 0, 0, 0, 0, today.Location())
+		if day > daysIn(first.Year(), first.Month()) {
+			continue
+		}
+
+		date := time.Date(first.Year(), first.Month(), day, 0, 0, 0, 0, today.Location())
+		if !date.Before(today) {
+			return date
+		}
+	}
+}
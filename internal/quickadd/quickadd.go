@@ -0,0 +1,633 @@
+// Package quickadd parses free text typed by the user into the fields of a todo
+package quickadd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/VinukaThejana/todoapp/internal/enums"
+)
+
+// ErrEmptyTitle is returned when nothing is left for the title after parsing the input
+var ErrEmptyTitle = errors.New("the title of the todo is empty")
+
+// Kind is the kind of a token recognised in the input
+type Kind string
+
+const (
+	// Tag is a #tag
+	Tag Kind = "tag"
+	// Priority is a !priority
+	Priority Kind = "priority"
+	// Project is a @project
+	Project Kind = "project"
+	// Due is a date or a time
+	Due Kind = "due"
+	// Recurrence is a phrase like "every month on the 1st"
+	Recurrence Kind = "recurrence"
+)
+
+// Token is a part of the input that was recognised, Start and End are rune offsets into the input
+// so that clients can highlight them
+type Token struct {
+	Kind  Kind
+	Text  string
+	Value string
+	Start int
+	End   int
+}
+
+// Result is the outcome of parsing the input
+type Result struct {
+	Title      string
+	Tags       []string
+	Priority   enums.Priority
+	Project    string
+	Due        *time.Time
+	Recurrence string
+	Tokens     []Token
+}
+
+type word struct {
+	text  string
+	lower string
+	start int
+	end   int
+}
+
+type clock struct {
+	hour   int
+	minute int
+}
+
+type parser struct {
+	words []word
+	now   time.Time
+	res   *Result
+
+	date  *time.Time
+	exact *time.Time
+	clock *clock
+	// defaultClock is the time that a word like "tonight" implies, a time given explicitly overrides it
+	defaultClock *clock
+	freq         string
+	interval     int
+	byDay        []time.Weekday
+	byMonthDay   int
+}
+
+// Parse parses the input, relative dates are resolved against now and in its location so the caller
+// must pass the current time in the time zone of the user.
+//
+// A date without a time is due at the end of that day, a time without a date is due today or tomorrow
+// if the time has already passed today.
+func Parse(input string, now time.Time) (*Result, error) {
+	p := &parser{
+		words: split(input),
+		now:   now,
+		res: &Result{
+			Tags:   []string{},
+			Tokens: []Token{},
+		},
+	}
+
+	used := make([]bool, len(p.words))
+	for i := 0; i < len(p.words); {
+		n := p.match(i)
+		if n == 0 {
+			i++
+			continue
+		}
+
+		for j := i; j < i+n; j++ {
+			used[j] = true
+		}
+		i += n
+	}
+
+	title := []string{}
+	for i, w := range p.words {
+		if !used[i] {
+			title = append(title, w.text)
+		}
+	}
+	p.res.Title = strings.Join(title, " ")
+	if p.res.Title == "" {
+		return nil, ErrEmptyTitle
+	}
+
+	p.resolveDue()
+	p.resolveRecurrence()
+
+	return p.res, nil
+}
+
+// split splits the input into words separated by white space while keeping their rune offsets
+func split(input string) []word {
+	words := []word{}
+	runes := []rune(input)
+
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		if i == len(runes) || unicode.IsSpace(runes[i]) {
+			if start >= 0 {
+				text := string(runes[start:i])
+				words = append(words, word{
+					text:  text,
+					lower: strings.TrimRight(strings.ToLower(text), ",.;"),
+					start: start,
+					end:   i,
+				})
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+
+	return words
+}
+
+// lower returns the normalised word at the given index or an empty string when out of range
+func (p *parser) lower(i int) string {
+	if i < 0 || i >= len(p.words) {
+		return ""
+	}
+
+	return p.words[i].lower
+}
+
+// token records a token that spans the words from i to i+n-1
+func (p *parser) token(kind Kind, i, n int, value string) {
+	texts := []string{}
+	for _, w := range p.words[i : i+n] {
+		texts = append(texts, w.text)
+	}
+
+	p.res.Tokens = append(p.res.Tokens, Token{
+		Kind:  kind,
+		Text:  strings.Join(texts, " "),
+		Value: value,
+		Start: p.words[i].start,
+		End:   p.words[i+n-1].end,
+	})
+}
+
+// match tries to recognise a token at the given word and returns the number of words consumed
+func (p *parser) match(i int) int {
+	w := p.lower(i)
+
+	switch {
+	case strings.HasPrefix(w, "#") && isName(w[1:]):
+		p.res.Tags = append(p.res.Tags, w[1:])
+		p.token(Tag, i, 1, w[1:])
+		return 1
+	case strings.HasPrefix(w, "@") && isName(w[1:]):
+		if p.res.Project != "" {
+			return 0
+		}
+		p.res.Project = w[1:]
+		p.token(Project, i, 1, w[1:])
+		return 1
+	case strings.HasPrefix(w, "!"):
+		priority, ok := priorities[w[1:]]
+		if !ok || p.res.Priority != "" {
+			return 0
+		}
+		p.res.Priority = priority
+		p.token(Priority, i, 1, string(priority))
+		return 1
+	}
+
+	if n := p.matchRecurrence(i); n > 0 {
+		return n
+	}
+	if n := p.matchDate(i); n > 0 {
+		return n
+	}
+
+	return p.matchClock(i)
+}
+
+// matchRecurrence recognises phrases like "daily", "every 2 weeks" and "every month on the 1st"
+func (p *parser) matchRecurrence(i int) int {
+	if p.freq != "" {
+		return 0
+	}
+
+	w := p.lower(i)
+	if freq, ok := adverbs[w]; ok {
+		p.freq, p.interval = freq, 1
+		n := 1 + p.matchAnchor(i+1)
+		p.token(Recurrence, i, n, p.rrule())
+		return n
+	}
+	if w != "every" {
+		return 0
+	}
+
+	n := 1
+	interval := 1
+	next := p.lower(i + n)
+	if next == "other" {
+		interval = 2
+		n++
+	} else if number, ok := parseNumber(next); ok && number > 0 {
+		interval = number
+		n++
+	}
+
+	unit := p.lower(i + n)
+	switch {
+	case unit == "weekday" || unit == "weekdays":
+		p.freq = "WEEKLY"
+		p.byDay = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	case unit == "weekend" || unit == "weekends":
+		p.freq = "WEEKLY"
+		p.byDay = []time.Weekday{time.Saturday, time.Sunday}
+	default:
+		if weekday, ok := weekdays[strings.TrimSuffix(unit, "s")]; ok {
+			p.freq = "WEEKLY"
+			p.byDay = []time.Weekday{weekday}
+		} else if freq, ok := units[strings.TrimSuffix(unit, "s")]; ok && freq != "" {
+			p.freq = freq
+		} else {
+			return 0
+		}
+	}
+	n++
+	p.interval = interval
+
+	n += p.matchAnchor(i + n)
+	p.token(Recurrence, i, n, p.rrule())
+	return n
+}
+
+// matchAnchor recognises "on the 1st" for monthly and "on friday" for weekly recurrences
+func (p *parser) matchAnchor(i int) int {
+	if p.lower(i) != "on" {
+		return 0
+	}
+
+	switch p.freq {
+	case "MONTHLY":
+		n := 1
+		if p.lower(i+n) == "the" {
+			n++
+		}
+		day, ok := parseOrdinal(p.lower(i + n))
+		if !ok || day < 1 || day > 31 {
+			return 0
+		}
+		p.byMonthDay = day
+		return n + 1
+	case "WEEKLY":
+		weekday, ok := weekdays[p.lower(i+1)]
+		if !ok || len(p.byDay) > 0 {
+			return 0
+		}
+		p.byDay = []time.Weekday{weekday}
+		return 2
+	}
+
+	return 0
+}
+
+// matchDate recognises a date with an optional "on", "by" or "due" prefix, like "tomorrow", "next friday",
+// "in 3 days", "oct 5" or "2024-10-05"
+func (p *parser) matchDate(i int) int {
+	if p.date != nil || p.exact != nil {
+		return 0
+	}
+
+	prefix := 0
+	switch p.lower(i) {
+	case "on", "by", "due":
+		prefix = 1
+	}
+
+	n := p.matchDateAt(i+prefix, prefix == 1)
+	if n == 0 {
+		return 0
+	}
+	n += prefix
+
+	value := ""
+	if p.exact != nil {
+		value = p.exact.Format(time.RFC3339)
+	} else {
+		value = p.date.Format(time.DateOnly)
+	}
+	p.token(Due, i, n, value)
+
+	return n
+}
+
+// matchDateAt recognises a date at the given word, triggered tells if it follows a word like "on" or "by"
+// that makes the ambiguous abbreviations a date
+func (p *parser) matchDateAt(i int, triggered bool) int {
+	today := time.Date(p.now.Year(), p.now.Month(), p.now.Day(), 0, 0, 0, 0, p.now.Location())
+	w := p.lower(i)
+
+	set := func(date time.Time, n int) int {
+		p.date = &date
+		return n
+	}
+	// meant checks that the ambiguous words of the date spanning n words are meant as one
+	meant := func(n int) bool {
+		for j := i; j < i+n; j++ {
+			if ambiguous[p.lower(j)] {
+				return triggered || p.afterDue(i) || p.startsClock(i+n)
+			}
+		}
+		return true
+	}
+
+	switch w {
+	case "today", "tod":
+		return set(today, 1)
+	case "tonight":
+		p.defaultClock = &clock{hour: 20}
+		return set(today, 1)
+	case "tomorrow", "tmr", "tmrw":
+		return set(today.AddDate(0, 0, 1), 1)
+	case "next":
+		next := p.lower(i + 1)
+		switch next {
+		case "week":
+			return set(nextWeekday(today, time.Monday), 2)
+		case "month":
+			return set(time.Date(today.Year(), today.Month()+1, 1, 0, 0, 0, 0, today.Location()), 2)
+		case "year":
+			return set(time.Date(today.Year()+1, time.January, 1, 0, 0, 0, 0, today.Location()), 2)
+		}
+		if weekday, ok := weekdays[next]; ok {
+			return set(nextWeekday(today, weekday), 2)
+		}
+		return 0
+	case "in":
+		amount, ok := parseNumber(p.lower(i + 1))
+		if p.lower(i+1) == "a" || p.lower(i+1) == "an" {
+			amount, ok = 1, true
+		}
+		if !ok || amount <= 0 {
+			return 0
+		}
+
+		switch strings.TrimSuffix(p.lower(i+2), "s") {
+		case "minute", "min":
+			exact := p.now.Add(time.Duration(amount) * time.Minute)
+			p.exact = &exact
+		case "hour", "hr":
+			exact := p.now.Add(time.Duration(amount) * time.Hour)
+			p.exact = &exact
+		case "day":
+			return set(today.AddDate(0, 0, amount), 3)
+		case "week":
+			return set(today.AddDate(0, 0, 7*amount), 3)
+		case "month":
+			return set(today.AddDate(0, amount, 0), 3)
+		case "year":
+			return set(today.AddDate(amount, 0, 0), 3)
+		default:
+			return 0
+		}
+		return 3
+	}
+
+	if weekday, ok := weekdays[w]; ok && meant(1) {
+		return set(nextWeekday(today, weekday), 1)
+	}
+
+	if date, err := time.ParseInLocation(time.DateOnly, w, today.Location()); err == nil {
+		return set(date, 1)
+	}
+
+	// oct 5, october 5th
+	if month, ok := months[w]; ok {
+		if day, ok := parseOrdinal(p.lower(i + 1)); ok && validDay(today.Year(), month, day) && meant(2) {
+			return set(upcoming(today, month, day), 2)
+		}
+	}
+	// 5 oct, 5th of october
+	if day, ok := parseOrdinal(w); ok {
+		n := 1
+		if p.lower(i+n) == "of" {
+			n++
+		}
+		if month, ok := months[p.lower(i+n)]; ok && validDay(today.Year(), month, day) && meant(n+1) {
+			return set(upcoming(today, month, day), n+1)
+		}
+	}
+
+	return 0
+}
+
+// matchClock recognises a time like "9am", "9:30 pm" or "at noon", a time without a meridiem like "21:00" is
+// only recognised after "at" or next to a date so that titles like "read ch 3:15" are kept
+func (p *parser) matchClock(i int) int {
+	if p.clock != nil || p.exact != nil {
+		return 0
+	}
+
+	prefix := 0
+	if p.lower(i) == "at" {
+		prefix = 1
+	}
+
+	c, n, bare := parseClock(p.lower(i+prefix), p.lower(i+prefix+1))
+	if n == 0 {
+		return 0
+	}
+	if bare && prefix == 0 && !p.afterDue(i) && !p.startsDate(i+n) {
+		return 0
+	}
+	p.clock = c
+	n += prefix
+
+	p.token(Due, i, n, fmt.Sprintf("%02d:%02d", c.hour, c.minute))
+	return n
+}
+
+// parseClock parses a time from the given word and the word that follows it, bare tells if it is a time
+// like "21:00" that has neither a meridiem nor a name
+func parseClock(w, next string) (c *clock, n int, bare bool) {
+	switch w {
+	case "noon", "midday":
+		return &clock{hour: 12}, 1, false
+	case "midnight":
+		return &clock{hour: 0}, 1, false
+	}
+
+	n = 1
+	meridiem := ""
+	for _, suffix := range []string{"am", "pm"} {
+		if strings.HasSuffix(w, suffix) {
+			meridiem = suffix
+			w = strings.TrimSuffix(w, suffix)
+		}
+	}
+	if meridiem == "" && (next == "am" || next == "pm") {
+		meridiem = next
+		n++
+	}
+
+	hour, minute := 0, 0
+	if h, m, ok := strings.Cut(w, ":"); ok {
+		var okH, okM bool
+		hour, okH = parseNumber(h)
+		minute, okM = parseNumber(m)
+		if !okH || !okM || len(m) != 2 {
+			return nil, 0, false
+		}
+	} else {
+		// a plain number is only a time when it has a meridiem, otherwise it is part of the title
+		var ok bool
+		hour, ok = parseNumber(w)
+		if !ok || meridiem == "" {
+			return nil, 0, false
+		}
+	}
+
+	if minute < 0 || minute > 59 {
+		return nil, 0, false
+	}
+	if meridiem != "" {
+		if hour < 1 || hour > 12 {
+			return nil, 0, false
+		}
+		hour %= 12
+		if meridiem == "pm" {
+			hour += 12
+		}
+	}
+	if hour < 0 || hour > 23 {
+		return nil, 0, false
+	}
+
+	return &clock{hour: hour, minute: minute}, n, meridiem == ""
+}
+
+// afterDue checks if the word before the given word ends a date or a time
+func (p *parser) afterDue(i int) bool {
+	if i == 0 || len(p.res.Tokens) == 0 {
+		return false
+	}
+
+	last := p.res.Tokens[len(p.res.Tokens)-1]
+	return last.Kind == Due && last.End == p.words[i-1].end
+}
+
+// startsDate checks if a date that needs no other word to be one starts at the given word
+func (p *parser) startsDate(i int) bool {
+	w := p.lower(i)
+	switch w {
+	case "on", "by", "due", "next", "today", "tod", "tonight", "tomorrow", "tmr", "tmrw":
+		return true
+	}
+	if _, ok := weekdays[w]; ok && !ambiguous[w] {
+		return true
+	}
+	_, err := time.Parse(time.DateOnly, w)
+
+	return err == nil
+}
+
+// startsClock checks if a time that needs no other word to be one starts at the given word
+func (p *parser) startsClock(i int) bool {
+	prefix := 0
+	if p.lower(i) == "at" {
+		prefix = 1
+	}
+
+	c, _, bare := parseClock(p.lower(i+prefix), p.lower(i+prefix+1))
+	return c != nil && (!bare || prefix == 1)
+}
+
+// resolveDue combines the recognised date, time and recurrence into the due date
+func (p *parser) resolveDue() {
+	if p.exact != nil {
+		p.res.Due = p.exact
+		return
+	}
+
+	if p.date != nil {
+		due := p.at(*p.date)
+		p.res.Due = &due
+		return
+	}
+	if p.freq == "" && p.clock == nil {
+		return
+	}
+
+	// without a date the todo is due on the first matching day that is still ahead
+	day := time.Date(p.now.Year(), p.now.Month(), p.now.Day(), 0, 0, 0, 0, p.now.Location())
+	for {
+		due := p.at(p.anchor(day))
+		if due.After(p.now) {
+			p.res.Due = &due
+			return
+		}
+
+		day = day.AddDate(0, 0, 1)
+	}
+}
+
+// anchor returns the first day starting from the given day that matches the recurrence
+func (p *parser) anchor(day time.Time) time.Time {
+	switch {
+	case p.byMonthDay > 0:
+		return nextMonthDay(day, p.byMonthDay)
+	case len(p.byDay) > 0:
+		return nextWeekdayIncluding(day, p.byDay)
+	default:
+		return day
+	}
+}
+
+// at returns the given date at the recognised time, at the time implied by the date or at the end of the day
+func (p *parser) at(date time.Time) time.Time {
+	c := p.clock
+	if c == nil {
+		c = p.defaultClock
+	}
+	if c != nil {
+		return time.Date(date.Year(), date.Month(), date.Day(), c.hour, c.minute, 0, 0, date.Location())
+	}
+
+	return time.Date(date.Year(), date.Month(), date.Day(), 23, 59, 59, 0, date.Location())
+}
+
+// resolveRecurrence sets the recurrence rule of the result
+func (p *parser) resolveRecurrence() {
+	if p.freq == "" {
+		return
+	}
+
+	p.res.Recurrence = p.rrule()
+}
+
+// rrule formats the recurrence as an RFC 5545 recurrence rule
+func (p *parser) rrule() string {
+	parts := []string{"FREQ=" + p.freq}
+	if p.interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", p.interval))
+	}
+	if len(p.byDay) > 0 {
+		days := []string{}
+		for _, day := range p.byDay {
+			days = append(days, strings.ToUpper(day.String()[:2]))
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if p.byMonthDay > 0 {
+		parts = append(parts, fmt.Sprintf("BYMONTHDAY=%d", p.byMonthDay))
+	}
+
+	return strings.Join(parts, ";")
+}
@@ -0,0 +1,166 @@
+package quickadd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/enums"
+)
+
+// now is a Wednesday morning
+var now = time.Date(2024, time.October, 2, 10, 0, 0, 0, time.UTC)
+
+// at returns the time on the given day of 2024 or later
+func at(year int, month time.Month, day, hour, minute, second int) *time.Time {
+	t := time.Date(year, month, day, hour, minute, second, 0, time.UTC)
+	return &t
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input      string
+		title      string
+		due        *time.Time
+		recurrence string
+		priority   enums.Priority
+		tags       []string
+		project    string
+	}{
+		{input: "buy milk tomorrow", title: "buy milk", due: at(2024, time.October, 3, 23, 59, 59)},
+		{input: "call mom tonight", title: "call mom", due: at(2024, time.October, 2, 20, 0, 0)},
+		{input: "call mom tonight 9pm", title: "call mom", due: at(2024, time.October, 2, 21, 0, 0)},
+		{input: "report in 3 days", title: "report", due: at(2024, time.October, 5, 23, 59, 59)},
+		{input: "stretch in 30 minutes", title: "stretch", due: at(2024, time.October, 2, 10, 30, 0)},
+		{input: "dentist next fri at 3pm", title: "dentist", due: at(2024, time.October, 4, 15, 0, 0)},
+		{input: "retro on wed", title: "retro", due: at(2024, time.October, 9, 23, 59, 59)},
+		{input: "standup at 9:30", title: "standup", due: at(2024, time.October, 3, 9, 30, 0)},
+		{input: "submit oct 5th", title: "submit", due: at(2024, time.October, 5, 23, 59, 59)},
+		{input: "renew 1st of feb", title: "renew", due: at(2025, time.February, 1, 23, 59, 59)},
+		{input: "launch 2024-12-24", title: "launch", due: at(2024, time.December, 24, 23, 59, 59)},
+		{input: "party on sat", title: "party", due: at(2024, time.October, 5, 23, 59, 59)},
+		{input: "yoga sat 9am", title: "yoga", due: at(2024, time.October, 5, 9, 0, 0)},
+		{input: "taxes due may 5", title: "taxes", due: at(2025, time.May, 5, 23, 59, 59)},
+		{input: "ship tomorrow 21:00", title: "ship", due: at(2024, time.October, 3, 21, 0, 0)},
+		{input: "ship 21:00 tomorrow", title: "ship", due: at(2024, time.October, 3, 21, 0, 0)},
+		{
+			input:      "pay rent every month on the 1st",
+			title:      "pay rent",
+			due:        at(2024, time.November, 1, 23, 59, 59),
+			recurrence: "FREQ=MONTHLY;BYMONTHDAY=1",
+		},
+		{
+			input:      "water plants every other sat",
+			title:      "water plants",
+			due:        at(2024, time.October, 5, 23, 59, 59),
+			recurrence: "FREQ=WEEKLY;INTERVAL=2;BYDAY=SA",
+		},
+		{
+			input:    "write report !high #work #q4 @job",
+			title:    "write report",
+			priority: enums.High,
+			tags:     []string{"work", "q4"},
+			project:  "job",
+		},
+
+		// ordinary words and numbers that look like dates and times are kept in the title
+		{input: "may the force be with you", title: "may the force be with you"},
+		{input: "buy sun cream", title: "buy sun cream"},
+		{input: "read ch 3:15", title: "read ch 3:15"},
+		{input: "wed plans", title: "wed plans"},
+		{input: "sat nav update", title: "sat nav update"},
+		{input: "thu long", title: "thu long"},
+		{input: "mar 3 lecture notes", title: "mar 3 lecture notes"},
+		{input: "buy 2 apples", title: "buy 2 apples"},
+		{input: "email #", title: "email #"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			res, err := Parse(tt.input, now)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			if res.Title != tt.title {
+				t.Errorf("title = %q, want %q", res.Title, tt.title)
+			}
+			switch {
+			case tt.due == nil && res.Due != nil:
+				t.Errorf("due = %v, want none", res.Due)
+			case tt.due != nil && (res.Due == nil || !res.Due.Equal(*tt.due)):
+				t.Errorf("due = %v, want %v", res.Due, tt.due)
+			}
+			if res.Recurrence != tt.recurrence {
+				t.Errorf("recurrence = %q, want %q", res.Recurrence, tt.recurrence)
+			}
+			if res.Priority != tt.priority {
+				t.Errorf("priority = %q, want %q", res.Priority, tt.priority)
+			}
+			if tt.tags == nil {
+				tt.tags = []string{}
+			}
+			if !reflect.DeepEqual(res.Tags, tt.tags) {
+				t.Errorf("tags = %v, want %v", res.Tags, tt.tags)
+			}
+			if res.Project != tt.project {
+				t.Errorf("project = %q, want %q", res.Project, tt.project)
+			}
+		})
+	}
+}
+
+func TestParseTokens(t *testing.T) {
+	tests := []struct {
+		input  string
+		tokens []Token
+	}{
+		{
+			input: "buy milk tomorrow",
+			tokens: []Token{
+				{Kind: Due, Text: "tomorrow", Value: "2024-10-03", Start: 9, End: 17},
+			},
+		},
+		{
+			// the offsets count runes and not bytes
+			input: "café ☕ at noon #treats",
+			tokens: []Token{
+				{Kind: Due, Text: "at noon", Value: "12:00", Start: 7, End: 14},
+				{Kind: Tag, Text: "#treats", Value: "treats", Start: 15, End: 22},
+			},
+		},
+		{
+			input: "rent every month on the 1st !urgent",
+			tokens: []Token{
+				{Kind: Recurrence, Text: "every month on the 1st", Value: "FREQ=MONTHLY;BYMONTHDAY=1", Start: 5, End: 27},
+				{Kind: Priority, Text: "!urgent", Value: string(enums.Urgent), Start: 28, End: 35},
+			},
+		},
+		{
+			input:  "may the force",
+			tokens: []Token{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			res, err := Parse(tt.input, now)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			if !reflect.DeepEqual(res.Tokens, tt.tokens) {
+				t.Fatalf("tokens = %+v, want %+v", res.Tokens, tt.tokens)
+			}
+		})
+	}
+}
+
+func TestParseEmptyTitle(t *testing.T) {
+	for _, input := range []string{"", "  ", "tomorrow", "#work !high tomorrow at 9am"} {
+		if _, err := Parse(input, now); !errors.Is(err, ErrEmptyTitle) {
+			t.Errorf("Parse(%q) err = %v, want ErrEmptyTitle", input, err)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Package quota implements the limits that the plan of a user puts on the resources they can use
+//
+// Every plan has built in limits that a row in the plans table can override, and a row in the quotas
+// table can override the limits of the plan for a single user. A limit of 0 means that there is no limit.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	rdb "github.com/VinukaThejana/todoapp/internal/redis"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// Limits are the resolved limits of a user
+type Limits struct {
+	Todos          int64
+	ContentSize    int64
+	Storage        int64
+	RequestsPerDay int64
+}
+
+// Plans are the built in limits of each plan
+var Plans = map[enums.Plan]Limits{
+	enums.Free: {
+		Todos:          1000,
+		ContentSize:    4 << 10,
+		Storage:        5 << 20,
+		RequestsPerDay: 5000,
+	},
+	enums.Pro: {
+		Todos:          50000,
+		ContentSize:    64 << 10,
+		Storage:        250 << 20,
+		RequestsPerDay: 100000,
+	},
+}
+
+// Of returns the plan and the limits of the given user
+func Of(db *gorm.DB, userID uint) (enums.Plan, Limits, error) {
+	user := database.User{}
+	err := db.Select("id", "plan").First(&user, userID).Error
+	if err != nil {
+		return "", Limits{}, err
+	}
+
+	plan := enums.Plan(user.Plan)
+	limits, ok := Plans[plan]
+	if !ok {
+		plan = enums.Free
+		limits = Plans[enums.Free]
+	}
+
+	planRow := database.Plan{}
+	err = db.Where("name = ?", string(plan)).Limit(1).Find(&planRow).Error
+	if err != nil {
+		return "", Limits{}, err
+	}
+	limits.apply(planRow.Limits)
+
+	quota := database.Quota{}
+	err = db.Where("user_id = ?", userID).Limit(1).Find(&quota).Error
+	if err != nil {
+		return "", Limits{}, err
+	}
+	limits.apply(quota.Limits)
+
+	return plan, limits, nil
+}
+
+func (l *Limits) apply(o database.QuotaLimits) {
+	if o.Todos != nil {
+		l.Todos = *o.Todos
+	}
+	if o.ContentSize != nil {
+		l.ContentSize = *o.ContentSize
+	}
+	if o.Storage != nil {
+		l.Storage = *o.Storage
+	}
+	if o.RequestsPerDay != nil {
+		l.RequestsPerDay = *o.RequestsPerDay
+	}
+}
+
+// Size returns the number of bytes that a todo with the given text takes from the storage quota
+func Size(title, description, content string) int64 {
+	return int64(len(title) + len(description) + len(content))
+}
+
+// Todos returns the number of todos that the user has created
+func Todos(db *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := db.Model(&database.Todo{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// Storage returns the number of bytes that the todos created by the user take
+func Storage(db *gorm.DB, userID uint) (int64, error) {
+	var size int64
+	err := db.Model(&database.Todo{}).
+		Select("COALESCE(SUM(LENGTH(CAST(title AS BLOB)) + LENGTH(CAST(description AS BLOB)) + LENGTH(CAST(content AS BLOB))), 0)").
+		Where("user_id = ?", userID).
+		Scan(&size).Error
+	return size, err
+}
+
+// day returns the UTC day that the requests made at the given time are counted against
+func day(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+// ResetAt returns the time at which the daily request count starts over
+func ResetAt(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// Requests returns the number of API requests that the user has made today
+func Requests(ctx context.Context, r *redis.Client, userID uint) (int64, error) {
+	count, err := r.Get(ctx, rdb.RequestsKey(userID, day(time.Now()))).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return count, err
+}
+
+// CountRequest counts an API request of the user and returns the number of requests made today including it
+func CountRequest(ctx context.Context, r *redis.Client, userID uint) (int64, error) {
+	key := rdb.RequestsKey(userID, day(time.Now()))
+
+	pipe := r.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, 48*time.Hour)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+// Exceeded is returned when a change would take the usage of a user over one of their limits, Used is the
+// usage that the change would lead to
+type Exceeded struct {
+	Subject enums.QuotaSubject
+	Limit   int64
+	Used    int64
+}
+
+func (e *Exceeded) Error() string {
+	return fmt.Sprintf("the %s quota of %d is exceeded", e.Subject, e.Limit)
+}
+
+// Status returns the ResourceExhausted status for the error with the violated quota in the details
+func (e *Exceeded) Status() *status.Status {
+	st := status.New(codes.ResourceExhausted, e.Error())
+	detailed, err := st.WithDetails(&errdetails.QuotaFailure{
+		Violations: []*errdetails.QuotaFailure_Violation{
+			{
+				Subject:     string(e.Subject),
+				Description: fmt.Sprintf("would use %d of %d", e.Used, e.Limit),
+			},
+		},
+	})
+	if err != nil {
+		return st
+	}
+
+	return detailed
+}
+
+// check returns an Exceeded error when the usage after the change is over the limit
+func check(subject enums.QuotaSubject, limit, used int64) error {
+	if limit > 0 && used > limit {
+		return &Exceeded{
+			Subject: subject,
+			Limit:   limit,
+			Used:    used,
+		}
+	}
+
+	return nil
+}
+
+// CheckCreate checks that the user can create a todo with the given size
+func CheckCreate(db *gorm.DB, userID uint, limits Limits, size int64) error {
+	if err := check(enums.ContentSize, limits.ContentSize, size); err != nil {
+		return err
+	}
+
+	if limits.Todos > 0 {
+		todos, err := Todos(db, userID)
+		if err != nil {
+			return err
+		}
+		if err := check(enums.TodoCount, limits.Todos, todos+1); err != nil {
+			return err
+		}
+	}
+
+	if limits.Storage > 0 {
+		storage, err := Storage(db, userID)
+		if err != nil {
+			return err
+		}
+		if err := check(enums.Storage, limits.Storage, storage+size); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckUpdate checks that the user can change the size of one of their todos from before to after
+func CheckUpdate(db *gorm.DB, userID uint, limits Limits, before, after int64) error {
+	if after <= before {
+		return nil
+	}
+	if err := check(enums.ContentSize, limits.ContentSize, after); err != nil {
+		return err
+	}
+
+	if limits.Storage > 0 {
+		storage, err := Storage(db, userID)
+		if err != nil {
+			return err
+		}
+		if err := check(enums.Storage, limits.Storage, storage-before+after); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckRequest checks that the number of requests made today is within the limit
+func CheckRequest(limits Limits, requests int64) error {
+	return check(enums.DailyRequests, limits.RequestsPerDay, requests)
+}
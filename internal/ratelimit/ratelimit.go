@@ -0,0 +1,278 @@
+// Package ratelimit implements the sliding window rate limiter of the API gateway
+//
+// The requests of a key are counted in fixed windows and the count of the previous window is weighted by how much
+// of it still overlaps with the sliding window, which is close to a true sliding window while only keeping two
+// counters per key. The counters are kept in Redis so that every gateway shares them, and in memory while Redis
+// can not be reached so that an outage does not turn the limits off.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	env "github.com/VinukaThejana/todoapp/internal/config"
+	rdb "github.com/VinukaThejana/todoapp/internal/redis"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// Rule is the number of requests that a key can make in a window, a negative limit turns the limiter off
+type Rule struct {
+	Limit  int64
+	Window time.Duration
+}
+
+// AuthRule returns the rule of the /auth routes, they are limited by the IP address of the client
+func AuthRule(e *env.Env) Rule {
+	rule := Rule{
+		Limit:  int64(e.RateLimitAuth),
+		Window: e.RateLimitAuthWindow,
+	}
+	if rule.Limit == 0 {
+		rule.Limit = 30
+	}
+	if rule.Window <= 0 {
+		rule.Window = time.Minute
+	}
+
+	return rule
+}
+
+// TodoRule returns the rule of the /todo routes, they are limited by the user
+func TodoRule(e *env.Env) Rule {
+	rule := Rule{
+		Limit:  int64(e.RateLimitTodo),
+		Window: e.RateLimitTodoWindow,
+	}
+	if rule.Limit == 0 {
+		rule.Limit = 300
+	}
+	if rule.Window <= 0 {
+		rule.Window = time.Minute
+	}
+
+	return rule
+}
+
+// CalDAVRule returns the rule of the CalDAV routes, they are limited by the user since a calendar client syncs
+// with bursts of requests that would soon run into the limit of the logins
+func CalDAVRule(e *env.Env) Rule {
+	rule := Rule{
+		Limit:  int64(e.RateLimitCalDAV),
+		Window: e.RateLimitCalDAVWindow,
+	}
+	if rule.Limit == 0 {
+		rule.Limit = 600
+	}
+	if rule.Window <= 0 {
+		rule.Window = time.Minute
+	}
+
+	return rule
+}
+
+// AppPasswordRule returns the rule of the failed app password attempts, they are limited by the IP address of
+// the client and by the account that they were made for
+func AppPasswordRule(e *env.Env) Rule {
+	rule := Rule{
+		Limit:  int64(e.RateLimitAppPassword),
+		Window: e.RateLimitAppPasswordWindow,
+	}
+	if rule.Limit == 0 {
+		rule.Limit = 10
+	}
+	if rule.Window <= 0 {
+		rule.Window = 15 * time.Minute
+	}
+
+	return rule
+}
+
+// allowScript counts the request only if it is allowed, so that clients that keep retrying while they are
+// limited do not push the time that they can make requests again further away
+var allowScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local previous = tonumber(redis.call("GET", KEYS[2]) or "0")
+if previous * tonumber(ARGV[1]) + current + 1 > tonumber(ARGV[2]) then
+	return {0, current, previous}
+end
+if ARGV[4] == "0" then
+	return {1, current, previous}
+end
+current = redis.call("INCR", KEYS[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return {1, current, previous}
+`)
+
+// locals are the in memory counters of each group, they are shared by the limiters of the group so that routes
+// that are mounted more than once still count against the same limit
+var locals sync.Map
+
+// Limiter limits the requests of a group of routes
+type Limiter struct {
+	R     *redis.Client
+	Group string
+	Rule  Rule
+	local *local
+}
+
+// New creates a new limiter for the group of routes
+func New(r *redis.Client, group string, rule Rule) *Limiter {
+	l, _ := locals.LoadOrStore(group, &local{
+		counters: map[string]*counter{},
+	})
+
+	return &Limiter{
+		R:     r,
+		Group: group,
+		Rule:  rule,
+		local: l.(*local),
+	}
+}
+
+// Result is the outcome of a request, Reset is the time until the current window ends and RetryAfter is the time
+// until a request that was not allowed would be
+type Result struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Disabled returns true if the limiter lets every request through
+func (l *Limiter) Disabled() bool {
+	return l.Rule.Limit < 0
+}
+
+// Allow counts a request of the key if it is within the limit
+func (l *Limiter) Allow(ctx context.Context, key string) Result {
+	return l.take(ctx, key, true)
+}
+
+// Check tells if a request of the key would be within the limit without counting it, it is used when only some
+// of the requests count like the failed attempts of a password
+func (l *Limiter) Check(ctx context.Context, key string) Result {
+	return l.take(ctx, key, false)
+}
+
+func (l *Limiter) take(ctx context.Context, key string, count bool) Result {
+	now := time.Now()
+	window := now.UnixNano() / int64(l.Rule.Window)
+	elapsed := time.Duration(now.UnixNano() - window*int64(l.Rule.Window))
+	weight := 1 - float64(elapsed)/float64(l.Rule.Window)
+
+	allowed, current, previous, err := l.allowRedis(ctx, key, window, weight, count)
+	if err != nil {
+		log.Error().Err(err).Str("group", l.Group).Msg("failed to rate limit with Redis, falling back to memory")
+		allowed, current, previous = l.local.allow(key, window, weight, l.Rule.Limit, count)
+	}
+
+	return l.result(allowed, current, previous, elapsed, weight)
+}
+
+func (l *Limiter) allowRedis(
+	ctx context.Context,
+	key string,
+	window int64,
+	weight float64,
+	count bool,
+) (bool, int64, int64, error) {
+	res, err := allowScript.Run(
+		ctx,
+		l.R,
+		[]string{
+			rdb.RateLimitKey(l.Group, key, window),
+			rdb.RateLimitKey(l.Group, key, window-1),
+		},
+		weight,
+		l.Rule.Limit,
+		(2 * l.Rule.Window).Milliseconds(),
+		count,
+	).Int64Slice()
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	return res[0] == 1, res[1], res[2], nil
+}
+
+func (l *Limiter) result(allowed bool, current, previous int64, elapsed time.Duration, weight float64) Result {
+	limit := l.Rule.Limit
+	window := l.Rule.Window
+
+	res := Result{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: max(limit-int64(math.Ceil(float64(previous)*weight+float64(current))), 0),
+		Reset:     window - elapsed,
+	}
+	if allowed {
+		return res
+	}
+
+	if current+1 > limit {
+		// the requests of this window have to slide far enough out of the next one
+		res.RetryAfter = res.Reset + time.Duration(float64(window)*(1-float64(limit-1)/float64(current)))
+	} else {
+		// the requests of the previous window have to slide out of this one
+		res.RetryAfter = time.Duration(float64(window)*(1-float64(limit-current-1)/float64(previous))) - elapsed
+	}
+	res.RetryAfter = max(res.RetryAfter, 0)
+
+	return res
+}
+
+type counter struct {
+	window   int64
+	current  int64
+	previous int64
+}
+
+// local counts the requests in memory while Redis can not be reached, each gateway only sees its own requests
+// so the limits are looser until Redis is back
+type local struct {
+	mu       sync.Mutex
+	counters map[string]*counter
+	swept    int64
+}
+
+func (l *local) allow(key string, window int64, weight float64, limit int64, count bool) (bool, int64, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the counters that are older than the previous window do not count anymore
+	if l.swept != window {
+		for k, c := range l.counters {
+			if c.window < window-1 {
+				delete(l.counters, k)
+			}
+		}
+		l.swept = window
+	}
+
+	c, ok := l.counters[key]
+	if !ok {
+		c = &counter{window: window}
+		l.counters[key] = c
+	}
+	switch {
+	case c.window == window-1:
+		c.previous, c.current = c.current, 0
+	case c.window < window-1:
+		c.previous, c.current = 0, 0
+	}
+	c.window = window
+
+	if float64(c.previous)*weight+float64(c.current)+1 > float64(limit) {
+		return false, c.current, c.previous
+	}
+	if !count {
+		return true, c.current, c.previous
+	}
+	c.current++
+
+	return true, c.current, c.previous
+}
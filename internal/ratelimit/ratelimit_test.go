@@ -0,0 +1,47 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCheckDoesNotCount(t *testing.T) {
+	r := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { r.Close() })
+
+	// a Redis that can not be reached falls back to the in memory counters
+	down := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	t.Cleanup(func() { down.Close() })
+
+	for name, client := range map[string]*redis.Client{"redis": r, "memory": down} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			l := New(client, "test_"+name, Rule{Limit: 3, Window: time.Hour})
+
+			for i := 0; i < 10; i++ {
+				if !l.Check(ctx, "key").Allowed {
+					t.Fatalf("check %d was limited, want checks to not count", i+1)
+				}
+			}
+
+			for i := 0; i < 3; i++ {
+				if !l.Allow(ctx, "key").Allowed {
+					t.Fatalf("request %d was limited, want the first 3 to be allowed", i+1)
+				}
+			}
+			if res := l.Check(ctx, "key"); res.Allowed || res.RetryAfter <= 0 {
+				t.Fatalf("check = %+v, want the key to be limited once it used up the limit", res)
+			}
+			if res := l.Allow(ctx, "key"); res.Allowed {
+				t.Fatalf("request = %+v, want the key to be limited once it used up the limit", res)
+			}
+			if !l.Check(ctx, "other").Allowed {
+				t.Fatal("another key was limited")
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package redis
 
 import (
 	"fmt"
+	"time"
 
 	env "github.com/VinukaThejana/todoapp/internal/config"
 )
@@ -16,6 +17,12 @@ func RefreshTokenTTL(e *env.Env) int {
 	return int(e.RefreshTokenExpiresIn.Seconds())
 }
 
+// RefreshFamilyKey returns the key for the jti of the latest refresh token of a family, every refresh token that
+// was rotated out of the session shares the family, which is the id of the session
+func RefreshFamilyKey(family string) string {
+	return fmt.Sprintf("refresh_family:%s", family)
+}
+
 // AccessTokenKey returns the key for an access token
 func AccessTokenKey(jti string) string {
 	return fmt.Sprintf("access_token:%s", jti)
@@ -25,3 +32,173 @@ func AccessTokenKey(jti string) string {
 func AccessTokenTTL(e *env.Env) int {
 	return int(e.AccessTokenExpiresIn.Seconds())
 }
+
+// StatsKey returns the key for the cached statistics of a user or a workspace, the version changes whenever
+// the todos change so stale statistics are never served
+func StatsKey(scope string, version int64, params string) string {
+	return fmt.Sprintf("stats:%s:%d:%s", scope, version, params)
+}
+
+// StatsVersionKey returns the key for the version of the statistics of a user or a workspace
+func StatsVersionKey(scope string) string {
+	return fmt.Sprintf("stats_version:%s", scope)
+}
+
+// StatsTTL returns the TTL for the cached statistics
+func StatsTTL(e *env.Env) time.Duration {
+	if e.StatsCacheTTL == 0 {
+		return 5 * time.Minute
+	}
+
+	return e.StatsCacheTTL
+}
+
+// NotificationChannel returns the pub/sub channel that new inbox notifications of a user are published to as JSON
+func NotificationChannel(userID uint) string {
+	return fmt.Sprintf("notifications:%d", userID)
+}
+
+// RequestsKey returns the key for the number of API requests that a user made on the given day (YYYY-MM-DD, UTC)
+func RequestsKey(userID uint, day string) string {
+	return fmt.Sprintf("requests:%d:%s", userID, day)
+}
+
+// EmailVerificationKey returns the key for an email verification token, only the hash of the token is used
+func EmailVerificationKey(hash string) string {
+	return fmt.Sprintf("email_verification:%s", hash)
+}
+
+// EmailVerificationUserKey returns the key for the hash of the latest email verification token of a user,
+// it is used to make the older tokens unusable when a new one is sent
+func EmailVerificationUserKey(userID uint) string {
+	return fmt.Sprintf("email_verification_user:%d", userID)
+}
+
+// EmailVerificationCooldownKey returns the key that is set while a user has to wait before another
+// verification email is sent
+func EmailVerificationCooldownKey(userID uint) string {
+	return fmt.Sprintf("email_verification_cooldown:%d", userID)
+}
+
+// EmailVerificationTTL returns the TTL for an email verification token
+func EmailVerificationTTL(e *env.Env) time.Duration {
+	if e.EmailVerificationTTL == 0 {
+		return 24 * time.Hour
+	}
+
+	return e.EmailVerificationTTL
+}
+
+// PasswordResetKey returns the key for a password reset token, only the hash of the token is used
+func PasswordResetKey(hash string) string {
+	return fmt.Sprintf("password_reset:%s", hash)
+}
+
+// PasswordResetUserKey returns the key for the hash of the latest password reset token of a user
+func PasswordResetUserKey(userID uint) string {
+	return fmt.Sprintf("password_reset_user:%d", userID)
+}
+
+// PasswordResetRateKey returns the key for the number of password resets that were asked for an email,
+// the email is hashed so that the addresses people type in are not kept around
+func PasswordResetRateKey(emailHash string) string {
+	return fmt.Sprintf("password_reset_rate:%s", emailHash)
+}
+
+// PasswordResetTTL returns the TTL for a password reset token
+func PasswordResetTTL(e *env.Env) time.Duration {
+	if e.PasswordResetTTL == 0 {
+		return 30 * time.Minute
+	}
+
+	return e.PasswordResetTTL
+}
+
+// SecondFactorKey returns the key for a challenge token that is given out at login to users who have to
+// prove a second factor, only the hash of the token is used
+func SecondFactorKey(hash string) string {
+	return fmt.Sprintf("second_factor:%s", hash)
+}
+
+// SecondFactorAttemptsKey returns the key for the number of failed attempts to complete a challenge
+func SecondFactorAttemptsKey(hash string) string {
+	return fmt.Sprintf("second_factor_attempts:%s", hash)
+}
+
+// WebAuthnKey returns the key for the session data of a passkey registration or login, the challenge that was
+// sent to the browser identifies the ceremony
+func WebAuthnKey(challenge string) string {
+	return fmt.Sprintf("webauthn:%s", challenge)
+}
+
+// OIDCStateKey returns the key for the state of a login with an OpenID Connect provider, it holds the PKCE
+// verifier and the nonce until the provider redirects back
+func OIDCStateKey(state string) string {
+	return fmt.Sprintf("oidc_state:%s", state)
+}
+
+// LoginFailuresKey returns the key for the number of failed logins with an account, the account is the hash of
+// the username or the email that was typed in so that accounts that do not exist are counted the same way
+func LoginFailuresKey(account string) string {
+	return fmt.Sprintf("login_failures:%s", account)
+}
+
+// LoginFailuresIPKey returns the key for the number of failed logins from an IP address
+func LoginFailuresIPKey(ip string) string {
+	return fmt.Sprintf("login_failures_ip:%s", ip)
+}
+
+// LockoutKey returns the key that is set while an account is locked out after too many failed logins
+func LockoutKey(account string) string {
+	return fmt.Sprintf("lockout:%s", account)
+}
+
+// LockoutIPKey returns the key that is set while an IP address is locked out after too many failed logins
+func LockoutIPKey(ip string) string {
+	return fmt.Sprintf("lockout_ip:%s", ip)
+}
+
+// LoginMetricsKey is the key for the hash with the counters of failed logins and lockouts
+const LoginMetricsKey = "login_metrics"
+
+// LockoutTTL returns how long an account or an IP address stays locked out, failures are counted over the
+// same window
+func LockoutTTL(e *env.Env) time.Duration {
+	if e.LoginLockoutDuration == 0 {
+		return 15 * time.Minute
+	}
+
+	return e.LoginLockoutDuration
+}
+
+// RateLimitKey returns the key for the number of requests that were let through for a key of a rate limited
+// group of routes in the given window, the window is the number of windows since the Unix epoch
+func RateLimitKey(group, key string, window int64) string {
+	return fmt.Sprintf("rate_limit:%s:%s:%d", group, key, window)
+}
+
+// DataExportKey returns the key for the archive of a data export, only the hash of the id in the download link
+// is used
+func DataExportKey(hash string) string {
+	return fmt.Sprintf("data_export:%s", hash)
+}
+
+// DataExportUserKey returns the key for the hash of the latest data export of a user, the archive of the export
+// before it is deleted when a new one is made
+func DataExportUserKey(userID uint) string {
+	return fmt.Sprintf("data_export_user:%d", userID)
+}
+
+// DataExportCooldownKey returns the key that is set while a user has to wait before another data export is made
+func DataExportCooldownKey(userID uint) string {
+	return fmt.Sprintf("data_export_cooldown:%d", userID)
+}
+
+// DataExportTTL returns how long the archive of a data export can be downloaded
+func DataExportTTL(e *env.Env) time.Duration {
+	if e.DataExportTTL == 0 {
+		return 24 * time.Hour
+	}
+
+	return e.DataExportTTL
+}
@@ -0,0 +1,401 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	pb "github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+var errDependencyCycle = errors.New("dependency cycle")
+
+// createsCycle checks if making todo blocked by blocker would create a cycle, that is the case when
+// the todo is already (directly or indirectly) blocking the blocker
+func createsCycle(dependencies []database.TodoDependency, todo, blocker uint) bool {
+	if todo == blocker {
+		return true
+	}
+
+	blockedBy := map[uint][]uint{}
+	for _, dependency := range dependencies {
+		blockedBy[dependency.TodoID] = append(blockedBy[dependency.TodoID], dependency.BlockedByID)
+	}
+
+	visited := map[uint]bool{blocker: true}
+	queue := []uint{blocker}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, next := range blockedBy[current] {
+			if next == todo {
+				return true
+			}
+			if !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return false
+}
+
+// topologicalOrder orders the todos so that each todo comes after all of its blockers, todos that
+// are not ordered by a dependency keep the order of their ids
+func topologicalOrder(todos []*database.Todo, dependencies []database.TodoDependency) []*database.Todo {
+	byID := map[uint]*database.Todo{}
+	inDegree := map[uint]int{}
+	for _, todo := range todos {
+		byID[todo.ID] = todo
+		inDegree[todo.ID] = 0
+	}
+
+	blocking := map[uint][]uint{}
+	for _, dependency := range dependencies {
+		if byID[dependency.TodoID] == nil || byID[dependency.BlockedByID] == nil {
+			continue
+		}
+		blocking[dependency.BlockedByID] = append(blocking[dependency.BlockedByID], dependency.TodoID)
+		inDegree[dependency.TodoID]++
+	}
+
+	ready := []uint{}
+	for id, degree := range inDegree {
+		if degree == 0 {
+			ready = append(ready, id)
+		}
+	}
+
+	ordered := []*database.Todo{}
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return ready[i] < ready[j] })
+		current := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, byID[current])
+
+		for _, next := range blocking[current] {
+			inDegree[next]--
+			if inDegree[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+
+	return ordered
+}
+
+// openBlockers returns the number of todos that block the given todo and are not completed yet
+func (s *Server) openBlockers(todoID uint) (int64, error) {
+	var count int64
+
+	err := s.DB.Model(&database.TodoDependency{}).
+		Joins("JOIN todos ON todos.id = todo_dependencies.blocked_by_id AND todos.deleted_at IS NULL").
+		Where("todo_dependencies.todo_id = ? AND todos.completed = ?", todoID, false).
+		Count(&count).Error
+
+	return count, err
+}
+
+// blockedPolicy returns the configured policy for completing todos with open blockers
+func (s *Server) blockedPolicy() enums.BlockedPolicy {
+	if s.E == nil || s.E.BlockedPolicy == "" {
+		return enums.Reject
+	}
+
+	return enums.BlockedPolicy(s.E.BlockedPolicy)
+}
+
+// dependencyIDs returns the ids of the todos that block the given todo and the ids of the todos
+// that are blocked by it
+func (s *Server) dependencyIDs(todoID uint) (blockedBy []string, blocking []string, err error) {
+	dependencies := []database.TodoDependency{}
+
+	err = s.DB.
+		Joins("JOIN todos blocked ON blocked.id = todo_dependencies.todo_id AND blocked.deleted_at IS NULL").
+		Joins("JOIN todos blocker ON blocker.id = todo_dependencies.blocked_by_id AND blocker.deleted_at IS NULL").
+		Where("todo_dependencies.todo_id = ? OR todo_dependencies.blocked_by_id = ?", todoID, todoID).
+		Find(&dependencies).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blockedBy, blocking = []string{}, []string{}
+	for _, dependency := range dependencies {
+		if dependency.TodoID == todoID {
+			blockedBy = append(blockedBy, fmt.Sprint(dependency.BlockedByID))
+		} else {
+			blocking = append(blocking, fmt.Sprint(dependency.TodoID))
+		}
+	}
+
+	return blockedBy, blocking, nil
+}
+
+// AddDependency is a gRPC endpoint to mark a todo as blocked by another todo of the same user
+// returns Internal, NotFound, InvalidArgument, FailedPrecondition, AlreadyExists, PermissionDenied, nil
+func (s *Server) AddDependency(ctx context.Context, req *pb.AddDependencyRequest) (*pb.AddDependencyResponse, error) {
+	todoID, err := strconv.ParseUint(req.Id, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse todo id")
+		return &pb.AddDependencyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse todo id")
+	}
+	blockedByID, err := strconv.ParseUint(req.BlockedById, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse blocker id")
+		return &pb.AddDependencyResponse{
+			Success: false,
+		}, status.Error(codes.InvalidArgument, "failed to parse blocker id")
+	}
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.AddDependencyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	sc, err := s.scopeOf(ctx, uint(userID))
+	if err != nil {
+		return &pb.AddDependencyResponse{
+			Success: false,
+		}, err
+	}
+	if !sc.canWrite() {
+		return &pb.AddDependencyResponse{
+			Success: false,
+		}, errReadOnly
+	}
+
+	if todoID == blockedByID {
+		return &pb.AddDependencyResponse{
+			Success: false,
+			Message: "A todo cannot block itself",
+		}, status.Error(codes.InvalidArgument, "a todo cannot block itself")
+	}
+
+	var count int64
+	err = sc.todos(s.DB.Model(&database.Todo{})).
+		Where("todos.id IN ?", []uint64{todoID, blockedByID}).
+		Count(&count).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the todos")
+		return &pb.AddDependencyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the todos")
+	}
+	if count != 2 {
+		return &pb.AddDependencyResponse{
+			Success: false,
+			Message: "Todo not found",
+		}, status.Error(codes.NotFound, "todo not found")
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		err := sc.lock(tx)
+		if err != nil {
+			return err
+		}
+
+		dependencies := []database.TodoDependency{}
+		err = sc.dependencies(tx).Find(&dependencies).Error
+		if err != nil {
+			return err
+		}
+
+		if createsCycle(dependencies, uint(todoID), uint(blockedByID)) {
+			return errDependencyCycle
+		}
+
+		dependency := &database.TodoDependency{
+			TodoID:      uint(todoID),
+			BlockedByID: uint(blockedByID),
+			UserID:      uint(userID),
+			WorkspaceID: sc.WorkspaceID,
+		}
+		err = tx.Create(dependency).Error
+		if err != nil {
+			return err
+		}
+
+		// check again with the dependencies that were added since they were read, rolling back the
+		// dependency if another request closed a cycle with it in the meantime
+		dependencies = []database.TodoDependency{}
+		err = sc.dependencies(tx).Where("todo_dependencies.id <> ?", dependency.ID).Find(&dependencies).Error
+		if err != nil {
+			return err
+		}
+		if createsCycle(dependencies, uint(todoID), uint(blockedByID)) {
+			return errDependencyCycle
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to add the dependency")
+
+		if errors.Is(err, errDependencyCycle) {
+			return &pb.AddDependencyResponse{
+				Success: false,
+				Message: "The dependency would create a cycle",
+			}, status.Error(codes.FailedPrecondition, "the dependency would create a cycle")
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return &pb.AddDependencyResponse{
+				Success: false,
+				Message: "Dependency already exists",
+			}, status.Error(codes.AlreadyExists, "dependency already exists")
+		}
+
+		return &pb.AddDependencyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to add the dependency")
+	}
+
+	return &pb.AddDependencyResponse{
+		Success: true,
+		Message: "Dependency added successfully",
+	}, nil
+}
+
+// RemoveDependency is a gRPC endpoint to remove a dependency between two todos
+// returns Internal, InvalidArgument, NotFound, PermissionDenied, nil
+func (s *Server) RemoveDependency(ctx context.Context, req *pb.RemoveDependencyRequest) (*pb.RemoveDependencyResponse, error) {
+	todoID, err := strconv.ParseUint(req.Id, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse todo id")
+		return &pb.RemoveDependencyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse todo id")
+	}
+	blockedByID, err := strconv.ParseUint(req.BlockedById, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse blocker id")
+		return &pb.RemoveDependencyResponse{
+			Success: false,
+		}, status.Error(codes.InvalidArgument, "failed to parse blocker id")
+	}
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.RemoveDependencyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	sc, err := s.scopeOf(ctx, uint(userID))
+	if err != nil {
+		return &pb.RemoveDependencyResponse{
+			Success: false,
+		}, err
+	}
+	if !sc.canWrite() {
+		return &pb.RemoveDependencyResponse{
+			Success: false,
+		}, errReadOnly
+	}
+
+	result := sc.dependencies(s.DB).
+		Where("todo_dependencies.todo_id = ? AND todo_dependencies.blocked_by_id = ?", todoID, blockedByID).
+		Delete(&database.TodoDependency{})
+	if result.Error != nil {
+		log.Error().Err(result.Error).Msg("failed to remove the dependency")
+		return &pb.RemoveDependencyResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to remove the dependency")
+	}
+	if result.RowsAffected == 0 {
+		return &pb.RemoveDependencyResponse{
+			Success: false,
+			Message: "Dependency not found",
+		}, status.Error(codes.NotFound, "dependency not found")
+	}
+
+	return &pb.RemoveDependencyResponse{
+		Success: true,
+		Message: "Dependency removed successfully",
+	}, nil
+}
+
+// DependencyGraph is a gRPC endpoint to get the todos of the user in topological order, blockers
+// always come before the todos they block
+// returns Internal, InvalidArgument, NotFound, nil
+func (s *Server) DependencyGraph(ctx context.Context, req *pb.DependencyGraphRequest) (*pb.DependencyGraphResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.DependencyGraphResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	sc, err := s.scopeOf(ctx, uint(userID))
+	if err != nil {
+		return &pb.DependencyGraphResponse{
+			Success: false,
+		}, err
+	}
+
+	workflow, err := s.loadWorkflow(sc.OwnerID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the workflow")
+		return &pb.DependencyGraphResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the workflow")
+	}
+
+	todos := []*database.Todo{}
+	err = sc.todos(s.DB.Preload("Tags")).Find(&todos).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the todos")
+		return &pb.DependencyGraphResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the todos")
+	}
+
+	dependencies := []database.TodoDependency{}
+	err = sc.dependencies(s.DB).Find(&dependencies).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the dependencies")
+		return &pb.DependencyGraphResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the dependencies")
+	}
+
+	exists := map[uint]bool{}
+	for _, todo := range todos {
+		exists[todo.ID] = true
+	}
+
+	pbTodos := []*pb.Todo{}
+	for _, todo := range topologicalOrder(todos, dependencies) {
+		pbTodos = append(pbTodos, toPB(todo, stateOf(workflow, todo)))
+	}
+	pbDependencies := []*pb.Dependency{}
+	for _, dependency := range dependencies {
+		if !exists[dependency.TodoID] || !exists[dependency.BlockedByID] {
+			continue
+		}
+		pbDependencies = append(pbDependencies, &pb.Dependency{
+			TodoId:      fmt.Sprint(dependency.TodoID),
+			BlockedById: fmt.Sprint(dependency.BlockedByID),
+		})
+	}
+
+	return &pb.DependencyGraphResponse{
+		Success:      true,
+		Todos:        pbTodos,
+		Dependencies: pbDependencies,
+	}, nil
+}
@@ -0,0 +1,78 @@
+package todo
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"gorm.io/gorm"
+)
+
+// edges builds the dependencies from pairs of todo and blocker ids
+func edges(pairs ...[2]uint) []database.TodoDependency {
+	dependencies := []database.TodoDependency{}
+	for _, pair := range pairs {
+		dependencies = append(dependencies, database.TodoDependency{TodoID: pair[0], BlockedByID: pair[1]})
+	}
+
+	return dependencies
+}
+
+func TestCreatesCycle(t *testing.T) {
+	tests := []struct {
+		name         string
+		dependencies []database.TodoDependency
+		todo         uint
+		blocker      uint
+		want         bool
+	}{
+		{"self loop", nil, 1, 1, true},
+		{"no dependencies", nil, 1, 2, false},
+		{"direct cycle", edges([2]uint{2, 1}), 1, 2, true},
+		{"transitive cycle", edges([2]uint{2, 1}, [2]uint{3, 2}), 1, 3, true},
+		{"same direction", edges([2]uint{2, 1}, [2]uint{3, 2}), 3, 1, false},
+		{"diamond", edges([2]uint{2, 1}, [2]uint{3, 1}, [2]uint{4, 2}, [2]uint{4, 3}), 4, 1, false},
+		{"closing a diamond", edges([2]uint{2, 1}, [2]uint{3, 1}, [2]uint{4, 2}, [2]uint{4, 3}), 1, 4, true},
+		{"disconnected", edges([2]uint{2, 1}, [2]uint{4, 3}), 3, 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := createsCycle(tt.dependencies, tt.todo, tt.blocker); got != tt.want {
+				t.Fatalf("createsCycle(%d, %d) = %v, want %v", tt.todo, tt.blocker, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTopologicalOrder(t *testing.T) {
+	tests := []struct {
+		name         string
+		ids          []uint
+		dependencies []database.TodoDependency
+		want         []uint
+	}{
+		{"no dependencies", []uint{3, 1, 2}, nil, []uint{1, 2, 3}},
+		{"chain", []uint{1, 2, 3}, edges([2]uint{1, 2}, [2]uint{2, 3}), []uint{3, 2, 1}},
+		{"diamond", []uint{1, 2, 3, 4}, edges([2]uint{2, 1}, [2]uint{3, 1}, [2]uint{4, 2}, [2]uint{4, 3}), []uint{1, 2, 3, 4}},
+		{"disconnected", []uint{1, 2, 3, 4}, edges([2]uint{1, 4}), []uint{2, 3, 4, 1}},
+		{"blocker outside the todos", []uint{1, 2}, edges([2]uint{1, 5}), []uint{1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			todos := []*database.Todo{}
+			for _, id := range tt.ids {
+				todos = append(todos, &database.Todo{Model: gorm.Model{ID: id}})
+			}
+
+			got := []uint{}
+			for _, todo := range topologicalOrder(todos, tt.dependencies) {
+				got = append(got, todo.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("topologicalOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/filter"
+	pb "github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// parseQuery parses the query and converts the parse errors to InvalidArgument errors that carry the position
+func parseQuery(query string) (filter.Node, error) {
+	node, err := filter.Parse(query)
+	if err != nil {
+		var filterErr *filter.Error
+		if errors.As(err, &filterErr) {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid query at position %d: %s", filterErr.Pos, filterErr.Msg))
+		}
+		return nil, status.Error(codes.InvalidArgument, "invalid query")
+	}
+
+	return node, nil
+}
+
+func filterToPB(f *database.Filter) *pb.Filter {
+	return &pb.Filter{
+		Id:    fmt.Sprint(f.ID),
+		Name:  f.Name,
+		Query: f.Query,
+	}
+}
+
+// CreateFilter is a gRPC endpoint to save a query as a named filter
+// returns Internal, InvalidArgument, AlreadyExists, nil
+func (s *Server) CreateFilter(ctx context.Context, req *pb.CreateFilterRequest) (*pb.CreateFilterResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.CreateFilterResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 50 {
+		return &pb.CreateFilterResponse{
+			Success: false,
+			Message: "Invalid filter name",
+		}, status.Error(codes.InvalidArgument, "the name must be between 1 and 50 characters")
+	}
+
+	_, err = parseQuery(req.Query)
+	if err != nil {
+		return &pb.CreateFilterResponse{
+			Success: false,
+			Message: "Invalid query",
+		}, err
+	}
+
+	f := &database.Filter{
+		Name:   name,
+		Query:  req.Query,
+		UserID: uint(userID),
+	}
+
+	err = s.DB.Create(f).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return &pb.CreateFilterResponse{
+				Success: false,
+				Message: "Filter already exists",
+			}, status.Error(codes.AlreadyExists, "a filter with the same name already exists")
+		}
+
+		log.Error().Err(err).Msg("failed to create the filter")
+		return &pb.CreateFilterResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to create the filter")
+	}
+
+	return &pb.CreateFilterResponse{
+		Success: true,
+		Filter:  filterToPB(f),
+	}, nil
+}
+
+// ListFilters is a gRPC endpoint to get the saved filters of the user
+// returns Internal, nil
+func (s *Server) ListFilters(ctx context.Context, req *pb.ListFiltersRequest) (*pb.ListFiltersResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.ListFiltersResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	filters := []*database.Filter{}
+
+	err = s.DB.Where("user_id = ?", userID).Order("name").Find(&filters).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the filters")
+		return &pb.ListFiltersResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the filters")
+	}
+
+	res := &pb.ListFiltersResponse{
+		Success: true,
+		Filters: []*pb.Filter{},
+	}
+	for _, f := range filters {
+		res.Filters = append(res.Filters, filterToPB(f))
+	}
+
+	return res, nil
+}
+
+// DeleteFilter is a gRPC endpoint to delete a saved filter
+// returns Internal, NotFound, nil
+func (s *Server) DeleteFilter(ctx context.Context, req *pb.DeleteFilterRequest) (*pb.DeleteFilterResponse, error) {
+	filterID, err := strconv.ParseUint(req.Id, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse filter id")
+		return &pb.DeleteFilterResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse filter id")
+	}
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.DeleteFilterResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	// the filter is removed for good so that the name can be used again
+	result := s.DB.Unscoped().Where("id = ? AND user_id = ?", filterID, userID).Delete(&database.Filter{})
+	if result.Error != nil {
+		log.Error().Err(result.Error).Msg("failed to delete the filter")
+		return &pb.DeleteFilterResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to delete the filter")
+	}
+	if result.RowsAffected == 0 {
+		return &pb.DeleteFilterResponse{
+			Success: false,
+			Message: "Filter not found",
+		}, status.Error(codes.NotFound, "filter not found")
+	}
+
+	return &pb.DeleteFilterResponse{
+		Success: true,
+	}, nil
+}
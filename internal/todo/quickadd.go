@@ -0,0 +1,100 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/quickadd"
+	pb "github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// QuickAdd is a gRPC endpoint to create a todo from free text, dates are resolved in the time zone of the user
+// returns Internal, InvalidArgument, NotFound, PermissionDenied, ResourceExhausted, nil
+func (s *Server) QuickAdd(ctx context.Context, req *pb.QuickAddRequest) (*pb.QuickAddResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.QuickAddResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	sc, err := s.scopeOf(ctx, uint(userID))
+	if err != nil {
+		return &pb.QuickAddResponse{
+			Success: false,
+		}, err
+	}
+	if !sc.canWrite() {
+		return &pb.QuickAddResponse{
+			Success: false,
+		}, errReadOnly
+	}
+
+	loc := time.UTC
+	if req.TimeZone != "" {
+		loc, err = time.LoadLocation(req.TimeZone)
+		if err != nil {
+			return &pb.QuickAddResponse{
+				Success: false,
+				Message: "Invalid time zone",
+			}, status.Error(codes.InvalidArgument, "invalid time zone")
+		}
+	}
+
+	res, err := quickadd.Parse(req.Text, time.Now().In(loc))
+	if err != nil {
+		if errors.Is(err, quickadd.ErrEmptyTitle) {
+			return &pb.QuickAddResponse{
+				Success: false,
+				Message: "The todo must have a title",
+			}, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		log.Error().Err(err).Msg("failed to parse the todo")
+		return &pb.QuickAddResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse the todo")
+	}
+
+	createReq := &pb.CreateRequest{
+		Title:      res.Title,
+		Tags:       res.Tags,
+		Priority:   string(res.Priority),
+		Project:    res.Project,
+		Recurrence: res.Recurrence,
+	}
+	if res.Due != nil {
+		createReq.DueAt = res.Due.Format(time.RFC3339)
+	}
+
+	todo, err := s.createTodo(ctx, sc, createReq)
+	if err != nil {
+		return &pb.QuickAddResponse{
+			Success: false,
+		}, err
+	}
+
+	tokens := []*pb.QuickAddToken{}
+	for _, token := range res.Tokens {
+		tokens = append(tokens, &pb.QuickAddToken{
+			Kind:  string(token.Kind),
+			Text:  token.Text,
+			Value: token.Value,
+			Start: int32(token.Start),
+			End:   int32(token.End),
+		})
+	}
+
+	return &pb.QuickAddResponse{
+		Success: true,
+		Message: "Todo created successfully",
+		Todo:    todo,
+		Tokens:  tokens,
+	}, nil
+}
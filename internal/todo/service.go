@@ -6,9 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
 	env "github.com/VinukaThejana/todoapp/internal/config"
 	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/internal/filter"
+	"github.com/VinukaThejana/todoapp/internal/quota"
 	pb "github.com/VinukaThejana/todoapp/pkg/todo"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
@@ -35,7 +40,7 @@ func NewServer(e *env.Env, db *gorm.DB, r *redis.Client) *Server {
 }
 
 // Create is a gRPC endpoint to create a new todo
-// returns Internal, nil
+// returns Internal, InvalidArgument, NotFound, PermissionDenied, ResourceExhausted, nil
 func (s *Server) Create(ctx context.Context, req *pb.CreateRequest) (*pb.CreateResponse, error) {
 	userID, err := strconv.ParseUint(req.UserId, 10, 64)
 	if err != nil {
@@ -45,34 +50,166 @@ func (s *Server) Create(ctx context.Context, req *pb.CreateRequest) (*pb.CreateR
 		}, status.Error(codes.Internal, "failed to parse user id")
 	}
 
-	todo := &database.Todo{
-		Title:       req.Title,
-		UserID:      uint(userID),
-		Completed:   false,
-		Content:     req.Content,
-		Description: req.Description,
+	sc, err := s.scopeOf(ctx, uint(userID))
+	if err != nil {
+		return &pb.CreateResponse{
+			Success: false,
+		}, err
+	}
+	if !sc.canWrite() {
+		return &pb.CreateResponse{
+			Success: false,
+		}, errReadOnly
 	}
 
-	err = s.DB.Create(&todo).Error
+	todo, err := s.createTodo(ctx, sc, req)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to create the todo")
 		return &pb.CreateResponse{
 			Success: false,
-		}, status.Error(codes.Internal, "failed to create the todo")
+		}, err
 	}
 
 	return &pb.CreateResponse{
 		Success: true,
 		Message: "Todo created successfully",
+		Todo:    todo,
 	}, nil
 }
 
+// normalizeTags lower cases the tags and drops the empty and the duplicated ones
+func normalizeTags(names []string) []database.TodoTag {
+	tags := []database.TodoTag{}
+	seen := map[string]bool{}
+	for _, tag := range names {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, database.TodoTag{Name: tag})
+	}
+
+	return tags
+}
+
+// createTodo validates and stores a new todo in the scope, the returned error is a gRPC status
+func (s *Server) createTodo(ctx context.Context, sc *scope, req *pb.CreateRequest) (*pb.Todo, error) {
+	workflow, err := s.loadWorkflow(sc.OwnerID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the workflow")
+		return nil, status.Error(codes.Internal, "failed to get the workflow")
+	}
+
+	state := initialState(workflow)
+	if req.State != "" {
+		var ok bool
+		state, ok = findState(workflow, req.State)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "the state is not part of the workflow")
+		}
+	}
+
+	switch enums.Priority(req.Priority) {
+	case "", enums.Low, enums.Medium, enums.High, enums.Urgent:
+	default:
+		return nil, status.Error(codes.InvalidArgument, "invalid priority")
+	}
+
+	var dueAt *time.Time
+	if req.DueAt != "" {
+		due, err := time.Parse(time.RFC3339, req.DueAt)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "due date must be in the RFC 3339 format")
+		}
+		dueAt = &due
+	}
+
+	if len(req.CalendarUid) > 255 || len(req.CalendarName) > 255 {
+		return nil, status.Error(codes.InvalidArgument, "the calendar uid and name must not be longer than 255 characters")
+	}
+
+	_, limits, err := quota.Of(s.DB, sc.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the quotas")
+		return nil, status.Error(codes.Internal, "failed to get the quotas")
+	}
+
+	tags := normalizeTags(req.Tags)
+
+	var completedAt *time.Time
+	if state.Terminal {
+		now := time.Now()
+		completedAt = &now
+	}
+
+	todo := &database.Todo{
+		Title:        req.Title,
+		UserID:       sc.UserID,
+		WorkspaceID:  sc.WorkspaceID,
+		Completed:    state.Terminal,
+		CompletedAt:  completedAt,
+		State:        state.Name,
+		Content:      req.Content,
+		Description:  req.Description,
+		Priority:     req.Priority,
+		Project:      req.Project,
+		DueAt:        dueAt,
+		Recurrence:   req.Recurrence,
+		Tags:         tags,
+		CalendarUID:  req.CalendarUid,
+		CalendarName: req.CalendarName,
+	}
+
+	// the quotas are counted in the transaction that creates the todo so that concurrent requests can not
+	// all pass the check before any of them is stored
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		err := quota.CheckCreate(tx, sc.UserID, limits, quota.Size(req.Title, req.Description, req.Content))
+		if err != nil {
+			return err
+		}
+
+		todo.Revision, err = nextRevision(tx, sc)
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&todo).Error
+	})
+	var exceeded *quota.Exceeded
+	if errors.As(err, &exceeded) {
+		return nil, exceeded.Status().Err()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the todo")
+		return nil, status.Error(codes.Internal, "failed to create the todo")
+	}
+
+	s.invalidateStats(ctx, sc)
+
+	return toPB(todo, state.Name), nil
+}
+
 // Get is a gRPC endpoint to get a todo
-// returns Internal, NotFound, nil
+// returns Internal, InvalidArgument, NotFound, nil
 func (s *Server) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.GetResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	sc, err := s.scopeOf(ctx, uint(userID))
+	if err != nil {
+		return &pb.GetResponse{
+			Success: false,
+		}, err
+	}
+
 	todo := &database.Todo{}
 
-	err := s.DB.Where("id = ?", req.Id).First(&todo).Error
+	err = sc.todos(s.DB.Preload("Tags")).Where("todos.id = ?", req.Id).First(&todo).Error
 	if err != nil {
 		log.Error().Err(err).Msg("failed to get the todo")
 
@@ -87,21 +224,34 @@ func (s *Server) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse,
 		}, status.Error(codes.Internal, "failed to get the todo")
 	}
 
+	workflow, err := s.loadWorkflow(sc.OwnerID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the workflow")
+		return &pb.GetResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the workflow")
+	}
+
+	blockedBy, blocking, err := s.dependencyIDs(todo.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the dependencies")
+		return &pb.GetResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the dependencies")
+	}
+
+	pbTodo := toPB(todo, stateOf(workflow, todo))
+	pbTodo.BlockedBy = blockedBy
+	pbTodo.Blocking = blocking
+
 	return &pb.GetResponse{
 		Success: true,
-		Todo: &pb.Todo{
-			Id:          fmt.Sprint(todo.ID),
-			Title:       todo.Title,
-			Description: todo.Description,
-			Content:     todo.Content,
-			Completed:   todo.Completed,
-			UserId:      fmt.Sprint(todo.UserID),
-		},
+		Todo:    pbTodo,
 	}, nil
 }
 
-// List is a gRPC endpoint to list all todos
-// returns Internal, nil
+// List is a gRPC endpoint to list all todos, optionally narrowed down by a query or a saved filter
+// returns Internal, InvalidArgument, NotFound, nil
 func (s *Server) List(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
 	userID, err := strconv.ParseUint(req.UserId, 10, 64)
 	if err != nil {
@@ -111,9 +261,72 @@ func (s *Server) List(ctx context.Context, req *pb.ListRequest) (*pb.ListRespons
 		}, status.Error(codes.Internal, "failed to parse user id")
 	}
 
+	sc, err := s.scopeOf(ctx, uint(userID))
+	if err != nil {
+		return &pb.ListResponse{
+			Todos: []*pb.Todo{},
+		}, err
+	}
+
+	workflow, err := s.loadWorkflow(sc.OwnerID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the workflow")
+		return &pb.ListResponse{
+			Todos: []*pb.Todo{},
+		}, status.Error(codes.Internal, "failed to get the workflow")
+	}
+
+	query := req.Query
+	if req.FilterId != "" {
+		if query != "" {
+			return &pb.ListResponse{
+				Todos: []*pb.Todo{},
+			}, status.Error(codes.InvalidArgument, "only one of query and filter_id can be given")
+		}
+
+		saved := &database.Filter{}
+		err = s.DB.Where("id = ? AND user_id = ?", req.FilterId, userID).First(saved).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &pb.ListResponse{
+					Todos: []*pb.Todo{},
+				}, status.Error(codes.NotFound, "filter not found")
+			}
+
+			log.Error().Err(err).Msg("failed to get the filter")
+			return &pb.ListResponse{
+				Todos: []*pb.Todo{},
+			}, status.Error(codes.Internal, "failed to get the filter")
+		}
+		query = saved.Query
+	}
+
+	loc := time.UTC
+	if req.TimeZone != "" {
+		loc, err = time.LoadLocation(req.TimeZone)
+		if err != nil {
+			return &pb.ListResponse{
+				Todos: []*pb.Todo{},
+			}, status.Error(codes.InvalidArgument, "invalid time zone")
+		}
+	}
+
+	tx := sc.todos(s.DB.Preload("Tags"))
+	if query != "" {
+		node, err := parseQuery(query)
+		if err != nil {
+			return &pb.ListResponse{
+				Todos: []*pb.Todo{},
+			}, err
+		}
+
+		condition, args := filter.SQL(node, time.Now().In(loc))
+		tx = tx.Where(condition, args...)
+	}
+
 	todos := []*database.Todo{}
 
-	err = s.DB.Where("user_id = ?", userID).Find(&todos).Error
+	err = tx.Find(&todos).Error
 	if err != nil {
 		log.Error().Err(err).Msg("failed to get the todos")
 		return &pb.ListResponse{
@@ -123,14 +336,7 @@ func (s *Server) List(ctx context.Context, req *pb.ListRequest) (*pb.ListRespons
 
 	pbTodos := []*pb.Todo{}
 	for _, todo := range todos {
-		pbTodos = append(pbTodos, &pb.Todo{
-			Id:          fmt.Sprint(todo.ID),
-			Title:       todo.Title,
-			Description: todo.Description,
-			Content:     todo.Content,
-			Completed:   todo.Completed,
-			UserId:      fmt.Sprint(todo.UserID),
-		})
+		pbTodos = append(pbTodos, toPB(todo, stateOf(workflow, todo)))
 	}
 
 	return &pb.ListResponse{
@@ -139,7 +345,7 @@ func (s *Server) List(ctx context.Context, req *pb.ListRequest) (*pb.ListRespons
 }
 
 // Update is a gRPC endpoint to update a todo
-// returns DataLoss, Internal, NotFound, nil
+// returns Internal, NotFound, InvalidArgument, FailedPrecondition, PermissionDenied, ResourceExhausted, nil
 func (s *Server) Update(ctx context.Context, req *pb.UpdateRequest) (*pb.UpdateResponse, error) {
 	todoID, err := strconv.ParseUint(req.Id, 10, 64)
 	if err != nil {
@@ -156,8 +362,37 @@ func (s *Server) Update(ctx context.Context, req *pb.UpdateRequest) (*pb.UpdateR
 		}, status.Error(codes.Internal, "failed to parse user id")
 	}
 
+	sc, err := s.scopeOf(ctx, uint(userID))
+	if err != nil {
+		return &pb.UpdateResponse{
+			Success: false,
+		}, err
+	}
+	if !sc.canWrite() {
+		return &pb.UpdateResponse{
+			Success: false,
+		}, errReadOnly
+	}
+
 	todo := &database.Todo{}
 
+	err = sc.todos(s.DB.Preload("Tags")).Where("todos.id = ?", todoID).First(&todo).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the todo")
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &pb.UpdateResponse{
+				Success: false,
+			}, status.Error(codes.NotFound, "todo not found")
+		}
+
+		return &pb.UpdateResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the todo")
+	}
+
+	before := quota.Size(todo.Title, todo.Description, todo.Content)
+
 	if req.Title != "" {
 		todo.Title = req.Title
 	}
@@ -167,33 +402,173 @@ func (s *Server) Update(ctx context.Context, req *pb.UpdateRequest) (*pb.UpdateR
 	if req.Content != "" {
 		todo.Content = req.Content
 	}
+	if req.Priority != nil {
+		switch enums.Priority(*req.Priority) {
+		case "", enums.Low, enums.Medium, enums.High, enums.Urgent:
+		default:
+			return &pb.UpdateResponse{
+				Success: false,
+				Message: "Invalid priority",
+			}, status.Error(codes.InvalidArgument, "invalid priority")
+		}
+		todo.Priority = *req.Priority
+	}
+	if req.Project != nil {
+		todo.Project = *req.Project
+	}
+	if req.DueAt != nil {
+		todo.DueAt = nil
+		if *req.DueAt != "" {
+			due, err := time.Parse(time.RFC3339, *req.DueAt)
+			if err != nil {
+				return &pb.UpdateResponse{
+					Success: false,
+					Message: "Invalid due date",
+				}, status.Error(codes.InvalidArgument, "due date must be in the RFC 3339 format")
+			}
+			todo.DueAt = &due
+		}
+	}
+	if req.Recurrence != nil {
+		todo.Recurrence = *req.Recurrence
+	}
 
-	todo.ID = uint(todoID)
-	todo.UserID = uint(userID)
-	todo.Completed = req.Completed
+	// the todo counts against the quotas of the user who created it
+	_, limits, err := quota.Of(s.DB, todo.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the quotas")
+		return &pb.UpdateResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the quotas")
+	}
 
-	err = s.DB.Save(&todo).Error
+	workflow, err := s.loadWorkflow(sc.OwnerID)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to update the todo")
+		log.Error().Err(err).Msg("failed to get the workflow")
+		return &pb.UpdateResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the workflow")
+	}
 
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	current := stateOf(workflow, todo)
+	next := current
+	if req.State != "" {
+		next = req.State
+	} else if req.Completed != nil {
+		// clients that only know about the completed flag move the todo to the
+		// terminal or the initial state of the workflow
+		currentState, _ := findState(workflow, current)
+		if *req.Completed && !currentState.Terminal {
+			next = terminalState(workflow).Name
+		}
+		if !*req.Completed && currentState.Terminal {
+			next = initialState(workflow).Name
+		}
+	}
+
+	state, ok := findState(workflow, next)
+	if !ok {
+		return &pb.UpdateResponse{
+			Success: false,
+			Message: "Unknown state",
+		}, status.Error(codes.InvalidArgument, "the state is not part of the workflow")
+	}
+	if !canTransition(workflow, current, next) {
+		return &pb.UpdateResponse{
+			Success: false,
+			Message: fmt.Sprintf("Cannot move the todo from %s to %s", current, next),
+		}, status.Error(codes.FailedPrecondition, "the workflow does not allow this transition")
+	}
+
+	message := ""
+	if state.Terminal && !todo.Completed {
+		blockers, err := s.openBlockers(todo.ID)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get the blockers")
 			return &pb.UpdateResponse{
 				Success: false,
-			}, status.Error(codes.NotFound, "todo not found")
+			}, status.Error(codes.Internal, "failed to get the blockers")
 		}
 
+		if blockers > 0 {
+			if s.blockedPolicy() == enums.Reject {
+				return &pb.UpdateResponse{
+					Success: false,
+					Message: fmt.Sprintf("The todo is blocked by %d open todos", blockers),
+				}, status.Error(codes.FailedPrecondition, "the todo is blocked by open todos")
+			}
+
+			message = fmt.Sprintf("The todo was completed while being blocked by %d open todos", blockers)
+		}
+	}
+
+	if state.Terminal && !todo.Completed {
+		now := time.Now()
+		todo.CompletedAt = &now
+	}
+	if !state.Terminal {
+		todo.CompletedAt = nil
+	}
+	todo.State = state.Name
+	todo.Completed = state.Terminal
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		err := quota.CheckUpdate(tx, todo.UserID, limits, before, quota.Size(todo.Title, todo.Description, todo.Content))
+		if err != nil {
+			return err
+		}
+
+		revision, err := nextRevision(tx, sc)
+		if err != nil {
+			return err
+		}
+		todo.Revision = revision
+
+		if err := tx.Omit("Tags").Save(&todo).Error; err != nil {
+			return err
+		}
+		if !req.SetTags {
+			return nil
+		}
+
+		if err := tx.Where("todo_id = ?", todo.ID).Delete(&database.TodoTag{}).Error; err != nil {
+			return err
+		}
+		todo.Tags = normalizeTags(req.Tags)
+		for i := range todo.Tags {
+			todo.Tags[i].TodoID = todo.ID
+		}
+		if len(todo.Tags) == 0 {
+			return nil
+		}
+
+		return tx.Create(&todo.Tags).Error
+	})
+	var exceeded *quota.Exceeded
+	if errors.As(err, &exceeded) {
+		return &pb.UpdateResponse{
+			Success: false,
+			Message: "Quota exceeded",
+		}, exceeded.Status().Err()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to update the todo")
 		return &pb.UpdateResponse{
 			Success: false,
 		}, status.Error(codes.Internal, "failed to update the todo")
 	}
 
+	s.invalidateStats(ctx, sc)
+
 	return &pb.UpdateResponse{
 		Success: true,
+		Message: message,
+		Todo:    toPB(todo, state.Name),
 	}, nil
 }
 
 // Delete is a gRPC endpoint to delete a todo
-// returns Unauthenticated, Internal, nil
+// returns Unauthenticated, Internal, InvalidArgument, NotFound, PermissionDenied, nil
 func (s *Server) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
 	todoID, err := strconv.ParseUint(req.Id, 10, 64)
 	if err != nil {
@@ -210,11 +585,47 @@ func (s *Server) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteR
 		}, status.Error(codes.Internal, "failed to parse user id")
 	}
 
+	sc, err := s.scopeOf(ctx, uint(userID))
+	if err != nil {
+		return &pb.DeleteResponse{
+			Success: false,
+		}, err
+	}
+	if !sc.canWrite() {
+		return &pb.DeleteResponse{
+			Success: false,
+		}, errReadOnly
+	}
+
 	todo := &database.Todo{}
 	todo.ID = uint(todoID)
-	todo.UserID = uint(userID)
 
-	err = s.DB.Delete(&todo).Error
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		revision, err := nextRevision(tx, sc)
+		if err != nil {
+			return err
+		}
+
+		// the revision is kept on the deleted todo so that offline clients learn about the deletion
+		result := sc.todos(tx.Model(&database.Todo{})).
+			Where("todos.id = ?", todo.ID).
+			Update("revision", revision)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		err = tx.Delete(&todo).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.
+			Where("todo_id = ? OR blocked_by_id = ?", todo.ID, todo.ID).
+			Delete(&database.TodoDependency{}).Error
+	})
 	if err != nil {
 		log.Error().Err(err).Msg("failed to delete the todo")
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -228,7 +639,46 @@ func (s *Server) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteR
 		}, status.Error(codes.Internal, "failed to delete the todo")
 	}
 
+	s.invalidateStats(ctx, sc)
+
 	return &pb.DeleteResponse{
 		Success: true,
 	}, nil
 }
+
+// toPB converts the todo model to the protobuf message
+func toPB(todo *database.Todo, state string) *pb.Todo {
+	tags := []string{}
+	for _, tag := range todo.Tags {
+		tags = append(tags, tag.Name)
+	}
+
+	dueAt := ""
+	if todo.DueAt != nil {
+		dueAt = todo.DueAt.Format(time.RFC3339)
+	}
+	completedAt := ""
+	if todo.CompletedAt != nil {
+		completedAt = todo.CompletedAt.Format(time.RFC3339)
+	}
+
+	return &pb.Todo{
+		Id:           fmt.Sprint(todo.ID),
+		Title:        todo.Title,
+		Description:  todo.Description,
+		Content:      todo.Content,
+		Completed:    todo.Completed,
+		State:        state,
+		UserId:       fmt.Sprint(todo.UserID),
+		Tags:         tags,
+		Priority:     todo.Priority,
+		Project:      todo.Project,
+		DueAt:        dueAt,
+		Recurrence:   todo.Recurrence,
+		CompletedAt:  completedAt,
+		CreatedAt:    todo.CreatedAt.Format(time.RFC3339Nano),
+		UpdatedAt:    todo.UpdatedAt.Format(time.RFC3339Nano),
+		CalendarUid:  todo.CalendarUID,
+		CalendarName: todo.CalendarName,
+	}
+}
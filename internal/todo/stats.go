@@ -0,0 +1,263 @@
+package todo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	rdb "github.com/VinukaThejana/todoapp/internal/redis"
+	pb "github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	maxStatsDays = 366
+	defaultDays  = 30
+	day          = "day"
+	week         = "week"
+)
+
+// statsRecord holds the columns of a todo that are needed to compute the statistics
+type statsRecord struct {
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+	Completed   bool
+	DueAt       *time.Time
+}
+
+// invalidateStats makes sure that the cached statistics of the scope are not served anymore
+func (s *Server) invalidateStats(ctx context.Context, sc *scope) {
+	err := s.R.Incr(ctx, rdb.StatsVersionKey(sc.key())).Err()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to invalidate the cached statistics")
+	}
+}
+
+// truncate returns the start of the bucket that the given time falls into
+func truncate(t time.Time, granularity string) time.Time {
+	date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if granularity == week {
+		// weeks start on monday
+		offset := (int(date.Weekday()) + 6) % 7
+		date = date.AddDate(0, 0, -offset)
+	}
+
+	return date
+}
+
+// computeStats computes the statistics of the records for the days between from and to (both inclusive),
+// from and to must be at midnight in the time zone of the user
+func computeStats(records []statsRecord, from, to time.Time, granularity string, now time.Time) *pb.StatsResponse {
+	loc := from.Location()
+	end := to.AddDate(0, 0, 1)
+
+	res := &pb.StatsResponse{
+		Success: true,
+		Buckets: []*pb.StatsBucket{},
+	}
+
+	index := map[time.Time]*pb.StatsBucket{}
+	for start := truncate(from, granularity); start.Before(end); {
+		bucket := &pb.StatsBucket{
+			Start: start.Format(time.DateOnly),
+		}
+		index[start] = bucket
+		res.Buckets = append(res.Buckets, bucket)
+
+		if granularity == week {
+			start = start.AddDate(0, 0, 7)
+		} else {
+			start = start.AddDate(0, 0, 1)
+		}
+	}
+
+	inRange := func(t time.Time) bool {
+		return !t.Before(from) && t.Before(end)
+	}
+
+	var createdAndCompleted int64
+	var completionTime time.Duration
+	completionDays := map[time.Time]bool{}
+	for _, record := range records {
+		created := record.CreatedAt.In(loc)
+		if inRange(created) {
+			res.Created++
+			index[truncate(created, granularity)].Created++
+			if record.Completed {
+				createdAndCompleted++
+			}
+		}
+
+		if record.Completed && record.CompletedAt != nil {
+			completed := record.CompletedAt.In(loc)
+			completionDays[truncate(completed, day)] = true
+
+			if inRange(completed) {
+				res.Completed++
+				index[truncate(completed, granularity)].Completed++
+				completionTime += completed.Sub(created)
+			}
+		}
+
+		if !record.Completed && record.DueAt != nil && record.DueAt.Before(now) {
+			res.Overdue++
+		}
+	}
+
+	if res.Created > 0 {
+		res.CompletionRate = float64(createdAndCompleted) / float64(res.Created)
+	}
+	if res.Completed > 0 {
+		res.AverageCompletionSeconds = completionTime.Seconds() / float64(res.Completed)
+	}
+
+	res.CurrentStreak, res.LongestStreak = streaks(completionDays, truncate(now.In(loc), day))
+
+	return res
+}
+
+// streaks returns the current and the longest number of consecutive days with at least one completed
+// todo, the current streak is still alive when nothing has been completed today yet
+func streaks(days map[time.Time]bool, today time.Time) (current int32, longest int32) {
+	for date := range days {
+		// only count from the first day of each streak
+		if days[date.AddDate(0, 0, -1)] {
+			continue
+		}
+
+		var length int32
+		for next := date; days[next]; next = next.AddDate(0, 0, 1) {
+			length++
+		}
+		if length > longest {
+			longest = length
+		}
+	}
+
+	start := today
+	if !days[start] {
+		start = start.AddDate(0, 0, -1)
+	}
+	for date := start; days[date]; date = date.AddDate(0, 0, -1) {
+		current++
+	}
+
+	return current, longest
+}
+
+// Stats is a gRPC endpoint to get the productivity statistics of the user for a date range
+// returns Internal, InvalidArgument, NotFound, nil
+func (s *Server) Stats(ctx context.Context, req *pb.StatsRequest) (*pb.StatsResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.StatsResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	sc, err := s.scopeOf(ctx, uint(userID))
+	if err != nil {
+		return &pb.StatsResponse{
+			Success: false,
+		}, err
+	}
+
+	loc := time.UTC
+	if req.TimeZone != "" {
+		loc, err = time.LoadLocation(req.TimeZone)
+		if err != nil {
+			return &pb.StatsResponse{
+				Success: false,
+				Message: "Invalid time zone",
+			}, status.Error(codes.InvalidArgument, "invalid time zone")
+		}
+	}
+
+	granularity := req.Granularity
+	if granularity == "" {
+		granularity = day
+	}
+	if granularity != day && granularity != week {
+		return &pb.StatsResponse{
+			Success: false,
+			Message: "Invalid granularity",
+		}, status.Error(codes.InvalidArgument, "granularity must be day or week")
+	}
+
+	now := time.Now().In(loc)
+	to := truncate(now, day)
+	if req.To != "" {
+		to, err = time.ParseInLocation(time.DateOnly, req.To, loc)
+		if err != nil {
+			return &pb.StatsResponse{
+				Success: false,
+				Message: "Invalid end date",
+			}, status.Error(codes.InvalidArgument, "to must be in the YYYY-MM-DD format")
+		}
+	}
+	from := to.AddDate(0, 0, -(defaultDays - 1))
+	if req.From != "" {
+		from, err = time.ParseInLocation(time.DateOnly, req.From, loc)
+		if err != nil {
+			return &pb.StatsResponse{
+				Success: false,
+				Message: "Invalid start date",
+			}, status.Error(codes.InvalidArgument, "from must be in the YYYY-MM-DD format")
+		}
+	}
+	if to.Before(from) || to.Sub(from) > maxStatsDays*24*time.Hour {
+		return &pb.StatsResponse{
+			Success: false,
+			Message: "Invalid date range",
+		}, status.Error(codes.InvalidArgument, fmt.Sprintf("the date range must be between 1 and %d days", maxStatsDays))
+	}
+
+	version, err := s.R.Get(ctx, rdb.StatsVersionKey(sc.key())).Int64()
+	if err != nil && err != redis.Nil {
+		log.Error().Err(err).Msg("failed to get the statistics version")
+	}
+	key := rdb.StatsKey(
+		sc.key(),
+		version,
+		fmt.Sprintf("%s:%s:%s:%s", from.Format(time.DateOnly), to.Format(time.DateOnly), granularity, loc.String()),
+	)
+
+	cached, err := s.R.Get(ctx, key).Bytes()
+	if err == nil {
+		res := &pb.StatsResponse{}
+		if err = proto.Unmarshal(cached, res); err == nil {
+			return res, nil
+		}
+	}
+
+	records := []statsRecord{}
+	err = sc.todos(s.DB.Model(&database.Todo{})).
+		Select("created_at", "completed_at", "completed", "due_at").
+		Find(&records).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the todos")
+		return &pb.StatsResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the todos")
+	}
+
+	res := computeStats(records, from, to, granularity, now)
+
+	// the overdue count and the streaks depend on the current time so the cache is kept short lived
+	b, err := proto.Marshal(res)
+	if err == nil {
+		err = s.R.Set(ctx, key, b, rdb.StatsTTL(s.E)).Err()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to cache the statistics")
+	}
+
+	return res, nil
+}
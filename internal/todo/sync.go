@@ -0,0 +1,325 @@
+package todo
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	pb "github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	syncTokenPrefix = "v1."
+	maxSyncChanges  = 500
+)
+
+// sequenceOf returns the key of the change sequence of the scope, the todos of a workspace share a single
+// sequence while every user has one for the personal todos
+func sequenceOf(sc *scope) *database.ChangeSequence {
+	if sc.WorkspaceID != 0 {
+		return &database.ChangeSequence{WorkspaceID: sc.WorkspaceID}
+	}
+
+	return &database.ChangeSequence{UserID: sc.UserID}
+}
+
+// nextRevision increments the change sequence of the scope and returns it, it must be called in the
+// transaction that changes the todos so that the revisions are never reused
+func nextRevision(tx *gorm.DB, sc *scope) (uint64, error) {
+	sequence := sequenceOf(sc)
+	sequence.Value = 1
+
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "workspace_id"}},
+		DoUpdates: clause.Assignments(map[string]any{"value": gorm.Expr("change_sequences.value + 1")}),
+	}).Create(sequence).Error
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Where("user_id = ? AND workspace_id = ?", sequence.UserID, sequence.WorkspaceID).First(sequence).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return sequence.Value, nil
+}
+
+// currentRevision returns the change sequence of the scope without changing it
+func (s *Server) currentRevision(sc *scope) (uint64, error) {
+	key := sequenceOf(sc)
+	sequence := &database.ChangeSequence{}
+
+	err := s.DB.Where("user_id = ? AND workspace_id = ?", key.UserID, key.WorkspaceID).Limit(1).Find(sequence).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return sequence.Value, nil
+}
+
+func encodeSyncToken(revision uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(syncTokenPrefix + strconv.FormatUint(revision, 10)))
+}
+
+// decodeSyncToken returns the revision that the token was issued at, an empty token is a full sync
+func decodeSyncToken(token string) (uint64, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || !strings.HasPrefix(string(raw), syncTokenPrefix) {
+		return 0, errors.New("invalid sync token")
+	}
+
+	return strconv.ParseUint(strings.TrimPrefix(string(raw), syncTokenPrefix), 10, 64)
+}
+
+// Sync is a gRPC endpoint for offline clients to apply the changes they made and to get the changes
+// made since their last sync. Changes to todos that were modified on the server after the token was issued
+// are not applied and are reported as conflicts with the version of the server, so that the client can
+// merge them and send them again with the new token.
+// returns Internal, InvalidArgument, NotFound, FailedPrecondition, PermissionDenied, nil
+func (s *Server) Sync(ctx context.Context, req *pb.SyncRequest) (*pb.SyncResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.SyncResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	since, err := decodeSyncToken(req.SyncToken)
+	if err != nil {
+		return &pb.SyncResponse{
+			Success: false,
+			Message: "Invalid sync token",
+		}, status.Error(codes.InvalidArgument, "invalid sync token")
+	}
+	if len(req.Changes) > maxSyncChanges {
+		return &pb.SyncResponse{
+			Success: false,
+			Message: "Too many changes",
+		}, status.Error(codes.InvalidArgument, fmt.Sprintf("at most %d changes can be sent at once", maxSyncChanges))
+	}
+
+	sc, err := s.scopeOf(ctx, uint(userID))
+	if err != nil {
+		return &pb.SyncResponse{
+			Success: false,
+		}, err
+	}
+	if len(req.Changes) > 0 && !sc.canWrite() {
+		return &pb.SyncResponse{
+			Success: false,
+		}, errReadOnly
+	}
+
+	current, err := s.currentRevision(sc)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the change sequence")
+		return &pb.SyncResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to sync the todos")
+	}
+	if since > current {
+		// the token was not issued by this server or the data was restored from an older backup
+		return &pb.SyncResponse{
+			Success: false,
+			Message: "The sync token has expired, sync again without a token",
+		}, status.Error(codes.FailedPrecondition, "the sync token is ahead of the server")
+	}
+
+	res := &pb.SyncResponse{
+		Success:   true,
+		Full:      req.SyncToken == "",
+		Todos:     []*pb.Todo{},
+		Deleted:   []string{},
+		Created:   []*pb.SyncCreated{},
+		Conflicts: []*pb.SyncConflict{},
+	}
+
+	for _, change := range req.Changes {
+		created, conflict, err := s.applyChange(ctx, sc, since, change)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to apply the change")
+			return &pb.SyncResponse{
+				Success: false,
+			}, status.Error(codes.Internal, "failed to sync the todos")
+		}
+
+		if created != nil {
+			res.Created = append(res.Created, created)
+		}
+		if conflict != nil {
+			res.Conflicts = append(res.Conflicts, conflict)
+		}
+	}
+
+	// the sequence is read before the todos so that a change made in between is sent again on the next
+	// sync instead of being missed
+	latest, err := s.currentRevision(sc)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the change sequence")
+		return &pb.SyncResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to sync the todos")
+	}
+
+	todos := []*database.Todo{}
+	tx := sc.todos(s.DB.Unscoped().Preload("Tags")).Where("todos.revision <= ?", latest)
+	if res.Full {
+		tx = tx.Where("todos.deleted_at IS NULL")
+	} else {
+		tx = tx.Where("todos.revision > ?", since)
+	}
+	err = tx.Order("todos.revision, todos.id").Find(&todos).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the changed todos")
+		return &pb.SyncResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to sync the todos")
+	}
+
+	workflow, err := s.loadWorkflow(sc.OwnerID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the workflow")
+		return &pb.SyncResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the workflow")
+	}
+
+	for _, todo := range todos {
+		if todo.DeletedAt.Valid {
+			res.Deleted = append(res.Deleted, fmt.Sprint(todo.ID))
+			continue
+		}
+		res.Todos = append(res.Todos, toPB(todo, stateOf(workflow, todo)))
+	}
+	res.SyncToken = encodeSyncToken(latest)
+
+	return res, nil
+}
+
+// applyChange applies a single change of an offline client, changes that can not be applied are returned
+// as conflicts while the returned error is only set for internal errors
+func (s *Server) applyChange(ctx context.Context, sc *scope, since uint64, change *pb.TodoChange) (*pb.SyncCreated, *pb.SyncConflict, error) {
+	conflict := func(reason enums.ConflictReason, message string, todo *pb.Todo) *pb.SyncConflict {
+		return &pb.SyncConflict{
+			Id:       change.Id,
+			ClientId: change.ClientId,
+			Reason:   string(reason),
+			Message:  message,
+			Todo:     todo,
+		}
+	}
+	rejected := func(err error) (*pb.SyncCreated, *pb.SyncConflict, error) {
+		st, ok := status.FromError(err)
+		if !ok || st.Code() == codes.Internal {
+			return nil, nil, err
+		}
+		return nil, conflict(enums.Rejected, st.Message(), nil), nil
+	}
+
+	if change.Id == "" {
+		if change.Deleted {
+			return nil, nil, nil
+		}
+
+		state := change.State
+		if state == "" && change.Completed {
+			workflow, err := s.loadWorkflow(sc.OwnerID)
+			if err != nil {
+				return nil, nil, err
+			}
+			state = terminalState(workflow).Name
+		}
+
+		todo, err := s.createTodo(ctx, sc, &pb.CreateRequest{
+			Title:       change.Title,
+			Description: change.Description,
+			Content:     change.Content,
+			UserId:      fmt.Sprint(sc.UserID),
+			State:       state,
+			Tags:        change.Tags,
+			Priority:    change.Priority,
+			Project:     change.Project,
+			DueAt:       change.DueAt,
+			Recurrence:  change.Recurrence,
+		})
+		if err != nil {
+			return rejected(err)
+		}
+
+		return &pb.SyncCreated{
+			ClientId: change.ClientId,
+			Id:       todo.Id,
+		}, nil, nil
+	}
+
+	existing := &database.Todo{}
+	err := sc.todos(s.DB.Unscoped().Preload("Tags")).Where("todos.id = ?", change.Id).First(existing).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, conflict(enums.Missing, "todo not found", nil), nil
+		}
+		return nil, nil, err
+	}
+
+	if existing.DeletedAt.Valid {
+		if change.Deleted {
+			return nil, nil, nil
+		}
+		return nil, conflict(enums.Deleted, "the todo was deleted", nil), nil
+	}
+	if existing.Revision > since {
+		workflow, err := s.loadWorkflow(sc.OwnerID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, conflict(enums.Modified, "the todo was changed after the last sync", toPB(existing, stateOf(workflow, existing))), nil
+	}
+
+	if change.Deleted {
+		_, err = s.Delete(ctx, &pb.DeleteRequest{
+			Id:     change.Id,
+			UserId: fmt.Sprint(sc.UserID),
+		})
+		if err != nil {
+			return rejected(err)
+		}
+		return nil, nil, nil
+	}
+
+	_, err = s.Update(ctx, &pb.UpdateRequest{
+		Id:          change.Id,
+		UserId:      fmt.Sprint(sc.UserID),
+		Title:       change.Title,
+		Description: change.Description,
+		Content:     change.Content,
+		Completed:   &change.Completed,
+		State:       change.State,
+		Priority:    &change.Priority,
+		Project:     &change.Project,
+		DueAt:       &change.DueAt,
+		Recurrence:  &change.Recurrence,
+		Tags:        change.Tags,
+		SetTags:     true,
+	})
+	if err != nil {
+		return rejected(err)
+	}
+
+	return nil, nil, nil
+}
@@ -0,0 +1,69 @@
+package todo
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/internal/quota"
+	pb "github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetUsage is a gRPC endpoint to get the plan of the user and the usage of each of their quotas
+// returns Internal, nil
+func (s *Server) GetUsage(ctx context.Context, req *pb.GetUsageRequest) (*pb.GetUsageResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.GetUsageResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	plan, limits, err := quota.Of(s.DB, uint(userID))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the quotas")
+		return &pb.GetUsageResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the quotas")
+	}
+
+	todos, err := quota.Todos(s.DB, uint(userID))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the todos")
+		return &pb.GetUsageResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the usage")
+	}
+	storage, err := quota.Storage(s.DB, uint(userID))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the storage")
+		return &pb.GetUsageResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the usage")
+	}
+	requests, err := quota.Requests(ctx, s.R, uint(userID))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the requests")
+		return &pb.GetUsageResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the usage")
+	}
+
+	return &pb.GetUsageResponse{
+		Success: true,
+		Plan:    string(plan),
+		Quotas: []*pb.QuotaUsage{
+			{Subject: string(enums.TodoCount), Limit: limits.Todos, Used: todos},
+			// the content size is limited per todo so there is no usage to report
+			{Subject: string(enums.ContentSize), Limit: limits.ContentSize},
+			{Subject: string(enums.Storage), Limit: limits.Storage, Used: storage},
+			{Subject: string(enums.DailyRequests), Limit: limits.RequestsPerDay, Used: requests},
+		},
+		ResetsAt: quota.ResetAt(time.Now()).Format(time.RFC3339),
+	}, nil
+}
@@ -0,0 +1,451 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	pb "github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	maxWorkflowStates    = 20
+	maxWorkflowStateLen  = 50
+	defaultWorkflowName  = "default"
+	defaultInitialState  = "todo"
+	defaultTerminalState = "done"
+)
+
+// defaultWorkflow is used for users that have not configured a workflow, it mirrors the completed flag
+func defaultWorkflow() *database.Workflow {
+	return &database.Workflow{
+		Name: defaultWorkflowName,
+		States: []database.WorkflowState{
+			{Name: defaultInitialState, Position: 0, Terminal: false},
+			{Name: defaultTerminalState, Position: 1, Terminal: true},
+		},
+		Transitions: []database.WorkflowTransition{
+			{FromState: defaultInitialState, ToState: defaultTerminalState},
+			{FromState: defaultTerminalState, ToState: defaultInitialState},
+		},
+	}
+}
+
+// loadWorkflow returns the workflow of the given user or the default workflow if the user does not have one
+func (s *Server) loadWorkflow(userID uint) (*database.Workflow, error) {
+	workflow := &database.Workflow{}
+
+	err := s.DB.
+		Preload("States", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position")
+		}).
+		Preload("Transitions").
+		Where("user_id = ?", userID).
+		First(&workflow).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return defaultWorkflow(), nil
+		}
+
+		return nil, err
+	}
+
+	return workflow, nil
+}
+
+// findState returns the state with the given name from the workflow
+func findState(workflow *database.Workflow, name string) (*database.WorkflowState, bool) {
+	for i := range workflow.States {
+		if workflow.States[i].Name == name {
+			return &workflow.States[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// initialState returns the first non terminal state of the workflow
+func initialState(workflow *database.Workflow) *database.WorkflowState {
+	for i := range workflow.States {
+		if !workflow.States[i].Terminal {
+			return &workflow.States[i]
+		}
+	}
+
+	return nil
+}
+
+// terminalState returns the first terminal state of the workflow
+func terminalState(workflow *database.Workflow) *database.WorkflowState {
+	for i := range workflow.States {
+		if workflow.States[i].Terminal {
+			return &workflow.States[i]
+		}
+	}
+
+	return nil
+}
+
+// canTransition checks if the workflow allows moving a todo from one state to another
+func canTransition(workflow *database.Workflow, from, to string) bool {
+	if from == to {
+		return true
+	}
+
+	for _, transition := range workflow.Transitions {
+		if transition.FromState == from && transition.ToState == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stateOf returns the state of the todo, todos created before workflows existed only have the completed
+// flag so they are mapped to the initial or the terminal state of the workflow
+func stateOf(workflow *database.Workflow, todo *database.Todo) string {
+	if todo.State != "" {
+		if _, ok := findState(workflow, todo.State); ok {
+			return todo.State
+		}
+	}
+
+	if todo.Completed {
+		return terminalState(workflow).Name
+	}
+
+	return initialState(workflow).Name
+}
+
+// validateWorkflow checks if the given workflow is valid
+func validateWorkflow(workflow *pb.Workflow) error {
+	if workflow == nil {
+		return errors.New("workflow is required")
+	}
+	if workflow.Name == "" {
+		return errors.New("workflow name is required")
+	}
+	if len(workflow.States) == 0 || len(workflow.States) > maxWorkflowStates {
+		return fmt.Errorf("the workflow must have between 1 and %d states", maxWorkflowStates)
+	}
+
+	states := map[string]bool{}
+	hasInitial, hasTerminal := false, false
+	for _, state := range workflow.States {
+		if state.Name == "" || len(state.Name) > maxWorkflowStateLen {
+			return fmt.Errorf("state names must be between 1 and %d characters", maxWorkflowStateLen)
+		}
+		if states[state.Name] {
+			return fmt.Errorf("duplicate state %s", state.Name)
+		}
+		states[state.Name] = true
+
+		if state.Terminal {
+			hasTerminal = true
+		} else {
+			hasInitial = true
+		}
+	}
+	if !hasInitial || !hasTerminal {
+		return errors.New("the workflow must have at least one initial and one terminal state")
+	}
+
+	for _, transition := range workflow.Transitions {
+		if !states[transition.From] || !states[transition.To] {
+			return fmt.Errorf("transition %s -> %s refers to an unknown state", transition.From, transition.To)
+		}
+		if transition.From == transition.To {
+			return fmt.Errorf("transition %s -> %s must change the state", transition.From, transition.To)
+		}
+	}
+
+	return nil
+}
+
+// workflowToPB converts the workflow model to the protobuf message
+func workflowToPB(workflow *database.Workflow) *pb.Workflow {
+	pbWorkflow := &pb.Workflow{
+		Name:        workflow.Name,
+		States:      []*pb.WorkflowState{},
+		Transitions: []*pb.WorkflowTransition{},
+	}
+	for _, state := range workflow.States {
+		pbWorkflow.States = append(pbWorkflow.States, stateToPB(&state))
+	}
+	for _, transition := range workflow.Transitions {
+		pbWorkflow.Transitions = append(pbWorkflow.Transitions, &pb.WorkflowTransition{
+			From: transition.FromState,
+			To:   transition.ToState,
+		})
+	}
+
+	return pbWorkflow
+}
+
+// stateToPB converts the workflow state model to the protobuf message
+func stateToPB(state *database.WorkflowState) *pb.WorkflowState {
+	return &pb.WorkflowState{
+		Name:     state.Name,
+		Position: int32(state.Position),
+		Terminal: state.Terminal,
+	}
+}
+
+// GetWorkflow is a gRPC endpoint to get the workflow of the user, the todos of a workspace follow the
+// workflow of its owner
+// returns Internal, InvalidArgument, NotFound, nil
+func (s *Server) GetWorkflow(ctx context.Context, req *pb.GetWorkflowRequest) (*pb.GetWorkflowResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.GetWorkflowResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	sc, err := s.scopeOf(ctx, uint(userID))
+	if err != nil {
+		return &pb.GetWorkflowResponse{
+			Success: false,
+		}, err
+	}
+
+	workflow, err := s.loadWorkflow(sc.OwnerID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the workflow")
+		return &pb.GetWorkflowResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the workflow")
+	}
+
+	return &pb.GetWorkflowResponse{
+		Success:  true,
+		Workflow: workflowToPB(workflow),
+	}, nil
+}
+
+// SetWorkflow is a gRPC endpoint to replace the workflow of the user, it is also used by the workspaces
+// that the user owns so only the owner can change it from within a workspace
+// returns Internal, InvalidArgument, NotFound, FailedPrecondition, PermissionDenied, nil
+func (s *Server) SetWorkflow(ctx context.Context, req *pb.SetWorkflowRequest) (*pb.SetWorkflowResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.SetWorkflowResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	sc, err := s.scopeOf(ctx, uint(userID))
+	if err != nil {
+		return &pb.SetWorkflowResponse{
+			Success: false,
+		}, err
+	}
+	if sc.OwnerID != sc.UserID {
+		return &pb.SetWorkflowResponse{
+			Success: false,
+			Message: "Only the owner of the workspace can change its workflow",
+		}, status.Error(codes.PermissionDenied, "only the owner of the workspace can change its workflow")
+	}
+
+	scopes, err := s.workflowScopes(uint(userID))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the workspaces")
+		return &pb.SetWorkflowResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to update the workflow")
+	}
+
+	err = validateWorkflow(req.Workflow)
+	if err != nil {
+		return &pb.SetWorkflowResponse{
+			Success: false,
+			Message: err.Error(),
+		}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	states := []database.WorkflowState{}
+	terminal, nonTerminal := []string{}, []string{}
+	for i, state := range req.Workflow.States {
+		states = append(states, database.WorkflowState{
+			Name:     state.Name,
+			Position: i,
+			Terminal: state.Terminal,
+		})
+		if state.Terminal {
+			terminal = append(terminal, state.Name)
+		} else {
+			nonTerminal = append(nonTerminal, state.Name)
+		}
+	}
+	transitions := []database.WorkflowTransition{}
+	for _, transition := range req.Workflow.Transitions {
+		transitions = append(transitions, database.WorkflowTransition{
+			FromState: transition.From,
+			ToState:   transition.To,
+		})
+	}
+
+	var inUse int64
+	for _, sc := range scopes {
+		var count int64
+		err = sc.todos(s.DB.Model(&database.Todo{})).
+			Where("todos.state <> '' AND todos.state NOT IN ?", append(terminal, nonTerminal...)).
+			Count(&count).Error
+		if err != nil {
+			log.Error().Err(err).Msg("failed to check the states that are in use")
+			return &pb.SetWorkflowResponse{
+				Success: false,
+			}, status.Error(codes.Internal, "failed to update the workflow")
+		}
+		inUse += count
+	}
+	if inUse > 0 {
+		return &pb.SetWorkflowResponse{
+			Success: false,
+			Message: "Move the todos out of the removed states first",
+		}, status.Error(codes.FailedPrecondition, "todos exist in states that are not part of the workflow")
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		workflow := &database.Workflow{}
+		err := tx.Where("user_id = ?", userID).First(&workflow).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		workflow.Name = req.Workflow.Name
+		workflow.UserID = uint(userID)
+		if err = tx.Save(&workflow).Error; err != nil {
+			return err
+		}
+
+		if err = tx.Where("workflow_id = ?", workflow.ID).Delete(&database.WorkflowState{}).Error; err != nil {
+			return err
+		}
+		if err = tx.Where("workflow_id = ?", workflow.ID).Delete(&database.WorkflowTransition{}).Error; err != nil {
+			return err
+		}
+
+		for i := range states {
+			states[i].WorkflowID = workflow.ID
+		}
+		if err = tx.Create(&states).Error; err != nil {
+			return err
+		}
+		if len(transitions) > 0 {
+			for i := range transitions {
+				transitions[i].WorkflowID = workflow.ID
+			}
+			if err = tx.Create(&transitions).Error; err != nil {
+				return err
+			}
+		}
+
+		// keep the completed flag in sync for the clients that still rely on it
+		for _, sc := range scopes {
+			revision, err := nextRevision(tx, sc)
+			if err != nil {
+				return err
+			}
+			if len(terminal) > 0 {
+				err = sc.todos(tx.Model(&database.Todo{})).
+					Where("todos.state IN ? AND todos.completed = ?", terminal, false).
+					Updates(map[string]any{"completed": true, "completed_at": time.Now(), "revision": revision}).Error
+				if err != nil {
+					return err
+				}
+			}
+			if len(nonTerminal) > 0 {
+				err = sc.todos(tx.Model(&database.Todo{})).
+					Where("todos.state IN ? AND todos.completed = ?", nonTerminal, true).
+					Updates(map[string]any{"completed": false, "completed_at": nil, "revision": revision}).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to update the workflow")
+		return &pb.SetWorkflowResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to update the workflow")
+	}
+
+	for _, sc := range scopes {
+		s.invalidateStats(ctx, sc)
+	}
+
+	return &pb.SetWorkflowResponse{
+		Success: true,
+		Message: "Workflow updated successfully",
+	}, nil
+}
+
+// Board is a gRPC endpoint to get the todos of the user grouped by their workflow state
+// returns Internal, InvalidArgument, NotFound, nil
+func (s *Server) Board(ctx context.Context, req *pb.BoardRequest) (*pb.BoardResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.BoardResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	sc, err := s.scopeOf(ctx, uint(userID))
+	if err != nil {
+		return &pb.BoardResponse{
+			Success: false,
+		}, err
+	}
+
+	workflow, err := s.loadWorkflow(sc.OwnerID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the workflow")
+		return &pb.BoardResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the workflow")
+	}
+
+	todos := []*database.Todo{}
+	err = sc.todos(s.DB.Preload("Tags")).Find(&todos).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the todos")
+		return &pb.BoardResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the todos")
+	}
+
+	columns := []*pb.BoardColumn{}
+	index := map[string]int{}
+	for i, state := range workflow.States {
+		index[state.Name] = i
+		columns = append(columns, &pb.BoardColumn{
+			State: stateToPB(&state),
+			Todos: []*pb.Todo{},
+		})
+	}
+
+	for _, todo := range todos {
+		state := stateOf(workflow, todo)
+		column := columns[index[state]]
+		column.Todos = append(column.Todos, toPB(todo, state))
+	}
+
+	return &pb.BoardResponse{
+		Success: true,
+		Columns: columns,
+	}, nil
+}
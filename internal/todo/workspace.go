@@ -0,0 +1,691 @@
+package todo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VinukaThejana/todoapp/internal/database"
+	"github.com/VinukaThejana/todoapp/internal/enums"
+	"github.com/VinukaThejana/todoapp/internal/lib"
+	pb "github.com/VinukaThejana/todoapp/pkg/todo"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxWorkspaces = 20
+	inviteTTL     = 7 * 24 * time.Hour
+)
+
+// ranks orders the roles of the members, members can only manage the members with a lower rank
+var ranks = map[enums.WorkspaceRole]int{
+	enums.Viewer: 1,
+	enums.Member: 2,
+	enums.Admin:  3,
+	enums.Owner:  4,
+}
+
+var errNotFound = status.Error(codes.NotFound, "workspace not found")
+
+// errOwnerDeleted is returned when a user tries to join a workspace whose owner deleted their account, the
+// workspace is purged with the account unless the owner logs in again
+var errOwnerDeleted = status.Error(codes.FailedPrecondition, "the owner of the workspace is deleting their account")
+
+// scope is the set of todos that a request works on, either the personal todos of the user or the
+// todos of the active workspace
+type scope struct {
+	UserID      uint
+	WorkspaceID uint
+	// OwnerID is the user whose workflow the todos follow
+	OwnerID uint
+	Role    enums.WorkspaceRole
+}
+
+// todos narrows a query on the todos table down to the todos of the scope
+func (sc *scope) todos(tx *gorm.DB) *gorm.DB {
+	if sc.WorkspaceID != 0 {
+		return tx.Where("todos.workspace_id = ?", sc.WorkspaceID)
+	}
+
+	return tx.Where("todos.user_id = ? AND todos.workspace_id = 0", sc.UserID)
+}
+
+// dependencies narrows a query on the todo dependencies table down to the dependencies of the scope
+func (sc *scope) dependencies(tx *gorm.DB) *gorm.DB {
+	if sc.WorkspaceID != 0 {
+		return tx.Where("todo_dependencies.workspace_id = ?", sc.WorkspaceID)
+	}
+
+	return tx.Where("todo_dependencies.user_id = ? AND todo_dependencies.workspace_id = 0", sc.UserID)
+}
+
+// lock locks the row of the workspace or of the user until the end of the transaction so that the changes to
+// the dependencies of the scope are serialized, SQLite serializes the writes on its own and leaves the clause out
+func (sc *scope) lock(tx *gorm.DB) error {
+	locked := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id")
+	if sc.WorkspaceID != 0 {
+		return locked.First(&database.Workspace{}, sc.WorkspaceID).Error
+	}
+
+	return locked.First(&database.User{}, sc.UserID).Error
+}
+
+// key identifies the scope in the keys of cached data
+func (sc *scope) key() string {
+	if sc.WorkspaceID != 0 {
+		return fmt.Sprintf("workspace:%d", sc.WorkspaceID)
+	}
+
+	return fmt.Sprintf("user:%d", sc.UserID)
+}
+
+// canWrite checks if the user can change the todos of the scope
+func (sc *scope) canWrite() bool {
+	return ranks[sc.Role] >= ranks[enums.Member]
+}
+
+// errReadOnly is returned when a viewer tries to change the todos of a workspace
+var errReadOnly = status.Error(codes.PermissionDenied, "viewers can not change the todos of the workspace")
+
+// scopeOf returns the scope of the request, the gateway passes the active workspace in the metadata
+// and requests without one work on the personal todos of the user
+func (s *Server) scopeOf(ctx context.Context, userID uint) (*scope, error) {
+	sc := &scope{
+		UserID:  userID,
+		OwnerID: userID,
+		Role:    enums.Owner,
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return sc, nil
+	}
+	values := md.Get(lib.WorkspaceMetadataKey)
+	if len(values) == 0 || values[0] == "" {
+		return sc, nil
+	}
+
+	workspaceID, err := strconv.ParseUint(values[0], 10, 64)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid workspace id")
+	}
+
+	workspace, member, err := s.membership(uint(workspaceID), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sc.WorkspaceID = workspace.ID
+	sc.OwnerID = workspace.OwnerID
+	sc.Role = enums.WorkspaceRole(member.Role)
+	return sc, nil
+}
+
+// membership returns the workspace and the membership of the user in it, workspaces that the user is not
+// a member of are reported as not found so that their existence is not leaked
+func (s *Server) membership(workspaceID uint, userID uint) (*database.Workspace, *database.WorkspaceMember, error) {
+	member := &database.WorkspaceMember{}
+	err := s.DB.Where("workspace_id = ? AND user_id = ?", workspaceID, userID).First(member).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errNotFound
+		}
+
+		log.Error().Err(err).Msg("failed to get the membership")
+		return nil, nil, status.Error(codes.Internal, "failed to get the workspace")
+	}
+
+	workspace := &database.Workspace{}
+	err = s.DB.Where("id = ?", workspaceID).First(workspace).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errNotFound
+		}
+
+		log.Error().Err(err).Msg("failed to get the workspace")
+		return nil, nil, status.Error(codes.Internal, "failed to get the workspace")
+	}
+
+	return workspace, member, nil
+}
+
+// workflowScopes returns the scopes whose todos follow the workflow of the user, the personal todos of the
+// user and the todos of the workspaces that the user owns
+func (s *Server) workflowScopes(userID uint) ([]*scope, error) {
+	ids := []uint{}
+	err := s.DB.Model(&database.Workspace{}).Where("owner_id = ?", userID).Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []*scope{{UserID: userID, OwnerID: userID, Role: enums.Owner}}
+	for _, id := range ids {
+		scopes = append(scopes, &scope{UserID: userID, WorkspaceID: id, OwnerID: userID, Role: enums.Owner})
+	}
+
+	return scopes, nil
+}
+
+func hashInviteCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func workspaceToPB(workspace *database.Workspace, role string) *pb.Workspace {
+	return &pb.Workspace{
+		Id:        fmt.Sprint(workspace.ID),
+		Name:      workspace.Name,
+		OwnerId:   fmt.Sprint(workspace.OwnerID),
+		Role:      role,
+		CreatedAt: workspace.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// CreateWorkspace is a gRPC endpoint to create a workspace that is owned by the user
+// returns Internal, InvalidArgument, ResourceExhausted, nil
+func (s *Server) CreateWorkspace(ctx context.Context, req *pb.CreateWorkspaceRequest) (*pb.CreateWorkspaceResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.CreateWorkspaceResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 50 {
+		return &pb.CreateWorkspaceResponse{
+			Success: false,
+			Message: "Invalid workspace name",
+		}, status.Error(codes.InvalidArgument, "the name must be between 1 and 50 characters")
+	}
+
+	var owned int64
+	err = s.DB.Model(&database.Workspace{}).Where("owner_id = ?", userID).Count(&owned).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the workspaces")
+		return &pb.CreateWorkspaceResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to create the workspace")
+	}
+	if owned >= maxWorkspaces {
+		return &pb.CreateWorkspaceResponse{
+			Success: false,
+			Message: "Too many workspaces",
+		}, status.Error(codes.ResourceExhausted, fmt.Sprintf("a user can not own more than %d workspaces", maxWorkspaces))
+	}
+
+	workspace := &database.Workspace{
+		Name:    name,
+		OwnerID: uint(userID),
+	}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(workspace).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&database.WorkspaceMember{
+			WorkspaceID: workspace.ID,
+			UserID:      uint(userID),
+			Role:        string(enums.Owner),
+		}).Error
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the workspace")
+		return &pb.CreateWorkspaceResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to create the workspace")
+	}
+
+	return &pb.CreateWorkspaceResponse{
+		Success:   true,
+		Message:   "Workspace created successfully",
+		Workspace: workspaceToPB(workspace, string(enums.Owner)),
+	}, nil
+}
+
+// ListWorkspaces is a gRPC endpoint to get the workspaces that the user is a member of
+// returns Internal, nil
+func (s *Server) ListWorkspaces(ctx context.Context, req *pb.ListWorkspacesRequest) (*pb.ListWorkspacesResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.ListWorkspacesResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	members := []*database.WorkspaceMember{}
+	err = s.DB.
+		Joins("Workspace").
+		Where("workspace_members.user_id = ? AND Workspace.deleted_at IS NULL", userID).
+		Order("Workspace.name").
+		Find(&members).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the workspaces")
+		return &pb.ListWorkspacesResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the workspaces")
+	}
+
+	res := &pb.ListWorkspacesResponse{
+		Success:    true,
+		Workspaces: []*pb.Workspace{},
+	}
+	for _, member := range members {
+		res.Workspaces = append(res.Workspaces, workspaceToPB(&member.Workspace, member.Role))
+	}
+
+	return res, nil
+}
+
+// ListMembers is a gRPC endpoint to get the members of a workspace, only the members can see them
+// returns Internal, NotFound, nil
+func (s *Server) ListMembers(ctx context.Context, req *pb.ListMembersRequest) (*pb.ListMembersResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.ListMembersResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+	workspaceID, err := strconv.ParseUint(req.WorkspaceId, 10, 64)
+	if err != nil {
+		return &pb.ListMembersResponse{
+			Success: false,
+		}, errNotFound
+	}
+
+	_, _, err = s.membership(uint(workspaceID), uint(userID))
+	if err != nil {
+		return &pb.ListMembersResponse{
+			Success: false,
+		}, err
+	}
+
+	members := []*database.WorkspaceMember{}
+	err = s.DB.Joins("User").Where("workspace_members.workspace_id = ?", workspaceID).Order("workspace_members.id").Find(&members).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get the members")
+		return &pb.ListMembersResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the members")
+	}
+
+	res := &pb.ListMembersResponse{
+		Success: true,
+		Members: []*pb.WorkspaceMember{},
+	}
+	for _, member := range members {
+		res.Members = append(res.Members, &pb.WorkspaceMember{
+			UserId:   fmt.Sprint(member.UserID),
+			Name:     member.User.Name,
+			Username: member.User.Username,
+			Role:     member.Role,
+			JoinedAt: member.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return res, nil
+}
+
+// InviteMember is a gRPC endpoint to create a single use invite code for a workspace, only owners and admins
+// can invite and they can not hand out a role above their own
+// returns Internal, InvalidArgument, NotFound, PermissionDenied, nil
+func (s *Server) InviteMember(ctx context.Context, req *pb.InviteMemberRequest) (*pb.InviteMemberResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.InviteMemberResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+	workspaceID, err := strconv.ParseUint(req.WorkspaceId, 10, 64)
+	if err != nil {
+		return &pb.InviteMemberResponse{
+			Success: false,
+		}, errNotFound
+	}
+
+	role := enums.WorkspaceRole(req.Role)
+	if role == "" {
+		role = enums.Member
+	}
+	if role != enums.Admin && role != enums.Member && role != enums.Viewer {
+		return &pb.InviteMemberResponse{
+			Success: false,
+			Message: "Invalid role",
+		}, status.Error(codes.InvalidArgument, "the role must be admin, member or viewer")
+	}
+
+	_, member, err := s.membership(uint(workspaceID), uint(userID))
+	if err != nil {
+		return &pb.InviteMemberResponse{
+			Success: false,
+		}, err
+	}
+	actor := enums.WorkspaceRole(member.Role)
+	if ranks[actor] < ranks[enums.Admin] || ranks[role] > ranks[actor] {
+		return &pb.InviteMemberResponse{
+			Success: false,
+			Message: "You are not allowed to invite members with this role",
+		}, status.Error(codes.PermissionDenied, "not allowed to invite members with this role")
+	}
+
+	b := make([]byte, 24)
+	if _, err = rand.Read(b); err != nil {
+		log.Error().Err(err).Msg("failed to generate the invite code")
+		return &pb.InviteMemberResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to create the invite")
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	invite := &database.WorkspaceInvite{
+		WorkspaceID: uint(workspaceID),
+		InvitedByID: uint(userID),
+		Email:       strings.ToLower(strings.TrimSpace(req.Email)),
+		Role:        string(role),
+		Hash:        hashInviteCode(code),
+		ExpiresAt:   time.Now().Add(inviteTTL),
+	}
+	err = s.DB.Create(invite).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create the invite")
+		return &pb.InviteMemberResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to create the invite")
+	}
+
+	return &pb.InviteMemberResponse{
+		Success:   true,
+		Message:   "Invite created successfully",
+		Code:      code,
+		ExpiresAt: invite.ExpiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+// JoinWorkspace is a gRPC endpoint to join a workspace with an invite code
+// returns Internal, NotFound, PermissionDenied, FailedPrecondition, AlreadyExists, nil
+func (s *Server) JoinWorkspace(ctx context.Context, req *pb.JoinWorkspaceRequest) (*pb.JoinWorkspaceResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.JoinWorkspaceResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+
+	invalid := status.Error(codes.NotFound, "the invite is invalid or has expired")
+
+	invite := &database.WorkspaceInvite{}
+	err = s.DB.Joins("Workspace").
+		Where("workspace_invites.hash = ? AND workspace_invites.accepted_at IS NULL AND Workspace.deleted_at IS NULL", hashInviteCode(req.Code)).
+		First(invite).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &pb.JoinWorkspaceResponse{
+				Success: false,
+				Message: "Invalid invite",
+			}, invalid
+		}
+
+		log.Error().Err(err).Msg("failed to get the invite")
+		return &pb.JoinWorkspaceResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the invite")
+	}
+	if time.Now().After(invite.ExpiresAt) {
+		return &pb.JoinWorkspaceResponse{
+			Success: false,
+			Message: "Invalid invite",
+		}, invalid
+	}
+
+	if invite.Email != "" {
+		user := &database.User{}
+		err = s.DB.Where("id = ?", userID).First(user).Error
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get the user")
+			return &pb.JoinWorkspaceResponse{
+				Success: false,
+			}, status.Error(codes.Internal, "failed to get the user")
+		}
+		if !strings.EqualFold(user.Email, invite.Email) {
+			return &pb.JoinWorkspaceResponse{
+				Success: false,
+				Message: "The invite was sent to another email",
+			}, status.Error(codes.PermissionDenied, "the invite was sent to another email")
+		}
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		// the invite is claimed first so that it can not be used twice at the same time
+		now := time.Now()
+		accepted := uint(userID)
+		result := tx.Model(&database.WorkspaceInvite{}).
+			Where("id = ? AND accepted_at IS NULL", invite.ID).
+			Updates(map[string]any{"accepted_at": now, "accepted_by_id": accepted})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		// the account of the owner is only purged while the workspace has no other members, checking it in
+		// the transaction keeps a purge from taking a member that just joined with it
+		var deleting int64
+		err := tx.Model(&database.User{}).
+			Where("id = ? AND deletion_scheduled_at IS NOT NULL", invite.Workspace.OwnerID).
+			Count(&deleting).Error
+		if err != nil {
+			return err
+		}
+		if deleting > 0 {
+			return errOwnerDeleted
+		}
+
+		return tx.Create(&database.WorkspaceMember{
+			WorkspaceID: invite.WorkspaceID,
+			UserID:      uint(userID),
+			Role:        invite.Role,
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &pb.JoinWorkspaceResponse{
+				Success: false,
+				Message: "Invalid invite",
+			}, invalid
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return &pb.JoinWorkspaceResponse{
+				Success: false,
+				Message: "Already a member",
+			}, status.Error(codes.AlreadyExists, "already a member of the workspace")
+		}
+		if errors.Is(err, errOwnerDeleted) {
+			return &pb.JoinWorkspaceResponse{
+				Success: false,
+				Message: "The owner of the workspace is deleting their account",
+			}, errOwnerDeleted
+		}
+
+		log.Error().Err(err).Msg("failed to join the workspace")
+		return &pb.JoinWorkspaceResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to join the workspace")
+	}
+
+	return &pb.JoinWorkspaceResponse{
+		Success:   true,
+		Message:   "Joined the workspace successfully",
+		Workspace: workspaceToPB(&invite.Workspace, invite.Role),
+	}, nil
+}
+
+// LeaveWorkspace is a gRPC endpoint to leave a workspace, the owner can only leave once every other member
+// is gone and the workspace is deleted with them
+// returns Internal, NotFound, FailedPrecondition, nil
+func (s *Server) LeaveWorkspace(ctx context.Context, req *pb.LeaveWorkspaceRequest) (*pb.LeaveWorkspaceResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.LeaveWorkspaceResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+	workspaceID, err := strconv.ParseUint(req.WorkspaceId, 10, 64)
+	if err != nil {
+		return &pb.LeaveWorkspaceResponse{
+			Success: false,
+		}, errNotFound
+	}
+
+	workspace, member, err := s.membership(uint(workspaceID), uint(userID))
+	if err != nil {
+		return &pb.LeaveWorkspaceResponse{
+			Success: false,
+		}, err
+	}
+
+	if workspace.OwnerID != uint(userID) {
+		err = s.DB.Delete(member).Error
+		if err != nil {
+			log.Error().Err(err).Msg("failed to leave the workspace")
+			return &pb.LeaveWorkspaceResponse{
+				Success: false,
+			}, status.Error(codes.Internal, "failed to leave the workspace")
+		}
+
+		return &pb.LeaveWorkspaceResponse{
+			Success: true,
+			Message: "Left the workspace successfully",
+		}, nil
+	}
+
+	var others int64
+	err = s.DB.Model(&database.WorkspaceMember{}).
+		Where("workspace_id = ? AND user_id <> ?", workspaceID, userID).
+		Count(&others).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the members")
+		return &pb.LeaveWorkspaceResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to leave the workspace")
+	}
+	if others > 0 {
+		return &pb.LeaveWorkspaceResponse{
+			Success: false,
+			Message: "Remove the other members before leaving the workspace",
+		}, status.Error(codes.FailedPrecondition, "the owner can not leave a workspace that has other members")
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(member).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("workspace_id = ?", workspaceID).Delete(&database.WorkspaceInvite{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("workspace_id = ?", workspaceID).Delete(&database.Todo{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(workspace).Error
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete the workspace")
+		return &pb.LeaveWorkspaceResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to leave the workspace")
+	}
+
+	return &pb.LeaveWorkspaceResponse{
+		Success: true,
+		Message: "Left and deleted the workspace successfully",
+	}, nil
+}
+
+// RemoveMember is a gRPC endpoint to remove a member from a workspace, only owners and admins can remove
+// members and only the ones with a lower role
+// returns Internal, InvalidArgument, NotFound, PermissionDenied, nil
+func (s *Server) RemoveMember(ctx context.Context, req *pb.RemoveMemberRequest) (*pb.RemoveMemberResponse, error) {
+	userID, err := strconv.ParseUint(req.UserId, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse user id")
+		return &pb.RemoveMemberResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to parse user id")
+	}
+	workspaceID, err := strconv.ParseUint(req.WorkspaceId, 10, 64)
+	if err != nil {
+		return &pb.RemoveMemberResponse{
+			Success: false,
+		}, errNotFound
+	}
+	memberID, err := strconv.ParseUint(req.MemberId, 10, 64)
+	if err != nil || memberID == userID {
+		return &pb.RemoveMemberResponse{
+			Success: false,
+			Message: "Invalid member",
+		}, status.Error(codes.InvalidArgument, "invalid member id, leave the workspace to remove yourself")
+	}
+
+	_, actor, err := s.membership(uint(workspaceID), uint(userID))
+	if err != nil {
+		return &pb.RemoveMemberResponse{
+			Success: false,
+		}, err
+	}
+
+	target := &database.WorkspaceMember{}
+	err = s.DB.Where("workspace_id = ? AND user_id = ?", workspaceID, memberID).First(target).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &pb.RemoveMemberResponse{
+				Success: false,
+				Message: "Member not found",
+			}, status.Error(codes.NotFound, "member not found")
+		}
+
+		log.Error().Err(err).Msg("failed to get the member")
+		return &pb.RemoveMemberResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to get the member")
+	}
+
+	actorRole, targetRole := enums.WorkspaceRole(actor.Role), enums.WorkspaceRole(target.Role)
+	if ranks[actorRole] < ranks[enums.Admin] || ranks[targetRole] >= ranks[actorRole] {
+		return &pb.RemoveMemberResponse{
+			Success: false,
+			Message: "You are not allowed to remove this member",
+		}, status.Error(codes.PermissionDenied, "not allowed to remove this member")
+	}
+
+	err = s.DB.Delete(target).Error
+	if err != nil {
+		log.Error().Err(err).Msg("failed to remove the member")
+		return &pb.RemoveMemberResponse{
+			Success: false,
+		}, status.Error(codes.Internal, "failed to remove the member")
+	}
+
+	return &pb.RemoveMemberResponse{
+		Success: true,
+		Message: "Member removed successfully",
+	}, nil
+}